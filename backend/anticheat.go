@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Score submissions (metric values and direct rating updates) pass through
+// the validators named in SUBMISSION_VALIDATORS, in order. The first one to
+// flag a submission sends it to quarantine instead of the leaderboard, where
+// it waits for an admin to approve or reject it.
+const (
+	ValidatorBounds   = "bounds"
+	ValidatorOutlier  = "outlier"
+	ValidatorExternal = "external"
+)
+
+// Submission is one score about to be written. Current is the stored value it
+// replaces, nil when the user has none yet.
+type Submission struct {
+	Username string `json:"username"`
+	Metric   string `json:"metric"`
+	Mode     string `json:"mode"`
+	Value    int    `json:"value"`
+	Current  *int   `json:"current"`
+}
+
+// delta is how far the submission moves the stored value; increments are
+// deltas already.
+func (s Submission) delta() int {
+	if s.Mode == SubmitIncrement || s.Current == nil {
+		return s.Value
+	}
+	return s.Value - *s.Current
+}
+
+type SubmissionValidator interface {
+	Name() string
+	// Validate returns a reason when the submission looks suspect.
+	Validate(s Submission) (reason string, suspect bool)
+}
+
+// submissionObserver is implemented by validators that learn from the
+// submissions that were accepted.
+type submissionObserver interface {
+	Observe(s Submission)
+}
+
+var submissionValidatorFactories = map[string]func() (SubmissionValidator, error){
+	ValidatorBounds:   newBoundsValidator,
+	ValidatorOutlier:  newOutlierValidator,
+	ValidatorExternal: newExternalValidator,
+}
+
+var (
+	submissionValidators []SubmissionValidator
+	quarantinedCount     atomic.Int64
+)
+
+func InitSubmissionValidators() error {
+	for _, name := range strings.Split(getEnv("SUBMISSION_VALIDATORS", ""), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		factory, ok := submissionValidatorFactories[name]
+		if !ok {
+			names := make([]string, 0, len(submissionValidatorFactories))
+			for n := range submissionValidatorFactories {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown submission validator %q (available: %v)", name, names)
+		}
+		v, err := factory()
+		if err != nil {
+			return fmt.Errorf("submission validator %s: %w", name, err)
+		}
+		submissionValidators = append(submissionValidators, v)
+	}
+	if len(submissionValidators) > 0 {
+		slog.Info("✓ Submission validators", "validators", strings.Join(submissionValidatorNames(), ", "))
+	}
+	return nil
+}
+
+func submissionValidatorNames() []string {
+	names := make([]string, len(submissionValidators))
+	for i, v := range submissionValidators {
+		names[i] = v.Name()
+	}
+	return names
+}
+
+// validateSubmission runs the pipeline and returns the validator and reason
+// of the first flag, or an empty validator when the submission is clean.
+func validateSubmission(s Submission) (validator, reason string) {
+	for _, v := range submissionValidators {
+		if reason, suspect := v.Validate(s); suspect {
+			return v.Name(), reason
+		}
+	}
+	return "", ""
+}
+
+// observeSubmission feeds an accepted submission to the learning validators.
+func observeSubmission(s Submission) {
+	for _, v := range submissionValidators {
+		if o, ok := v.(submissionObserver); ok {
+			o.Observe(s)
+		}
+	}
+}
+
+// boundsValidator flags a submission that moves a score further than
+// SUBMISSION_MAX_DELTA allows in one go, e.g. "rating:500,kills:50".
+type boundsValidator struct {
+	limits map[string]int
+}
+
+func newBoundsValidator() (SubmissionValidator, error) {
+	v := &boundsValidator{limits: map[string]int{}}
+	for _, kv := range parseKeyValueList(getEnv("SUBMISSION_MAX_DELTA", "")) {
+		limit, err := strconv.Atoi(kv[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid SUBMISSION_MAX_DELTA limit %q for %s", kv[1], kv[0])
+		}
+		v.limits[strings.ToLower(kv[0])] = limit
+	}
+	if len(v.limits) == 0 {
+		return nil, errors.New("SUBMISSION_MAX_DELTA is not set")
+	}
+	return v, nil
+}
+
+func (v *boundsValidator) Name() string { return ValidatorBounds }
+
+func (v *boundsValidator) Validate(s Submission) (string, bool) {
+	limit, ok := v.limits[s.Metric]
+	if !ok {
+		return "", false
+	}
+	if d := s.delta(); d > limit || -d > limit {
+		return fmt.Sprintf("%s changed by %d, more than %d in one submission", s.Metric, d, limit), true
+	}
+	return "", false
+}
+
+// outlierValidator keeps a running mean and variance of accepted deltas per
+// metric and flags a delta more than SUBMISSION_OUTLIER_Z standard deviations
+// from the mean, once SUBMISSION_OUTLIER_MIN_SAMPLES have been seen.
+type outlierValidator struct {
+	z          float64
+	minSamples int
+
+	mu    sync.Mutex
+	stats map[string]*runningStats
+}
+
+type runningStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func newOutlierValidator() (SubmissionValidator, error) {
+	v := &outlierValidator{
+		z:          getEnvFloat("SUBMISSION_OUTLIER_Z", 4),
+		minSamples: getEnvInt("SUBMISSION_OUTLIER_MIN_SAMPLES", 50),
+		stats:      map[string]*runningStats{},
+	}
+	if v.z <= 0 {
+		return nil, errors.New("SUBMISSION_OUTLIER_Z must be positive")
+	}
+	return v, nil
+}
+
+func (v *outlierValidator) Name() string { return ValidatorOutlier }
+
+func (v *outlierValidator) Validate(s Submission) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	st, ok := v.stats[s.Metric]
+	if !ok || st.n < max(v.minSamples, 2) {
+		return "", false
+	}
+	stddev := math.Sqrt(st.m2 / float64(st.n-1))
+	if stddev == 0 {
+		return "", false
+	}
+	if z := math.Abs(float64(s.delta())-st.mean) / stddev; z > v.z {
+		return fmt.Sprintf("%s changed by %d, %.1f standard deviations from the typical %d", s.Metric, s.delta(), z, int(math.Round(st.mean))), true
+	}
+	return "", false
+}
+
+func (v *outlierValidator) Observe(s Submission) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	st, ok := v.stats[s.Metric]
+	if !ok {
+		st = &runningStats{}
+		v.stats[s.Metric] = st
+	}
+	// Welford's algorithm.
+	d := float64(s.delta())
+	st.n++
+	delta := d - st.mean
+	st.mean += delta / float64(st.n)
+	st.m2 += delta * (d - st.mean)
+}
+
+// externalValidator posts each submission to SUBMISSION_VALIDATOR_URL, which
+// answers {"suspect": bool, "reason": "..."}. When it can't be reached the
+// submission is accepted, or quarantined with SUBMISSION_VALIDATOR_FAIL_CLOSED.
+type externalValidator struct {
+	url        string
+	failClosed bool
+	client     *http.Client
+}
+
+type externalValidatorResponse struct {
+	Suspect bool   `json:"suspect"`
+	Reason  string `json:"reason"`
+}
+
+func newExternalValidator() (SubmissionValidator, error) {
+	v := &externalValidator{
+		url:        getEnv("SUBMISSION_VALIDATOR_URL", ""),
+		failClosed: getEnv("SUBMISSION_VALIDATOR_FAIL_CLOSED", "false") == "true",
+		client: &http.Client{
+			Timeout: time.Duration(getEnvInt("SUBMISSION_VALIDATOR_TIMEOUT_MS", 500)) * time.Millisecond,
+		},
+	}
+	if v.url == "" {
+		return nil, errors.New("SUBMISSION_VALIDATOR_URL is not set")
+	}
+	return v, nil
+}
+
+func (v *externalValidator) Name() string { return ValidatorExternal }
+
+func (v *externalValidator) Validate(s Submission) (string, bool) {
+	out, err := v.call(s)
+	if err != nil {
+		slog.Error("Submission validator unavailable", "username", s.Username, "error", err)
+		if v.failClosed {
+			return "external validator unavailable", true
+		}
+		return "", false
+	}
+	if out.Suspect && out.Reason == "" {
+		out.Reason = "flagged by external validator"
+	}
+	return out.Reason, out.Suspect
+}
+
+func (v *externalValidator) call(s Submission) (externalValidatorResponse, error) {
+	var out externalValidatorResponse
+	body, err := json.Marshal(s)
+	if err != nil {
+		return out, fmt.Errorf("failed to encode submission: %w", err)
+	}
+	resp, err := v.client.Post(v.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("validator returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, fmt.Errorf("invalid validator response: %w", err)
+	}
+	return out, nil
+}
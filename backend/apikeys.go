@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API keys gate every endpoint that writes data; reads stay public. Keys are
+// random tokens handed out once and stored only as their SHA-256, so the
+// table never holds a usable credential. Setting API_BOOTSTRAP_KEY turns the
+// check on and registers that key as the "bootstrap" admin key, which can
+// then issue and revoke the others. Without it writes are open.
+const (
+	APIKeyRoleAdmin = "admin"
+	APIKeyRoleWrite = "write"
+
+	apiKeyPrefix        = "lbk_"
+	apiKeyDisplayLen    = 12
+	bootstrapAPIKeyName = "bootstrap"
+)
+
+const apiKeySchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		key_hash TEXT NOT NULL UNIQUE,
+		display_prefix TEXT NOT NULL,
+		role TEXT NOT NULL CHECK (role IN ('admin', 'write')),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ
+	);
+`
+
+var (
+	bootstrapAPIKey = getEnv("API_BOOTSTRAP_KEY", "")
+
+	apiKeyNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+type APIKey struct {
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func apiKeysEnabled() bool {
+	return bootstrapAPIKey != ""
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// InitAPIKeys (re)registers the bootstrap key, so rotating API_BOOTSTRAP_KEY
+// and restarting replaces the old one.
+func InitAPIKeys() error {
+	if !apiKeysEnabled() {
+		slog.Warn("API_BOOTSTRAP_KEY not set, write endpoints don't require an API key")
+		return nil
+	}
+	if isReplica() {
+		return nil
+	}
+	_, err := db.Exec(`
+		INSERT INTO api_keys (name, key_hash, display_prefix, role) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET
+			key_hash = EXCLUDED.key_hash, display_prefix = EXCLUDED.display_prefix,
+			role = EXCLUDED.role, revoked_at = NULL
+	`, bootstrapAPIKeyName, hashAPIKey(bootstrapAPIKey), displayPrefix(bootstrapAPIKey), APIKeyRoleAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to register bootstrap API key: %w", err)
+	}
+	slog.Info("✓ API key authentication enabled")
+	return nil
+}
+
+func displayPrefix(key string) string {
+	if len(key) <= apiKeyDisplayLen {
+		return key[:len(key)/2]
+	}
+	return key[:apiKeyDisplayLen]
+}
+
+// lookupAPIKey returns the name and role of an active key.
+func lookupAPIKey(key string) (string, string, error) {
+	var name, role string
+	err := db.QueryRow(`
+		SELECT name, role FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL
+	`, hashAPIKey(key)).Scan(&name, &role)
+	return name, role, err
+}
+
+// apiKeyMiddleware requires an active X-API-Key with at least role; admin
+// keys can do everything a write key can. With JWT_SIGNING_KEY set a bearer
+// token with the matching role (writer or admin) is accepted instead.
+func apiKeyMiddleware(role string) gin.HandlerFunc {
+	jwtRole := JWTRoleWriter
+	if role == APIKeyRoleAdmin {
+		jwtRole = JWTRoleAdmin
+	}
+
+	return func(c *gin.Context) {
+		if _, ok := bearerToken(c); jwtEnabled() && (ok || !apiKeysEnabled()) {
+			if authorizeJWT(c, jwtRole) {
+				c.Next()
+			}
+			return
+		}
+		if !apiKeysEnabled() {
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			abortWithError(c, http.StatusUnauthorized, "A valid X-API-Key is required")
+			return
+		}
+		name, keyRole, err := lookupAPIKey(key)
+		if errors.Is(err, sql.ErrNoRows) {
+			abortWithError(c, http.StatusUnauthorized, "A valid X-API-Key is required")
+			return
+		}
+		if err != nil {
+			requestLog(c).Error("Error checking API key", "error", err)
+			abortWithError(c, http.StatusInternalServerError, "Failed to verify API key")
+			return
+		}
+		if role == APIKeyRoleAdmin && keyRole != APIKeyRoleAdmin {
+			abortWithError(c, http.StatusForbidden, "This API key can't manage API keys")
+			return
+		}
+
+		c.Set("api_key", name)
+		c.Next()
+	}
+}
+
+// requireAPIKeyMiddleware is apiKeyMiddleware for routes that must never be
+// open. With neither API keys nor bearer tokens configured nobody can be
+// checked, so the route is refused rather than left public.
+func requireAPIKeyMiddleware(role string) gin.HandlerFunc {
+	check := apiKeyMiddleware(role)
+	return func(c *gin.Context) {
+		if !apiKeysEnabled() && !jwtEnabled() {
+			abortWithError(c, http.StatusForbidden, "This endpoint needs API_BOOTSTRAP_KEY or JWT_SIGNING_KEY to be set")
+			return
+		}
+		check(c)
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// HandleCreateAPIKey issues a key. The key itself is only ever in this
+// response, as "secret" so request auditing redacts it.
+func HandleCreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = APIKeyRoleWrite
+	}
+	if !apiKeyNamePattern.MatchString(req.Name) || (req.Role != APIKeyRoleWrite && req.Role != APIKeyRoleAdmin) {
+		respondError(c, http.StatusBadRequest, "name (1-64 letters, digits, _ or -) is required and role must be write or admin")
+		return
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		requestLog(c).Error("Error generating API key", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(raw)
+
+	created := APIKey{Name: req.Name, Prefix: displayPrefix(key), Role: req.Role}
+	err := db.QueryRow(`
+		INSERT INTO api_keys (name, key_hash, display_prefix, role) VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`, req.Name, hashAPIKey(key), created.Prefix, req.Role).Scan(&created.CreatedAt)
+	if isUniqueViolation(err) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("API key %s already exists", req.Name))
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error creating API key", "name", req.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	requestLog(c).Info("✓ API key created", "name", req.Name, "role", req.Role, "by", c.GetString("api_key"))
+
+	respond(c, http.StatusCreated, gin.H{
+		"api_key": created,
+		"secret":  key,
+	})
+}
+
+func HandleListAPIKeys(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT name, display_prefix, role, created_at, revoked_at FROM api_keys ORDER BY id
+	`)
+	if err != nil {
+		requestLog(c).Error("Error listing API keys", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.Name, &k.Prefix, &k.Role, &k.CreatedAt, &k.RevokedAt); err != nil {
+			requestLog(c).Error("Error scanning API key", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list API keys")
+			return
+		}
+		keys = append(keys, k)
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"api_keys": keys,
+	})
+}
+
+// HandleRevokeAPIKey revokes a key immediately. The bootstrap key is managed
+// through API_BOOTSTRAP_KEY instead.
+func HandleRevokeAPIKey(c *gin.Context) {
+	name := c.Param("name")
+	if name == bootstrapAPIKeyName {
+		respondError(c, http.StatusBadRequest, "The bootstrap key is rotated by changing API_BOOTSTRAP_KEY")
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE api_keys SET revoked_at = NOW() WHERE name = $1 AND revoked_at IS NULL
+	`, name)
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		requestLog(c).Error("Error revoking API key", "name", name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if n == 0 {
+		respondError(c, http.StatusNotFound, "API key not found or already revoked")
+		return
+	}
+	keyQuotas.forget(name)
+	requestLog(c).Info("✓ API key revoked", "name", name, "by", c.GetString("api_key"))
+
+	respond(c, http.StatusOK, gin.H{
+		"revoked": name,
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Two-person approval. ADMIN_KEYS lists the admins as "name:key,name:key";
+// every /admin request must then carry one of the keys in X-Admin-Key.
+// Destructive calls don't run when first made: they are parked as a pending
+// action, and a second admin has APPROVAL_TTL_SEC to approve it, at which
+// point the original request is replayed through the router and its response
+// returned to the approver. Dry runs never need approval. Without ADMIN_KEYS
+// the admin API is open and destructive calls run immediately; with keys for
+// fewer than two admins nothing could ever be approved, so startup refuses.
+// Pending actions live in this process's memory: a restart loses them, and
+// behind a load balancer the approval must reach the instance that parked
+// the action.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+
+	approvalMaxBody = 1 << 20
+)
+
+var (
+	adminKeys   = parseAdminKeys(getEnv("ADMIN_KEYS", ""))
+	approvalTTL = time.Duration(getEnvInt("APPROVAL_TTL_SEC", 900)) * time.Second
+
+	// approvalRouter replays approved actions; setupRouter sets it.
+	approvalRouter http.Handler
+)
+
+type adminKey struct {
+	name string
+	key  []byte
+}
+
+func parseAdminKeys(value string) []adminKey {
+	var keys []adminKey
+	for _, kv := range parseKeyValueList(value) {
+		keys = append(keys, adminKey{name: kv[0], key: []byte(kv[1])})
+	}
+	return keys
+}
+
+// validateAdminKeys refuses an ADMIN_KEYS that names only one admin, since
+// decide never lets an admin approve their own action.
+func validateAdminKeys() error {
+	names := map[string]bool{}
+	for _, k := range adminKeys {
+		names[k.name] = true
+	}
+	if len(names) == 1 {
+		return errors.New("ADMIN_KEYS names a single admin, but destructive calls need a second admin to approve them; list at least two, or unset it to leave the admin API open")
+	}
+	return nil
+}
+
+func approvalsEnabled() bool {
+	return len(adminKeys) > 0
+}
+
+type PendingAction struct {
+	ID          string    `json:"id"`
+	Action      string    `json:"action"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	RequestedBy string    `json:"requested_by"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+
+	body        []byte
+	contentType string
+}
+
+type approvalStore struct {
+	mu      sync.Mutex
+	actions map[string]*PendingAction
+	nextID  atomic.Int64
+}
+
+var approvals = &approvalStore{actions: map[string]*PendingAction{}}
+
+type approvedActionKey struct{}
+
+// expireLocked marks lapsed actions expired and forgets decided ones once
+// they are a TTL past their expiry.
+func (s *approvalStore) expireLocked(now time.Time) {
+	for id, a := range s.actions {
+		if a.Status == ApprovalPending && now.After(a.ExpiresAt) {
+			a.Status = ApprovalExpired
+		}
+		if a.Status != ApprovalPending && now.After(a.ExpiresAt.Add(approvalTTL)) {
+			delete(s.actions, id)
+		}
+	}
+}
+
+func (s *approvalStore) add(a *PendingAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(a.CreatedAt)
+	a.ID = fmt.Sprintf("approval-%d", s.nextID.Add(1))
+	s.actions[a.ID] = a
+}
+
+func (s *approvalStore) list() []PendingAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+	list := make([]PendingAction, 0, len(s.actions))
+	for _, a := range s.actions {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt.Before(list[k].CreatedAt) })
+	return list
+}
+
+// decide moves a pending action to status on behalf of admin. The requester
+// can't decide their own action.
+func (s *approvalStore) decide(id, admin, status string) (*PendingAction, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+
+	a, ok := s.actions[id]
+	switch {
+	case !ok:
+		return nil, http.StatusNotFound, "Pending action not found"
+	case a.Status != ApprovalPending:
+		return nil, http.StatusConflict, fmt.Sprintf("Pending action is already %s", a.Status)
+	case status == ApprovalApproved && a.RequestedBy == admin:
+		return nil, http.StatusForbidden, "A pending action must be approved by a different admin"
+	}
+	a.Status = status
+	a.DecidedBy = admin
+	copied := *a
+	return &copied, 0, ""
+}
+
+func adminAuthMiddleware() gin.HandlerFunc {
+	if !approvalsEnabled() {
+		slog.Warn("ADMIN_KEYS not set, admin endpoints are unauthenticated and need no approval")
+	}
+
+	return func(c *gin.Context) {
+		if !approvalsEnabled() {
+			c.Next()
+			return
+		}
+		if a, ok := c.Request.Context().Value(approvedActionKey{}).(*PendingAction); ok {
+			c.Set("admin", a.RequestedBy)
+			c.Next()
+			return
+		}
+
+		presented := []byte(c.GetHeader("X-Admin-Key"))
+		for _, k := range adminKeys {
+			if subtle.ConstantTimeCompare(presented, k.key) == 1 {
+				c.Set("admin", k.name)
+				c.Next()
+				return
+			}
+		}
+		abortWithError(c, http.StatusUnauthorized, "A valid X-Admin-Key is required")
+	}
+}
+
+// requireApproval parks a destructive call as a pending action unless it is
+// a dry run or the replay of an approved action.
+func requireApproval(action string) gin.HandlerFunc {
+	return requireApprovalUpTo(action, approvalMaxBody)
+}
+
+// requireApprovalUpTo holds request bodies of up to maxBody bytes, for
+// actions such as an import whose upload is bigger than approvalMaxBody. The
+// body stays in memory until the action is decided or forgotten.
+func requireApprovalUpTo(action string, maxBody int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !approvalsEnabled() || dryRunRequested(c) {
+			c.Next()
+			return
+		}
+		if _, ok := c.Request.Context().Value(approvedActionKey{}).(*PendingAction); ok {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		if err != nil || int64(len(body)) > maxBody {
+			abortWithError(c, http.StatusBadRequest, "Request body is too large to hold for approval")
+			return
+		}
+
+		now := time.Now().UTC()
+		pending := &PendingAction{
+			Action:      action,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.RequestURI(),
+			RequestedBy: c.GetString("admin"),
+			Status:      ApprovalPending,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(approvalTTL),
+			body:        body,
+			contentType: c.GetHeader("Content-Type"),
+		}
+		approvals.add(pending)
+		requestLog(c).Info("Action awaits approval", "id", pending.ID, "action", action, "requested_by", pending.RequestedBy)
+
+		respond(c, http.StatusAccepted, gin.H{
+			"pending_action": pending,
+		})
+		c.Abort()
+	}
+}
+
+func HandleListApprovals(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{
+		"actions": approvals.list(),
+	})
+}
+
+func HandleRejectAction(c *gin.Context) {
+	action, status, msg := approvals.decide(c.Param("id"), c.GetString("admin"), ApprovalRejected)
+	if action == nil {
+		respondError(c, status, msg)
+		return
+	}
+
+	requestLog(c).Info("✓ Action rejected", "id", action.ID, "action", action.Action, "decided_by", action.DecidedBy)
+	respond(c, http.StatusOK, gin.H{
+		"action": action,
+	})
+}
+
+// HandleApproveAction replays the approved request and returns its response
+// alongside the action.
+func HandleApproveAction(c *gin.Context) {
+	action, status, msg := approvals.decide(c.Param("id"), c.GetString("admin"), ApprovalApproved)
+	if action == nil {
+		respondError(c, status, msg)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(
+		context.WithValue(context.Background(), approvedActionKey{}, action),
+		action.Method, action.Path, bytes.NewReader(action.body),
+	)
+	if err != nil {
+		requestLog(c).Error("Error replaying action", "id", action.ID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to execute approved action")
+		return
+	}
+	// An import is replayed as the CSV or multipart upload it was sent as.
+	contentType := action.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	approvalRouter.ServeHTTP(rec, req)
+
+	requestLog(c).Info("✓ Action approved and executed", "id", action.ID, "action", action.Action, "decided_by", action.DecidedBy, "status", rec.Code)
+	result := json.RawMessage(rec.Body.Bytes())
+	if !json.Valid(result) {
+		result = nil
+	}
+	respond(c, http.StatusOK, gin.H{
+		"action": action,
+		"status": rec.Code,
+		"result": result,
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import "sync"
+
+// ApproxEngine trades precision for memory on very wide rating ranges: it
+// keeps one count per APPROX_BUCKET_WIDTH ratings instead of one per rating,
+// so RATING_MAX=1000000 with width 100 needs 10,000 counters rather than a
+// million. A rank is exact up to the bucket the rating falls in and
+// interpolated within it, so it can be off by at most that bucket's
+// population.
+//
+// Ranks that land in the top APPROX_EXACT_TOP are recomputed exactly by the
+// SQL engine, which is cheap there since only a few rows rate higher. That
+// keeps the first leaderboard pages and top players' ranks exact.
+type ApproxEngine struct {
+	mu sync.RWMutex
+
+	width      int
+	buckets    []int
+	totalUsers int
+
+	exactTop int
+	exact    RankEngine
+
+	engineVersion
+}
+
+func NewApproxEngine(counts map[int]int) *ApproxEngine {
+	width := getEnvInt("APPROX_BUCKET_WIDTH", 100)
+	if width < 1 {
+		width = 1
+	}
+	ae := &ApproxEngine{
+		width:    width,
+		buckets:  make([]int, (MaxRating-MinRating)/width+1),
+		exactTop: getEnvInt("APPROX_EXACT_TOP", 1000),
+		exact:    NewSQLEngine(),
+	}
+	for rating, count := range counts {
+		if rating >= MinRating && rating <= MaxRating {
+			ae.buckets[ae.bucket(rating)] += count
+			ae.totalUsers += count
+		}
+	}
+	ae.bump()
+	return ae
+}
+
+func (ae *ApproxEngine) bucket(rating int) int {
+	return (rating - MinRating) / ae.width
+}
+
+// approxRankLocked counts every bucket above the rating's own and assumes the
+// users inside it are spread evenly across its ratings.
+func (ae *ApproxEngine) approxRankLocked(rating int) int {
+	if rating < MinRating || rating > MaxRating {
+		return -1
+	}
+	b := ae.bucket(rating)
+	rank := 1
+	for i := b + 1; i < len(ae.buckets); i++ {
+		rank += ae.buckets[i]
+	}
+	top := MinRating + (b+1)*ae.width - 1
+	if top > MaxRating {
+		top = MaxRating
+	}
+	return rank + ae.buckets[b]*(top-rating)/ae.width
+}
+
+func (ae *ApproxEngine) inExactRange(rank int) bool {
+	return rank > 0 && rank <= ae.exactTop
+}
+
+func (ae *ApproxEngine) GetRank(rating int) int {
+	if rating > MaxRating {
+		return 1
+	}
+	ae.mu.RLock()
+	rank := ae.approxRankLocked(rating)
+	totalUsers := ae.totalUsers
+	ae.mu.RUnlock()
+
+	if rating < MinRating {
+		return 1 + totalUsers
+	}
+	if ae.inExactRange(rank) {
+		if exact := ae.exact.GetRank(rating); exact > 0 {
+			return exact
+		}
+	}
+	return rank
+}
+
+func (ae *ApproxEngine) GetRankBatch(ratings []int) []int {
+	ae.mu.RLock()
+	ranks := make([]int, len(ratings))
+	needExact := false
+	for i, rating := range ratings {
+		ranks[i] = ae.approxRankLocked(rating)
+		needExact = needExact || ae.inExactRange(ranks[i])
+	}
+	ae.mu.RUnlock()
+
+	if needExact {
+		for i, exact := range ae.exact.GetRankBatch(ratings) {
+			if exact > 0 && ranks[i] > 0 {
+				ranks[i] = exact
+			}
+		}
+	}
+	return ranks
+}
+
+func (ae *ApproxEngine) FillRanks(rows []UserWithRank) {
+	ratings := make([]int, len(rows))
+	for i := range rows {
+		ratings[i] = rows[i].Rating
+	}
+	for i, rank := range ae.GetRankBatch(ratings) {
+		rows[i].Rank = rank
+	}
+}
+
+func (ae *ApproxEngine) applyLocked(oldRating, newRating int) {
+	if oldRating == newRating {
+		return
+	}
+	if oldRating >= MinRating && oldRating <= MaxRating && ae.buckets[ae.bucket(oldRating)] > 0 {
+		ae.buckets[ae.bucket(oldRating)]--
+		ae.totalUsers--
+	}
+	if newRating >= MinRating && newRating <= MaxRating {
+		ae.buckets[ae.bucket(newRating)]++
+		ae.totalUsers++
+	}
+}
+
+func (ae *ApproxEngine) UpdateRating(oldRating, newRating int) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	ae.bump()
+	ae.applyLocked(oldRating, newRating)
+}
+
+func (ae *ApproxEngine) BatchUpdateRatings(updates []RatingUpdate) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	ae.bump()
+	for _, u := range updates {
+		ae.applyLocked(u.OldRating, u.NewRating)
+	}
+}
+
+func (ae *ApproxEngine) AddUser(rating int) {
+	if rating < MinRating || rating > MaxRating {
+		return
+	}
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	ae.bump()
+	ae.buckets[ae.bucket(rating)]++
+	ae.totalUsers++
+}
+
+func (ae *ApproxEngine) RemoveUser(rating int) {
+	if rating < MinRating || rating > MaxRating {
+		return
+	}
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	ae.bump()
+	if ae.buckets[ae.bucket(rating)] > 0 {
+		ae.buckets[ae.bucket(rating)]--
+		ae.totalUsers--
+	}
+}
+
+// GetStats reports non-empty buckets as unique ratings, and the lowest and
+// highest rating those buckets can hold as the rating extremes.
+func (ae *ApproxEngine) GetStats() (totalUsers int, uniqueRatings int, minRatingWithUsers int, maxRatingWithUsers int) {
+	ae.mu.RLock()
+	defer ae.mu.RUnlock()
+
+	minRatingWithUsers = -1
+	maxRatingWithUsers = -1
+	for i, count := range ae.buckets {
+		if count > 0 {
+			uniqueRatings++
+			if minRatingWithUsers == -1 {
+				minRatingWithUsers = MinRating + i*ae.width
+			}
+			maxRatingWithUsers = MinRating + (i+1)*ae.width - 1
+		}
+	}
+	if maxRatingWithUsers > MaxRating {
+		maxRatingWithUsers = MaxRating
+	}
+	return ae.totalUsers, uniqueRatings, minRatingWithUsers, maxRatingWithUsers
+}
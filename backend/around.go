@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /leaderboard/around shows a user's neighbourhood: the rows just above
+// and below them on the board. The user is found by name, and the two sides
+// are keyset reads from their cursor position, so the cost doesn't depend on
+// how far down the board they are. Ranks come from the engine as usual.
+const defaultAroundWindow = 10
+
+var maxAroundWindow = max(MaxPageSize/2, defaultAroundWindow)
+
+var errAroundUnranked = errors.New("user has no rank yet")
+
+type AroundResponse struct {
+	Success  bool           `json:"success"`
+	Username string         `json:"username"`
+	Rank     int            `json:"rank"`
+	Window   int            `json:"window"`
+	Position int            `json:"position"` // index of the user in data
+	Data     []UserWithRank `json:"data"`
+	Count    int            `json:"count"`
+}
+
+// Around returns up to window rows on each side of username, top first, and
+// the index of the user's own row.
+func (s *LeaderboardService) Around(ctx context.Context, username string, window int) ([]UserWithRank, int, error) {
+	user, err := s.users.UserByUsername(ctx, username)
+	if err != nil {
+		return nil, 0, err
+	}
+	if user.InPlacement {
+		return nil, 0, errAroundUnranked
+	}
+
+	at := Cursor{Rating: user.Rating, Username: user.Username, ID: user.ID}
+	above, err := s.users.TopUsersBefore(ctx, at, window)
+	if err != nil {
+		return nil, 0, err
+	}
+	below, err := s.users.TopUsersAfter(ctx, at, window)
+	if err != nil {
+		return nil, 0, err
+	}
+	if budgetExhausted(ctx) {
+		return nil, 0, errBudgetExhausted
+	}
+
+	slices.Reverse(above)
+	users := append(append(above, *user), below...)
+	rows := make([]UserWithRank, len(users))
+	for i, u := range users {
+		rows[i] = UserWithRank{Username: u.Username, Rating: u.Rating, Cursor: cursorFor(u)}
+	}
+	s.ranks.FillRanks(rows)
+	return rows, len(above), nil
+}
+
+// HandleLeaderboardAround serves GET /leaderboard/around?username=&window=,
+// taking ?board= and ?include_bots= like /leaderboard.
+func (h *Handlers) HandleLeaderboardAround(c *gin.Context) {
+	username := strings.TrimSpace(c.Query("username"))
+	window := parseIntParam(c.Query("window"), defaultAroundWindow)
+	if username == "" || window < 1 || window > maxAroundWindow {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("username is required and window must be 1-%d", maxAroundWindow))
+		return
+	}
+
+	svc, ok := h.boardService(c)
+	if !ok {
+		return
+	}
+	rows, position, err := svc.Around(c.Request.Context(), username, window)
+	if isBudgetError(err) {
+		writeBudgetTimeout(c)
+		return
+	}
+	if errors.Is(err, errAroundUnranked) {
+		respondError(c, http.StatusConflict, "User is still in placement and has no rank yet")
+		return
+	}
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error fetching leaderboard around", "username", username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+
+	respond(c, http.StatusOK, AroundResponse{
+		Success:  true,
+		Username: rows[position].Username,
+		Rank:     rows[position].Rank,
+		Window:   window,
+		Position: position,
+		Data:     rows,
+		Count:    len(rows),
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const auditSchema = `
+	CREATE TABLE IF NOT EXISTS request_audit (
+		id BIGSERIAL PRIMARY KEY,
+		method TEXT NOT NULL,
+		path TEXT NOT NULL,
+		status INT NOT NULL,
+		duration_ms INT NOT NULL,
+		client_ip TEXT NOT NULL,
+		request_headers JSONB NOT NULL,
+		request_body TEXT NOT NULL,
+		response_body TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_request_audit_created ON request_audit(created_at DESC);
+`
+
+const auditRedacted = "[REDACTED]"
+
+// Headers that carry credentials are never stored, whatever AUDIT_REDACT_FIELDS says.
+var auditSecretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"X-Admin-Key":         true,
+	"X-Api-Key":           true,
+	"X-Signature":         true,
+	"X-Slack-Signature":   true,
+}
+
+type auditConfig struct {
+	rate      float64
+	maxBody   int
+	retention time.Duration
+	redacted  map[string]bool
+}
+
+var (
+	audit = loadAuditConfig()
+
+	// auditPrunedAt throttles the retention sweep that store runs to once
+	// per auditPruneInterval.
+	auditPrunedAt atomic.Int64
+)
+
+const auditPruneInterval = time.Hour
+
+func loadAuditConfig() auditConfig {
+	cfg := auditConfig{
+		rate:      getEnvFloat("AUDIT_SAMPLE_RATE", 0),
+		maxBody:   getEnvInt("AUDIT_MAX_BODY_BYTES", 16*1024),
+		retention: time.Duration(getEnvInt("AUDIT_RETENTION_DAYS", 7)) * 24 * time.Hour,
+		redacted:  map[string]bool{},
+	}
+	fields := getEnv("AUDIT_REDACT_FIELDS", "password,token,secret,api_key,signature")
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			cfg.redacted[f] = true
+		}
+	}
+	return cfg
+}
+
+// auditWriter tees the response body, up to the configured cap.
+type auditWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if room := w.limit - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// auditMiddleware stores a sample (AUDIT_SAMPLE_RATE, 0-1) of mutating
+// requests with their request and response bodies, for debugging game server
+// integrations. Only the first AUDIT_MAX_BODY_BYTES of a request body are
+// buffered; the handler reads the rest straight from the connection, under
+// its own size limits. Fields named in AUDIT_REDACT_FIELDS are masked in JSON
+// and form bodies before anything is written.
+func auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if audit.rate <= 0 || rand.Float64() >= audit.rate {
+			c.Next()
+			return
+		}
+
+		body := c.Request.Body
+		reqBody, err := io.ReadAll(io.LimitReader(body, int64(audit.maxBody)))
+		c.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(reqBody), body), body}
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		w := &auditWriter{ResponseWriter: c.Writer, limit: audit.maxBody}
+		c.Writer = w
+
+		start := time.Now()
+		c.Next()
+
+		entry := auditEntry{
+			method:      c.Request.Method,
+			path:        c.Request.URL.RequestURI(),
+			status:      w.Status(),
+			durationMs:  int(time.Since(start).Milliseconds()),
+			clientIP:    c.ClientIP(),
+			headers:     redactHeaders(c.Request.Header),
+			requestBody: audit.redactBody(c.ContentType(), reqBody),
+			respBody:    audit.redactBody(w.Header().Get("Content-Type"), w.body.Bytes()),
+		}
+		go entry.store()
+	}
+}
+
+type auditEntry struct {
+	method      string
+	path        string
+	status      int
+	durationMs  int
+	clientIP    string
+	headers     map[string]string
+	requestBody string
+	respBody    string
+}
+
+func (e auditEntry) store() {
+	headers, _ := json.Marshal(e.headers)
+	_, err := db.Exec(`
+		INSERT INTO request_audit (method, path, status, duration_ms, client_ip, request_headers, request_body, response_body)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, e.method, e.path, e.status, e.durationMs, e.clientIP, headers, e.requestBody, e.respBody)
+	if err != nil {
+		slog.Warn("Failed to store request audit", "error", err)
+	}
+
+	last := auditPrunedAt.Load()
+	if now := time.Now().UnixNano(); now-last >= int64(auditPruneInterval) && auditPrunedAt.CompareAndSwap(last, now) {
+		if err := pruneStoredAudit(); err != nil {
+			slog.Warn("Failed to prune request audits", "error", err)
+		}
+	}
+}
+
+// pruneStoredAudit deletes audits older than AUDIT_RETENTION_DAYS (0 keeps
+// them all). It runs at startup and then at most hourly while audits are
+// being stored.
+func pruneStoredAudit() error {
+	if audit.retention <= 0 {
+		return nil
+	}
+	auditPrunedAt.Store(time.Now().UnixNano())
+	res, err := db.Exec(`DELETE FROM request_audit WHERE created_at < $1`, time.Now().Add(-audit.retention))
+	if err != nil {
+		return fmt.Errorf("failed to prune request audits: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("✓ Pruned request audits", "rows", n)
+	}
+	return nil
+}
+
+// redactStoredAudit masks secret headers in rows stored before the header was
+// on auditSecretHeaders, so adding one also cleans up what is already there.
+func redactStoredAudit() error {
+	names := make([]string, 0, len(auditSecretHeaders))
+	for h := range auditSecretHeaders {
+		names = append(names, h)
+	}
+	res, err := db.Exec(`
+		UPDATE request_audit
+		SET request_headers = request_headers || (
+			SELECT jsonb_object_agg(k, $2::text) FROM unnest($1::text[]) k WHERE request_headers ->> k <> $2
+		)
+		WHERE EXISTS (SELECT 1 FROM unnest($1::text[]) k WHERE request_headers ->> k <> $2)
+	`, pq.Array(names), auditRedacted)
+	if err != nil {
+		return fmt.Errorf("failed to redact stored audit headers: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Warn("Redacted credentials in stored request audits", "rows", n)
+	}
+	return nil
+}
+
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if auditSecretHeaders[k] {
+			out[k] = auditRedacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// redactBody masks configured fields in JSON and form bodies. A body that
+// can't be parsed, such as truncated JSON, is kept only if no redacted field
+// name appears in it.
+func (a auditConfig) redactBody(contentType string, body []byte) string {
+	switch {
+	case strings.Contains(contentType, "json"):
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return a.unparsedBody(body)
+		}
+		out, err := json.Marshal(a.redactValue(v))
+		if err != nil {
+			return a.unparsedBody(body)
+		}
+		return string(out)
+
+	case strings.Contains(contentType, "x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return a.unparsedBody(body)
+		}
+		for k := range form {
+			if a.redacted[strings.ToLower(k)] {
+				form[k] = []string{auditRedacted}
+			}
+		}
+		return form.Encode()
+	}
+	return a.unparsedBody(body)
+}
+
+func (a auditConfig) unparsedBody(body []byte) string {
+	lower := strings.ToLower(string(body))
+	for field := range a.redacted {
+		if strings.Contains(lower, field) {
+			return auditRedacted
+		}
+	}
+	return string(body)
+}
+
+func (a auditConfig) redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, inner := range t {
+			if a.redacted[strings.ToLower(k)] {
+				t[k] = auditRedacted
+			} else {
+				t[k] = a.redactValue(inner)
+			}
+		}
+	case []any:
+		for i := range t {
+			t[i] = a.redactValue(t[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A backfill rewrites a table's existing rows in id-ordered chunks, one
+// transaction per chunk. Each chunk advances the persisted cursor in its own
+// transaction, so a backfill stopped by a pause, a failure, or a restart
+// carries on from the last committed chunk. The id range is fixed when a run
+// starts; rows inserted later are expected to be written correctly already.
+const (
+	BackfillIdle      = "idle"
+	BackfillRunning   = "running"
+	BackfillPaused    = "paused"
+	BackfillCompleted = "completed"
+	BackfillFailed    = "failed"
+)
+
+const backfillSchema = `
+	CREATE TABLE IF NOT EXISTS backfills (
+		name TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		min_id BIGINT NOT NULL,
+		max_id BIGINT NOT NULL,
+		next_id BIGINT NOT NULL,
+		processed BIGINT NOT NULL DEFAULT 0,
+		chunk_size INT NOT NULL,
+		rows_per_sec INT NOT NULL,
+		error TEXT,
+		started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		finished_at TIMESTAMPTZ
+	);
+`
+
+type Backfill struct {
+	Name        string
+	Table       string
+	Description string
+	// Apply migrates the rows with ids in [lo, hi] and reports how many it
+	// changed.
+	Apply func(tx *sql.Tx, lo, hi int64) (int64, error)
+	// Done runs after the last chunk; an error fails the backfill.
+	Done func() error
+}
+
+type BackfillStatus struct {
+	Name        string     `json:"name"`
+	Table       string     `json:"table"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	MinID       int64      `json:"min_id"`
+	MaxID       int64      `json:"max_id"`
+	NextID      int64      `json:"next_id"`
+	Percent     float64    `json:"percent"`
+	Processed   int64      `json:"processed"`
+	ChunkSize   int        `json:"chunk_size"`
+	RowsPerSec  int        `json:"rows_per_sec"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// BackfillOptions override the throttle for one run; unset fields keep the
+// run's current values, or the BACKFILL_* defaults for a fresh run.
+type BackfillOptions struct {
+	ChunkSize  *int `json:"chunk_size"`
+	RowsPerSec *int `json:"rows_per_sec"`
+}
+
+var (
+	backfillDefs = schemaChangeBackfills()
+
+	defaultBackfillChunk = max(getEnvInt("BACKFILL_CHUNK_SIZE", 1000), 1)
+	defaultBackfillRate  = max(getEnvInt("BACKFILL_ROWS_PER_SEC", 5000), 0)
+
+	errBackfillRunning   = errors.New("backfill already running")
+	errBackfillCompleted = errors.New("backfill already completed")
+	errBackfillNotActive = errors.New("backfill is not running")
+)
+
+func findBackfill(name string) (*Backfill, bool) {
+	for _, bf := range backfillDefs {
+		if bf.Name == name {
+			return bf, true
+		}
+	}
+	return nil, false
+}
+
+type backfillRunner struct {
+	mu   sync.Mutex
+	stop map[string]chan struct{}
+	wg   sync.WaitGroup
+}
+
+var backfillRuns = &backfillRunner{stop: map[string]chan struct{}{}}
+
+func loadBackfillStatus(bf *Backfill) (*BackfillStatus, error) {
+	st := &BackfillStatus{Name: bf.Name, Table: bf.Table, Description: bf.Description, State: BackfillIdle}
+	var errText sql.NullString
+	var startedAt, updatedAt time.Time
+	err := db.QueryRow(`
+		SELECT state, min_id, max_id, next_id, processed, chunk_size, rows_per_sec, error, started_at, updated_at, finished_at
+		FROM backfills WHERE name = $1
+	`, bf.Name).Scan(&st.State, &st.MinID, &st.MaxID, &st.NextID, &st.Processed, &st.ChunkSize, &st.RowsPerSec,
+		&errText, &startedAt, &updatedAt, &st.FinishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backfill %s: %w", bf.Name, err)
+	}
+	st.Error = errText.String
+	st.StartedAt, st.UpdatedAt = &startedAt, &updatedAt
+	if span := st.MaxID - st.MinID + 1; span > 0 {
+		st.Percent = float64(int(float64(min(st.NextID, st.MaxID+1)-st.MinID)/float64(span)*1000)) / 10
+	} else {
+		st.Percent = 100
+	}
+	return st, nil
+}
+
+// start begins a backfill, or resumes a paused or failed one. A completed
+// backfill only runs again with restart, which starts over from the table's
+// current id range.
+func (r *backfillRunner) start(bf *Backfill, restart bool, opts BackfillOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.stop[bf.Name]; ok {
+		return errBackfillRunning
+	}
+
+	st, err := loadBackfillStatus(bf)
+	if err != nil {
+		return err
+	}
+	if st.State == BackfillCompleted && !restart {
+		return errBackfillCompleted
+	}
+
+	if restart || st.State == BackfillIdle || st.State == BackfillCompleted {
+		var lo, hi sql.NullInt64
+		if err := db.QueryRow(fmt.Sprintf(`SELECT MIN(id), MAX(id) FROM %s`, bf.Table)).Scan(&lo, &hi); err != nil {
+			return fmt.Errorf("failed to find backfill range: %w", err)
+		}
+		if !lo.Valid {
+			lo.Int64, hi.Int64 = 1, 0
+		}
+		chunk, rate := defaultBackfillChunk, defaultBackfillRate
+		if opts.ChunkSize != nil {
+			chunk = *opts.ChunkSize
+		}
+		if opts.RowsPerSec != nil {
+			rate = *opts.RowsPerSec
+		}
+		_, err = db.Exec(`
+			INSERT INTO backfills (name, state, min_id, max_id, next_id, chunk_size, rows_per_sec)
+			VALUES ($1, $2, $3, $4, $3, $5, $6)
+			ON CONFLICT (name) DO UPDATE SET
+				state = EXCLUDED.state, min_id = EXCLUDED.min_id, max_id = EXCLUDED.max_id,
+				next_id = EXCLUDED.next_id, processed = 0, chunk_size = EXCLUDED.chunk_size,
+				rows_per_sec = EXCLUDED.rows_per_sec, error = NULL, started_at = NOW(),
+				updated_at = NOW(), finished_at = NULL
+		`, bf.Name, BackfillRunning, lo.Int64, hi.Int64, chunk, rate)
+	} else {
+		_, err = db.Exec(`
+			UPDATE backfills SET state = $2, error = NULL, updated_at = NOW(),
+				chunk_size = COALESCE($3, chunk_size), rows_per_sec = COALESCE($4, rows_per_sec)
+			WHERE name = $1
+		`, bf.Name, BackfillRunning, opts.ChunkSize, opts.RowsPerSec)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start backfill %s: %w", bf.Name, err)
+	}
+
+	stop := make(chan struct{})
+	r.stop[bf.Name] = stop
+	r.wg.Add(1)
+	go r.run(bf, stop)
+	return nil
+}
+
+// pause stops a running backfill after its current chunk.
+func (r *backfillRunner) pause(bf *Backfill) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stop, ok := r.stop[bf.Name]
+	if !ok {
+		return errBackfillNotActive
+	}
+	if _, err := db.Exec(`
+		UPDATE backfills SET state = $2, updated_at = NOW() WHERE name = $1
+	`, bf.Name, BackfillPaused); err != nil {
+		return fmt.Errorf("failed to pause backfill %s: %w", bf.Name, err)
+	}
+	close(stop)
+	delete(r.stop, bf.Name)
+	return nil
+}
+
+func (r *backfillRunner) run(bf *Backfill, stop chan struct{}) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		if r.stop[bf.Name] == stop {
+			delete(r.stop, bf.Name)
+		}
+		r.mu.Unlock()
+	}()
+
+	st, err := loadBackfillStatus(bf)
+	if err != nil {
+		slog.Error("Backfill failed to start", "name", bf.Name, "error", err)
+		return
+	}
+	slog.Info("✓ Backfill running", "name", bf.Name, "from_id", st.NextID, "to_id", st.MaxID,
+		"chunk_size", st.ChunkSize, "ids_per_sec", st.RowsPerSec)
+
+	for next := st.NextID; next <= st.MaxID; {
+		select {
+		case <-stop:
+			slog.Info("Backfill stopped", "name", bf.Name, "stopped_at", next)
+			return
+		default:
+		}
+
+		began := time.Now()
+		hi := min(next+int64(max(st.ChunkSize, 1))-1, st.MaxID)
+		advanced, err := bf.runChunk(next, hi)
+		if err != nil {
+			bf.fail(err)
+			return
+		}
+		if !advanced {
+			return // paused while the chunk ran; it was rolled back
+		}
+		if st.RowsPerSec > 0 {
+			wait := time.Duration(float64(hi-next+1)/float64(st.RowsPerSec)*float64(time.Second)) - time.Since(began)
+			select {
+			case <-stop:
+			case <-time.After(max(wait, 0)):
+			}
+		}
+		next = hi + 1
+	}
+
+	if bf.Done != nil {
+		if err := bf.Done(); err != nil {
+			bf.fail(err)
+			return
+		}
+	}
+	if _, err := db.Exec(`
+		UPDATE backfills SET state = $2, updated_at = NOW(), finished_at = NOW() WHERE name = $1
+	`, bf.Name, BackfillCompleted); err != nil {
+		slog.Error("Backfill finished but could not be marked completed", "name", bf.Name, "error", err)
+		return
+	}
+	slog.Info("✓ Backfill completed", "name", bf.Name)
+}
+
+// runChunk applies one chunk and advances the cursor in the same
+// transaction. It reports false, writing nothing, if the backfill was paused
+// meanwhile.
+func (bf *Backfill) runChunk(lo, hi int64) (bool, error) {
+	defer writeSlots.acquire(WriteBackground)()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin backfill chunk: %w", err)
+	}
+	defer tx.Rollback()
+
+	n, err := bf.Apply(tx, lo, hi)
+	if err != nil {
+		return false, fmt.Errorf("failed to backfill ids %d-%d: %w", lo, hi, err)
+	}
+	result, err := tx.Exec(`
+		UPDATE backfills SET next_id = $2, processed = processed + $3, updated_at = NOW()
+		WHERE name = $1 AND state = $4
+	`, bf.Name, hi+1, n, BackfillRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to save backfill progress: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit backfill chunk: %w", err)
+	}
+	return true, nil
+}
+
+func (bf *Backfill) fail(cause error) {
+	slog.Error("Backfill failed", "name", bf.Name, "error", cause)
+	if _, err := db.Exec(`
+		UPDATE backfills SET state = $2, error = $3, updated_at = NOW() WHERE name = $1
+	`, bf.Name, BackfillFailed, cause.Error()); err != nil {
+		slog.Error("Failed to record backfill failure", "name", bf.Name, "error", err)
+	}
+}
+
+// ResumeBackfills restarts the backfills that were running when the service
+// last stopped.
+func ResumeBackfills() {
+	for _, bf := range backfillDefs {
+		st, err := loadBackfillStatus(bf)
+		if err != nil {
+			slog.Warn("Backfill not resumed", "error", err)
+			continue
+		}
+		if st.State != BackfillRunning {
+			continue
+		}
+		if err := backfillRuns.start(bf, false, BackfillOptions{}); err != nil {
+			slog.Warn("Backfill not resumed", "name", bf.Name, "error", err)
+		}
+	}
+}
+
+// StopBackfills stops the running backfills after their current chunk. They
+// stay marked running and resume on the next start.
+func StopBackfills() {
+	backfillRuns.mu.Lock()
+	for name, stop := range backfillRuns.stop {
+		close(stop)
+		delete(backfillRuns.stop, name)
+	}
+	backfillRuns.mu.Unlock()
+	backfillRuns.wg.Wait()
+}
+
+func HandleListBackfills(c *gin.Context) {
+	list := make([]*BackfillStatus, 0, len(backfillDefs))
+	for _, bf := range backfillDefs {
+		st, err := loadBackfillStatus(bf)
+		if err != nil {
+			requestLog(c).Error("Error reading backfills", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to read backfills")
+			return
+		}
+		list = append(list, st)
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"backfills": list,
+	})
+}
+
+func backfillParam(c *gin.Context) (*Backfill, bool) {
+	bf, ok := findBackfill(c.Param("name"))
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Backfill %q not found", c.Param("name")))
+	}
+	return bf, ok
+}
+
+func HandleGetBackfill(c *gin.Context) {
+	bf, ok := backfillParam(c)
+	if !ok {
+		return
+	}
+	st, err := loadBackfillStatus(bf)
+	if err != nil {
+		requestLog(c).Error("Error reading backfill", "name", bf.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to read backfill")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{
+		"backfill": st,
+	})
+}
+
+// HandleStartBackfill serves POST /admin/backfills/:name/start?restart=true.
+// The optional body sets chunk_size and rows_per_sec for this run.
+func HandleStartBackfill(c *gin.Context) {
+	bf, ok := backfillParam(c)
+	if !ok {
+		return
+	}
+	var opts BackfillOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if (opts.ChunkSize != nil && *opts.ChunkSize < 1) || (opts.RowsPerSec != nil && *opts.RowsPerSec < 0) {
+		respondError(c, http.StatusBadRequest, "chunk_size must be at least 1 and rows_per_sec at least 0")
+		return
+	}
+
+	err := backfillRuns.start(bf, c.Query("restart") == "true", opts)
+	switch {
+	case errors.Is(err, errBackfillRunning):
+		respondError(c, http.StatusConflict, fmt.Sprintf("Backfill %s is already running", bf.Name))
+		return
+	case errors.Is(err, errBackfillCompleted):
+		respondError(c, http.StatusConflict, fmt.Sprintf("Backfill %s has completed; use restart=true to run it again", bf.Name))
+		return
+	case err != nil:
+		requestLog(c).Error("Error starting backfill", "name", bf.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to start backfill")
+		return
+	}
+
+	st, err := loadBackfillStatus(bf)
+	if err != nil {
+		st = &BackfillStatus{Name: bf.Name, State: BackfillRunning}
+	}
+	respond(c, http.StatusAccepted, gin.H{
+		"backfill": st,
+	})
+}
+
+func HandlePauseBackfill(c *gin.Context) {
+	bf, ok := backfillParam(c)
+	if !ok {
+		return
+	}
+	err := backfillRuns.pause(bf)
+	if errors.Is(err, errBackfillNotActive) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("Backfill %s is not running", bf.Name))
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error pausing backfill", "name", bf.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to pause backfill")
+		return
+	}
+
+	requestLog(c).Info("✓ Paused backfill", "name", bf.Name)
+	respond(c, http.StatusOK, gin.H{
+		"name":  bf.Name,
+		"state": BackfillPaused,
+	})
+}
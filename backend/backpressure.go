@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// writePacer sizes the batches background rating updates are written in,
+// AIMD-style: each batch that finishes under WRITE_BATCH_TARGET_MS without
+// anyone waiting on the connection pool grows the next one by
+// WRITE_BATCH_STEP; a slow batch or new pool waits halve it and pause the
+// writer for as long as the batch took. Interactive reads share the pool, so
+// backing off as soon as they queue keeps simulation from starving them.
+type writePacer struct {
+	mu        sync.Mutex
+	size      int
+	delay     time.Duration
+	waitCount int64
+
+	minSize int
+	maxSize int
+	step    int
+	target  time.Duration
+}
+
+type WritePacerStats struct {
+	BatchSize       int   `json:"batch_size"`
+	DelayMs         int64 `json:"delay_ms"`
+	TargetLatencyMs int64 `json:"target_latency_ms"`
+	PoolWaitCount   int64 `json:"pool_wait_count"`
+}
+
+var ratingWritePacer = newWritePacer()
+
+func newWritePacer() *writePacer {
+	minSize := max(getEnvInt("WRITE_BATCH_MIN", 10), 1)
+	return &writePacer{
+		size:    minSize,
+		minSize: minSize,
+		maxSize: max(getEnvInt("WRITE_BATCH_MAX", 500), minSize),
+		step:    max(getEnvInt("WRITE_BATCH_STEP", 10), 1),
+		target:  time.Duration(getEnvInt("WRITE_BATCH_TARGET_MS", 50)) * time.Millisecond,
+	}
+}
+
+// next returns how many updates to write in the next batch and how long to
+// wait before writing it.
+func (p *writePacer) next() (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, p.delay
+}
+
+// observe adjusts the batch size after a batch took elapsed.
+func (p *writePacer) observe(elapsed time.Duration) {
+	stats := db.Stats()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waited := stats.WaitCount > p.waitCount
+	p.waitCount = stats.WaitCount
+
+	if elapsed > p.target || waited {
+		p.size = max(p.size/2, p.minSize)
+		p.delay = elapsed
+		return
+	}
+	p.size = min(p.size+p.step, p.maxSize)
+	p.delay = 0
+}
+
+func (p *writePacer) Stats() WritePacerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return WritePacerStats{
+		BatchSize:       p.size,
+		DelayMs:         p.delay.Milliseconds(),
+		TargetLatencyMs: p.target.Milliseconds(),
+		PoolWaitCount:   p.waitCount,
+	}
+}
+
+var updateRatingsStmt = &cachedStmt{name: "update_ratings", query: func() string {
+	return `
+		WITH v AS (
+			SELECT * FROM unnest($1::bigint[], $2::int[], $3::int[]) AS v(id, old_rating, rating)
+		), old AS (
+			SELECT u.id, u.username, u.rating FROM users u JOIN v ON v.id = u.id
+		), updated AS (
+			UPDATE users SET rating = v.rating
+			FROM v
+			WHERE users.id = v.id AND (NOT $5 OR users.rating IN (v.old_rating, v.rating))
+			RETURNING users.id, users.in_placement
+		), changes AS (
+			SELECT old.id, old.username, old.rating AS old_rating, v.rating AS new_rating, updated.in_placement
+			FROM old JOIN v ON v.id = old.id JOIN updated ON updated.id = old.id
+			WHERE old.rating <> v.rating
+		), history AS (
+			INSERT INTO rating_history (user_id, old_rating, new_rating, source)
+			SELECT id, old_rating, new_rating, $4 FROM changes
+		), events AS (
+			INSERT INTO outbox (event_type, payload, processed_at)
+			SELECT $6, jsonb_build_object('source', $4::text, 'updates', jsonb_agg(jsonb_build_object(
+				'user_id', id, 'username', username, 'old_rating', old_rating, 'new_rating', new_rating, 'source', $4::text
+			))), NOW()
+			FROM changes
+			WHERE NOT in_placement
+			HAVING COUNT(*) > 0
+			RETURNING id
+		)
+		SELECT old.id, old.rating, (SELECT id FROM events) FROM old JOIN updated ON updated.id = old.id
+	`
+}}
+
+// collapseRatingUpdates merges updates to the same user into one, from the
+// first old rating to the last new rating, keeping first-seen order. An
+// UPDATE ... FROM with a user listed twice would apply an arbitrary one of
+// them.
+func collapseRatingUpdates(updates []RatingUpdate) []RatingUpdate {
+	index := make(map[int64]int, len(updates))
+	collapsed := make([]RatingUpdate, 0, len(updates))
+	for _, u := range updates {
+		i, seen := index[u.UserID]
+		if !seen {
+			index[u.UserID] = len(collapsed)
+			collapsed = append(collapsed, u)
+			continue
+		}
+		collapsed[i].NewRating = u.NewRating
+		if u.Username != "" {
+			collapsed[i].Username = u.Username
+		}
+	}
+	return collapsed
+}
+
+// updateUserRatings writes a batch of rating updates in one statement and
+// records each change in rating_history under source. Old ratings are read
+// from the table rather than the batch, so a batch written twice (a WAL
+// replayed after a failed truncate) records nothing the second time. The
+// changes also go into the outbox as one ratings.updated event, already
+// processed: callers update this instance's engine themselves, and the event
+// is there for replicas.
+func updateUserRatings(updates []RatingUpdate, source string) error {
+	_, _, err := writeRatingUpdates(updates, source, false)
+	return err
+}
+
+// writeRatingUpdates is updateUserRatings for updates computed from a rating
+// read earlier. It returns the collapsed updates that changed a rating, with
+// OldRating as it was in the table. With guarded set a user whose rating is
+// neither the update's OldRating nor already its NewRating (a match was
+// recorded since) is left alone and returned in skipped.
+func writeRatingUpdates(updates []RatingUpdate, source string, guarded bool) (changed, skipped []RatingUpdate, err error) {
+	updates = collapseRatingUpdates(updates)
+	ids := make([]int64, len(updates))
+	olds := make([]int64, len(updates))
+	ratings := make([]int64, len(updates))
+	for i, u := range updates {
+		ids[i] = u.UserID
+		olds[i] = int64(u.OldRating)
+		ratings[i] = int64(u.NewRating)
+	}
+
+	rows, err := updateRatingsStmt.QueryContext(context.Background(), pq.Array(ids), pq.Array(olds), pq.Array(ratings), source, guarded, EventRatingsUpdated)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update user ratings: %w", err)
+	}
+	defer rows.Close()
+
+	written := make(map[int64]int, len(updates))
+	var eventID sql.NullInt64
+	for rows.Next() {
+		var id int64
+		var old int
+		if err := rows.Scan(&id, &old, &eventID); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan updated user: %w", err)
+		}
+		written[id] = old
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to update user ratings: %w", err)
+	}
+	// The engine already has these ratings; reads see them from here on.
+	noteOutboxApplied(eventID.Int64)
+
+	for _, u := range updates {
+		old, ok := written[u.UserID]
+		switch {
+		case !ok:
+			skipped = append(skipped, u)
+		case old != u.NewRating:
+			u.OldRating = old
+			changed = append(changed, u)
+		}
+	}
+	return changed, skipped, nil
+}
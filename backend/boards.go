@@ -0,0 +1,907 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Named leaderboards let one deployment host several boards, e.g. one per
+// game mode. The default board is the users table itself; every other board
+// keeps its ratings in board_ratings and gets its own engine, so
+// /leaderboard, /search, and /simulate take ?board=name. Board writes go
+// through the outbox, which keeps replicas' board engines current.
+//
+// Matches can be recorded on a board too. An elo board scores each match as
+// it arrives with the rating calculator; a glicko2 board holds its matches
+// until the rating period closes (see glicko.go).
+const (
+	DefaultBoard = "default"
+
+	EventBoardRatingUpdated = "board.rating.updated"
+)
+
+const boardsSchema = `
+	CREATE TABLE IF NOT EXISTS leaderboards (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS board_ratings (
+		leaderboard_id INT NOT NULL REFERENCES leaderboards(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		rating INT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (leaderboard_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_board_ratings_board ON board_ratings (leaderboard_id, rating DESC, user_id);
+
+	ALTER TABLE leaderboards ADD COLUMN IF NOT EXISTS algorithm TEXT NOT NULL DEFAULT 'elo';
+
+	CREATE TABLE IF NOT EXISTS board_matches (
+		id BIGSERIAL PRIMARY KEY,
+		leaderboard_id INT NOT NULL REFERENCES leaderboards(id) ON DELETE CASCADE,
+		external_id TEXT NOT NULL,
+		player_a_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		player_b_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		score_a REAL NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (leaderboard_id, external_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_board_matches_player_a ON board_matches (leaderboard_id, player_a_id);
+	CREATE INDEX IF NOT EXISTS idx_board_matches_player_b ON board_matches (leaderboard_id, player_b_id);
+`
+
+type Board struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Algorithm string    `json:"algorithm"`
+	CreatedAt time.Time `json:"created_at"`
+
+	engine   RankEngine
+	handlers *Handlers
+}
+
+type BoardRatingUpdatedEvent struct {
+	Board     string `json:"board"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	OldRating *int   `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+}
+
+type CreateBoardRequest struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Populate  bool   `json:"populate"`
+}
+
+var (
+	boardsMu sync.RWMutex
+	boards   = map[string]*Board{}
+
+	errBoardExists = errors.New("board already exists")
+)
+
+func getBoard(name string) (*Board, bool) {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+	b, ok := boards[strings.ToLower(name)]
+	return b, ok
+}
+
+func hasBoards() bool {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+	return len(boards) > 0
+}
+
+// newBoard builds a board's engine from its rating counts. The SQL engine
+// ranks the users table, so boards use the bucket engine in its place.
+func newBoard(id int, name, algorithm string, createdAt time.Time, counts map[int]int) (*Board, error) {
+	kind := getEnv("RANK_ENGINE", EngineBucket)
+	if kind == EngineSQL {
+		kind = EngineBucket
+	}
+	engine, err := newRankEngine(kind, counts)
+	if err != nil {
+		return nil, err
+	}
+	b := &Board{ID: id, Name: name, Algorithm: algorithm, CreatedAt: createdAt, engine: engine}
+	b.handlers = NewHandlers(boardUserStore{board: id}, boardRankStore{board: b})
+	return b, nil
+}
+
+func boardRatingCounts(q queryer, boardID int) (map[int]int, error) {
+	rows, err := q.Query(`SELECT rating, COUNT(*) FROM board_ratings WHERE leaderboard_id = $1 GROUP BY rating`, boardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get board rating counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var rating, count int
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan board rating count: %w", err)
+		}
+		counts[rating] = count
+	}
+	return counts, rows.Err()
+}
+
+// loadBoards builds every board's engine from q, which lets the replica load
+// them from the same snapshot as its rating engine.
+func loadBoards(q queryer) error {
+	rows, err := q.Query(`SELECT id, name, algorithm, created_at FROM leaderboards ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to load boards: %w", err)
+	}
+	var loaded []*Board
+	for rows.Next() {
+		var b Board
+		if err := rows.Scan(&b.ID, &b.Name, &b.Algorithm, &b.CreatedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan board: %w", err)
+		}
+		loaded = append(loaded, &b)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("error iterating boards: %w", err)
+	}
+
+	boardsMu.Lock()
+	defer boardsMu.Unlock()
+	for _, row := range loaded {
+		counts, err := boardRatingCounts(q, row.ID)
+		if err != nil {
+			return err
+		}
+		b, err := newBoard(row.ID, row.Name, row.Algorithm, row.CreatedAt, counts)
+		if err != nil {
+			return err
+		}
+		boards[b.Name] = b
+		totalUsers, _, _, _ := b.engine.GetStats()
+		slog.Info("✓ Board initialized", "board", b.Name, "total_users", totalUsers)
+	}
+	return nil
+}
+
+// createBoard adds a board, optionally starting every ranked user at their
+// current default-board rating.
+func createBoard(name, algorithm string, populate bool) (*Board, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin board creation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	var createdAt time.Time
+	err = tx.QueryRow(`
+		INSERT INTO leaderboards (name, algorithm) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id, created_at
+	`, name, algorithm).Scan(&id, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errBoardExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create board: %w", err)
+	}
+	if populate {
+		if _, err := tx.Exec(`
+			INSERT INTO board_ratings (leaderboard_id, user_id, rating)
+			SELECT $1, id, rating FROM users WHERE NOT in_placement
+		`, id); err != nil {
+			return nil, fmt.Errorf("failed to populate board: %w", err)
+		}
+	}
+	counts, err := boardRatingCounts(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newBoard(id, name, algorithm, createdAt, counts)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit board creation: %w", err)
+	}
+
+	boardsMu.Lock()
+	boards[name] = b
+	boardsMu.Unlock()
+	return b, nil
+}
+
+func applyBoardEvent(ev BoardRatingUpdatedEvent) {
+	b, ok := getBoard(ev.Board)
+	if !ok {
+		return
+	}
+	if ev.OldRating == nil {
+		b.engine.AddUser(ev.NewRating)
+		return
+	}
+	applyRatingChange(b.engine, ev.Username, *ev.OldRating, ev.NewRating, "board:"+b.Name)
+}
+
+// setBoardRating sets one user's rating on a board, adding them to it if
+// they aren't on it yet. A change to an existing rating is subject to the
+// board's volatility limits.
+func setBoardRating(b *Board, username string, rating int) (*BoardRatingUpdatedEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin board update: %w", err)
+	}
+	defer tx.Rollback()
+
+	ev := BoardRatingUpdatedEvent{Board: b.Name, NewRating: rating}
+	err = tx.QueryRow(`
+		SELECT id, username FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1 FOR UPDATE
+	`, username).Scan(&ev.UserID, &ev.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMatchUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	var old int
+	err = tx.QueryRow(`
+		SELECT rating FROM board_ratings WHERE leaderboard_id = $1 AND user_id = $2
+	`, b.ID, ev.UserID).Scan(&old)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read board rating: %w", err)
+	default:
+		ev.OldRating = &old
+		if err := checkVolatility(tx, b.Name, &User{ID: ev.UserID, Username: ev.Username}, rating-old); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO board_ratings (leaderboard_id, user_id, rating) VALUES ($1, $2, $3)
+		ON CONFLICT (leaderboard_id, user_id) DO UPDATE SET rating = EXCLUDED.rating, updated_at = NOW()
+	`, b.ID, ev.UserID, rating); err != nil {
+		return nil, fmt.Errorf("failed to store board rating: %w", err)
+	}
+	if err := insertOutboxEvent(tx, EventBoardRatingUpdated, ev); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit board update: %w", err)
+	}
+	return &ev, nil
+}
+
+// simulateBoard moves a random sample of the board's users the way the
+// default board's simulation does.
+func simulateBoard(b *Board, count int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin board simulation: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT br.user_id, u.username, br.rating
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1
+		ORDER BY random()
+		LIMIT $2
+		FOR UPDATE OF br SKIP LOCKED
+	`, b.ID, count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select board users: %w", err)
+	}
+	var events []BoardRatingUpdatedEvent
+	for rows.Next() {
+		var ev BoardRatingUpdatedEvent
+		var old int
+		if err := rows.Scan(&ev.UserID, &ev.Username, &old); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan board user: %w", err)
+		}
+		ev.Board, ev.OldRating, ev.NewRating = b.Name, &old, simProfile.nextRating(old)
+		events = append(events, ev)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("error iterating board users: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, len(events))
+	ratings := make([]int64, len(events))
+	for i, ev := range events {
+		ids[i], ratings[i] = ev.UserID, int64(ev.NewRating)
+	}
+	if _, err := tx.Exec(`
+		UPDATE board_ratings br SET rating = v.rating, updated_at = NOW()
+		FROM unnest($2::BIGINT[], $3::INT[]) AS v(user_id, rating)
+		WHERE br.leaderboard_id = $1 AND br.user_id = v.user_id
+	`, b.ID, pq.Array(ids), pq.Array(ratings)); err != nil {
+		return 0, fmt.Errorf("failed to update board ratings: %w", err)
+	}
+	for _, ev := range events {
+		if err := insertOutboxEvent(tx, EventBoardRatingUpdated, ev); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit board simulation: %w", err)
+	}
+	return len(events), nil
+}
+
+// recordBoardMatch records a match on a named board. Players who aren't on
+// the board yet join it at NEW_USER_RATING. An elo board applies the rating
+// change in the same transaction, within the board's volatility limits; on a
+// glicko2 board the result is pending until the rating period closes, so
+// ratings are returned unchanged and there is no change to limit.
+func recordBoardMatch(b *Board, req MatchRequest, scoreA float64) (int64, []MatchPlayerResult, error) {
+	defer writeSlots.acquire(WriteInteractive)()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin board match transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, username FROM users
+		WHERE LOWER(username) IN (LOWER($1), LOWER($2))
+		ORDER BY id
+	`, req.PlayerA, req.PlayerB)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to find match players: %w", err)
+	}
+	var pa, pb *User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan match player: %w", err)
+		}
+		if strings.EqualFold(u.Username, req.PlayerA) {
+			pa = &u
+		} else {
+			pb = &u
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating match players: %w", err)
+	}
+	if pa == nil || pb == nil {
+		return 0, nil, errMatchUserNotFound
+	}
+
+	for _, u := range []*User{pa, pb} {
+		var joined bool
+		err := tx.QueryRow(`
+			INSERT INTO board_ratings (leaderboard_id, user_id, rating) VALUES ($1, $2, $3)
+			ON CONFLICT (leaderboard_id, user_id) DO NOTHING
+			RETURNING TRUE
+		`, b.ID, u.ID, newUserRating).Scan(&joined)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to add match player to board: %w", err)
+		}
+		if err := insertOutboxEvent(tx, EventBoardRatingUpdated, BoardRatingUpdatedEvent{
+			Board:     b.Name,
+			UserID:    u.ID,
+			Username:  u.Username,
+			NewRating: newUserRating,
+		}); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// Lock both rows in id order, like recordMatch.
+	rows, err = tx.Query(`
+		SELECT user_id, rating FROM board_ratings
+		WHERE leaderboard_id = $1 AND user_id IN ($2, $3)
+		ORDER BY user_id
+		FOR UPDATE
+	`, b.ID, pa.ID, pb.ID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to lock board match players: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var rating int
+		if err := rows.Scan(&id, &rating); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan board rating: %w", err)
+		}
+		if id == pa.ID {
+			pa.Rating = rating
+		} else {
+			pb.Rating = rating
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating board ratings: %w", err)
+	}
+	if err := checkMatchRecorded(tx, `
+		SELECT EXISTS (SELECT 1 FROM board_matches WHERE leaderboard_id = $1 AND external_id = $2)
+	`, b.ID, req.MatchID); err != nil {
+		return 0, nil, err
+	}
+
+	newA, newB := pa.Rating, pb.Rating
+	if b.Algorithm != BoardAlgorithmGlicko2 {
+		var gamesA, gamesB int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FILTER (WHERE $2 IN (player_a_id, player_b_id)),
+				COUNT(*) FILTER (WHERE $3 IN (player_a_id, player_b_id))
+			FROM board_matches
+			WHERE leaderboard_id = $1
+		`, b.ID, pa.ID, pb.ID).Scan(&gamesA, &gamesB)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to count board matches: %w", err)
+		}
+		newA, newB, err = ratingCalculator.Calculate(MatchInput{
+			MatchID: req.MatchID,
+			PlayerA: pa.Username,
+			PlayerB: pb.Username,
+			RatingA: pa.Rating,
+			RatingB: pb.Rating,
+			ScoreA:  scoreA,
+			GamesA:  gamesA,
+			GamesB:  gamesB,
+		})
+		if err != nil {
+			return 0, nil, fmt.Errorf("rating calculator %s failed: %w", ratingCalculator.Name(), err)
+		}
+	}
+	limits, err := loadVolatilityLimit(tx, b.Name)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := limits.check(tx, pa, newA-pa.Rating); err != nil {
+		return 0, nil, err
+	}
+	if err := limits.check(tx, pb, newB-pb.Rating); err != nil {
+		return 0, nil, err
+	}
+
+	var matchID int64
+	err = tx.QueryRow(`
+		INSERT INTO board_matches (leaderboard_id, external_id, player_a_id, player_b_id, score_a)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, b.ID, req.MatchID, pa.ID, pb.ID, scoreA).Scan(&matchID)
+	if isUniqueViolation(err) {
+		return 0, nil, errDuplicateMatch
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to insert board match: %w", err)
+	}
+
+	players := []MatchPlayerResult{
+		{Username: pa.Username, OldRating: pa.Rating, NewRating: newA, Delta: newA - pa.Rating},
+		{Username: pb.Username, OldRating: pb.Rating, NewRating: newB, Delta: newB - pb.Rating},
+	}
+	for i, u := range []*User{pa, pb} {
+		p := players[i]
+		if p.Delta == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`
+			UPDATE board_ratings SET rating = $3, updated_at = NOW() WHERE leaderboard_id = $1 AND user_id = $2
+		`, b.ID, u.ID, p.NewRating); err != nil {
+			return 0, nil, fmt.Errorf("failed to update board rating: %w", err)
+		}
+		if err := limits.note(tx, u.ID, p.Delta); err != nil {
+			return 0, nil, err
+		}
+		if err := insertOutboxEvent(tx, EventBoardRatingUpdated, BoardRatingUpdatedEvent{
+			Board:     b.Name,
+			UserID:    u.ID,
+			Username:  u.Username,
+			OldRating: &p.OldRating,
+			NewRating: p.NewRating,
+		}); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit board match: %w", err)
+	}
+	return matchID, players, nil
+}
+
+func handleBoardMatch(c *gin.Context, req MatchRequest, scoreA float64) {
+	b, ok := getBoard(req.Board)
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Board %q not found", req.Board))
+		return
+	}
+
+	matchID, players, err := recordBoardMatch(b, req, scoreA)
+	if errors.Is(err, errDuplicateMatch) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("Match %s has already been recorded on board %s", req.MatchID, b.Name))
+		return
+	}
+	if errors.Is(err, errCalculatorUnavailable) {
+		requestLog(c).Error("Error recording match", "match_id", req.MatchID, "board", b.Name, "error", err)
+		respondError(c, http.StatusServiceUnavailable, "Rating calculator unavailable, please retry")
+		return
+	}
+	if errors.Is(err, errMatchUserNotFound) {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	var limited *VolatilityError
+	if errors.As(err, &limited) {
+		c.Error(err)
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error recording match", "player_a", req.PlayerA, "player_b", req.PlayerB, "board", b.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to record match")
+		return
+	}
+
+	outboxRelay.Flush()
+
+	updated := 0
+	for i := range players {
+		if players[i].Delta != 0 {
+			updated++
+		}
+		players[i].Rank = b.engine.GetRank(players[i].NewRating)
+	}
+	meterRatingUpdates(c, updated)
+
+	pending := b.Algorithm == BoardAlgorithmGlicko2
+	status := http.StatusCreated
+	if pending {
+		status = http.StatusAccepted
+	}
+	respond(c, status, MatchResponse{
+		Success: true,
+		ID:      matchID,
+		MatchID: req.MatchID,
+		Board:   b.Name,
+		Outcome: req.Outcome,
+		Pending: pending,
+		Players: players,
+	})
+}
+
+// boardService picks the read service for the ?board= parameter, writing a
+// 404 itself for an unknown board.
+func (h *Handlers) boardService(c *gin.Context) (*LeaderboardService, bool) {
+	name := c.Query("board")
+	if name == "" || strings.EqualFold(name, DefaultBoard) {
+		return h.publicService(c), true
+	}
+	b, ok := getBoard(name)
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Board %q not found", name))
+		return nil, false
+	}
+	return b.handlers.Service, true
+}
+
+func handleBoardSimulation(c *gin.Context, name string) {
+	b, ok := getBoard(name)
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Board %q not found", name))
+		return
+	}
+
+	var req SimulateUserRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.Username != "" {
+		if req.NewRating < MinRating || req.NewRating > MaxRating {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Rating must be between %d and %d", MinRating, MaxRating))
+			return
+		}
+		release := writeSlots.acquire(WriteInteractive)
+		ev, err := setBoardRating(b, req.Username, req.NewRating)
+		release()
+		if errors.Is(err, errMatchUserNotFound) {
+			respondError(c, http.StatusNotFound, "User not found")
+			return
+		}
+		var limited *VolatilityError
+		if errors.As(err, &limited) {
+			c.Error(err)
+			return
+		}
+		if err != nil {
+			requestLog(c).Error("Error updating rating", "username", req.Username, "board", b.Name, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to update rating")
+			return
+		}
+		outboxRelay.Flush()
+		requestLog(c).Info("✓ Updated rating", "username", ev.Username, "board", b.Name, "new_rating", ev.NewRating)
+		meterRatingUpdates(c, 1)
+		respond(c, http.StatusOK, SimulateResponse{
+			Success: true,
+			Message: "Rating updated successfully",
+			Updated: 1,
+		})
+		return
+	}
+
+	release := writeSlots.acquire(WriteBackground)
+	updated, err := simulateBoard(b, 50)
+	release()
+	if err != nil {
+		requestLog(c).Error("Error simulating board", "board", b.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to start simulation")
+		return
+	}
+	outboxRelay.Flush()
+	meterRatingUpdates(c, updated)
+
+	message := "Rating simulation complete"
+	if updated == 0 {
+		message = "No users available to simulate"
+	}
+	respond(c, http.StatusOK, SimulateResponse{
+		Success: true,
+		Message: message,
+		Updated: updated,
+	})
+}
+
+func HandleListBoards(c *gin.Context) {
+	boardsMu.RLock()
+	list := make([]gin.H, 0, len(boards))
+	for _, b := range boards {
+		totalUsers, _, _, _ := b.engine.GetStats()
+		list = append(list, gin.H{
+			"id":          b.ID,
+			"name":        b.Name,
+			"algorithm":   b.Algorithm,
+			"created_at":  b.CreatedAt,
+			"total_users": totalUsers,
+		})
+	}
+	boardsMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i]["id"].(int) < list[j]["id"].(int) })
+
+	respond(c, http.StatusOK, gin.H{
+		"default": DefaultBoard,
+		"boards":  list,
+	})
+}
+
+// validateBoard normalizes a new board's name and algorithm, which defaults
+// to elo.
+func validateBoard(name, algorithm string) (string, string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if !metricNamePattern.MatchString(normalized) || normalized == DefaultBoard {
+		return "", "", fmt.Errorf("Invalid board name %q: use lowercase letters, digits, and underscores", name)
+	}
+	if algorithm == "" {
+		algorithm = BoardAlgorithmElo
+	}
+	if algorithm != BoardAlgorithmElo && algorithm != BoardAlgorithmGlicko2 {
+		return "", "", fmt.Errorf("algorithm must be %s or %s", BoardAlgorithmElo, BoardAlgorithmGlicko2)
+	}
+	return normalized, algorithm, nil
+}
+
+func HandleCreateBoard(c *gin.Context) {
+	var req CreateBoardRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		respondError(c, http.StatusBadRequest, "name is required")
+		return
+	}
+	name, algorithm, err := validateBoard(req.Name, req.Algorithm)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b, err := createBoard(name, algorithm, req.Populate)
+	if errors.Is(err, errBoardExists) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("Board %s already exists", name))
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error creating board", "name", name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create board")
+		return
+	}
+
+	totalUsers, _, _, _ := b.engine.GetStats()
+	requestLog(c).Info("✓ Created board", "board", b.Name, "algorithm", b.Algorithm, "total_users", totalUsers)
+	respond(c, http.StatusCreated, gin.H{
+		"board":       b,
+		"total_users": totalUsers,
+	})
+}
+
+// boardUserStore is the UserStore for a named board: users joined to their
+// rating on it.
+type boardUserStore struct {
+	board int
+}
+
+func (s boardUserStore) TopUsers(ctx context.Context, limit, offset int) ([]User, error) {
+	return s.query(ctx, `
+		SELECT u.id, u.username, br.rating
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1
+		ORDER BY br.rating DESC, `+collateUsername("u.username")+` ASC, u.id ASC
+		LIMIT $2 OFFSET $3
+	`, s.board, limit, offset)
+}
+
+func (s boardUserStore) TopUsersAfter(ctx context.Context, after Cursor, limit int) ([]User, error) {
+	return s.query(ctx, `
+		SELECT u.id, u.username, br.rating
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1
+			AND br.rating <= $2 AND (br.rating < $2 OR br.rating = $2 AND (`+collateUsername("u.username")+` > $3 OR u.username = $3 AND u.id > $4))
+		ORDER BY br.rating DESC, `+collateUsername("u.username")+` ASC, u.id ASC
+		LIMIT $5
+	`, s.board, after.Rating, after.Username, after.ID, limit)
+}
+
+func (s boardUserStore) TopUsersBefore(ctx context.Context, before Cursor, limit int) ([]User, error) {
+	return s.query(ctx, `
+		SELECT u.id, u.username, br.rating
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1
+			AND br.rating >= $2 AND (br.rating > $2 OR br.rating = $2 AND (`+collateUsername("u.username")+` < $3 OR u.username = $3 AND u.id < $4))
+		ORDER BY br.rating ASC, `+collateUsername("u.username")+` DESC, u.id DESC
+		LIMIT $5
+	`, s.board, before.Rating, before.Username, before.ID, limit)
+}
+
+func (s boardUserStore) SearchUsers(ctx context.Context, term string, limit, offset int) ([]User, bool, error) {
+	return searchUsers(ctx, searchQuery{
+		Match: `
+			SELECT u.id, u.username, br.rating
+			FROM board_ratings br
+			JOIN users u ON u.id = br.user_id
+			WHERE br.leaderboard_id = $1 AND u.username ILIKE $2
+		`,
+		Order:  `rating DESC, ` + collateUsername("username") + ` ASC, id ASC`,
+		Args:   []any{s.board, "%" + term + "%"},
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+func (s boardUserStore) UserByUsername(ctx context.Context, username string) (*User, error) {
+	users, err := s.query(ctx, `
+		SELECT u.id, u.username, br.rating
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1 AND LOWER(u.username) = LOWER($2)
+		LIMIT 1
+	`, s.board, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("user not found on board: %s", username)
+	}
+	return &users[0], nil
+}
+
+func (s boardUserStore) query(ctx context.Context, query string, args ...any) ([]User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query board users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err != nil {
+			return nil, fmt.Errorf("failed to scan board user row: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+type boardRankStore struct {
+	board *Board
+}
+
+func (s boardRankStore) GetRank(rating int) int {
+	return s.board.engine.GetRank(rating)
+}
+
+func (s boardRankStore) FillRanks(rows []UserWithRank) {
+	s.board.engine.FillRanks(rows)
+}
+
+func (s boardRankStore) Rebuilding() bool {
+	return false
+}
+
+type BoardUserResponse struct {
+	Success   bool   `json:"success"`
+	Board     string `json:"board"`
+	Algorithm string `json:"algorithm"`
+	Username  string `json:"username"`
+	Rating    int    `json:"rating"`
+	Rank      int    `json:"rank"`
+	// Glicko-2 boards only.
+	Deviation      *float64 `json:"deviation,omitempty"`
+	Volatility     *float64 `json:"volatility,omitempty"`
+	PendingMatches *int     `json:"pending_matches,omitempty"`
+}
+
+// HandleBoardUser serves GET /leaderboards/:name/users/:username. On a
+// glicko2 board it adds the rating deviation and volatility, and how many of
+// the user's matches wait for the period to close.
+func HandleBoardUser(c *gin.Context) {
+	b, ok := getBoard(c.Param("name"))
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Board %q not found", c.Param("name")))
+		return
+	}
+
+	resp := BoardUserResponse{Success: true, Board: b.Name, Algorithm: b.Algorithm}
+	var deviation, volatility float64
+	var pending int
+	err := db.QueryRowContext(c.Request.Context(), `
+		SELECT u.username, br.rating, br.deviation, br.volatility,
+			(SELECT COUNT(*) FROM board_matches m
+			 WHERE m.leaderboard_id = br.leaderboard_id AND m.period_id IS NULL
+				AND u.id IN (m.player_a_id, m.player_b_id))
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1 AND LOWER(u.username) = LOWER($2)
+		LIMIT 1
+	`, b.ID, c.Param("username")).Scan(&resp.Username, &resp.Rating, &deviation, &volatility, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("User not found on board %s", b.Name))
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error fetching board rating", "username", c.Param("username"), "board", b.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+
+	resp.Rank = b.engine.GetRank(resp.Rating)
+	if b.Algorithm == BoardAlgorithmGlicko2 {
+		resp.Deviation, resp.Volatility, resp.PendingMatches = &deviation, &volatility, &pending
+	}
+	respond(c, http.StatusOK, resp)
+}
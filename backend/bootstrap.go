@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BootstrapManifest is the declarative configuration applied at startup from
+// BOOTSTRAP_MANIFEST, so deployment pipelines can configure the service
+// without admin API calls. It has the sections of a configuration bundle (see
+// configimport.go), so an export can serve as a manifest. Applying it is
+// idempotent: tiers replace the in-memory table before the database is opened,
+// and boards, API keys, and webhooks are upserted once it is.
+type BootstrapManifest struct {
+	ConfigBundle
+}
+
+// bootstrapManifest holds the parsed manifest until its stored sections are
+// applied.
+var bootstrapManifest *BootstrapManifest
+
+// ApplyBootstrapManifest loads and validates the manifest, if one is
+// configured, and applies its tiers. Unknown sections are rejected so a typo
+// fails the deploy instead of being silently ignored.
+func ApplyBootstrapManifest() error {
+	path := getEnv("BOOTSTRAP_MANIFEST", "")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m BootstrapManifest
+	if err := dec.Decode(&m); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if m.Tiers != nil {
+		defaultTiers = m.Tiers
+	}
+	bootstrapManifest = &m
+
+	slog.Info("✓ Applied bootstrap manifest", "path", path, "tiers", len(defaultTiers))
+	return nil
+}
+
+// ApplyBootstrapResources upserts the manifest's boards, API keys, and
+// webhooks. It runs on the primary once boards are loaded and before webhooks
+// start; replicas get them through the shared database.
+func ApplyBootstrapResources() error {
+	if bootstrapManifest == nil || isReplica() {
+		return nil
+	}
+	m := bootstrapManifest.ConfigBundle
+	if m.Boards == nil && m.APIKeys == nil && m.Webhooks == nil {
+		return nil
+	}
+	m.Tiers = nil
+
+	result, err := applyConfigBundle(m)
+	if err != nil {
+		return err
+	}
+	slog.Info("✓ Applied bootstrap resources",
+		"boards_created", len(result.BoardsCreated), "api_keys", result.APIKeys,
+		"webhooks_created", len(result.WebhooksCreated), "webhooks_updated", result.WebhooksUpdated)
+	return nil
+}
+
+// validateTiers checks a tier table and orders it from highest to lowest.
+// Every tier is cut by min_rating, or every tier by min_percentile.
+func validateTiers(tiers []Tier) ([]Tier, error) {
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("tiers must not be empty")
+	}
+	byPercentile := tiers[0].MinPercentile != nil
+	for _, t := range tiers {
+		if (t.MinPercentile != nil) != byPercentile {
+			return nil, fmt.Errorf("tiers must all use min_rating or all use min_percentile")
+		}
+	}
+	if byPercentile {
+		return validatePercentileTiers(tiers)
+	}
+
+	sorted := append([]Tier(nil), tiers...)
+	sort.Slice(sorted, func(i, k int) bool { return sorted[i].MinRating > sorted[k].MinRating })
+
+	names := map[string]bool{}
+	for i, t := range sorted {
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" {
+			return nil, fmt.Errorf("tier names must not be empty")
+		}
+		if names[strings.ToLower(t.Name)] {
+			return nil, fmt.Errorf("duplicate tier %q", t.Name)
+		}
+		names[strings.ToLower(t.Name)] = true
+		if i > 0 && t.MinRating == sorted[i-1].MinRating {
+			return nil, fmt.Errorf("tiers %q and %q share min_rating %d", sorted[i-1].Name, t.Name, t.MinRating)
+		}
+		if t.MinRating < MinRating || t.MinRating > MaxRating {
+			return nil, fmt.Errorf("tier %q min_rating %d is outside the rating bounds [%d, %d]", t.Name, t.MinRating, MinRating, MaxRating)
+		}
+		sorted[i] = t
+	}
+	return sorted, nil
+}
+
+// validatePercentileTiers checks a percentile table. Its cutoffs are resolved
+// at run time, so MinRating is only a placeholder until then.
+func validatePercentileTiers(tiers []Tier) ([]Tier, error) {
+	sorted := append([]Tier(nil), tiers...)
+	sort.Slice(sorted, func(i, k int) bool { return *sorted[i].MinPercentile > *sorted[k].MinPercentile })
+
+	names := map[string]bool{}
+	for i, t := range sorted {
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" {
+			return nil, fmt.Errorf("tier names must not be empty")
+		}
+		if names[strings.ToLower(t.Name)] {
+			return nil, fmt.Errorf("duplicate tier %q", t.Name)
+		}
+		names[strings.ToLower(t.Name)] = true
+		p := *t.MinPercentile
+		if i > 0 && p == *sorted[i-1].MinPercentile {
+			return nil, fmt.Errorf("tiers %q and %q share min_percentile %g", sorted[i-1].Name, t.Name, p)
+		}
+		if p < 0 || p >= 100 {
+			return nil, fmt.Errorf("tier %q min_percentile %g must be at least 0 and below 100", t.Name, p)
+		}
+		t.MinRating = MinRating
+		sorted[i] = t
+	}
+	if *sorted[len(sorted)-1].MinPercentile != 0 {
+		return nil, fmt.Errorf("the lowest tier must have min_percentile 0 so every user has a tier")
+	}
+	return sorted, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Seeded and simulated users are tagged is_bot so demo data can share a
+// database with real accounts. The public leaderboard hides them unless
+// ?include_bots=true, and ranks real users among real users only: the engine
+// still counts everyone, and the bots rated above a user, counted in memory
+// by botRanks, are subtracted.
+//
+// Users already in the database when the column is added can't be told apart,
+// so they stay visible; flag them with an UPDATE if they are demo data.
+const botSchema = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+	DROP INDEX IF EXISTS idx_users_bot_rating;
+`
+
+func includeBots(c *gin.Context) bool {
+	return c.Query("include_bots") == "true"
+}
+
+// publicService picks the default board's service for a request.
+func (h *Handlers) publicService(c *gin.Context) *LeaderboardService {
+	if h.Humans == nil || includeBots(c) {
+		return h.Service
+	}
+	return h.Humans
+}
+
+// humanUserStore lists only real users. Lookups by name still find bots, so
+// a bot's page shows where it would place among real users.
+type humanUserStore struct{}
+
+var topHumansStmt = &cachedStmt{name: "top_humans", query: func() string {
+	return `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement AND NOT is_bot
+		ORDER BY rating DESC, ` + collateUsername("username") + ` ASC, id ASC
+		LIMIT $1 OFFSET $2
+	`
+}}
+
+func (humanUserStore) TopUsers(ctx context.Context, limit, offset int) ([]User, error) {
+	rows, err := topHumansStmt.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	return scanUsers(rows)
+}
+
+func (humanUserStore) TopUsersAfter(ctx context.Context, after Cursor, limit int) ([]User, error) {
+	return queryUsers(ctx, `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement AND NOT is_bot
+			AND rating <= $1 AND (rating < $1 OR rating = $1 AND (`+collateUsername("username")+` > $2 OR username = $2 AND id > $3))
+		ORDER BY rating DESC, `+collateUsername("username")+` ASC, id ASC
+		LIMIT $4
+	`, after.Rating, after.Username, after.ID, limit)
+}
+
+func (humanUserStore) TopUsersBefore(ctx context.Context, before Cursor, limit int) ([]User, error) {
+	return queryUsers(ctx, `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement AND NOT is_bot
+			AND rating >= $1 AND (rating > $1 OR rating = $1 AND (`+collateUsername("username")+` < $2 OR username = $2 AND id < $3))
+		ORDER BY rating ASC, `+collateUsername("username")+` DESC, id DESC
+		LIMIT $4
+	`, before.Rating, before.Username, before.ID, limit)
+}
+
+func (humanUserStore) SearchUsers(ctx context.Context, term string, limit, offset int) ([]User, bool, error) {
+	return searchUsers(ctx, searchQuery{
+		Match: `
+			SELECT id, username, rating
+			FROM users
+			WHERE username ILIKE $1 AND NOT in_placement AND NOT is_bot
+		`,
+		Order:  `rating DESC, ` + collateUsername("username") + ` ASC, id ASC`,
+		Args:   []any{"%" + term + "%"},
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+func (humanUserStore) UserByUsername(ctx context.Context, username string) (*User, error) {
+	return GetUserByUsernameContext(ctx, username)
+}
+
+func queryUsers(ctx context.Context, query string, args ...any) ([]User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	return scanUsers(rows)
+}
+
+func scanUsers(rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// humanRankStore ranks against real users by taking the engine's rank and
+// subtracting the ranked bots rated strictly higher.
+type humanRankStore struct{}
+
+func (humanRankStore) GetRank(rating int) int {
+	rank := GetRankingEngine().GetRank(rating)
+	if rank < 0 {
+		return rank
+	}
+	return rank - bots.above([]int{rating})[0]
+}
+
+func (humanRankStore) FillRanks(rows []UserWithRank) {
+	fillRanks(rows)
+
+	ratings := make([]int, len(rows))
+	for i := range rows {
+		ratings[i] = rows[i].Rating
+	}
+	above := bots.above(ratings)
+	for i := range rows {
+		if rows[i].Rank > 0 {
+			rows[i].Rank -= above[i]
+		}
+	}
+}
+
+func (humanRankStore) Rebuilding() bool {
+	return engineRebuilding.Load()
+}
+
+// botRanks counts the ranked bots by rating in an engine of their own, so a
+// real user's rank among real users is two engine reads. It follows the main
+// engine: every change made there to a user whose name belongs to a bot is
+// made here too.
+type botRanks struct {
+	mu     sync.RWMutex
+	names  map[string]bool
+	engine *FenwickEngine
+}
+
+var bots = &botRanks{names: map[string]bool{}, engine: NewFenwickEngine(nil)}
+
+// loadBots replaces the bot counts with the bots in the users table, read
+// alongside the main engine's counts.
+func loadBots(q queryer) error {
+	rows, err := q.Query(`SELECT username, rating, in_placement FROM users WHERE is_bot`)
+	if err != nil {
+		return fmt.Errorf("failed to load bots: %w", err)
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	counts := map[int]int{}
+	for rows.Next() {
+		var username string
+		var rating int
+		var inPlacement bool
+		if err := rows.Scan(&username, &rating, &inPlacement); err != nil {
+			return fmt.Errorf("failed to scan bot: %w", err)
+		}
+		names[strings.ToLower(username)] = true
+		if !inPlacement {
+			counts[rating]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating bots: %w", err)
+	}
+
+	bots.mu.Lock()
+	defer bots.mu.Unlock()
+	bots.names = names
+	bots.engine = NewFenwickEngine(counts)
+	return nil
+}
+
+// register marks a new user as a bot before they reach the engine.
+func (b *botRanks) register(username string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.names[strings.ToLower(username)] = true
+}
+
+// botEngine returns the bot engine if username is a bot.
+func (b *botRanks) botEngine(username string) *FenwickEngine {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.names[strings.ToLower(username)] {
+		return nil
+	}
+	return b.engine
+}
+
+func (b *botRanks) add(username string, rating int) {
+	if e := b.botEngine(username); e != nil {
+		e.AddUser(rating)
+	}
+}
+
+// remove takes a deleted user out; ranked says whether they were counted.
+func (b *botRanks) remove(username string, rating int, ranked bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := strings.ToLower(username)
+	if !b.names[key] {
+		return
+	}
+	delete(b.names, key)
+	if ranked {
+		b.engine.RemoveUser(rating)
+	}
+}
+
+func (b *botRanks) update(username string, oldRating, newRating int) {
+	if e := b.botEngine(username); e != nil {
+		e.UpdateRating(oldRating, newRating)
+	}
+}
+
+func (b *botRanks) updateBatch(updates []RatingUpdate) {
+	var moved []RatingUpdate
+	b.mu.RLock()
+	for _, u := range updates {
+		if b.names[strings.ToLower(u.Username)] {
+			moved = append(moved, u)
+		}
+	}
+	e := b.engine
+	b.mu.RUnlock()
+	if len(moved) > 0 {
+		e.BatchUpdateRatings(moved)
+	}
+}
+
+// above counts, for each rating, the ranked bots rated strictly higher.
+func (b *botRanks) above(ratings []int) []int {
+	b.mu.RLock()
+	e := b.engine
+	b.mu.RUnlock()
+
+	counts := make([]int, len(ratings))
+	for i, r := range ratings {
+		counts[i] = e.GetRank(r) - 1
+	}
+	return counts
+}
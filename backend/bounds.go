@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ratingBoundsSampleSize = 10
+
+// Matches pg_get_constraintdef output such as
+// CHECK (((rating >= 100) AND (rating <= 5000))).
+var ratingConstraintPattern = regexp.MustCompile(`rating >= (-?\d+)\).*rating <= (-?\d+)\)`)
+
+type RatingBounds struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+type RatingBoundsReport struct {
+	Success    bool          `json:"success"`
+	Database   *RatingBounds `json:"database"`
+	Configured RatingBounds  `json:"configured"`
+	Proposed   RatingBounds  `json:"proposed"`
+	TotalUsers int           `json:"total_users"`
+	BelowMin   int           `json:"below_min"`
+	AboveMax   int           `json:"above_max"`
+	Violating  int           `json:"violating"`
+	Sample     []User        `json:"sample"`
+}
+
+func validateRatingBounds() error {
+	if MinRating < 0 {
+		return fmt.Errorf("RATING_MIN must be >= 0, got %d", MinRating)
+	}
+	if MaxRating <= MinRating {
+		return fmt.Errorf("RATING_MAX (%d) must be greater than RATING_MIN (%d)", MaxRating, MinRating)
+	}
+	return nil
+}
+
+// currentRatingConstraint returns the bounds enforced by users_rating_check,
+// or nil if the constraint is missing or not in the expected shape.
+func currentRatingConstraint() (*RatingBounds, error) {
+	var def string
+	err := db.QueryRow(`
+		SELECT pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE conrelid = 'users'::regclass AND conname = 'users_rating_check'
+	`).Scan(&def)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rating constraint: %w", err)
+	}
+
+	m := ratingConstraintPattern.FindStringSubmatch(def)
+	if m == nil {
+		return nil, nil
+	}
+	min, _ := strconv.Atoi(m[1])
+	max, _ := strconv.Atoi(m[2])
+	return &RatingBounds{Min: min, Max: max}, nil
+}
+
+func countRatingViolations(bounds RatingBounds) (below int, above int, err error) {
+	err = db.QueryRow(`
+		SELECT
+			COUNT(*) FILTER (WHERE rating < $1),
+			COUNT(*) FILTER (WHERE rating > $2)
+		FROM users
+	`, bounds.Min, bounds.Max).Scan(&below, &above)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count rating violations: %w", err)
+	}
+	return below, above, nil
+}
+
+// syncRatingConstraint rewrites users_rating_check when RATING_MIN/RATING_MAX
+// differ from what the database enforces. It refuses to migrate while rows
+// would violate the new bounds, rather than silently clamping player data.
+func syncRatingConstraint() error {
+	current, err := currentRatingConstraint()
+	if err != nil {
+		return err
+	}
+
+	want := RatingBounds{Min: MinRating, Max: MaxRating}
+	if current != nil && *current == want {
+		return nil
+	}
+
+	below, above, err := countRatingViolations(want)
+	if err != nil {
+		return err
+	}
+	if below+above > 0 {
+		return fmt.Errorf("cannot apply rating bounds %d-%d: %d users below and %d above (see GET /admin/rating-bounds)",
+			want.Min, want.Max, below, above)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin rating constraint migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_rating_check`); err != nil {
+		return fmt.Errorf("failed to drop rating constraint: %w", err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf(
+		`ALTER TABLE users ADD CONSTRAINT users_rating_check CHECK (rating BETWEEN %d AND %d)`,
+		want.Min, want.Max,
+	)); err != nil {
+		return fmt.Errorf("failed to add rating constraint: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rating constraint migration: %w", err)
+	}
+
+	if current != nil {
+		slog.Info("✓ Rating constraint migrated", "from_min", current.Min, "from_max", current.Max, "min", want.Min, "max", want.Max)
+	} else {
+		slog.Info("✓ Rating constraint set", "min", want.Min, "max", want.Max)
+	}
+	return nil
+}
+
+// HandleRatingBoundsReport is a dry run: it reports how many rows would
+// violate the proposed bounds (?min=&max=, defaulting to the configured ones)
+// without changing anything.
+func HandleRatingBoundsReport(c *gin.Context) {
+	proposed := RatingBounds{
+		Min: parseIntParam(c.Query("min"), MinRating),
+		Max: parseIntParam(c.Query("max"), MaxRating),
+	}
+	if proposed.Min < 0 || proposed.Max <= proposed.Min {
+		respondError(c, http.StatusBadRequest, "min must be >= 0 and less than max")
+		return
+	}
+
+	current, err := currentRatingConstraint()
+	if err != nil {
+		requestLog(c).Error("Error reading rating constraint", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to read rating constraint")
+		return
+	}
+
+	below, above, err := countRatingViolations(proposed)
+	if err != nil {
+		requestLog(c).Error("Error counting rating violations", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to check rating bounds")
+		return
+	}
+
+	total, err := GetTotalUserCount()
+	if err != nil {
+		requestLog(c).Error("Error counting users", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to check rating bounds")
+		return
+	}
+
+	sample := []User{}
+	if below+above > 0 {
+		rows, err := db.Query(`
+			SELECT id, username, rating
+			FROM users
+			WHERE rating < $1 OR rating > $2
+			ORDER BY rating DESC
+			LIMIT $3
+		`, proposed.Min, proposed.Max, ratingBoundsSampleSize)
+		if err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var u User
+				if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err == nil {
+					sample = append(sample, u)
+				}
+			}
+		}
+	}
+
+	respond(c, http.StatusOK, RatingBoundsReport{
+		Success:    true,
+		Database:   current,
+		Configured: RatingBounds{Min: MinRating, Max: MaxRating},
+		Proposed:   proposed,
+		TotalUsers: total,
+		BelowMin:   below,
+		AboveMax:   above,
+		Violating:  below + above,
+		Sample:     sample,
+	})
+}
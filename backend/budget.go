@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errBudgetExhausted = errors.New("request deadline budget exhausted")
+
+// Deadline budgets: REQUEST_TIMEOUT_MS bounds a read request end to end, and
+// once BUDGET_THRESHOLD of it is spent the remaining optional steps (rank
+// enrichment, encoding) are skipped. BUDGET_PARTIAL chooses between returning
+// unranked rows flagged "partial" and failing with 504.
+var (
+	requestTimeout  = time.Duration(getEnvInt("REQUEST_TIMEOUT_MS", 0)) * time.Millisecond
+	budgetThreshold = getEnvFloat("BUDGET_THRESHOLD", 0.8)
+	budgetPartial   = getEnv("BUDGET_PARTIAL", "false") == "true"
+)
+
+type budgetKey struct{}
+
+type deadlineBudget struct {
+	start time.Time
+	total time.Duration
+}
+
+// withBudget attaches a deadline and records the budget it came from, so
+// later layers can tell how much of it is left.
+func withBudget(ctx context.Context, total time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, budgetKey{}, deadlineBudget{start: time.Now(), total: total})
+	return context.WithTimeout(ctx, total)
+}
+
+// budgetSpent reports the fraction of the request's budget already used, or
+// 0 when the request has no budget.
+func budgetSpent(ctx context.Context) float64 {
+	b, ok := ctx.Value(budgetKey{}).(deadlineBudget)
+	if !ok || b.total <= 0 {
+		return 0
+	}
+	return float64(time.Since(b.start)) / float64(b.total)
+}
+
+func budgetExhausted(ctx context.Context) bool {
+	return budgetSpent(ctx) >= budgetThreshold
+}
+
+// budgetMiddleware gives each request on the route a deadline budget. It is
+// applied to bounded read routes only; streams must not inherit a deadline.
+func budgetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requestTimeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := withBudget(c.Request.Context(), requestTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// isBudgetError reports whether err came from running out of request budget,
+// either at a checkpoint or inside the database driver.
+func isBudgetError(err error) bool {
+	return errors.Is(err, errBudgetExhausted) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func writeBudgetTimeout(c *gin.Context) {
+	respondError(c, http.StatusGatewayTimeout, "Request exceeded its deadline")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CalculatorElo        = "elo"
+	CalculatorFixedDelta = "fixed"
+)
+
+// MatchInput is what a calculator sees for one head-to-head result.
+// ScoreA is 1 for a win by A, 0.5 for a draw, and 0 for a loss.
+type MatchInput struct {
+	MatchID string
+	PlayerA string
+	PlayerB string
+	RatingA int
+	RatingB int
+	ScoreA  float64
+
+	// Matches each player had completed before this one.
+	GamesA int
+	GamesB int
+}
+
+type RatingCalculator interface {
+	Name() string
+	Calculate(in MatchInput) (newA int, newB int, err error)
+}
+
+var calculatorFactories = map[string]func() RatingCalculator{
+	CalculatorElo: func() RatingCalculator {
+		return loadEloCalculator()
+	},
+	CalculatorFixedDelta: func() RatingCalculator {
+		return FixedDeltaCalculator{Delta: getEnvInt("FIXED_DELTA", 25)}
+	},
+}
+
+var ratingCalculator RatingCalculator
+
+func InitRatingCalculator() error {
+	calc, err := newRatingCalculator(getEnv("RATING_CALCULATOR", CalculatorElo))
+	if err != nil {
+		return err
+	}
+
+	if name := getEnv("RATING_CALCULATOR_CANDIDATE", ""); name != "" {
+		candidate, err := newRatingCalculator(name)
+		if err != nil {
+			return fmt.Errorf("RATING_CALCULATOR_CANDIDATE: %w", err)
+		}
+		calc = NewRolloutCalculator(calc, candidate, getEnvInt("RATING_ROLLOUT_PERCENT", 0))
+	}
+
+	ratingCalculator = calc
+	slog.Info("✓ Rating calculator", "calculator", calc.Name())
+	return nil
+}
+
+func newRatingCalculator(name string) (RatingCalculator, error) {
+	factory, ok := calculatorFactories[name]
+	if !ok {
+		names := make([]string, 0, len(calculatorFactories))
+		for n := range calculatorFactories {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown rating calculator %q (available: %v)", name, names)
+	}
+	return factory(), nil
+}
+
+// KStep applies K to players rated at or above MinRating.
+type KStep struct {
+	MinRating int
+	K         float64
+}
+
+// EloCalculator is tuned the way chess federations tune Elo: a higher K while
+// a player is provisional, a K schedule that shrinks with rating, a floor
+// nobody drops below, and optional per-tier caps on a single match's change.
+type EloCalculator struct {
+	K                float64
+	ProvisionalK     float64
+	ProvisionalGames int
+	Schedule         []KStep
+	Floor            int
+	TierCaps         map[string]int
+}
+
+func loadEloCalculator() EloCalculator {
+	e := EloCalculator{
+		K:                getEnvFloat("ELO_K_FACTOR", 32),
+		ProvisionalK:     getEnvFloat("ELO_PROVISIONAL_K", 40),
+		ProvisionalGames: getEnvInt("ELO_PROVISIONAL_GAMES", 20),
+		Floor:            getEnvInt("RATING_FLOOR", MinRating),
+		TierCaps:         map[string]int{},
+	}
+
+	for _, entry := range parseKeyValueList(getEnv("ELO_K_SCHEDULE", "")) {
+		rating, err1 := strconv.Atoi(entry[0])
+		k, err2 := strconv.ParseFloat(entry[1], 64)
+		if err1 != nil || err2 != nil || k <= 0 {
+			slog.Warn("Ignoring ELO_K_SCHEDULE entry", "entry", entry[0]+":"+entry[1])
+			continue
+		}
+		e.Schedule = append(e.Schedule, KStep{MinRating: rating, K: k})
+	}
+	sort.Slice(e.Schedule, func(i, j int) bool { return e.Schedule[i].MinRating > e.Schedule[j].MinRating })
+
+	for _, entry := range parseKeyValueList(getEnv("ELO_TIER_CAPS", "")) {
+		limit, err := strconv.Atoi(entry[1])
+		if err != nil || limit < 0 {
+			slog.Warn("Ignoring ELO_TIER_CAPS entry", "entry", entry[0]+":"+entry[1])
+			continue
+		}
+		e.TierCaps[entry[0]] = limit
+	}
+
+	if e.Floor < MinRating {
+		e.Floor = MinRating
+	}
+	return e
+}
+
+func (e EloCalculator) Name() string { return CalculatorElo }
+
+func (e EloCalculator) kFor(rating, games int) float64 {
+	if games < e.ProvisionalGames {
+		return e.ProvisionalK
+	}
+	for _, step := range e.Schedule {
+		if rating >= step.MinRating {
+			return step.K
+		}
+	}
+	return e.K
+}
+
+func (e EloCalculator) adjust(rating int, delta int) int {
+	if limit, ok := e.TierCaps[tierForRating(rating)]; ok {
+		if delta > limit {
+			delta = limit
+		} else if delta < -limit {
+			delta = -limit
+		}
+	}
+
+	// A loss can't push anyone below the floor, and players already under it
+	// (e.g. after the floor was raised) simply stop dropping.
+	newRating := clampRating(rating + delta)
+	if delta < 0 && newRating < e.Floor {
+		newRating = min(rating, e.Floor)
+	}
+	return newRating
+}
+
+// Each player uses their own K, so the two deltas need not cancel out.
+func (e EloCalculator) Calculate(in MatchInput) (int, int, error) {
+	expectedA := 1 / (1 + math.Pow(10, float64(in.RatingB-in.RatingA)/400))
+	deltaA := int(math.Round(e.kFor(in.RatingA, in.GamesA) * (in.ScoreA - expectedA)))
+	deltaB := int(math.Round(e.kFor(in.RatingB, in.GamesB) * (expectedA - in.ScoreA)))
+	return e.adjust(in.RatingA, deltaA), e.adjust(in.RatingB, deltaB), nil
+}
+
+// parseKeyValueList parses "a:1,b:2" into pairs, skipping malformed entries.
+func parseKeyValueList(value string) [][2]string {
+	var pairs [][2]string
+	for _, item := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || k == "" || v == "" {
+			continue
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(k), strings.TrimSpace(v)})
+	}
+	return pairs
+}
+
+// FixedDeltaCalculator moves the winner up and the loser down by the same
+// amount regardless of ratings; draws change nothing.
+type FixedDeltaCalculator struct {
+	Delta int
+}
+
+func (f FixedDeltaCalculator) Name() string { return CalculatorFixedDelta }
+
+func (f FixedDeltaCalculator) Calculate(in MatchInput) (int, int, error) {
+	switch {
+	case in.ScoreA > 0.5:
+		return clampRating(in.RatingA + f.Delta), clampRating(in.RatingB - f.Delta), nil
+	case in.ScoreA < 0.5:
+		return clampRating(in.RatingA - f.Delta), clampRating(in.RatingB + f.Delta), nil
+	default:
+		return in.RatingA, in.RatingB, nil
+	}
+}
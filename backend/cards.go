@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Cards are drawn at half size with the 7x13 bitmap font and scaled up 2x,
+// which keeps the text crisp without shipping a TTF in the binary.
+const (
+	DefaultCardTop = 10
+	MaxCardTop     = 25
+
+	cardScale      = 2
+	cardBaseWidth  = 320
+	cardBaseHeight = 168
+	cardLineHeight = 16
+	cardPadding    = 10
+)
+
+var (
+	cardBackground = color.RGBA{R: 0x15, G: 0x17, B: 0x1c, A: 0xff}
+	cardForeground = color.RGBA{R: 0xec, G: 0xed, B: 0xee, A: 0xff}
+	cardMuted      = color.RGBA{R: 0x9b, G: 0xa1, B: 0xa6, A: 0xff}
+
+	tierColors = map[string]color.RGBA{
+		"Grandmaster": {R: 0xe0, G: 0x4f, B: 0x5f, A: 0xff},
+		"Diamond":     {R: 0x5d, G: 0xc8, B: 0xf0, A: 0xff},
+		"Platinum":    {R: 0x6f, G: 0xd6, B: 0xb8, A: 0xff},
+		"Gold":        {R: 0xf2, G: 0xc1, B: 0x4e, A: 0xff},
+		"Silver":      {R: 0xc0, G: 0xc6, B: 0xcc, A: 0xff},
+		"Bronze":      {R: 0xcd, G: 0x7f, B: 0x32, A: 0xff},
+	}
+)
+
+// tierColor falls back to the foreground for tiers from a bootstrap manifest.
+func tierColor(tier string) color.RGBA {
+	if c, ok := tierColors[tier]; ok {
+		return c
+	}
+	return cardForeground
+}
+
+type cardLine struct {
+	text  string
+	color color.RGBA
+}
+
+func (h *Handlers) HandleLeaderboardCard(c *gin.Context) {
+	top := parseIntParam(c.Query("top"), DefaultCardTop)
+	if top < 1 {
+		top = DefaultCardTop
+	}
+	if top > MaxCardTop {
+		top = MaxCardTop
+	}
+
+	rows, err := h.Service.Top(c.Request.Context(), top)
+	if err != nil {
+		requestLog(c).Error("Error fetching leaderboard card", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+
+	lines := []cardLine{
+		{text: fmt.Sprintf("TOP %d LEADERBOARD", top), color: cardForeground},
+		{text: "", color: cardMuted},
+	}
+	for _, row := range rows {
+		tier := tierForRating(row.Rating)
+		lines = append(lines, cardLine{
+			text:  fmt.Sprintf("#%-5d %-16s %5d %s", row.Rank, truncateCardText(row.Username, 16), row.Rating, tier),
+			color: tierColor(tier),
+		})
+	}
+
+	height := cardPadding*2 + cardLineHeight*len(lines)
+	if height < cardBaseHeight {
+		height = cardBaseHeight
+	}
+	writeCard(c, renderCard(lines, height))
+}
+
+func (h *Handlers) HandleUserCard(c *gin.Context) {
+	username := c.Param("username")
+
+	user, rank, err := h.Service.User(c.Request.Context(), username)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	tier := tierForRating(user.Rating)
+
+	rankText := fmt.Sprintf("Rank    #%d", rank)
+	if user.InPlacement {
+		rankText = fmt.Sprintf("Rank    placing %d/%d", user.PlacementGames, PlacementGames)
+	}
+
+	lines := []cardLine{
+		{text: truncateCardText(user.Username, 40), color: cardForeground},
+		{text: "", color: cardMuted},
+		{text: rankText, color: cardForeground},
+		{text: fmt.Sprintf("Rating  %d", user.Rating), color: cardForeground},
+		{text: fmt.Sprintf("Tier    %s", tier), color: tierColor(tier)},
+	}
+	writeCard(c, renderCard(lines, cardBaseHeight))
+}
+
+func renderCard(lines []cardLine, height int) image.Image {
+	base := image.NewRGBA(image.Rect(0, 0, cardBaseWidth, height))
+	draw.Draw(base, base.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: base, Face: basicfont.Face7x13}
+	for i, line := range lines {
+		d.Src = image.NewUniform(line.color)
+		d.Dot = fixed.P(cardPadding, cardPadding+cardLineHeight*(i+1)-3)
+		d.DrawString(line.text)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, cardBaseWidth*cardScale, height*cardScale))
+	draw.NearestNeighbor.Scale(scaled, scaled.Bounds(), base, base.Bounds(), draw.Src, nil)
+	return scaled
+}
+
+func writeCard(c *gin.Context, img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		requestLog(c).Error("Error encoding card", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to render card")
+		return
+	}
+
+	// Short max-age so embeds refresh while still absorbing crawler bursts.
+	c.Header("Cache-Control", "public, max-age=60")
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+func truncateCardText(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "~"
+}
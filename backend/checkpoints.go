@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Engine checkpoints let the primary boot without scanning users. A trigger
+// logs every change to a ranked rating (from any write path) into
+// rating_log, tagged with its transaction id. Every
+// ENGINE_CHECKPOINT_INTERVAL_SEC the rating counts are stored together with
+// the database snapshot they were read under; at startup the engine loads the
+// latest checkpoint and applies the logged changes that snapshot couldn't see.
+// Counts are commutative, so the result doesn't depend on replay order.
+const engineCheckpointSchema = `
+	CREATE TABLE IF NOT EXISTS engine_checkpoints (
+		id BIGSERIAL PRIMARY KEY,
+		taken_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		snapshot TEXT NOT NULL,
+		ratings INT[] NOT NULL,
+		counts INT[] NOT NULL,
+		total_users INT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS rating_log (
+		id BIGSERIAL PRIMARY KEY,
+		xid XID8 NOT NULL DEFAULT pg_current_xact_id(),
+		old_rating INT,
+		new_rating INT
+	);
+
+	-- NULL on either side means the user wasn't ranked (absent or placing).
+	CREATE OR REPLACE FUNCTION log_rating_change() RETURNS trigger AS $$
+	DECLARE
+		old_ranked INT;
+		new_ranked INT;
+	BEGIN
+		IF TG_OP IN ('UPDATE', 'DELETE') AND NOT OLD.in_placement THEN
+			old_ranked := OLD.rating;
+		END IF;
+		IF TG_OP IN ('UPDATE', 'INSERT') AND NOT NEW.in_placement THEN
+			new_ranked := NEW.rating;
+		END IF;
+		IF old_ranked IS DISTINCT FROM new_ranked THEN
+			INSERT INTO rating_log (old_rating, new_rating) VALUES (old_ranked, new_ranked);
+		END IF;
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;
+`
+
+const engineCheckpointsKept = 2
+
+type EngineCheckpointer struct {
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	engineCheckpointInterval = time.Duration(getEnvInt("ENGINE_CHECKPOINT_INTERVAL_SEC", 300)) * time.Second
+
+	engineCheckpointer *EngineCheckpointer
+)
+
+// PrepareEngineCheckpoints installs or removes the rating_log trigger. It
+// must run before the engine loads. Checkpoints taken while the trigger was
+// missing can't be replayed correctly, so they are discarded whenever the
+// trigger is (re)installed or removed.
+func PrepareEngineCheckpoints() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin checkpoint setup: %w", err)
+	}
+	defer tx.Rollback()
+
+	var installed bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'users_rating_log' AND tgrelid = 'users'::regclass)
+	`).Scan(&installed); err != nil {
+		return fmt.Errorf("failed to check rating log trigger: %w", err)
+	}
+
+	enabled := engineCheckpointInterval > 0
+	if installed == enabled {
+		return nil
+	}
+	if enabled {
+		_, err = tx.Exec(`
+			CREATE TRIGGER users_rating_log
+			AFTER INSERT OR DELETE OR UPDATE OF rating, in_placement ON users
+			FOR EACH ROW EXECUTE FUNCTION log_rating_change()
+		`)
+	} else {
+		_, err = tx.Exec(`DROP TRIGGER users_rating_log ON users`)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update rating log trigger: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM engine_checkpoints`); err != nil {
+		return fmt.Errorf("failed to discard engine checkpoints: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM rating_log`); err != nil {
+		return fmt.Errorf("failed to clear rating log: %w", err)
+	}
+	return tx.Commit()
+}
+
+// loadCheckpointCounts rebuilds the rating counts from the latest checkpoint
+// plus the changes logged since. ok is false when there is no checkpoint.
+func loadCheckpointCounts() (counts map[int]int, ok bool, err error) {
+	if engineCheckpointInterval <= 0 {
+		return nil, false, nil
+	}
+
+	var id int64
+	var snapshot string
+	var ratings, values pq.Int64Array
+	err = db.QueryRow(`
+		SELECT id, snapshot, ratings, counts FROM engine_checkpoints ORDER BY id DESC LIMIT 1
+	`).Scan(&id, &snapshot, &ratings, &values)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load engine checkpoint: %w", err)
+	}
+
+	counts = make(map[int]int, len(ratings))
+	for i, rating := range ratings {
+		counts[int(rating)] = int(values[i])
+	}
+
+	rows, err := db.Query(`
+		SELECT old_rating, new_rating FROM rating_log
+		WHERE NOT pg_visible_in_snapshot(xid, $1::pg_snapshot)
+	`, snapshot)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load rating log: %w", err)
+	}
+	defer rows.Close()
+
+	replayed := 0
+	for rows.Next() {
+		var oldRating, newRating sql.NullInt64
+		if err := rows.Scan(&oldRating, &newRating); err != nil {
+			return nil, false, fmt.Errorf("failed to scan rating log: %w", err)
+		}
+		if oldRating.Valid {
+			if counts[int(oldRating.Int64)]--; counts[int(oldRating.Int64)] <= 0 {
+				delete(counts, int(oldRating.Int64))
+			}
+		}
+		if newRating.Valid {
+			counts[int(newRating.Int64)]++
+		}
+		replayed++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating rating log: %w", err)
+	}
+
+	slog.Info("✓ Loaded engine checkpoint", "id", id, "replayed", replayed)
+	return counts, true, nil
+}
+
+func StartEngineCheckpointer() {
+	if engineCheckpointInterval <= 0 || isReplica() {
+		slog.Info("Engine checkpoints disabled")
+		return
+	}
+
+	engineCheckpointer = &EngineCheckpointer{
+		interval: engineCheckpointInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go engineCheckpointer.run()
+	slog.Info("✓ Engine checkpoints started", "interval", engineCheckpointInterval)
+}
+
+func StopEngineCheckpointer() {
+	if engineCheckpointer == nil {
+		return
+	}
+	close(engineCheckpointer.stop)
+	<-engineCheckpointer.done
+}
+
+func (c *EngineCheckpointer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	c.checkpoint()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.checkpoint()
+		}
+	}
+}
+
+func (c *EngineCheckpointer) checkpoint() {
+	if err := takeEngineCheckpoint(); err != nil {
+		slog.Error("Engine checkpoint failed", "error", err)
+	}
+}
+
+// takeEngineCheckpoint reads the counts and the snapshot they came from in
+// one repeatable-read transaction, then prunes log rows every kept
+// checkpoint already reflects.
+func takeEngineCheckpoint() error {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return fmt.Errorf("failed to begin checkpoint transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var snapshot string
+	if err := tx.QueryRow(`SELECT pg_current_snapshot()::text`).Scan(&snapshot); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	counts, err := getRatingCounts(tx)
+	if err != nil {
+		return err
+	}
+
+	ratings := make([]int64, 0, len(counts))
+	values := make([]int64, 0, len(counts))
+	total := 0
+	for rating, count := range counts {
+		ratings = append(ratings, int64(rating))
+		values = append(values, int64(count))
+		total += count
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO engine_checkpoints (snapshot, ratings, counts, total_users) VALUES ($1, $2, $3, $4)
+	`, snapshot, pq.Array(ratings), pq.Array(values), total); err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit checkpoint: %w", err)
+	}
+
+	if _, err := db.Exec(`
+		DELETE FROM engine_checkpoints
+		WHERE id NOT IN (SELECT id FROM engine_checkpoints ORDER BY id DESC LIMIT $1)
+	`, engineCheckpointsKept); err != nil {
+		return fmt.Errorf("failed to prune engine checkpoints: %w", err)
+	}
+	if _, err := db.Exec(`
+		DELETE FROM rating_log
+		WHERE pg_visible_in_snapshot(xid, (SELECT snapshot FROM engine_checkpoints ORDER BY id LIMIT 1)::pg_snapshot)
+	`); err != nil {
+		return fmt.Errorf("failed to prune rating log: %w", err)
+	}
+	return nil
+}
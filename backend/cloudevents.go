@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Stream events can be sent as CloudEvents 1.0 in structured JSON mode, for
+// consumers (Knative, EventBridge, and the like) that route on the envelope:
+// /events?format=cloudevents, or a webhook subscription's format. type is
+// the event type under the "leaderboard." prefix, source is CLOUDEVENTS_SOURCE,
+// dataschema names the event schema version the data follows, and subject is
+// the user a rank_change is about.
+const (
+	EventFormatNative      = "native"
+	EventFormatCloudEvents = "cloudevents"
+
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventsTypePrefix  = "leaderboard."
+)
+
+var (
+	cloudEventsSource = getEnv("CLOUDEVENTS_SOURCE", "/leaderboard")
+
+	eventFormats = []string{EventFormatNative, EventFormatCloudEvents}
+)
+
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// newCloudEvent wraps data already encoded for schema version (0 = latest).
+func newCloudEvent(id, eventType string, at time.Time, version int, subject string, data json.RawMessage) CloudEvent {
+	if version == 0 {
+		version = eventSchemaVersion
+	}
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          cloudEventsSource,
+		Type:            cloudEventsTypePrefix + eventType,
+		Subject:         subject,
+		Time:            at,
+		DataContentType: "application/json",
+		DataSchema:      eventSchemaID(EventChannelStream, eventType, version),
+		Data:            data,
+	}
+}
+
+func eventSubject(payload any) string {
+	if e, ok := payload.(RankChangeEvent); ok {
+		return e.Username
+	}
+	return ""
+}
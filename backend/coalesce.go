@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RankCoalescer batches rank lookups from concurrent requests into a single
+// GetRankBatch call. The first request to arrive opens a window; everything
+// that arrives before it closes shares one engine pass, so the engine's lock
+// and the bucket engine's cumulative scan are paid once per window instead of
+// once per request.
+type RankCoalescer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending []*rankRequest
+
+	batches  atomic.Int64
+	requests atomic.Int64
+}
+
+type rankRequest struct {
+	rows []UserWithRank
+	done chan struct{}
+}
+
+type CoalescerStats struct {
+	WindowMicros int64   `json:"window_us"`
+	Batches      int64   `json:"batches"`
+	Requests     int64   `json:"requests"`
+	AvgBatch     float64 `json:"avg_batch"`
+}
+
+var rankCoalescer = newRankCoalescer()
+
+func newRankCoalescer() *RankCoalescer {
+	window := time.Duration(getEnvInt("RANK_COALESCE_WINDOW_US", 0)) * time.Microsecond
+	if window <= 0 {
+		return nil
+	}
+	slog.Info("Rank reads coalesced", "window", window)
+	return &RankCoalescer{window: window}
+}
+
+// fillRanks is what handlers call: it goes through the coalescer when one is
+// configured and straight to the engine otherwise.
+func fillRanks(rows []UserWithRank) {
+	if rankCoalescer == nil || len(rows) == 0 {
+		GetRankingEngine().FillRanks(rows)
+	} else {
+		rankCoalescer.FillRanks(rows)
+	}
+	fillTiers(rows)
+}
+
+func (rc *RankCoalescer) FillRanks(rows []UserWithRank) {
+	req := &rankRequest{rows: rows, done: make(chan struct{})}
+
+	rc.mu.Lock()
+	rc.pending = append(rc.pending, req)
+	if len(rc.pending) == 1 {
+		time.AfterFunc(rc.window, rc.flush)
+	}
+	rc.mu.Unlock()
+
+	<-req.done
+}
+
+func (rc *RankCoalescer) flush() {
+	rc.mu.Lock()
+	batch := rc.pending
+	rc.pending = nil
+	rc.mu.Unlock()
+
+	n := 0
+	for _, req := range batch {
+		n += len(req.rows)
+	}
+	ratings := make([]int, 0, n)
+	for _, req := range batch {
+		for _, row := range req.rows {
+			ratings = append(ratings, row.Rating)
+		}
+	}
+
+	ranks := GetRankingEngine().GetRankBatch(ratings)
+
+	i := 0
+	for _, req := range batch {
+		for j := range req.rows {
+			req.rows[j].Rank = ranks[i]
+			i++
+		}
+		close(req.done)
+	}
+
+	rc.batches.Add(1)
+	rc.requests.Add(int64(len(batch)))
+}
+
+func (rc *RankCoalescer) Stats() CoalescerStats {
+	stats := CoalescerStats{
+		WindowMicros: rc.window.Microseconds(),
+		Batches:      rc.batches.Load(),
+		Requests:     rc.requests.Load(),
+	}
+	if stats.Batches > 0 {
+		stats.AvgBatch = float64(stats.Requests) / float64(stats.Batches)
+	}
+	return stats
+}
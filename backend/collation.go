@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Usernames order ties on every board, in cursors, and in the few lists
+// sorted in memory. USERNAME_COLLATION picks that order once for all of them:
+// "bytes" (the default) compares UTF-8 bytes, and a BCP 47 tag such as "und",
+// "de", or "sv" uses ICU collation for that locale. With
+// USERNAME_COLLATION_NUMERIC, digit runs compare by value, so "player2" sorts
+// before "player10".
+//
+// SQL names the collation explicitly rather than relying on the database
+// default, which depends on how the cluster was initialized.
+type usernameCollator struct {
+	// sqlName is the Postgres collation, quoted for use after COLLATE.
+	sqlName string
+	locale  string
+
+	mu       sync.Mutex
+	collator *collate.Collator
+}
+
+var usernameCollation = &usernameCollator{sqlName: `"C"`}
+
+// InitUsernameCollation runs after InitDB. An ICU collation is created on
+// the primary under a name derived from its locale; replicas only check that
+// it has arrived.
+func InitUsernameCollation() error {
+	name := strings.TrimSpace(getEnv("USERNAME_COLLATION", "bytes"))
+	numeric := getEnv("USERNAME_COLLATION_NUMERIC", "false") == "true"
+	if name == "bytes" || name == "C" {
+		if numeric {
+			return fmt.Errorf("USERNAME_COLLATION_NUMERIC needs an ICU USERNAME_COLLATION")
+		}
+		slog.Info("✓ Username collation: bytes")
+		return nil
+	}
+
+	tag, err := language.Parse(name)
+	if err != nil {
+		return fmt.Errorf("USERNAME_COLLATION %q is neither bytes nor a BCP 47 tag: %w", name, err)
+	}
+	if numeric {
+		if tag, err = tag.SetTypeForKey("kn", "true"); err != nil {
+			return fmt.Errorf("USERNAME_COLLATION %q: %w", name, err)
+		}
+	}
+	// Canonical tags are letters, digits, and hyphens, so they are safe to
+	// splice into the statement below.
+	locale := tag.String()
+	sqlName := `"username-` + locale + `"`
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_collation WHERE collname = $1)`,
+		"username-"+locale).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up collation %s: %w", sqlName, err)
+	}
+	if !exists {
+		if isReplica() {
+			return fmt.Errorf("collation %s has not been created by the primary yet", sqlName)
+		}
+		if _, err := db.Exec(`CREATE COLLATION IF NOT EXISTS ` + sqlName + ` (provider = icu, locale = '` + locale + `')`); err != nil {
+			return fmt.Errorf("failed to create collation %s (does the server have ICU?): %w", sqlName, err)
+		}
+	}
+
+	usernameCollation = &usernameCollator{
+		sqlName:  sqlName,
+		locale:   locale,
+		collator: collate.New(tag),
+	}
+	slog.Info("✓ Username collation: ICU", "locale", locale)
+	return nil
+}
+
+// collateUsername returns col with the configured collation, for ORDER BY
+// clauses and the cursor comparisons that must agree with them.
+func collateUsername(col string) string {
+	return col + ` COLLATE ` + usernameCollation.sqlName
+}
+
+// compareUsernames orders usernames as the database does under the
+// configured collation.
+func compareUsernames(a, b string) int {
+	u := usernameCollation
+	if u.collator == nil {
+		return strings.Compare(a, b)
+	}
+	// A Collator keeps scratch buffers and isn't safe for concurrent use.
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if r := u.collator.CompareString(a, b); r != 0 {
+		return r
+	}
+	// ICU collations created by Postgres are deterministic: strings that
+	// collate equal fall back to byte order.
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// The binary serves by default. The other subcommands manage the database
+// directly and exit, for operators who would rather not go through the HTTP
+// admin endpoints:
+//
+//	./leaderboard seed -count 50000
+//	./leaderboard clear -yes
+//	./leaderboard rebuild-engine
+//	./leaderboard export -format csv -out leaderboard.csv
+//
+// A running service only learns about writes made through its own
+// endpoints, so seed and clear are meant for a stopped service; the next
+// start loads the engine from what they left.
+const commandUsage = `Usage: leaderboard [command] [flags]
+
+Commands:
+  serve           Run the HTTP service (the default)
+  seed            Seed an empty database with bots (-count)
+  clear           Delete every user (-yes)
+  rebuild-engine  Recount ratings from users and store a fresh engine checkpoint
+  export          Write the ranked leaderboard as CSV or JSON lines (-format, -out, -include-bots)
+`
+
+var errCommandUsage = errors.New("invalid usage")
+
+// runCommand runs the subcommand named by args and reports its exit code.
+// ok is false for serve, which main carries on with.
+func runCommand(args []string) (code int, ok bool) {
+	if len(args) == 0 || args[0] == "serve" {
+		return 0, false
+	}
+
+	var run func([]string) error
+	switch args[0] {
+	case "seed":
+		run = runSeedCommand
+	case "clear":
+		run = runClearCommand
+	case "rebuild-engine":
+		run = runRebuildEngineCommand
+	case "export":
+		run = runExportCommand
+	case "help", "-h", "-help", "--help":
+		fmt.Print(commandUsage)
+		return 0, true
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], commandUsage)
+		return 2, true
+	}
+
+	if err := run(args[1:]); err != nil {
+		if errors.Is(err, errCommandUsage) {
+			return 2, true
+		}
+		slog.Error("Command failed", "command", args[0], "error", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// openCommandDB validates the settings the data depends on and connects,
+// creating the schema on a primary like the service does.
+func openCommandDB(writes bool) error {
+	if err := validateRatingBounds(); err != nil {
+		return fmt.Errorf("invalid rating bounds: %w", err)
+	}
+	if err := validateDeploymentMode(); err != nil {
+		return fmt.Errorf("invalid deployment mode: %w", err)
+	}
+	if writes && isReplica() {
+		return fmt.Errorf("a replica's database is read-only; run this against the primary")
+	}
+	if err := InitDB(); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	if err := InitUsernameCollation(); err != nil {
+		CloseDB()
+		return fmt.Errorf("invalid username collation: %w", err)
+	}
+	return nil
+}
+
+func parseCommandFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return errCommandUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return errCommandUsage
+	}
+	return nil
+}
+
+// runSeedCommand seeds like a blocking startup seed, and likewise leaves a
+// database that already has users alone.
+func runSeedCommand(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := fs.Int("count", getEnvInt("SEED_COUNT", 10000), "bots to seed")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "-count must be positive")
+		return errCommandUsage
+	}
+
+	if err := openCommandDB(true); err != nil {
+		return err
+	}
+	defer CloseDB()
+	return SeedUsersWithTransaction(*count)
+}
+
+func runClearCommand(args []string) error {
+	fs := flag.NewFlagSet("clear", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm deleting every user, with their history and matches")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if !*yes {
+		fmt.Fprintln(os.Stderr, "clear deletes every user, with their history and matches; pass -yes to confirm")
+		return errCommandUsage
+	}
+
+	if err := openCommandDB(true); err != nil {
+		return err
+	}
+	defer CloseDB()
+	return ClearAllUsers()
+}
+
+// runRebuildEngineCommand recounts the ranked ratings and stores them as an
+// engine checkpoint, so the next start doesn't replay the rating log
+// accumulated since the last one. A running server keeps its own engine;
+// POST /admin/engine/rebuild rebuilds that one.
+func runRebuildEngineCommand(args []string) error {
+	fs := flag.NewFlagSet("rebuild-engine", flag.ContinueOnError)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if engineCheckpointInterval <= 0 {
+		return errors.New("engine checkpoints are disabled (ENGINE_CHECKPOINT_INTERVAL_SEC=0), so there is nothing to store: " +
+			"the next start loads the engine from the users table, and POST /admin/engine/rebuild rebuilds a running server's engine")
+	}
+
+	if err := openCommandDB(true); err != nil {
+		return err
+	}
+	defer CloseDB()
+
+	counts, err := GetRatingCounts()
+	if err != nil {
+		return err
+	}
+	kind := getEnv("RANK_ENGINE", EngineBucket)
+	engine, err := buildRankingEngine(kind, counts)
+	if err != nil {
+		return err
+	}
+	if err := PrepareEngineCheckpoints(); err != nil {
+		return err
+	}
+	if err := takeEngineCheckpoint(); err != nil {
+		return err
+	}
+	totalUsers, _, _, _ := engine.GetStats()
+	slog.Info("✓ Stored engine checkpoint", "engine", kind, "total_users", totalUsers, "unique_ratings", len(counts))
+	return nil
+}
+
+// runExportCommand writes the ranked users in leaderboard order, real users
+// only unless -include-bots, as /leaderboard does. Ranks are 1 + the users
+// listed above with a strictly higher rating; players in placement have no
+// rank and are left out.
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", ExportCSV, "csv, or json or ndjson for one object per line")
+	out := fs.String("out", "", "file to write instead of stdout")
+	includeBots := fs.Bool("include-bots", false, "include bots and rank against everyone, like ?include_bots=true")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if *format == "json" {
+		*format = ExportNDJSON
+	}
+	if *format != ExportCSV && *format != ExportNDJSON {
+		fmt.Fprintf(os.Stderr, "unknown -format %q, use csv or json\n", *format)
+		return errCommandUsage
+	}
+
+	if err := openCommandDB(false); err != nil {
+		return err
+	}
+	defer CloseDB()
+
+	if *out == "" {
+		n, err := exportLeaderboard(context.Background(), os.Stdout, *format, *includeBots)
+		if err != nil {
+			return err
+		}
+		slog.Info("✓ Exported leaderboard", "users", n, "format", *format)
+		return nil
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", *out, err)
+	}
+	n, err := exportLeaderboard(context.Background(), f, *format, *includeBots)
+	// A failed close can lose buffered data, so it fails the export too.
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", *out, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("✓ Exported leaderboard", "users", n, "format", *format, "out", *out)
+	return nil
+}
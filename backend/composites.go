@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Composite leaderboards rank a formula over rating and stored metrics,
+// declared in COMPOSITES as name=formula pairs separated by ";", e.g.
+// "power=0.7*rating + 0.3*kills". Formulas support numbers, + - * /,
+// parentheses, and division by constants; a missing metric counts as 0.
+//
+// The formulas are compiled into one trigger function on users and
+// user_metrics, so every write path keeps composite values current: the
+// trigger stores the rounded value as a metric row and queues a
+// metric.updated outbox event, which moves the user in the composite's
+// engine like any other metric. Users in placement have no composite values.
+const compositeSchema = `
+	CREATE TABLE IF NOT EXISTS composite_definitions (
+		name TEXT PRIMARY KEY,
+		formula TEXT NOT NULL
+	);
+`
+
+// compositeMaxRange caps the engine size a formula's value range may need.
+const compositeMaxRange = 10_000_000
+
+// compositeTerm is a parsed (sub)formula: its SQL and the range of values it
+// can take given the bounds of its inputs.
+type compositeTerm struct {
+	sql      string
+	lo, hi   float64
+	constant bool
+}
+
+type compositeParser struct {
+	formula string
+	pos     int
+	inputs  map[string]bool
+}
+
+func parseComposites(raw string) error {
+	for _, item := range strings.Split(raw, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, formula, ok := strings.Cut(item, "=")
+		name, formula = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(formula)
+		if !ok || formula == "" {
+			return fmt.Errorf("composite %q must be name=formula", strings.TrimSpace(item))
+		}
+		if !metricNamePattern.MatchString(name) || name == MetricRating {
+			return fmt.Errorf("invalid composite name %q", name)
+		}
+		if _, dup := metricDefs[name]; dup {
+			return fmt.Errorf("composite %q clashes with another metric", name)
+		}
+
+		p := &compositeParser{formula: formula, inputs: map[string]bool{}}
+		term, err := p.parse()
+		if err != nil {
+			return fmt.Errorf("composite %s: %w", name, err)
+		}
+		lo, hi := int(math.Round(term.lo)), int(math.Round(term.hi))
+		if hi-lo+1 > compositeMaxRange {
+			return fmt.Errorf("composite %s spans %d values, more than the %d an engine can hold", name, hi-lo+1, compositeMaxRange)
+		}
+		metricDefs[name] = MetricDef{Name: name, Min: lo, Max: hi, Formula: formula}
+		compositeSQL[name] = term.sql
+		for input := range p.inputs {
+			compositeInputs[input] = true
+		}
+	}
+	return nil
+}
+
+var (
+	compositeSQL    = map[string]string{}
+	compositeInputs = map[string]bool{}
+)
+
+func (p *compositeParser) parse() (compositeTerm, error) {
+	term, err := p.sum()
+	if err != nil {
+		return term, err
+	}
+	if p.skipSpace(); p.pos < len(p.formula) {
+		return term, fmt.Errorf("unexpected %q at position %d", p.formula[p.pos], p.pos+1)
+	}
+	return term, nil
+}
+
+func (p *compositeParser) skipSpace() {
+	for p.pos < len(p.formula) && p.formula[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *compositeParser) peek() byte {
+	if p.skipSpace(); p.pos < len(p.formula) {
+		return p.formula[p.pos]
+	}
+	return 0
+}
+
+func (p *compositeParser) sum() (compositeTerm, error) {
+	left, err := p.product()
+	if err != nil {
+		return left, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return left, err
+		}
+		if op == '+' {
+			left = compositeTerm{sql: left.sql + " + " + right.sql, lo: left.lo + right.lo, hi: left.hi + right.hi}
+		} else {
+			left = compositeTerm{sql: left.sql + " - " + right.sql, lo: left.lo - right.hi, hi: left.hi - right.lo}
+		}
+	}
+	return left, nil
+}
+
+func (p *compositeParser) product() (compositeTerm, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return left, err
+		}
+		if op == '/' {
+			// A divisor that can be zero has no bounded range.
+			if !right.constant || right.lo == 0 {
+				return left, fmt.Errorf("can only divide by a non-zero constant")
+			}
+			right.lo, right.hi = 1/right.lo, 1/right.hi
+		}
+		a, b, c, d := left.lo*right.lo, left.lo*right.hi, left.hi*right.lo, left.hi*right.hi
+		left = compositeTerm{
+			sql: left.sql + " " + string(op) + " " + right.sql,
+			lo:  min(a, b, c, d),
+			hi:  max(a, b, c, d),
+		}
+	}
+	return left, nil
+}
+
+func (p *compositeParser) unary() (compositeTerm, error) {
+	if p.peek() == '-' {
+		p.pos++
+		term, err := p.unary()
+		return compositeTerm{sql: "-(" + term.sql + ")", lo: -term.hi, hi: -term.lo, constant: term.constant}, err
+	}
+	return p.primary()
+}
+
+func (p *compositeParser) primary() (compositeTerm, error) {
+	c := p.peek()
+	start := p.pos
+	switch {
+	case c == '(':
+		p.pos++
+		term, err := p.sum()
+		if err != nil {
+			return term, err
+		}
+		if p.peek() != ')' {
+			return term, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		term.sql = "(" + term.sql + ")"
+		return term, nil
+
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.formula) && (p.formula[p.pos] >= '0' && p.formula[p.pos] <= '9' || p.formula[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.formula[start:p.pos], 64)
+		if err != nil {
+			return compositeTerm{}, fmt.Errorf("invalid number %q", p.formula[start:p.pos])
+		}
+		// numeric keeps integer inputs out of integer division.
+		return compositeTerm{sql: p.formula[start:p.pos] + "::numeric", lo: value, hi: value, constant: true}, nil
+
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+		for p.pos < len(p.formula) && isIdentByte(p.formula[p.pos]) {
+			p.pos++
+		}
+		name := strings.ToLower(p.formula[start:p.pos])
+		if name == MetricRating {
+			return compositeTerm{sql: "r", lo: float64(MinRating), hi: float64(MaxRating)}, nil
+		}
+		def, ok := metricDefs[name]
+		if !ok || def.Formula != "" {
+			return compositeTerm{}, fmt.Errorf("unknown metric %q", name)
+		}
+		p.inputs[name] = true
+		return compositeTerm{sql: "m_" + name, lo: float64(def.Min), hi: float64(def.Max)}, nil
+	}
+
+	if c == 0 {
+		return compositeTerm{}, fmt.Errorf("formula ends unexpectedly")
+	}
+	return compositeTerm{}, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func compositeNames() []string {
+	names := make([]string, 0, len(compositeSQL))
+	for name := range compositeSQL {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compositeFunctionSQL renders the trigger function that recomputes every
+// composite for the user whose rating or input metric changed.
+func compositeFunctionSQL() string {
+	var b strings.Builder
+	b.WriteString(`
+		CREATE OR REPLACE FUNCTION refresh_composites() RETURNS trigger AS $$
+		DECLARE
+			uid BIGINT;
+			uname TEXT;
+			r INT;
+			placing BOOLEAN;
+			val INT;
+			prev INT;
+	`)
+	inputs := make([]string, 0, len(compositeInputs))
+	for input := range compositeInputs {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	for _, input := range inputs {
+		fmt.Fprintf(&b, "\t\tm_%s INT;\n", input)
+	}
+	b.WriteString(`
+		BEGIN
+			IF TG_TABLE_NAME = 'users' THEN
+				uid := NEW.id;
+			ELSE
+				uid := NEW.user_id;
+			END IF;
+			SELECT username, rating, in_placement INTO uname, r, placing FROM users WHERE id = uid;
+			IF NOT FOUND OR placing THEN
+				RETURN NULL;
+			END IF;
+	`)
+	for _, input := range inputs {
+		fmt.Fprintf(&b, "\t\tm_%s := COALESCE((SELECT value FROM user_metrics WHERE user_id = uid AND metric = %s), 0);\n",
+			input, pq.QuoteLiteral(input))
+	}
+	for _, name := range compositeNames() {
+		def, quoted := metricDefs[name], pq.QuoteLiteral(name)
+		fmt.Fprintf(&b, `
+			val := GREATEST(%d, LEAST(%d, ROUND(%s)::INT));
+			prev := NULL;
+			SELECT value INTO prev FROM user_metrics WHERE user_id = uid AND metric = %s;
+			IF prev IS DISTINCT FROM val THEN
+				INSERT INTO user_metrics (user_id, metric, value) VALUES (uid, %s, val)
+				ON CONFLICT (user_id, metric) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW();
+				INSERT INTO outbox (event_type, payload) VALUES (%s, jsonb_build_object(
+					'user_id', uid, 'username', uname, 'metric', %s, 'old_value', prev, 'new_value', val));
+			END IF;
+		`, def.Min, def.Max, compositeSQL[name], quoted, quoted, pq.QuoteLiteral(EventMetricUpdated), quoted)
+	}
+	b.WriteString(`
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+	`)
+	return b.String()
+}
+
+// PrepareComposites installs the composite triggers, or drops them when no
+// composites are configured, and recomputes every user's values for
+// composites whose formula is new or changed. It must run before
+// MarkOutboxCaughtUp so the recompute's events count as already applied when
+// the engines load.
+func PrepareComposites() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin composite setup: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DROP TRIGGER IF EXISTS users_composites ON users;
+		DROP TRIGGER IF EXISTS user_metrics_composites ON user_metrics;
+	`); err != nil {
+		return fmt.Errorf("failed to drop composite triggers: %w", err)
+	}
+	names := compositeNames()
+	if len(names) == 0 {
+		return tx.Commit()
+	}
+
+	if _, err := tx.Exec(compositeFunctionSQL()); err != nil {
+		return fmt.Errorf("failed to create composite function: %w", err)
+	}
+	if _, err := tx.Exec(`
+		CREATE TRIGGER users_composites
+		AFTER INSERT OR UPDATE OF rating, in_placement ON users
+		FOR EACH ROW EXECUTE FUNCTION refresh_composites()
+	`); err != nil {
+		return fmt.Errorf("failed to create composite trigger: %w", err)
+	}
+	if len(compositeInputs) > 0 {
+		inputs := make([]string, 0, len(compositeInputs))
+		for input := range compositeInputs {
+			inputs = append(inputs, pq.QuoteLiteral(input))
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`
+			CREATE TRIGGER user_metrics_composites
+			AFTER INSERT OR UPDATE OF value ON user_metrics
+			FOR EACH ROW WHEN (NEW.metric IN (%s)) EXECUTE FUNCTION refresh_composites()
+		`, strings.Join(inputs, ", "))); err != nil {
+			return fmt.Errorf("failed to create composite trigger: %w", err)
+		}
+	}
+
+	stored := map[string]string{}
+	rows, err := tx.Query(`SELECT name, formula FROM composite_definitions`)
+	if err != nil {
+		return fmt.Errorf("failed to read composite definitions: %w", err)
+	}
+	for rows.Next() {
+		var name, formula string
+		if err := rows.Scan(&name, &formula); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan composite definition: %w", err)
+		}
+		stored[name] = formula
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("error iterating composite definitions: %w", err)
+	}
+
+	// Dropping a composite forgets its definition, so adding it back later
+	// recomputes the values it left behind.
+	if _, err := tx.Exec(`DELETE FROM composite_definitions WHERE NOT (name = ANY($1))`, pq.Array(names)); err != nil {
+		return fmt.Errorf("failed to prune composite definitions: %w", err)
+	}
+
+	var changed []string
+	for _, name := range names {
+		if stored[name] != metricDefs[name].Formula {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return tx.Commit()
+	}
+
+	// Touching every ranked user fires the trigger, which only writes values
+	// that actually change.
+	result, err := tx.Exec(`UPDATE users SET rating = rating WHERE NOT in_placement`)
+	if err != nil {
+		return fmt.Errorf("failed to recompute composites: %w", err)
+	}
+	for _, name := range changed {
+		if _, err := tx.Exec(`
+			INSERT INTO composite_definitions (name, formula) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET formula = EXCLUDED.formula
+		`, name, metricDefs[name].Formula); err != nil {
+			return fmt.Errorf("failed to store composite definition: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit composite setup: %w", err)
+	}
+
+	users, _ := result.RowsAffected()
+	slog.Info("✓ Recomputed composites", "composites", strings.Join(changed, ", "), "users", users)
+	return nil
+}
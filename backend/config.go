@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every setting is read through getEnv, getEnvInt, or getEnvFloat, which
+// record the effective value here, so the running configuration can be
+// exported and replayed into another environment's env file. Source says
+// where the value came from: env, file (CONFIG_FILE), or default.
+type ConfigSetting struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	IsDefault bool   `json:"is_default"`
+	Secret    bool   `json:"secret,omitempty"`
+}
+
+var (
+	configMu   sync.Mutex
+	configRead = map[string]ConfigSetting{}
+)
+
+func recordConfig(key, value, source string) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	configRead[key] = ConfigSetting{Key: key, Value: value, Source: source, IsDefault: source == "default"}
+}
+
+func isSecretSetting(key string) bool {
+	// The URL carries the database password, a DSN carries its project key,
+	// and webhook URLs often embed the receiver's token.
+	if key == "DATABASE_URL" || strings.HasSuffix(key, "_DSN") || strings.HasSuffix(key, "WEBHOOK_URL") {
+		return true
+	}
+	// Quota defaults are limits, not keys.
+	if strings.HasPrefix(key, "API_KEY_MAX_") {
+		return false
+	}
+	for _, marker := range []string{"SECRET", "PASSWORD", "TOKEN", "KEY"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportConfig lists the settings read so far, sorted by key. Secret values
+// are redacted; they have to be carried over separately.
+func exportConfig() []ConfigSetting {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	settings := make([]ConfigSetting, 0, len(configRead))
+	for _, s := range configRead {
+		if isSecretSetting(s.Key) && s.Value != "" {
+			s.Value = ""
+			s.Secret = true
+		}
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, k int) bool { return settings[i].Key < settings[k].Key })
+	return settings
+}
+
+// HandleConfigExport serves GET /admin/config/export: the settings plus the
+// configuration bundle (see configimport.go). format=env returns only the
+// settings, as an env file with defaults and secrets commented out.
+func HandleConfigExport(c *gin.Context) {
+	settings := exportConfig()
+
+	if c.Query("format") == "env" {
+		var b strings.Builder
+		for _, s := range settings {
+			switch {
+			case s.Secret:
+				fmt.Fprintf(&b, "# %s=<secret, set separately>\n", s.Key)
+			case s.IsDefault:
+				fmt.Fprintf(&b, "# %s=%s\n", s.Key, s.Value)
+			default:
+				fmt.Fprintf(&b, "%s=%s\n", s.Key, s.Value)
+			}
+		}
+		c.String(http.StatusOK, b.String())
+		return
+	}
+
+	bundle, err := exportConfigBundle()
+	if err != nil {
+		requestLog(c).Error("Error exporting configuration", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to export configuration")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"settings": settings,
+		"tiers":    bundle.Tiers,
+		"boards":   bundle.Boards,
+		"api_keys": bundle.APIKeys,
+		"webhooks": bundle.Webhooks,
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// CONFIG_FILE names a YAML (.yaml, .yml) or TOML (.toml) file of settings.
+// Its values sit between the environment and the built-in defaults: getEnv
+// and friends use an environment variable when it is set, the file's value
+// otherwise, and the default last. The file is read while the package
+// initializes, so the settings read into package variables see it too.
+//
+// The common settings have named sections (see configSections); the
+// settings section takes any other setting by its environment name:
+//
+//	server:
+//	  port: 9090
+//	database:
+//	  max_open_conns: 20
+//	settings:
+//	  ELO_K_FACTOR: 24
+var (
+	configFilePath              = os.Getenv("CONFIG_FILE")
+	fileSettings, fileConfigErr = loadConfigFile(configFilePath)
+)
+
+var configSections = map[string]map[string]string{
+	"server": {
+		"port":     "PORT",
+		"gin_mode": "GIN_MODE",
+	},
+	"database": {
+		"url":                   "DATABASE_URL",
+		"host":                  "DB_HOST",
+		"port":                  "DB_PORT",
+		"user":                  "DB_USER",
+		"password":              "DB_PASSWORD",
+		"name":                  "DB_NAME",
+		"sslmode":               "DB_SSLMODE",
+		"max_open_conns":        "DB_MAX_OPEN_CONNS",
+		"max_idle_conns":        "DB_MAX_IDLE_CONNS",
+		"conn_max_lifetime_sec": "DB_CONN_MAX_LIFETIME_SEC",
+	},
+	"ratings": {
+		"min":      "RATING_MIN",
+		"max":      "RATING_MAX",
+		"new_user": "NEW_USER_RATING",
+	},
+	"pagination": {
+		"default_limit": "PAGE_SIZE_DEFAULT",
+		"max_limit":     "PAGE_SIZE_MAX",
+	},
+	"seeding": {
+		"count":       "SEED_COUNT",
+		"mode":        "SEED_MODE",
+		"batch_size":  "SEED_BATCH_SIZE",
+		"interval_ms": "SEED_INTERVAL_MS",
+	},
+	"logging": {
+		"level":  "LOG_LEVEL",
+		"format": "LOG_FORMAT",
+	},
+}
+
+var settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// lookupSetting returns key from the environment, then from the config file.
+// An empty environment variable counts as unset, as it always has.
+func lookupSetting(key string) (value, source string, ok bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, "env", true
+	}
+	if value, ok := fileSettings[key]; ok {
+		return value, "file", true
+	}
+	return "", "", false
+}
+
+func loadConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format %q, use .yaml, .yml, or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// Unknown sections and keys are rejected, so a typo fails the deploy
+	// instead of being silently ignored.
+	settings := map[string]string{}
+	set := func(key string, v any, where string) error {
+		s, err := configScalar(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		if _, dup := settings[key]; dup {
+			return fmt.Errorf("%s: %s is set more than once", where, key)
+		}
+		settings[key] = s
+		return nil
+	}
+	for _, section := range sortedKeys(doc) {
+		values, ok := doc[section].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a table of settings", path, section)
+		}
+		if section == "settings" {
+			for _, key := range sortedKeys(values) {
+				if !settingName.MatchString(key) {
+					return nil, fmt.Errorf("%s: settings.%s is not a setting name, e.g. ELO_K_FACTOR", path, key)
+				}
+				if err := set(key, values[key], path+": settings."+key); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		keys, ok := configSections[section]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown section %q", path, section)
+		}
+		for _, key := range sortedKeys(values) {
+			env, ok := keys[key]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown setting %s.%s", path, section, key)
+			}
+			if err := set(env, values[key], path+": "+section+"."+key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return settings, nil
+}
+
+func configScalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, uint64:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", fmt.Errorf("value is empty")
+	default:
+		return "", fmt.Errorf("value must be a string, number, or boolean, not %T", v)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CheckConfigFile reports the outcome of reading CONFIG_FILE, which happens
+// before logging is set up.
+func CheckConfigFile() error {
+	if fileConfigErr != nil {
+		return fileConfigErr
+	}
+	if configFilePath != "" {
+		slog.Info("✓ Loaded config file", "path", configFilePath, "settings", len(fileSettings))
+	}
+	return nil
+}
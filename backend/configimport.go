@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ConfigBundle is the configuration that lives in the database or in memory
+// rather than in the environment: the tier table, named boards, API keys, and
+// webhook subscriptions. GET /admin/config/export includes it and POST
+// /admin/config/import applies it, so one environment can be cloned into
+// another. API keys travel as their SHA-256, so the same keys work in both and
+// the bundle never holds a usable credential. Webhook secrets don't travel;
+// imported subscriptions get new ones.
+type ConfigBundle struct {
+	Tiers    []Tier          `json:"tiers,omitempty"`
+	Boards   []BoardConfig   `json:"boards,omitempty"`
+	APIKeys  []APIKeyConfig  `json:"api_keys,omitempty"`
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+}
+
+type BoardConfig struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+}
+
+type APIKeyConfig struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	KeyHash string `json:"key_hash"`
+	Prefix  string `json:"prefix"`
+	QuotaLimits
+}
+
+type WebhookConfig struct {
+	WebhookSubscriptionInput
+	Active *bool `json:"active"`
+}
+
+// ConfigImportResult reports what an import changed. Created webhooks carry
+// their new secrets, which are only ever returned here.
+type ConfigImportResult struct {
+	Tiers           int              `json:"tiers"`
+	BoardsCreated   []string         `json:"boards_created"`
+	APIKeys         int              `json:"api_keys"`
+	WebhooksCreated []CreatedWebhook `json:"webhooks_created"`
+	WebhooksUpdated int              `json:"webhooks_updated"`
+}
+
+var keyHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// exportConfigBundle reads the current bundle. Revoked keys and the bootstrap
+// key, which each environment sets through API_BOOTSTRAP_KEY, are left out.
+func exportConfigBundle() (ConfigBundle, error) {
+	bundle := ConfigBundle{
+		Tiers:    defaultTiers,
+		Boards:   []BoardConfig{},
+		APIKeys:  []APIKeyConfig{},
+		Webhooks: []WebhookConfig{},
+	}
+
+	rows, err := db.Query(`SELECT name, algorithm FROM leaderboards ORDER BY id`)
+	if err != nil {
+		return bundle, fmt.Errorf("failed to read boards: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b BoardConfig
+		if err := rows.Scan(&b.Name, &b.Algorithm); err != nil {
+			return bundle, fmt.Errorf("failed to scan board: %w", err)
+		}
+		bundle.Boards = append(bundle.Boards, b)
+	}
+	if err := rows.Err(); err != nil {
+		return bundle, fmt.Errorf("error iterating boards: %w", err)
+	}
+
+	keys, err := db.Query(`
+		SELECT name, role, key_hash, display_prefix, max_users, max_updates_per_sec, max_webhooks
+		FROM api_keys
+		WHERE revoked_at IS NULL AND name <> $1
+		ORDER BY id
+	`, bootstrapAPIKeyName)
+	if err != nil {
+		return bundle, fmt.Errorf("failed to read API keys: %w", err)
+	}
+	defer keys.Close()
+	for keys.Next() {
+		var k APIKeyConfig
+		if err := keys.Scan(&k.Name, &k.Role, &k.KeyHash, &k.Prefix, &k.MaxUsers, &k.MaxUpdatesPerSec, &k.MaxWebhooks); err != nil {
+			return bundle, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		bundle.APIKeys = append(bundle.APIKeys, k)
+	}
+	if err := keys.Err(); err != nil {
+		return bundle, fmt.Errorf("error iterating API keys: %w", err)
+	}
+
+	subs, err := loadWebhookSubscriptions("")
+	if err != nil {
+		return bundle, err
+	}
+	for _, s := range subs {
+		active := s.Active
+		bundle.Webhooks = append(bundle.Webhooks, WebhookConfig{
+			WebhookSubscriptionInput: WebhookSubscriptionInput{URL: s.URL, Events: s.Events, Filter: s.Filter, SchemaVersion: s.SchemaVersion, Format: s.Format, APIKey: s.APIKey},
+			Active:                   &active,
+		})
+	}
+	return bundle, nil
+}
+
+// validate checks every section before anything is applied, so a bad bundle
+// changes nothing.
+func (b *ConfigBundle) validate() error {
+	if b.Tiers != nil {
+		tiers, err := validateTiers(b.Tiers)
+		if err != nil {
+			return err
+		}
+		b.Tiers = tiers
+	}
+	for i := range b.Boards {
+		name, algorithm, err := validateBoard(b.Boards[i].Name, b.Boards[i].Algorithm)
+		if err != nil {
+			return fmt.Errorf("boards[%d]: %v", i, err)
+		}
+		b.Boards[i] = BoardConfig{Name: name, Algorithm: algorithm}
+	}
+	keys := map[string]bool{}
+	for i, k := range b.APIKeys {
+		if !apiKeyNamePattern.MatchString(k.Name) || k.Name == bootstrapAPIKeyName {
+			return fmt.Errorf("api_keys[%d]: name must be 1-64 letters, digits, _ or -, and not %s", i, bootstrapAPIKeyName)
+		}
+		if k.Role != APIKeyRoleWrite && k.Role != APIKeyRoleAdmin {
+			return fmt.Errorf("api_keys[%d]: role must be write or admin", i)
+		}
+		if !keyHashPattern.MatchString(k.KeyHash) {
+			return fmt.Errorf("api_keys[%d]: key_hash must be a hex SHA-256", i)
+		}
+		for _, v := range []*int{k.MaxUsers, k.MaxUpdatesPerSec, k.MaxWebhooks} {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("api_keys[%d]: quota limits must be 0 (unlimited) or more", i)
+			}
+		}
+		if keys[k.Name] {
+			return fmt.Errorf("api_keys[%d]: duplicate name %s", i, k.Name)
+		}
+		keys[k.Name] = true
+	}
+	for i := range b.Webhooks {
+		if err := b.Webhooks[i].validate(); err != nil {
+			return fmt.Errorf("webhooks[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// applyConfigBundle makes the configuration match the bundle, leaving
+// anything it doesn't mention alone. Boards are created if missing; an
+// existing board keeps its ratings, and its algorithm must match. API keys
+// are matched by name and webhooks by URL and api_key, and updated in place,
+// so applying the same bundle twice changes nothing the second time. Keys
+// and webhooks go in one transaction; quotas don't limit an import.
+func applyConfigBundle(b ConfigBundle) (*ConfigImportResult, error) {
+	result := &ConfigImportResult{BoardsCreated: []string{}, WebhooksCreated: []CreatedWebhook{}}
+
+	for _, bc := range b.Boards {
+		if existing, ok := getBoard(bc.Name); ok && existing.Algorithm != bc.Algorithm {
+			return result, &configConflictError{fmt.Sprintf("board %s already exists with algorithm %s", bc.Name, existing.Algorithm)}
+		}
+	}
+	for _, bc := range b.Boards {
+		if _, ok := getBoard(bc.Name); ok {
+			continue
+		}
+		if _, err := createBoard(bc.Name, bc.Algorithm, false); err != nil && !errors.Is(err, errBoardExists) {
+			return result, err
+		}
+		result.BoardsCreated = append(result.BoardsCreated, bc.Name)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to begin config import: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, k := range b.APIKeys {
+		if _, err := tx.Exec(`
+			INSERT INTO api_keys (name, key_hash, display_prefix, role, max_users, max_updates_per_sec, max_webhooks)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (name) DO UPDATE SET
+				key_hash = EXCLUDED.key_hash, display_prefix = EXCLUDED.display_prefix, role = EXCLUDED.role,
+				max_users = EXCLUDED.max_users, max_updates_per_sec = EXCLUDED.max_updates_per_sec,
+				max_webhooks = EXCLUDED.max_webhooks, revoked_at = NULL
+		`, k.Name, k.KeyHash, k.Prefix, k.Role, k.MaxUsers, k.MaxUpdatesPerSec, k.MaxWebhooks); err != nil {
+			if isUniqueViolation(err) {
+				return result, &configConflictError{fmt.Sprintf("API key %s has the same key_hash as another key", k.Name)}
+			}
+			return result, fmt.Errorf("failed to import API key %s: %w", k.Name, err)
+		}
+		result.APIKeys++
+	}
+
+	for _, w := range b.Webhooks {
+		active := w.Active == nil || *w.Active
+		res, err := tx.Exec(`
+			UPDATE webhook_subscriptions
+			SET events = $3, filter = $4, schema_version = $5, format = $6, active = $7, updated_at = NOW()
+			WHERE id = (
+				SELECT MIN(id) FROM webhook_subscriptions WHERE url = $1 AND COALESCE(api_key, '') = $2
+			)
+		`, w.URL, w.APIKey, pq.Array(w.Events), w.Filter, w.SchemaVersion, w.Format, active)
+		var n int64
+		if err == nil {
+			n, err = res.RowsAffected()
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to import webhook subscription: %w", err)
+		}
+		if n > 0 {
+			result.WebhooksUpdated++
+			continue
+		}
+
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return result, err
+		}
+		created := CreatedWebhook{
+			WebhookSubscription: WebhookSubscription{URL: w.URL, Events: w.Events, Filter: w.Filter, SchemaVersion: w.SchemaVersion, Format: w.Format, Active: active, APIKey: w.APIKey},
+			Secret:              secret,
+		}
+		if err := tx.QueryRow(`
+			INSERT INTO webhook_subscriptions (url, events, filter, schema_version, format, secret, active, api_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+			RETURNING id, created_at, updated_at
+		`, w.URL, pq.Array(w.Events), w.Filter, w.SchemaVersion, w.Format, secret, active, w.APIKey).Scan(&created.ID, &created.CreatedAt, &created.UpdatedAt); err != nil {
+			return result, fmt.Errorf("failed to import webhook subscription: %w", err)
+		}
+		result.WebhooksCreated = append(result.WebhooksCreated, created)
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit config import: %w", err)
+	}
+
+	for _, k := range b.APIKeys {
+		keyQuotas.forget(k.Name)
+	}
+	if b.Tiers != nil {
+		defaultTiers = b.Tiers
+		result.Tiers = len(b.Tiers)
+	}
+	if webhooks != nil && len(b.Webhooks) > 0 {
+		reloadWebhooks()
+	}
+	return result, nil
+}
+
+// configConflictError is an import the current configuration can't take.
+type configConflictError struct {
+	msg string
+}
+
+func (e *configConflictError) Error() string { return e.msg }
+
+// HandleConfigImport serves POST /admin/config/import. The body is the bundle
+// part of an export (settings, if present, are ignored: they come from the
+// environment).
+func HandleConfigImport(c *gin.Context) {
+	var req struct {
+		ConfigBundle
+		Settings []ConfigSetting `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.ConfigBundle.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := applyConfigBundle(req.ConfigBundle)
+	var conflict *configConflictError
+	if errors.As(err, &conflict) {
+		respondErrorWith(c, http.StatusConflict, conflict.Error(), gin.H{"applied": result})
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error importing configuration", "error", err)
+		respondErrorWith(c, http.StatusInternalServerError, "Failed to import configuration", gin.H{"applied": result})
+		return
+	}
+	requestLog(c).Info("✓ Configuration imported",
+		"tiers", result.Tiers, "boards_created", len(result.BoardsCreated), "api_keys", result.APIKeys,
+		"webhooks_created", len(result.WebhooksCreated), "webhooks_updated", result.WebhooksUpdated)
+
+	respond(c, http.StatusOK, gin.H{
+		"applied": result,
+	})
+}
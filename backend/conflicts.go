@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// A replica checks each user's events form a chain: a rating update must start
+// from the rating the previous event for that user ended at, and arrive with a
+// higher outbox id. Matches lock their players, so on the primary a user's
+// events always chain; a break on a replica means an event was missed or
+// arrived late. Checking starts from the first event the replica sees for a
+// user, so a break before that is not detected.
+
+const (
+	ConflictSequenceGap = "sequence_gap"
+	ConflictOutOfOrder  = "out_of_order"
+
+	conflictReportLimit = 100
+)
+
+type ReplicationConflict struct {
+	EventID     int64  `json:"event_id"`
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	Kind        string `json:"kind"`
+	Expected    int    `json:"expected_old_rating"`
+	Got         int    `json:"got_old_rating"`
+	PrevEventID int64  `json:"prev_event_id"`
+	DetectedAt  string `json:"detected_at"`
+}
+
+// replicaUser is where the replica's engine currently counts a user.
+type replicaUser struct {
+	rating   int
+	ranked   bool
+	lastID   int64
+	username string
+}
+
+type ReplicationStats struct {
+	OutboxPosition int64 `json:"outbox_position"`
+	SequenceGaps   int64 `json:"sequence_gaps"`
+	OutOfOrder     int64 `json:"out_of_order"`
+	AffectedUsers  int   `json:"affected_users"`
+	Reconciled     int64 `json:"reconciled"`
+}
+
+// applyLocked applies one outbox event to the engine, keeping the engine's
+// view of the user consistent with the replica's even when the event doesn't
+// chain: the user is moved from where the engine has them, not from where the
+// event says they were, and an event older than one already applied is
+// skipped rather than moving them back.
+func (f *ReplicaFeed) applyLocked(e OutboxEvent) {
+	switch e.Type {
+	case EventRatingUpdated:
+		var ev RatingUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		u, seen := f.users[ev.UserID]
+		if !seen {
+			u = &replicaUser{rating: ev.OldRating, ranked: true}
+			f.users[ev.UserID] = u
+		}
+		u.username = ev.Username
+		if stale := f.check(e.ID, ev.UserID, u, ev.OldRating); stale {
+			return
+		}
+		if u.ranked {
+			applyRatingChange(GetRankingEngine(), ev.Username, u.rating, ev.NewRating, ev.Source)
+		}
+		u.rating = ev.NewRating
+
+	case EventRatingsUpdated:
+		var ev RatingsUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		var updates []RatingUpdate
+		for _, r := range ev.Updates {
+			u, seen := f.users[r.UserID]
+			if !seen {
+				u = &replicaUser{rating: r.OldRating, ranked: true}
+				f.users[r.UserID] = u
+			}
+			u.username = r.Username
+			if stale := f.check(e.ID, r.UserID, u, r.OldRating); stale {
+				continue
+			}
+			if u.ranked {
+				updates = append(updates, RatingUpdate{UserID: r.UserID, Username: r.Username, OldRating: u.rating, NewRating: r.NewRating})
+			}
+			u.rating = r.NewRating
+		}
+		applyRatingBatch(GetRankingEngine(), updates, ev.Source)
+
+	case EventUserPlaced:
+		var ev UserPlacedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		u, seen := f.users[ev.UserID]
+		if !seen {
+			u = &replicaUser{}
+			f.users[ev.UserID] = u
+		}
+		u.username = ev.Username
+		if ev.IsBot {
+			bots.register(ev.Username)
+		}
+		if u.ranked {
+			f.record(ReplicationConflict{EventID: e.ID, UserID: ev.UserID, Kind: ConflictSequenceGap, Expected: u.rating, Got: ev.Rating, PrevEventID: u.lastID}, u)
+			GetRankingEngine().UpdateRating(u.rating, ev.Rating)
+			bots.update(ev.Username, u.rating, ev.Rating)
+		} else {
+			GetRankingEngine().AddUser(ev.Rating)
+			bots.add(ev.Username, ev.Rating)
+		}
+		u.rating, u.ranked, u.lastID = ev.Rating, true, max(u.lastID, e.ID)
+
+	case EventUserDeleted:
+		var ev UserDeletedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		// Remove the user from where the engine has them.
+		if u, seen := f.users[ev.UserID]; seen {
+			if u.ranked {
+				GetRankingEngine().RemoveUser(u.rating)
+			}
+			bots.remove(ev.Username, u.rating, u.ranked)
+			delete(f.users, ev.UserID)
+			delete(f.affected, ev.UserID)
+		} else if ev.Ranked {
+			GetRankingEngine().RemoveUser(ev.Rating)
+			bots.remove(ev.Username, ev.Rating, true)
+		}
+		forgetUserScores(ev.Metrics, ev.Boards)
+
+	case EventMetricUpdated:
+		var ev MetricUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyMetricEvent(ev)
+
+	case EventBoardRatingUpdated:
+		var ev BoardRatingUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyBoardEvent(ev)
+
+	case EventLeaderboardRefreshed:
+		var ev LeaderboardRefreshedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyLeaderboardRefresh(ev)
+	}
+}
+
+// check records a conflict if the event doesn't chain, and reports whether
+// it is older than one already applied for the user and should be skipped.
+func (f *ReplicaFeed) check(id, userID int64, u *replicaUser, oldRating int) bool {
+	if id < u.lastID {
+		f.record(ReplicationConflict{EventID: id, UserID: userID, Kind: ConflictOutOfOrder, Expected: u.rating, Got: oldRating, PrevEventID: u.lastID}, u)
+		return true
+	}
+	if oldRating != u.rating {
+		f.record(ReplicationConflict{EventID: id, UserID: userID, Kind: ConflictSequenceGap, Expected: u.rating, Got: oldRating, PrevEventID: u.lastID}, u)
+	}
+	u.lastID = id
+	return false
+}
+
+func (f *ReplicaFeed) record(c ReplicationConflict, u *replicaUser) {
+	c.Username = u.username
+	c.DetectedAt = time.Now().UTC().Format(time.RFC3339)
+	if c.Kind == ConflictOutOfOrder {
+		f.outOfOrder++
+	} else {
+		f.sequenceGaps++
+	}
+	f.affected[c.UserID] = true
+	f.conflicts = append(f.conflicts, c)
+	if len(f.conflicts) > conflictReportLimit {
+		f.conflicts = f.conflicts[len(f.conflicts)-conflictReportLimit:]
+	}
+	slog.Warn("Replication conflict", "kind", c.Kind, "username", c.Username, "event_id", c.EventID,
+		"expected_old_rating", c.Expected, "got", c.Got)
+}
+
+func (f *ReplicaFeed) Stats() ReplicationStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return ReplicationStats{
+		OutboxPosition: f.maxApplied,
+		SequenceGaps:   f.sequenceGaps,
+		OutOfOrder:     f.outOfOrder,
+		AffectedUsers:  len(f.affected),
+		Reconciled:     f.reconciled,
+	}
+}
+
+// Reconcile moves every affected user in the engine from where the replica
+// has them to their current rating in the database, and clears them from the
+// report. It returns the number of users resynced.
+func (f *ReplicaFeed) Reconcile() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.affected) == 0 {
+		return 0, nil
+	}
+	ids := make([]int64, 0, len(f.affected))
+	for id := range f.affected {
+		ids = append(ids, id)
+	}
+
+	rows, err := db.Query(`SELECT id, rating, in_placement FROM users WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read affected users: %w", err)
+	}
+	defer rows.Close()
+
+	current := make(map[int64]replicaUser, len(ids))
+	for rows.Next() {
+		var id int64
+		var inPlacement bool
+		var u replicaUser
+		if err := rows.Scan(&id, &u.rating, &inPlacement); err != nil {
+			return 0, fmt.Errorf("failed to scan affected user: %w", err)
+		}
+		u.ranked = !inPlacement
+		current[id] = u
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating affected users: %w", err)
+	}
+
+	engine := GetRankingEngine()
+	for _, id := range ids {
+		u := f.users[id]
+		want, exists := current[id]
+		move := func(e RankEngine) {
+			switch {
+			case u.ranked && want.ranked:
+				e.UpdateRating(u.rating, want.rating)
+			case u.ranked:
+				e.RemoveUser(u.rating)
+			case want.ranked:
+				e.AddUser(want.rating)
+			}
+		}
+		move(engine)
+		if be := bots.botEngine(u.username); be != nil {
+			move(be)
+		}
+		if exists {
+			u.rating, u.ranked = want.rating, want.ranked
+		} else {
+			u.ranked = false
+		}
+		delete(f.affected, id)
+	}
+
+	remaining := f.conflicts[:0]
+	for _, c := range f.conflicts {
+		if f.affected[c.UserID] {
+			remaining = append(remaining, c)
+		}
+	}
+	f.conflicts = remaining
+	f.reconciled += int64(len(ids))
+
+	slog.Info("✓ Reconciled users after replication conflicts", "users", len(ids))
+	return len(ids), nil
+}
+
+// HandleReplicationConflicts reports conflicts the replica feed has detected
+// and not yet reconciled, newest first.
+func HandleReplicationConflicts(c *gin.Context) {
+	if replicaFeed == nil {
+		respondError(c, http.StatusNotFound, "Not running as a replica")
+		return
+	}
+
+	stats := replicaFeed.Stats()
+	replicaFeed.mu.Lock()
+	conflicts := append([]ReplicationConflict{}, replicaFeed.conflicts...)
+	replicaFeed.mu.Unlock()
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].EventID > conflicts[j].EventID })
+
+	respond(c, http.StatusOK, gin.H{
+		"stats":     stats,
+		"conflicts": conflicts,
+	})
+}
+
+func HandleReplicationReconcile(c *gin.Context) {
+	if replicaFeed == nil {
+		respondError(c, http.StatusNotFound, "Not running as a replica")
+		return
+	}
+
+	n, err := replicaFeed.Reconcile()
+	if err != nil {
+		requestLog(c).Error("Error reconciling replication conflicts", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to reconcile users")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"reconciled": n,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultConsistencySample = 100
+	MaxConsistencySample     = 10000
+	consistencyReportLimit   = 20
+)
+
+type ConsistencyMismatch struct {
+	Username   string `json:"username"`
+	Rating     int    `json:"rating"`
+	SQLRank    int    `json:"sql_rank"`
+	EngineRank int    `json:"engine_rank"`
+}
+
+type ConsistencyReport struct {
+	Success      bool                  `json:"success"`
+	Sampled      int                   `json:"sampled"`
+	Mismatched   int                   `json:"mismatched"`
+	MismatchRate float64               `json:"mismatch_rate"`
+	DBUsers      int                   `json:"db_users"`
+	EngineUsers  int                   `json:"engine_users"`
+	Mismatches   []ConsistencyMismatch `json:"mismatches"`
+	DurationMs   int64                 `json:"duration_ms"`
+}
+
+// HandleConsistencyCheck samples ranked users, recomputes their rank in SQL
+// with RANK() OVER (ORDER BY rating DESC), and compares against the engine.
+// It is meant as a quick smoke test after a deploy.
+func HandleConsistencyCheck(c *gin.Context) {
+	sample := parseIntParam(c.Query("sample"), DefaultConsistencySample)
+	if sample < 1 {
+		sample = DefaultConsistencySample
+	}
+	if sample > MaxConsistencySample {
+		sample = MaxConsistencySample
+	}
+
+	start := time.Now()
+	rows, err := db.Query(`
+		WITH ranked AS (
+			SELECT username, rating, RANK() OVER (ORDER BY rating DESC) AS rank
+			FROM users
+			WHERE NOT in_placement
+		)
+		SELECT username, rating, rank, (SELECT COUNT(*) FROM ranked)
+		FROM ranked
+		ORDER BY RANDOM()
+		LIMIT $1
+	`, sample)
+	if err != nil {
+		requestLog(c).Error("Error running consistency check", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to run consistency check")
+		return
+	}
+	defer rows.Close()
+
+	re := GetRankingEngine()
+	report := ConsistencyReport{Success: true, Mismatches: []ConsistencyMismatch{}}
+	for rows.Next() {
+		var m ConsistencyMismatch
+		if err := rows.Scan(&m.Username, &m.Rating, &m.SQLRank, &report.DBUsers); err != nil {
+			requestLog(c).Error("Error scanning consistency sample", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to run consistency check")
+			return
+		}
+
+		report.Sampled++
+		m.EngineRank = re.GetRank(m.Rating)
+		if m.EngineRank != m.SQLRank {
+			report.Mismatched++
+			if len(report.Mismatches) < consistencyReportLimit {
+				report.Mismatches = append(report.Mismatches, m)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating consistency sample", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to run consistency check")
+		return
+	}
+
+	report.EngineUsers, _, _, _ = re.GetStats()
+	if report.Sampled > 0 {
+		report.MismatchRate = float64(report.Mismatched) / float64(report.Sampled)
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	if report.Mismatched > 0 {
+		requestLog(c).Warn("Consistency check: sampled ranks differ from SQL", "mismatched", report.Mismatched, "sampled", report.Sampled)
+	}
+	respond(c, http.StatusOK, report)
+}
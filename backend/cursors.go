@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Every board is ordered by rating DESC, username ASC, id ASC, so each row has
+// a unique position; usernames compare under USERNAME_COLLATION. A cursor
+// names that position; ?after=<cursor> continues the board from just past it,
+// so rows never repeat or go missing because other users moved between
+// requests. Ranks come from the engine, which ranks
+// by rating alone: users with the same rating share a rank and appear in
+// username, id order within it.
+type Cursor struct {
+	Rating   int
+	Username string
+	ID       int64
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func cursorFor(u User) string {
+	raw := strconv.Itoa(u.Rating) + ":" + strconv.FormatInt(u.ID, 10) + ":" + u.Username
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return Cursor{}, errInvalidCursor
+	}
+	rating, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Rating: rating, ID: id, Username: parts[2]}, nil
+}
+
+// LeaderboardAfter returns the rows that follow after. Positions past the
+// cursor aren't known, so in degraded mode unranked rows stay unranked.
+func (s *LeaderboardService) LeaderboardAfter(ctx context.Context, after Cursor, limit int, dst []UserWithRank) (Page, error) {
+	req := PageRequest{Page: 1, Limit: limit}.normalize()
+	req.Page = 0
+	users, err := s.users.TopUsersAfter(ctx, after, req.Limit+1)
+	if err != nil {
+		return Page{}, err
+	}
+	return s.page(ctx, req, users, dst)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"strconv"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -13,13 +15,44 @@ import (
 
 var db *sql.DB
 
+// Tables owned by individual features, created after users in this order.
+var featureSchemas = []string{
+	placementSchema,
+	historySchema,
+	outboxSchema,
+	matchesSchema,
+	auditSchema,
+	usageSchema,
+	ratingSnapshotSchema,
+	seasonSchema,
+	engineCheckpointSchema,
+	metricsSchema,
+	compositeSchema,
+	quarantineSchema,
+	boardsSchema,
+	glickoSchema,
+	submissionNonceSchema,
+	botSchema,
+	schemaChangeSchema,
+	backfillSchema,
+	recordsSchema,
+	teamSchema,
+	apiKeySchema,
+	webhookSchema,
+	ratingRulesSchema,
+	usernameKeySchema,
+	quotaSchema,
+	volatilitySchema,
+	gainersReportSchema,
+}
+
 func InitDB() error {
 	var connStr string
 	
 
-	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
+	if databaseURL := getEnv("DATABASE_URL", ""); databaseURL != "" {
 		connStr = databaseURL
-		log.Println("Using DATABASE_URL for connection")
+		slog.Info("Using DATABASE_URL for connection")
 	} else {
 	
 		host := getEnv("DB_HOST", "localhost")
@@ -33,7 +66,7 @@ func InitDB() error {
 			"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 			host, port, user, password, dbname, sslmode,
 		)
-		log.Println("Using individual DB env vars for connection")
+		slog.Info("Using individual DB env vars for connection")
 	}
 
 	var err error
@@ -46,18 +79,23 @@ func InitDB() error {
 
 
 
-	db.SetMaxOpenConns(50)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(getEnvInt("DB_MAX_OPEN_CONNS", 50))
+	db.SetMaxIdleConns(getEnvInt("DB_MAX_IDLE_CONNS", 25))
+	db.SetConnMaxLifetime(time.Duration(getEnvInt("DB_CONN_MAX_LIFETIME_SEC", 300)) * time.Second)
 
 
 	if err = db.Ping(); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("✓ Database connection established successfully")
+	slog.Info("✓ Database connection established successfully")
 	
 
+	if isReplica() {
+		slog.Info("✓ Replica mode: schema is managed by the primary region")
+		return nil
+	}
+
 	if err = ensureSchema(); err != nil {
 		return fmt.Errorf("failed to ensure schema: %w", err)
 	}
@@ -66,12 +104,12 @@ func InitDB() error {
 }
 
 func ensureSchema() error {
-	schema := `
+	schema := fmt.Sprintf(`
 		-- Create the users table if it doesn't exist
 		CREATE TABLE IF NOT EXISTS users (
 			id BIGSERIAL PRIMARY KEY,
 			username TEXT UNIQUE NOT NULL,
-			rating INT NOT NULL CHECK (rating BETWEEN 100 AND 5000)
+			rating INT NOT NULL CONSTRAINT users_rating_check CHECK (rating BETWEEN %d AND %d)
 		);
 
 		-- Create index on rating for fast ORDER BY queries
@@ -79,39 +117,62 @@ func ensureSchema() error {
 
 		-- Create index on username for fast search queries
 		CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
-
-		-- Create index for case-insensitive search
-		CREATE INDEX IF NOT EXISTS idx_users_username_lower ON users(LOWER(username));
-	`
+	`, MinRating, MaxRating)
 	
 	_, err := db.Exec(schema)
 	if err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
+
+	if err := syncRatingConstraint(); err != nil {
+		return err
+	}
+
+	if err := ensureUsernameLowerIndex(); err != nil {
+		return err
+	}
+
+	for _, featureSchema := range featureSchemas {
+		if _, err := db.Exec(featureSchema); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	if err := redactStoredAudit(); err != nil {
+		return err
+	}
+	if err := pruneStoredAudit(); err != nil {
+		return err
+	}
 	
-	log.Println("✓ Database schema verified")
+	slog.Info("✓ Database schema verified")
 	return nil
 }
 
 func CloseDB() {
 	if db != nil {
+		closeCachedStmts()
 		db.Close()
-		log.Println("✓ Database connection closed")
+		slog.Info("✓ Database connection closed")
 	}
 }
 
 
 func GetTopUsers(limit int, offset int) ([]User, error) {
-	query := `
-		SELECT id, username, rating 
-		FROM users 
-		ORDER BY rating DESC, username ASC 
+	return GetTopUsersContext(context.Background(), limit, offset)
+}
+
+var topUsersStmt = &cachedStmt{name: "top_users", query: func() string {
+	return `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement
+		ORDER BY rating DESC, ` + collateUsername("username") + ` ASC, id ASC
 		LIMIT $1 OFFSET $2
 	`
+}}
 
-
-
-	rows, err := db.Query(query, limit, offset)
+func GetTopUsersContext(ctx context.Context, limit int, offset int) ([]User, error) {
+	rows, err := topUsersStmt.QueryContext(ctx, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query top users: %w", err)
 	}
@@ -134,43 +195,36 @@ func GetTopUsers(limit int, offset int) ([]User, error) {
 }
 
 func SearchUsersByUsername(searchTerm string, limit int, offset int) ([]User, error) {
+	users, _, err := SearchUsersByUsernameContext(context.Background(), searchTerm, limit, offset)
+	return users, err
+}
 
-
-	query := `
-		SELECT id, username, rating 
-		FROM users 
-		WHERE username ILIKE $1 
-		ORDER BY rating DESC, username ASC
-		LIMIT $2 OFFSET $3
-	`
-
-	pattern := "%" + searchTerm + "%"
-	rows, err := db.Query(query, pattern, limit, offset)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search users: %w", err)
-	}
-	defer rows.Close()
-
-	users := make([]User, 0)
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err != nil {
-			return nil, fmt.Errorf("failed to scan user row: %w", err)
-		}
-		users = append(users, u)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating user rows: %w", err)
-	}
-
-	return users, nil
+// SearchUsersByUsernameContext also reports whether the scan stopped at
+// SEARCH_SCAN_CAP (see search.go).
+func SearchUsersByUsernameContext(ctx context.Context, searchTerm string, limit int, offset int) ([]User, bool, error) {
+	return searchUsers(ctx, searchQuery{
+		Match: `
+			SELECT id, username, rating 
+			FROM users 
+			WHERE username ILIKE $1 AND NOT in_placement
+		`,
+		Order:  `rating DESC, ` + collateUsername("username") + ` ASC, id ASC`,
+		Args:   []any{"%" + searchTerm + "%"},
+		Limit:  limit,
+		Offset: offset,
+	})
 }
 
+// GetRandomUsers picks ranked bots for the simulator, which never touches
+// real accounts.
 func GetRandomUsers(count int) ([]User, error) {
+	if simSeeded {
+		return getSeededRandomUsers(count)
+	}
 	query := `
 		SELECT id, username, rating 
 		FROM users 
+		WHERE NOT in_placement AND is_bot
 		ORDER BY RANDOM() 
 		LIMIT $1
 	`
@@ -198,15 +252,21 @@ func GetRandomUsers(count int) ([]User, error) {
 }
 
 func GetUserByUsername(username string) (*User, error) {
-	query := `
-		SELECT id, username, rating 
-		FROM users 
+	return GetUserByUsernameContext(context.Background(), username)
+}
+
+var userByUsernameStmt = &cachedStmt{name: "user_by_username", query: func() string {
+	return `
+		SELECT id, username, rating, in_placement, placement_games, is_bot
+		FROM users
 		WHERE LOWER(username) = LOWER($1)
 		LIMIT 1
 	`
+}}
 
+func GetUserByUsernameContext(ctx context.Context, username string) (*User, error) {
 	var u User
-	err := db.QueryRow(query, username).Scan(&u.ID, &u.Username, &u.Rating)
+	err := userByUsernameStmt.QueryRowContext(ctx, username).Scan(&u.ID, &u.Username, &u.Rating, &u.InPlacement, &u.PlacementGames, &u.IsBot)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found: %s", username)
@@ -217,25 +277,174 @@ func GetUserByUsername(username string) (*User, error) {
 	return &u, nil
 }
 
-func UpdateUserRating(userID int64, newRating int) error {
-	query := `UPDATE users SET rating = $1 WHERE id = $2`
-	_, err := db.Exec(query, newRating, userID)
+// UpdateUserRating sets one user's rating and records the change in
+// rating_history under source.
+func UpdateUserRating(userID int64, newRating int, source string) error {
+	return updateUserRatings([]RatingUpdate{{UserID: userID, NewRating: newRating}}, source)
+}
+
+// setUserRating sets one user's rating with the row locked, so the rating
+// rules, the volatility limits, the history row, and the outbox event all see
+// the rating it replaces. Rules may cap the change, which ev reports, or
+// reject it with a HookRejectedError; the limits refuse it with a
+// VolatilityError. The caller flushes the outbox when changed is true.
+func setUserRating(username string, value int, source string) (ev RatingUpdatedEvent, changed bool, err error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to update user rating: %w", err)
+		return ev, false, fmt.Errorf("failed to begin rating update: %w", err)
 	}
-	return nil
+	defer tx.Rollback()
+
+	var inPlacement bool
+	err = tx.QueryRow(`
+		SELECT id, username, rating, in_placement FROM users WHERE LOWER(username) = LOWER($1) FOR UPDATE
+	`, username).Scan(&ev.UserID, &ev.Username, &ev.OldRating, &inPlacement)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ev, false, errMatchUserNotFound
+	}
+	if err != nil {
+		return ev, false, fmt.Errorf("failed to lock user: %w", err)
+	}
+	if inPlacement {
+		return ev, false, errors.New("user is still in placement")
+	}
+	ev.NewRating, ev.Source = value, source
+
+	changes := []RatingUpdatedEvent{ev}
+	if err := applyRatingRules(tx, changes); err != nil {
+		return ev, false, err
+	}
+	ev = changes[0]
+	if ev.NewRating == ev.OldRating {
+		return ev, false, tx.Commit()
+	}
+	if err := checkVolatility(tx, DefaultBoard, &User{ID: ev.UserID, Username: ev.Username}, ev.NewRating-ev.OldRating); err != nil {
+		return ev, false, err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET rating = $1 WHERE id = $2`, ev.NewRating, ev.UserID); err != nil {
+		return ev, false, fmt.Errorf("failed to update rating: %w", err)
+	}
+	if err := insertRatingHistory(tx, ev.UserID, ev.OldRating, ev.NewRating, source, nil); err != nil {
+		return ev, false, err
+	}
+	if err := insertOutboxEvent(tx, EventRatingUpdated, ev); err != nil {
+		return ev, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ev, false, fmt.Errorf("failed to commit rating update: %w", err)
+	}
+	return ev, true, nil
+}
+
+// CreateUser inserts a new player. When placement is enabled they start in
+// placement and stay out of the engine until PlacementGames are recorded.
+// Simulated players are created as bots. The caller adds a ranked user to the
+// engine; the user.placed event is for replicas.
+func CreateUser(username string, rating int, isBot bool) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (username, rating, in_placement, is_bot, username_key)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	u := User{Username: username, Rating: rating, InPlacement: PlacementGames > 0, IsBot: isBot}
+	if err := tx.QueryRow(query, username, rating, u.InPlacement, isBot, usernameKey(username)).Scan(&u.ID); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	var eventID int64
+	if !u.InPlacement {
+		if eventID, err = insertAppliedOutboxEvent(tx, EventUserPlaced, UserPlacedEvent{UserID: u.ID, Username: u.Username, Rating: u.Rating, IsBot: isBot}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	noteOutboxApplied(eventID)
+	if isBot {
+		bots.register(username)
+	}
+	return &u, nil
+}
+
+// DeleteUserByID deletes a simulated player with their scores. The caller
+// takes them out of the rating engine; the user.deleted event is for
+// replicas.
+func DeleteUserByID(userID int64) error {
+	_, err := deleteUser(userID, false)
+	return err
+}
+
+// DeleteBotByID deletes a user only if it is a bot, so simulated churn can
+// never remove a real account. Deleting a real user's id fails with
+// sql.ErrNoRows. It returns the row as it was deleted, so the caller takes
+// the rating the database held out of the engine rather than one it read
+// earlier.
+func DeleteBotByID(userID int64) (UserDeletedEvent, error) {
+	return deleteUser(userID, true)
+}
+
+func deleteUser(userID int64, botsOnly bool) (UserDeletedEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return UserDeletedEvent{}, fmt.Errorf("failed to delete user: %w", err)
+	}
+	defer tx.Rollback()
+
+	ev := UserDeletedEvent{UserID: userID}
+	if len(metricDefs) > 0 || hasBoards() {
+		if ev.Metrics, ev.Boards, err = deleteUserScores(tx, userID); err != nil {
+			return UserDeletedEvent{}, err
+		}
+	}
+	var inPlacement bool
+	err = tx.QueryRow(`
+		DELETE FROM users WHERE id = $1 AND (is_bot OR NOT $2) RETURNING username, rating, in_placement
+	`, userID, botsOnly).Scan(&ev.Username, &ev.Rating, &inPlacement)
+	if err != nil {
+		return UserDeletedEvent{}, fmt.Errorf("failed to delete user: %w", err)
+	}
+	ev.Ranked = !inPlacement
+	eventID, err := insertAppliedOutboxEvent(tx, EventUserDeleted, ev)
+	if err != nil {
+		return UserDeletedEvent{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return UserDeletedEvent{}, fmt.Errorf("failed to delete user: %w", err)
+	}
+	noteOutboxApplied(eventID)
+
+	forgetUserScores(ev.Metrics, ev.Boards)
+	return ev, nil
 }
 
 func GetRatingCounts() (map[int]int, error) {
+	return getRatingCounts(db)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func getRatingCounts(q queryer) (map[int]int, error) {
 	query := `
 		SELECT rating, COUNT(*) as count 
 		FROM users 
+		WHERE NOT in_placement
 		GROUP BY rating
 	`
 
 
 
-	rows, err := db.Query(query)
+	rows, err := q.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get rating counts: %w", err)
 	}
@@ -268,8 +477,32 @@ func GetTotalUserCount() (int, error) {
 
 
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value, source, ok := lookupSetting(key); ok {
+		recordConfig(key, value, source)
 		return value
 	}
+	recordConfig(key, defaultValue, "default")
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	raw, source, _ := lookupSetting(key)
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		recordConfig(key, strconv.Itoa(defaultValue), "default")
+		return defaultValue
+	}
+	recordConfig(key, strconv.Itoa(value), source)
+	return value
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	raw, source, _ := lookupSetting(key)
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		recordConfig(key, strconv.FormatFloat(defaultValue, 'f', -1, 64), "default")
+		return defaultValue
+	}
+	recordConfig(key, strconv.FormatFloat(value, 'f', -1, 64), source)
+	return value
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const debugHistoryTail = 20
+
+// Flags raised by the user debug endpoint.
+const (
+	DebugFlagInPlacement   = "in_placement"
+	DebugFlagOutOfBounds   = "rating_out_of_bounds"
+	DebugFlagPendingEvents = "pending_outbox_events"
+	DebugFlagRankMismatch  = "engine_db_rank_mismatch"
+)
+
+type DebugUserResponse struct {
+	Success bool                 `json:"success"`
+	User    DebugUserRow         `json:"user"`
+	Ranks   DebugRanks           `json:"ranks"`
+	History []RatingHistoryEntry `json:"history"`
+	Pending DebugPending         `json:"pending"`
+	Flags   []string             `json:"flags"`
+}
+
+type DebugUserRow struct {
+	ID             int64              `json:"id"`
+	Username       string             `json:"username"`
+	Rating         int                `json:"rating"`
+	Tier           string             `json:"tier"`
+	InPlacement    bool               `json:"in_placement"`
+	PlacementGames int                `json:"placement_games"`
+	Placement      *PlacementProgress `json:"placement,omitempty"`
+	Matches        int                `json:"matches"`
+}
+
+// DebugRanks shows every place a rank can come from, so a support engineer
+// can see at a glance which one disagrees.
+type DebugRanks struct {
+	Engine          *int       `json:"engine"`
+	Database        *int       `json:"database"`
+	Snapshot        *int       `json:"snapshot"`
+	SnapshotTakenAt *time.Time `json:"snapshot_taken_at,omitempty"`
+}
+
+type DebugPending struct {
+	OutboxEvents      []json.RawMessage `json:"outbox_events"`
+	BackgroundUpdates int64             `json:"background_updates"`
+}
+
+// HandleDebugUser is read-only: it reports what the service knows about a
+// user without acting as them or changing anything.
+func HandleDebugUser(c *gin.Context) {
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	resp := DebugUserResponse{
+		Success: true,
+		User: DebugUserRow{
+			ID:             user.ID,
+			Username:       user.Username,
+			Rating:         user.Rating,
+			Tier:           tierForRating(user.Rating),
+			InPlacement:    user.InPlacement,
+			PlacementGames: user.PlacementGames,
+			Placement:      placementProgress(user),
+		},
+		Pending: DebugPending{
+			OutboxEvents:      []json.RawMessage{},
+			BackgroundUpdates: backgroundUpdates.Load(),
+		},
+		Flags: []string{},
+	}
+
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM matches WHERE player_a_id = $1 OR player_b_id = $1
+	`, user.ID).Scan(&resp.User.Matches); err != nil {
+		requestLog(c).Error("Debug user: failed to count matches", "username", user.Username, "error", err)
+	}
+
+	if user.InPlacement {
+		resp.Flags = append(resp.Flags, DebugFlagInPlacement)
+	} else {
+		engineRank := GetRankingEngine().GetRank(user.Rating)
+		resp.Ranks.Engine = &engineRank
+
+		var dbRank int
+		if err := db.QueryRow(`
+			SELECT COUNT(*) + 1 FROM users WHERE rating > $1 AND NOT in_placement
+		`, user.Rating).Scan(&dbRank); err != nil {
+			requestLog(c).Error("Debug user: failed to compute database rank", "username", user.Username, "error", err)
+		} else {
+			resp.Ranks.Database = &dbRank
+			if dbRank != engineRank {
+				resp.Flags = append(resp.Flags, DebugFlagRankMismatch)
+			}
+		}
+	}
+	if user.Rating < MinRating || user.Rating > MaxRating {
+		resp.Flags = append(resp.Flags, DebugFlagOutOfBounds)
+	}
+
+	if stabilityTracker != nil {
+		if pos, at, ok := stabilityTracker.Position(user.ID); ok {
+			resp.Ranks.Snapshot = &pos
+			resp.Ranks.SnapshotTakenAt = &at
+		}
+	}
+
+	history, err := GetRatingHistory(user.ID, debugHistoryTail)
+	if err != nil {
+		requestLog(c).Error("Debug user: failed to read recent history", "username", user.Username, "error", err)
+		history = []RatingHistoryEntry{}
+	}
+	resp.History = history
+
+	rows, err := db.Query(`
+		SELECT payload FROM outbox
+		WHERE processed_at IS NULL AND (payload->>'user_id' = $1
+			OR payload->'updates' @> jsonb_build_array(jsonb_build_object('user_id', $1::bigint)))
+		ORDER BY id
+	`, strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		requestLog(c).Error("Debug user: failed to read pending outbox events", "username", user.Username, "error", err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var payload json.RawMessage
+			if err := rows.Scan(&payload); err == nil {
+				resp.Pending.OutboxEvents = append(resp.Pending.OutboxEvents, payload)
+			}
+		}
+	}
+	if len(resp.Pending.OutboxEvents) > 0 {
+		resp.Flags = append(resp.Flags, DebugFlagPendingEvents)
+	}
+
+	respond(c, http.StatusOK, resp)
+}
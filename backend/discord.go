@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Requests older than this are rejected to stop captured signatures
+	// from being replayed.
+	discordSignatureMaxAge = 5 * time.Minute
+)
+
+type DiscordResponse struct {
+	Success bool   `json:"success"`
+	Content string `json:"content"`
+}
+
+// discordAuthMiddleware verifies X-Signature, which the bot computes as
+// hex(HMAC-SHA256(secret, timestamp + "\n" + method + "\n" + requestURI)).
+// Verification is skipped when DISCORD_SIGNING_SECRET is unset.
+func discordAuthMiddleware() gin.HandlerFunc {
+	secret := getEnv("DISCORD_SIGNING_SECRET", "")
+	if secret == "" {
+		slog.Warn("DISCORD_SIGNING_SECRET not set, Discord endpoints are unauthenticated")
+	}
+
+	return func(c *gin.Context) {
+		if secret == "" {
+			c.Next()
+			return
+		}
+
+		timestamp := c.GetHeader("X-Signature-Timestamp")
+		signature := c.GetHeader("X-Signature")
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || signature == "" {
+			abortWithError(c, http.StatusUnauthorized, "Missing or malformed request signature")
+			return
+		}
+
+		if math.Abs(time.Since(time.Unix(ts, 0)).Seconds()) > discordSignatureMaxAge.Seconds() {
+			abortWithError(c, http.StatusUnauthorized, "Request signature expired")
+			return
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\n" + c.Request.Method + "\n" + c.Request.URL.RequestURI()))
+		expected := hex.EncodeToString(mac.Sum(nil))
+
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+			abortWithError(c, http.StatusUnauthorized, "Invalid request signature")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (h *Handlers) HandleDiscordTop(c *gin.Context) {
+	n := parseIntParam(c.Query("n"), DefaultIntegrationTop)
+	if n < 1 {
+		n = DefaultIntegrationTop
+	}
+	if n > MaxIntegrationTop {
+		n = MaxIntegrationTop
+	}
+
+	rows, err := h.Service.Top(c.Request.Context(), n)
+	if err != nil {
+		requestLog(c).Error("Error fetching Discord top list", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+
+	c.JSON(http.StatusOK, DiscordResponse{
+		Success: true,
+		Content: formatTopTable(n, rows, discordBold),
+	})
+}
+
+func (h *Handlers) HandleDiscordRank(c *gin.Context) {
+	username := c.Param("username")
+
+	user, rank, err := h.Service.User(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, DiscordResponse{
+			Success: false,
+			Content: fmt.Sprintf("❓ No player named `%s`", username),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, DiscordResponse{
+		Success: true,
+		Content: formatUserRank(user, rank, discordBold),
+	})
+}
@@ -0,0 +1,12 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// Destructive admin endpoints accept ?dry_run=true: they run their checks and
+// report what would change (affected counts and a sample of the changes)
+// without committing anything.
+const dryRunSampleSize = 10
+
+func dryRunRequested(c *gin.Context) bool {
+	return c.Query("dry_run") == "true"
+}
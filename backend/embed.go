@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const embedRefreshInterval = 2 * time.Second
+
+var (
+	// Long-lived SSE responses would otherwise hold up server.Shutdown until
+	// its timeout, so they all watch this channel and return when it closes.
+	streamShutdown     = make(chan struct{})
+	streamShutdownOnce sync.Once
+)
+
+func closeStreams() {
+	streamShutdownOnce.Do(func() { close(streamShutdown) })
+}
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Leaderboard</title>
+<style>
+  body { margin: 0; font: 14px/1.4 -apple-system, "Segoe UI", Roboto, sans-serif; }
+  body.dark { background: #151718; color: #ecedee; }
+  body.light { background: #ffffff; color: #11181c; }
+  table { width: 100%; border-collapse: collapse; }
+  th, td { padding: 6px 10px; text-align: left; }
+  th { font-size: 12px; text-transform: uppercase; opacity: 0.6; }
+  tr + tr td { border-top: 1px solid rgba(128, 128, 128, 0.2); }
+  td.num { text-align: right; font-variant-numeric: tabular-nums; }
+  #status { font-size: 11px; opacity: 0.5; padding: 4px 10px; }
+</style>
+</head>
+<body class="{{.Theme}}">
+<table>
+  <thead><tr><th>#</th><th>Player</th><th class="num">Rating</th></tr></thead>
+  <tbody id="rows"></tbody>
+</table>
+<div id="status">connecting…</div>
+<script>
+  var rows = document.getElementById("rows");
+  var statusEl = document.getElementById("status");
+  function render(data) {
+    rows.innerHTML = "";
+    data.forEach(function (u) {
+      var tr = document.createElement("tr");
+      [u.rank, u.username, u.rating].forEach(function (v, i) {
+        var td = document.createElement("td");
+        td.textContent = v;
+        if (i === 2) td.className = "num";
+        tr.appendChild(td);
+      });
+      rows.appendChild(tr);
+    });
+  }
+  var source = new EventSource("top/stream?n={{.N}}");
+  source.addEventListener("leaderboard", function (e) {
+    render(JSON.parse(e.data));
+    statusEl.textContent = "live · updated " + new Date().toLocaleTimeString();
+  });
+  source.onerror = function () { statusEl.textContent = "reconnecting…"; };
+</script>
+</body>
+</html>
+`))
+
+func parseEmbedTop(c *gin.Context) int {
+	n := parseIntParam(c.Query("n"), DefaultIntegrationTop)
+	if n < 1 {
+		n = DefaultIntegrationTop
+	}
+	if n > MaxPageSize {
+		n = MaxPageSize
+	}
+	return n
+}
+
+func HandleEmbedTop(c *gin.Context) {
+	theme := c.DefaultQuery("theme", "light")
+	if theme != "dark" {
+		theme = "light"
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "public, max-age=300")
+	if err := embedTemplate.Execute(c.Writer, gin.H{"Theme": theme, "N": parseEmbedTop(c)}); err != nil {
+		requestLog(c).Error("Error rendering embed widget", "error", err)
+	}
+}
+
+// HandleEmbedTopStream pushes the current top N as a "leaderboard" SSE event,
+// re-sending only when the standings actually change.
+func (h *Handlers) HandleEmbedTopStream(c *gin.Context) {
+	n := parseEmbedTop(c)
+
+	// The server-wide WriteTimeout would cut the stream after 15s.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		requestLog(c).Warn("Could not clear write deadline for SSE stream", "error", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(embedRefreshInterval)
+	defer ticker.Stop()
+	refreshes, unsubscribe := leaderboardRefreshes.subscribe()
+	defer unsubscribe()
+
+	var last string
+	send := func() {
+		rows, err := h.Service.Top(c.Request.Context(), n)
+		if err != nil {
+			requestLog(c).Error("Error fetching embed standings", "error", err)
+			return
+		}
+		payload, _ := json.Marshal(rows)
+		if string(payload) == last {
+			c.SSEvent("ping", "")
+			return
+		}
+		last = string(payload)
+		c.SSEvent("leaderboard", string(payload))
+	}
+
+	send()
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-streamShutdown:
+			return false
+		case <-ticker.C:
+			send()
+			return true
+		case <-refreshes:
+			last = ""
+			send()
+			return true
+		}
+	})
+}
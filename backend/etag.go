@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reads that only change with the ratings carry a weak ETag built from the
+// instance's outbox position, and a request whose If-None-Match already has
+// it gets 304 without the page being built. Every rating write records an
+// outbox event in its transaction, so the newest outbox id an instance has
+// applied names the data it serves. Ids come from the database, so they are
+// never reused after a restart and mean the same thing on every instance.
+
+// outboxPosition is the newest outbox id whose change this instance's engine
+// and reads reflect.
+var outboxPosition atomic.Int64
+
+// noteOutboxApplied advances the position to id once its change is visible:
+// for relayed and tailed events when they are applied, and for writes that
+// update the engine themselves once they have committed.
+func noteOutboxApplied(id int64) {
+	for {
+		current := outboxPosition.Load()
+		if id <= current || outboxPosition.CompareAndSwap(current, id) {
+			return
+		}
+	}
+}
+
+// readVersion is the version of what a read can see.
+func readVersion() int64 {
+	return outboxPosition.Load()
+}
+
+func engineETag(version int64) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+// statsETag adds a digest of the stats' data fields to the version. Counters
+// such as write_queues move on every request, so they are left out: a tag
+// that changed with them would never match.
+func statsETag(version int64, data gin.H) string {
+	h := fnv.New64a()
+	json.NewEncoder(h).Encode(data)
+	return fmt.Sprintf(`W/"%d-%x"`, version, h.Sum64())
+}
+
+// etagMatches compares an If-None-Match header with etag the weak way, as
+// conditional GETs do.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers 304 when the client already has etag. It reports
+// whether the request is done.
+func notModified(c *gin.Context, etag string) bool {
+	if !etagMatches(c.GetHeader("If-None-Match"), etag) {
+		return false
+	}
+	setETag(c, etag)
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// setETag tags a successful response. no-cache has shared caches revalidate
+// it on every request instead of serving it stale.
+func setETag(c *gin.Context, etag string) {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The EventBridge sink calls PutEvents on EVENTBRIDGE_BUS. Each event becomes
+// an entry with Source EVENTBRIDGE_SOURCE, DetailType the CloudEvents type
+// (leaderboard.rank_change), and Detail the event payload, so rules can match
+// on fields such as detail.new_rank. Requests are signed with SigV4 using the
+// standard credential chain: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, then
+// the ECS task role, then the EC2 instance role.
+const (
+	eventBridgeMaxBatch = 10
+	awsCredentialMargin = 5 * time.Minute
+)
+
+type eventBridgeSink struct {
+	bus      string
+	source   string
+	region   string
+	endpoint string
+	client   *http.Client
+	creds    *awsCredentialChain
+}
+
+func newEventBridgeSink() (*eventBridgeSink, error) {
+	bus := getEnv("EVENTBRIDGE_BUS", "")
+	if bus == "" {
+		return nil, nil
+	}
+	region := getEnv("AWS_REGION", getEnv("AWS_DEFAULT_REGION", ""))
+	if region == "" {
+		return nil, fmt.Errorf("EVENTBRIDGE_BUS is set but AWS_REGION is not")
+	}
+	client := &http.Client{Timeout: eventSinkTimeout}
+	return &eventBridgeSink{
+		bus:      bus,
+		source:   getEnv("EVENTBRIDGE_SOURCE", "leaderboard"),
+		region:   region,
+		endpoint: getEnv("EVENTBRIDGE_ENDPOINT", "https://events."+region+".amazonaws.com"),
+		client:   client,
+		creds:    &awsCredentialChain{client: client},
+	}, nil
+}
+
+func (s *eventBridgeSink) Name() string  { return "eventbridge:" + s.bus }
+func (s *eventBridgeSink) MaxBatch() int { return eventBridgeMaxBatch }
+
+type eventBridgeEntry struct {
+	EventBusName string `json:"EventBusName"`
+	Source       string `json:"Source"`
+	DetailType   string `json:"DetailType"`
+	Detail       string `json:"Detail"`
+	Time         int64  `json:"Time"` // epoch seconds, as the AWS JSON protocol sends timestamps
+}
+
+func (s *eventBridgeSink) Publish(ctx context.Context, events []CloudEvent) ([]CloudEvent, error) {
+	entries := make([]eventBridgeEntry, len(events))
+	for i, ev := range events {
+		entries[i] = eventBridgeEntry{EventBusName: s.bus, Source: s.source, DetailType: ev.Type, Detail: string(ev.Data), Time: ev.Time.Unix()}
+	}
+	body, err := json.Marshal(map[string]any{"Entries": entries})
+	if err != nil {
+		return events, err
+	}
+	creds, err := s.creds.get(ctx)
+	if err != nil {
+		return events, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return events, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	signAWSRequest(req, body, creds, s.region, "events", time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return events, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		return events, sinkError("EventBridge", resp.StatusCode, respBody)
+	}
+
+	// Entries that failed come back in place with an ErrorCode.
+	var result struct {
+		FailedEntryCount int `json:"FailedEntryCount"`
+		Entries          []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"Entries"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil || result.FailedEntryCount == 0 {
+		return nil, nil
+	}
+	var failed []CloudEvent
+	var firstErr error
+	for i, e := range result.Entries {
+		if e.ErrorCode != "" && i < len(events) {
+			failed = append(failed, events[i])
+			if firstErr == nil {
+				firstErr = fmt.Errorf("EventBridge rejected an entry: %s: %s", e.ErrorCode, e.ErrorMessage)
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// awsCredentialChain resolves credentials the way the AWS SDKs do for the
+// common cases, caching role credentials until shortly before they expire.
+type awsCredentialChain struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	cached *awsCredentials
+}
+
+func (c *awsCredentialChain) get(ctx context.Context) (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != nil && time.Until(c.cached.Expiration) > awsCredentialMargin {
+		return c.cached, nil
+	}
+	var creds *awsCredentials
+	var err error
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		creds, err = c.containerCredentials(ctx)
+	} else {
+		creds, err = c.instanceCredentials(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials: %w", err)
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+func (c *awsCredentialChain) containerCredentials(ctx context.Context) (*awsCredentials, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		url = "http://169.254.170.2" + rel
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return c.fetchCredentials(req)
+}
+
+// instanceCredentials reads the EC2 instance role through IMDSv2.
+func (c *awsCredentialChain) instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := c.fetchText(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, imds+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := c.fetchText(req)
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ = strings.Cut(strings.TrimSpace(role), "\n")
+	if role == "" {
+		return nil, errors.New("the instance has no IAM role")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, imds+"/meta-data/iam/security-credentials/"+role, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return c.fetchCredentials(req)
+}
+
+func (c *awsCredentialChain) fetchText(req *http.Request) (string, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = sinkError(req.URL.Host, resp.StatusCode, body)
+	}
+	return string(body), err
+}
+
+func (c *awsCredentialChain) fetchCredentials(req *http.Request) (*awsCredentials, error) {
+	body, err := c.fetchText(req)
+	if err != nil {
+		return nil, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return nil, fmt.Errorf("unreadable credentials: %w", err)
+	}
+	if creds.AccessKeyID == "" {
+		return nil, errors.New("credentials response has no AccessKeyId")
+	}
+	return &creds, nil
+}
+
+// signAWSRequest adds a SigV4 Authorization header for a request whose
+// payload is body.
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
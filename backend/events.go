@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /events streams a rank_change event for every rating update that moves
+// someone's position. Ranks are only computed while at least one client is
+// subscribed, so the write paths pay nothing otherwise. A client that can't
+// keep up loses events rather than slowing the writers down.
+const (
+	rankEventBuffer    = 256
+	rankEventHeartbeat = 15 * time.Second
+)
+
+type RankChangeEvent struct {
+	Username  string `json:"username"`
+	OldRank   int    `json:"old_rank"`
+	NewRank   int    `json:"new_rank"`
+	OldRating int    `json:"old_rating"`
+	Rating    int    `json:"rating"`
+	Source    string `json:"source"`
+}
+
+type RankEventStats struct {
+	Subscribers int   `json:"subscribers"`
+	Dropped     int64 `json:"dropped"`
+}
+
+type rankEventHub struct {
+	mu      sync.Mutex
+	subs    map[chan RankChangeEvent]struct{}
+	active  atomic.Int32
+	dropped atomic.Int64
+}
+
+var rankEvents = &rankEventHub{subs: map[chan RankChangeEvent]struct{}{}}
+
+func (h *rankEventHub) subscribe() (<-chan RankChangeEvent, func()) {
+	ch := make(chan RankChangeEvent, rankEventBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.active.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.active.Store(int32(len(h.subs)))
+		h.mu.Unlock()
+	}
+}
+
+func (h *rankEventHub) publish(events []RankChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+				h.dropped.Add(1)
+			}
+		}
+	}
+}
+
+func (h *rankEventHub) Stats() RankEventStats {
+	return RankEventStats{Subscribers: int(h.active.Load()), Dropped: h.dropped.Load()}
+}
+
+// applyRatingChange moves one user in the engine and publishes their rank
+// change to /events subscribers. Moves in the main engine are mirrored in the
+// bot counts.
+func applyRatingChange(re RankEngine, username string, oldRating, newRating int, source string) {
+	if re == GetRankingEngine() {
+		bots.update(username, oldRating, newRating)
+	}
+	if rankEvents.active.Load() == 0 {
+		re.UpdateRating(oldRating, newRating)
+		return
+	}
+	oldRank := re.GetRank(oldRating)
+	re.UpdateRating(oldRating, newRating)
+	if newRank := re.GetRank(newRating); newRank != oldRank {
+		rankEvents.publish([]RankChangeEvent{{
+			Username:  username,
+			OldRank:   oldRank,
+			NewRank:   newRank,
+			OldRating: oldRating,
+			Rating:    newRating,
+			Source:    source,
+		}})
+	}
+}
+
+// applyRatingBatch is applyRatingChange for a batch. Old ranks are read
+// before and new ranks after the whole batch, so each reflects the board the
+// batch started from and the one it produced.
+func applyRatingBatch(re RankEngine, updates []RatingUpdate, source string) {
+	if re == GetRankingEngine() {
+		bots.updateBatch(updates)
+	}
+	if rankEvents.active.Load() == 0 {
+		re.BatchUpdateRatings(updates)
+		return
+	}
+	ratings := make([]int, len(updates))
+	for i, u := range updates {
+		ratings[i] = u.OldRating
+	}
+	oldRanks := re.GetRankBatch(ratings)
+	re.BatchUpdateRatings(updates)
+	for i, u := range updates {
+		ratings[i] = u.NewRating
+	}
+	newRanks := re.GetRankBatch(ratings)
+
+	var events []RankChangeEvent
+	for i, u := range updates {
+		if oldRanks[i] != newRanks[i] {
+			events = append(events, RankChangeEvent{
+				Username:  u.Username,
+				OldRank:   oldRanks[i],
+				NewRank:   newRanks[i],
+				OldRating: u.OldRating,
+				Rating:    u.NewRating,
+				Source:    source,
+			})
+		}
+	}
+	if len(events) > 0 {
+		rankEvents.publish(events)
+	}
+}
+
+// HandleRankEvents serves the stream. ?schema_version= pins the payloads to
+// an event schema version (see eventschemas.go), and ?format=cloudevents
+// wraps each one in a CloudEvent.
+func HandleRankEvents(c *gin.Context) {
+	version, err := parseSchemaVersion(c.Query("schema_version"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := cmp.Or(c.Query("format"), EventFormatNative)
+	if !slices.Contains(eventFormats, format) {
+		respondError(c, http.StatusBadRequest, "format must be native or cloudevents")
+		return
+	}
+
+	// The server-wide WriteTimeout would cut the stream after 15s.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		requestLog(c).Warn("Could not clear write deadline for SSE stream", "error", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("X-Event-Schema-Version", strconv.Itoa(cmp.Or(version, eventSchemaVersion)))
+
+	events, unsubscribe := rankEvents.subscribe()
+	defer unsubscribe()
+	refreshes, unsubscribeRefreshes := leaderboardRefreshes.subscribe()
+	defer unsubscribeRefreshes()
+
+	heartbeat := time.NewTicker(rankEventHeartbeat)
+	defer heartbeat.Stop()
+
+	c.SSEvent("ping", "")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-streamShutdown:
+			return false
+		case <-heartbeat.C:
+			c.SSEvent("ping", "")
+			return true
+		case e := <-events:
+			sendStreamEvent(c, WebhookEventRankChange, e, version, format)
+			return true
+		case e := <-refreshes:
+			sendStreamEvent(c, WebhookEventRefresh, e, version, format)
+			return true
+		}
+	})
+}
+
+func sendStreamEvent(c *gin.Context, eventType string, payload any, version int, format string) {
+	data, ok, err := encodeEvent(EventChannelStream, eventType, payload, version)
+	if err == nil && ok && format == EventFormatCloudEvents {
+		data, err = json.Marshal(newCloudEvent(newEventID(), eventType, time.Now().UTC(), version, eventSubject(payload), data))
+	}
+	if err != nil {
+		requestLog(c).Error("Error encoding event", "event_type", eventType, "error", err)
+		return
+	}
+	if ok {
+		c.SSEvent(eventType, string(data))
+	}
+}
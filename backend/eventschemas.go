@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every event the service emits has a JSON schema, served at /events/schemas.
+// Schemas only grow: a change may add fields or event types, never remove,
+// rename, or retype one, and consumers are expected to ignore fields they
+// don't know. The registry as a whole carries one version, bumped whenever
+// any event gains a field or a new event type appears. Consumers that can't
+// tolerate additions pin a version (?schema_version= on /events, or a
+// webhook's schema_version) and then receive events exactly as they were at
+// that version: later fields are stripped and later event types withheld.
+//
+// Schemas are generated from the payload structs, so they can't drift from
+// what is sent. To change an event, add the field to its struct and to Added
+// with the new eventSchemaVersion.
+const eventSchemaVersion = 2
+
+const (
+	EventChannelStream = "stream" // GET /events and webhooks
+	EventChannelOutbox = "outbox" // between instances, through the outbox table
+)
+
+type eventSchema struct {
+	Type    string
+	Channel string
+	Payload any
+	// Since is the registry version that introduced the event.
+	Since int
+	// Added maps top-level fields added after Since to the version that
+	// added them.
+	Added map[string]int
+}
+
+type WebhookTestEvent struct {
+	SubscriptionID int64  `json:"subscription_id"`
+	Message        string `json:"message"`
+}
+
+var eventSchemas = []eventSchema{
+	{Type: WebhookEventRankChange, Channel: EventChannelStream, Payload: RankChangeEvent{}, Since: 1},
+	{Type: WebhookEventRefresh, Channel: EventChannelStream, Payload: LeaderboardRefreshedEvent{}, Since: 1},
+	{Type: WebhookEventTest, Channel: EventChannelStream, Payload: WebhookTestEvent{}, Since: 1},
+	{Type: EventRatingUpdated, Channel: EventChannelOutbox, Payload: RatingUpdatedEvent{}, Since: 1},
+	{Type: EventRatingsUpdated, Channel: EventChannelOutbox, Payload: RatingsUpdatedEvent{}, Since: 1},
+	{Type: EventUserPlaced, Channel: EventChannelOutbox, Payload: UserPlacedEvent{}, Since: 1, Added: map[string]int{"is_bot": 2}},
+	{Type: EventUserDeleted, Channel: EventChannelOutbox, Payload: UserDeletedEvent{}, Since: 1},
+	{Type: EventMetricUpdated, Channel: EventChannelOutbox, Payload: MetricUpdatedEvent{}, Since: 1},
+	{Type: EventBoardRatingUpdated, Channel: EventChannelOutbox, Payload: BoardRatingUpdatedEvent{}, Since: 1},
+	{Type: EventLeaderboardRefreshed, Channel: EventChannelOutbox, Payload: LeaderboardRefreshedEvent{}, Since: 1},
+}
+
+// ValidateEventSchemas checks that the registry only describes additions
+// the payload structs actually have.
+func ValidateEventSchemas() error {
+	seen := map[string]bool{}
+	for _, s := range eventSchemas {
+		key := s.Channel + ":" + s.Type
+		if seen[key] {
+			return fmt.Errorf("event %s is registered twice", key)
+		}
+		seen[key] = true
+		if s.Since < 1 || s.Since > eventSchemaVersion {
+			return fmt.Errorf("event %s: since %d is outside 1-%d", s.Type, s.Since, eventSchemaVersion)
+		}
+		props := jsonSchemaFor(reflect.TypeOf(s.Payload))["properties"].(map[string]any)
+		for field, v := range s.Added {
+			if _, ok := props[field]; !ok {
+				return fmt.Errorf("event %s: added field %q is not in its payload", s.Type, field)
+			}
+			if v <= s.Since || v > eventSchemaVersion {
+				return fmt.Errorf("event %s: field %q added at version %d, outside %d-%d", s.Type, field, v, s.Since+1, eventSchemaVersion)
+			}
+		}
+	}
+	return nil
+}
+
+func findEventSchema(channel, eventType string) (eventSchema, bool) {
+	i := slices.IndexFunc(eventSchemas, func(s eventSchema) bool { return s.Channel == channel && s.Type == eventType })
+	if i < 0 {
+		return eventSchema{}, false
+	}
+	return eventSchemas[i], true
+}
+
+// at returns the event's JSON schema as of a registry version.
+func (s eventSchema) at(version int) map[string]any {
+	doc := jsonSchemaFor(reflect.TypeOf(s.Payload))
+	props := doc["properties"].(map[string]any)
+	var required []string
+	for _, f := range doc["required"].([]string) {
+		if s.Added[f] <= version {
+			required = append(required, f)
+		}
+	}
+	for f, v := range s.Added {
+		if v > version {
+			delete(props, f)
+		}
+	}
+	doc["required"] = required
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["$id"] = eventSchemaID(s.Channel, s.Type, version)
+	doc["title"] = s.Type
+	return doc
+}
+
+func eventSchemaID(channel, eventType string, version int) string {
+	return fmt.Sprintf("urn:leaderboard:%s:%s:v%d", channel, eventType, version)
+}
+
+// versions lists the registry versions at which the event changed.
+func (s eventSchema) versions() []int {
+	vs := []int{s.Since}
+	for _, v := range s.Added {
+		if !slices.Contains(vs, v) {
+			vs = append(vs, v)
+		}
+	}
+	slices.Sort(vs)
+	return vs
+}
+
+// encodeEvent encodes a payload as consumers pinned to version expect it; 0
+// means the latest. ok is false when the event type is newer than the pin.
+func encodeEvent(channel, eventType string, payload any, version int) (data json.RawMessage, ok bool, err error) {
+	data, err = json.Marshal(payload)
+	if err != nil || version == 0 || version >= eventSchemaVersion {
+		return data, err == nil, err
+	}
+	s, found := findEventSchema(channel, eventType)
+	if !found {
+		return data, true, nil
+	}
+	if s.Since > version {
+		return nil, false, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, false, err
+	}
+	for f, v := range s.Added {
+		if v > version {
+			delete(fields, f)
+		}
+	}
+	data, err = json.Marshal(fields)
+	return data, err == nil, err
+}
+
+// parseSchemaVersion reads a pinned version; empty is 0, the latest.
+func parseSchemaVersion(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 || v > eventSchemaVersion {
+		return 0, fmt.Errorf("schema_version must be 1-%d", eventSchemaVersion)
+	}
+	return v, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchemaFor describes a payload type the way encoding/json writes it.
+// Objects stay open to additional properties, since schemas only grow.
+func jsonSchemaFor(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		doc := jsonSchemaFor(t.Elem())
+		doc["type"] = []any{doc["type"], "null"}
+		return doc
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "required": required, "additionalProperties": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	}
+	return map[string]any{}
+}
+
+type EventSchemaInfo struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Since   int            `json:"since"`
+	Schema  map[string]any `json:"schema"`
+}
+
+// HandleEventSchemas serves GET /events/schemas, every event's schema as of
+// ?version= (default the latest).
+func HandleEventSchemas(c *gin.Context) {
+	version, err := parseSchemaVersion(c.Query("version"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, strings.Replace(err.Error(), "schema_version", "version", 1))
+		return
+	}
+	if version == 0 {
+		version = eventSchemaVersion
+	}
+
+	events := []EventSchemaInfo{}
+	for _, s := range eventSchemas {
+		if s.Since <= version {
+			events = append(events, EventSchemaInfo{Type: s.Type, Channel: s.Channel, Since: s.Since, Schema: s.at(version)})
+		}
+	}
+	respond(c, http.StatusOK, gin.H{
+		"version":        version,
+		"latest_version": eventSchemaVersion,
+		"compatibility":  "additive",
+		"events":         events,
+	})
+}
+
+// HandleEventSchemaVersions serves GET /events/schemas/:type, every version
+// of one event's schema. ?channel= picks between a stream and an outbox event
+// of the same name.
+func HandleEventSchemaVersions(c *gin.Context) {
+	var matches []eventSchema
+	for _, s := range eventSchemas {
+		if s.Type == c.Param("type") && (c.Query("channel") == "" || c.Query("channel") == s.Channel) {
+			matches = append(matches, s)
+		}
+	}
+	if len(matches) == 0 {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("No schema for event %q", c.Param("type")))
+		return
+	}
+
+	type schemaVersion struct {
+		Version int            `json:"version"`
+		Schema  map[string]any `json:"schema"`
+	}
+	var out []gin.H
+	for _, s := range matches {
+		var versions []schemaVersion
+		for _, v := range s.versions() {
+			versions = append(versions, schemaVersion{Version: v, Schema: s.at(v)})
+		}
+		out = append(out, gin.H{"type": s.Type, "channel": s.Channel, "versions": versions})
+	}
+	respond(c, http.StatusOK, gin.H{
+		"events": out,
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event sinks publish the /events stream to a managed cloud bus, so users on
+// AWS or GCP can consume rank events without running a broker or receiving
+// webhooks. A sink is enabled by its environment variables (EVENTBRIDGE_BUS,
+// PUBSUB_TOPIC) and authenticates with the platform's usual credentials.
+// Events are queued per sink, published in batches, and retried; a full
+// queue drops events. Only the primary publishes, and while any sink is
+// enabled every write computes ranks, as for an /events client.
+var (
+	eventSinkQueueSize   = max(getEnvInt("EVENT_SINK_QUEUE_SIZE", 10000), 1)
+	eventSinkFlush       = time.Duration(max(getEnvInt("EVENT_SINK_FLUSH_MS", 250), 1)) * time.Millisecond
+	eventSinkMaxAttempts = max(getEnvInt("EVENT_SINK_MAX_ATTEMPTS", 3), 1)
+	eventSinkTimeout     = time.Duration(getEnvInt("EVENT_SINK_TIMEOUT_MS", 5000)) * time.Millisecond
+
+	eventSinks            []*sinkRunner
+	unsubscribeEventSinks func()
+)
+
+// EventSink publishes batches of at most MaxBatch events. It returns the
+// events that weren't accepted, which are retried.
+type EventSink interface {
+	Name() string
+	MaxBatch() int
+	Publish(ctx context.Context, events []CloudEvent) ([]CloudEvent, error)
+}
+
+type EventSinkStats struct {
+	Sink            string     `json:"sink"`
+	Published       int64      `json:"published"`
+	Failed          int64      `json:"failed"`
+	Retries         int64      `json:"retries"`
+	Dropped         int64      `json:"dropped"`
+	Pending         int        `json:"pending"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastPublishedAt *time.Time `json:"last_published_at,omitempty"`
+}
+
+type sinkRunner struct {
+	sink  EventSink
+	queue chan CloudEvent
+	stop  chan struct{}
+	done  chan struct{}
+
+	published, failed, retries, dropped atomic.Int64
+
+	mu              sync.Mutex
+	lastError       string
+	lastPublishedAt time.Time
+}
+
+// StartEventSinks starts every configured sink. A sink that is configured
+// but incomplete stops startup.
+func StartEventSinks() error {
+	var sinks []EventSink
+	if sink, err := newEventBridgeSink(); err != nil {
+		return err
+	} else if sink != nil {
+		sinks = append(sinks, sink)
+	}
+	if sink, err := newPubSubSink(); err != nil {
+		return err
+	} else if sink != nil {
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	for _, sink := range sinks {
+		r := &sinkRunner{
+			sink:  sink,
+			queue: make(chan CloudEvent, eventSinkQueueSize),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		eventSinks = append(eventSinks, r)
+		go r.run()
+		slog.Info("✓ Publishing rank events", "sink", sink.Name())
+	}
+
+	events, unsubscribeEvents := rankEvents.subscribe()
+	refreshes, unsubscribeRefreshes := leaderboardRefreshes.subscribe()
+	stop := make(chan struct{})
+	unsubscribeEventSinks = func() {
+		unsubscribeEvents()
+		unsubscribeRefreshes()
+		close(stop)
+	}
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				publishToSinks(WebhookEventRankChange, e)
+			case e := <-refreshes:
+				publishToSinks(WebhookEventRefresh, e)
+			}
+		}
+	}()
+	return nil
+}
+
+// StopEventSinks publishes what is already queued, one attempt per batch,
+// and stops the sinks.
+func StopEventSinks() {
+	if unsubscribeEventSinks == nil {
+		return
+	}
+	unsubscribeEventSinks()
+	for _, r := range eventSinks {
+		close(r.stop)
+	}
+	for _, r := range eventSinks {
+		<-r.done
+	}
+	slog.Info("✓ Event sinks stopped")
+}
+
+func publishToSinks(eventType string, payload any) {
+	data, _, err := encodeEvent(EventChannelStream, eventType, payload, 0)
+	if err != nil {
+		slog.Error("Error encoding event for sinks", "event_type", eventType, "error", err)
+		return
+	}
+	ev := newCloudEvent(newEventID(), eventType, time.Now().UTC(), 0, eventSubject(payload), data)
+	for _, r := range eventSinks {
+		select {
+		case r.queue <- ev:
+		default:
+			r.dropped.Add(1)
+		}
+	}
+}
+
+func (r *sinkRunner) run() {
+	defer close(r.done)
+	for {
+		var first CloudEvent
+		select {
+		case <-r.stop:
+			r.drain()
+			return
+		case first = <-r.queue:
+		}
+
+		// Wait up to the flush interval for the batch to fill.
+		batch := []CloudEvent{first}
+		flush := time.NewTimer(eventSinkFlush)
+	fill:
+		for len(batch) < r.sink.MaxBatch() {
+			select {
+			case ev := <-r.queue:
+				batch = append(batch, ev)
+			case <-flush.C:
+				break fill
+			case <-r.stop:
+				break fill
+			}
+		}
+		flush.Stop()
+		r.publish(batch, eventSinkMaxAttempts)
+	}
+}
+
+func (r *sinkRunner) drain() {
+	for {
+		var batch []CloudEvent
+	fill:
+		for len(batch) < r.sink.MaxBatch() {
+			select {
+			case ev := <-r.queue:
+				batch = append(batch, ev)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		r.publish(batch, 1)
+	}
+}
+
+func (r *sinkRunner) publish(batch []CloudEvent, attempts int) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), eventSinkTimeout)
+		failed, err := r.sink.Publish(ctx, batch)
+		cancel()
+
+		r.published.Add(int64(len(batch) - len(failed)))
+		r.mu.Lock()
+		if len(failed) < len(batch) {
+			r.lastPublishedAt = time.Now()
+		}
+		if err != nil {
+			r.lastError = err.Error()
+		}
+		r.mu.Unlock()
+
+		if len(failed) == 0 {
+			return
+		}
+		if attempt >= attempts {
+			r.failed.Add(int64(len(failed)))
+			slog.Error("Event sink giving up on events", "sink", r.sink.Name(), "events", len(failed), "attempts", attempt, "error", err)
+			return
+		}
+		r.retries.Add(1)
+		batch = failed
+		select {
+		case <-r.stop:
+			attempts = attempt + 1
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+func (r *sinkRunner) Stats() EventSinkStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := EventSinkStats{
+		Sink:      r.sink.Name(),
+		Published: r.published.Load(),
+		Failed:    r.failed.Load(),
+		Retries:   r.retries.Load(),
+		Dropped:   r.dropped.Load(),
+		Pending:   len(r.queue),
+		LastError: r.lastError,
+	}
+	if !r.lastPublishedAt.IsZero() {
+		t := r.lastPublishedAt
+		s.LastPublishedAt = &t
+	}
+	return s
+}
+
+func eventSinkStats() []EventSinkStats {
+	stats := make([]EventSinkStats, len(eventSinks))
+	for i, r := range eventSinks {
+		stats[i] = r.Stats()
+	}
+	return stats
+}
+
+// sinkError wraps a non-2xx answer from a sink's API.
+func sinkError(sink string, status int, body []byte) error {
+	if len(body) > 300 {
+		body = body[:300]
+	}
+	return fmt.Errorf("%s answered %d: %s", sink, status, body)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The full ranked leaderboard, for analytics and backups, is written by the
+// export command and streamed by GET /export. Both read it with one query, so
+// the rows are a consistent snapshot, and write each row as it is scanned, so
+// memory stays flat however many users there are.
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// exportSlots caps the exports streaming at once; each holds a connection
+// for as long as its client takes to read.
+var exportSlots = make(chan struct{}, max(getEnvInt("EXPORT_CONCURRENCY", 2), 1))
+
+type ExportRow struct {
+	Rank     int    `json:"rank"`
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	IsBot    bool   `json:"is_bot"`
+}
+
+// exportLeaderboard writes the ranked users in leaderboard order, real users
+// only unless includeBots, as /leaderboard does. Ranks are 1 + the users
+// listed above with a strictly higher rating; players in placement have no
+// rank and are left out. When w is an http.Flusher it is flushed every
+// STREAM_FLUSH_ROWS rows.
+func exportLeaderboard(ctx context.Context, w io.Writer, format string, includeBots bool) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT RANK() OVER (ORDER BY rating DESC), id, username, rating, is_bot
+		FROM users
+		WHERE NOT in_placement AND ($1 OR NOT is_bot)
+		ORDER BY rating DESC, `+collateUsername("username")+` ASC, id ASC
+	`, includeBots)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	buf := bufio.NewWriter(w)
+	var cw *csv.Writer
+	enc := json.NewEncoder(buf)
+	if format == ExportCSV {
+		cw = csv.NewWriter(buf)
+		cw.Write([]string{"rank", "id", "username", "rating", "is_bot"})
+	}
+	flush := func() error {
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		}
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	n := 0
+	for rows.Next() {
+		var r ExportRow
+		if err := rows.Scan(&r.Rank, &r.ID, &r.Username, &r.Rating, &r.IsBot); err != nil {
+			return n, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		if cw != nil {
+			err = cw.Write([]string{
+				strconv.Itoa(r.Rank), strconv.FormatInt(r.ID, 10), r.Username,
+				strconv.Itoa(r.Rating), strconv.FormatBool(r.IsBot),
+			})
+		} else {
+			err = enc.Encode(r)
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to write export: %w", err)
+		}
+		n++
+		if streamFlushRows > 0 && n%streamFlushRows == 0 {
+			if err := flush(); err != nil {
+				return n, fmt.Errorf("failed to write export: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("error iterating user rows: %w", err)
+	}
+
+	if err := flush(); err != nil {
+		return n, fmt.Errorf("failed to write export: %w", err)
+	}
+	return n, nil
+}
+
+// HandleExport streams the leaderboard as CSV or NDJSON. Once the first row
+// is out the status can't change, so the outcome is sent in the
+// X-Export-Status and X-Export-Rows trailers: a download without
+// "complete" is cut short.
+func HandleExport(c *gin.Context) {
+	format := c.DefaultQuery("format", ExportCSV)
+	var contentType string
+	switch format {
+	case ExportCSV:
+		contentType = "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		contentType = "application/x-ndjson"
+	default:
+		respondError(c, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	select {
+	case exportSlots <- struct{}{}:
+		defer func() { <-exportSlots }()
+	default:
+		c.Header("Retry-After", "10")
+		respondError(c, http.StatusTooManyRequests, "Too many exports are running; try again shortly")
+		return
+	}
+
+	// An export of a large board can outlive the server-wide WriteTimeout.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		requestLog(c).Warn("Could not clear write deadline for export", "error", err)
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="leaderboard.%s"`, format))
+	c.Header("Trailer", "X-Export-Status, X-Export-Rows")
+	c.Status(http.StatusOK)
+
+	started := time.Now()
+	n, err := exportLeaderboard(c.Request.Context(), c.Writer, format, includeBots(c))
+	if err != nil && !c.Writer.Written() {
+		// Nothing was sent yet, so the client can still get a proper error.
+		requestLog(c).Error("Error exporting leaderboard", "error", err)
+		for _, h := range []string{"Content-Type", "Content-Disposition", "Trailer"} {
+			c.Writer.Header().Del(h)
+		}
+		respondError(c, http.StatusInternalServerError, "Failed to export leaderboard")
+		return
+	}
+
+	status := "complete"
+	if err != nil {
+		status = "interrupted"
+		requestLog(c).Error("Error streaming export", "rows_sent", n, "error", err)
+	} else {
+		requestLog(c).Info("✓ Exported leaderboard", "users", n, "format", format, "duration", time.Since(started))
+	}
+	c.Writer.Header().Set("X-Export-Status", status)
+	c.Writer.Header().Set("X-Export-Rows", strconv.Itoa(n))
+}
@@ -0,0 +1,168 @@
+package main
+
+import "sync"
+
+// FenwickEngine keeps rating counts in a binary indexed tree, so a rank is an
+// O(log n) prefix sum instead of a scan over every rating above it.
+type FenwickEngine struct {
+	mu sync.RWMutex
+
+	// lo and hi bound the values counted: the rating bounds for the rating
+	// engine, or a metric's range.
+	lo, hi int
+
+	// tree is 1-indexed: position rating-lo+1.
+	tree       []int
+	counts     []int
+	totalUsers int
+
+	engineVersion
+}
+
+func NewFenwickEngine(counts map[int]int) *FenwickEngine {
+	return newFenwickRange(MinRating, MaxRating, counts)
+}
+
+func newFenwickRange(lo, hi int, counts map[int]int) *FenwickEngine {
+	size := hi - lo + 1
+	fe := &FenwickEngine{
+		lo:     lo,
+		hi:     hi,
+		tree:   make([]int, size+1),
+		counts: make([]int, size+1),
+	}
+	for rating, count := range counts {
+		if rating >= fe.lo && rating <= fe.hi {
+			fe.add(rating, count)
+		}
+	}
+	fe.bump()
+	return fe
+}
+
+func (fe *FenwickEngine) add(rating int, delta int) {
+	i := rating - fe.lo + 1
+	fe.counts[i] += delta
+	fe.totalUsers += delta
+	for ; i < len(fe.tree); i += i & -i {
+		fe.tree[i] += delta
+	}
+}
+
+// prefix returns the number of users with rating <= the given rating.
+func (fe *FenwickEngine) prefix(rating int) int {
+	sum := 0
+	for i := rating - fe.lo + 1; i > 0; i -= i & -i {
+		sum += fe.tree[i]
+	}
+	return sum
+}
+
+func (fe *FenwickEngine) rankLocked(rating int) int {
+	if rating < fe.lo || rating > fe.hi {
+		return -1
+	}
+	return 1 + fe.totalUsers - fe.prefix(rating)
+}
+
+func (fe *FenwickEngine) GetRank(rating int) int {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	if rating > fe.hi {
+		return 1
+	}
+	if rating < fe.lo {
+		return 1 + fe.totalUsers
+	}
+	return fe.rankLocked(rating)
+}
+
+func (fe *FenwickEngine) GetRankBatch(ratings []int) []int {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	ranks := make([]int, len(ratings))
+	for i, rating := range ratings {
+		ranks[i] = fe.rankLocked(rating)
+	}
+	return ranks
+}
+
+func (fe *FenwickEngine) FillRanks(rows []UserWithRank) {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	for i := range rows {
+		rows[i].Rank = fe.rankLocked(rows[i].Rating)
+	}
+}
+
+func (fe *FenwickEngine) applyLocked(oldRating, newRating int) {
+	if oldRating == newRating {
+		return
+	}
+	if oldRating >= fe.lo && oldRating <= fe.hi && fe.counts[oldRating-fe.lo+1] > 0 {
+		fe.add(oldRating, -1)
+	}
+	if newRating >= fe.lo && newRating <= fe.hi {
+		fe.add(newRating, 1)
+	}
+}
+
+func (fe *FenwickEngine) UpdateRating(oldRating, newRating int) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.bump()
+	fe.applyLocked(oldRating, newRating)
+}
+
+func (fe *FenwickEngine) BatchUpdateRatings(updates []RatingUpdate) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.bump()
+	for _, u := range updates {
+		fe.applyLocked(u.OldRating, u.NewRating)
+	}
+}
+
+func (fe *FenwickEngine) AddUser(rating int) {
+	if rating < fe.lo || rating > fe.hi {
+		return
+	}
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.bump()
+	fe.add(rating, 1)
+}
+
+func (fe *FenwickEngine) RemoveUser(rating int) {
+	if rating < fe.lo || rating > fe.hi {
+		return
+	}
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.bump()
+	if fe.counts[rating-fe.lo+1] > 0 {
+		fe.add(rating, -1)
+	}
+}
+
+func (fe *FenwickEngine) GetStats() (totalUsers int, uniqueRatings int, minRatingWithUsers int, maxRatingWithUsers int) {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	minRatingWithUsers = -1
+	maxRatingWithUsers = -1
+	for i := 1; i < len(fe.counts); i++ {
+		if fe.counts[i] > 0 {
+			rating := i + fe.lo - 1
+			uniqueRatings++
+			if minRatingWithUsers == -1 {
+				minRatingWithUsers = rating
+			}
+			maxRatingWithUsers = rating
+		}
+	}
+	return fe.totalUsers, uniqueRatings, minRatingWithUsers, maxRatingWithUsers
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Glicko-2 (Glickman) rates a player with a rating, a rating deviation (how
+// sure we are of the rating), and a volatility (how erratic their results
+// are). Results aren't applied one by one: a glicko2 board stores its matches
+// and rates everyone at once when the rating period closes. Players who sat a
+// period out keep their rating while their deviation grows back towards 350.
+const (
+	BoardAlgorithmElo     = "elo"
+	BoardAlgorithmGlicko2 = "glicko2"
+
+	glickoScale        = 173.7178
+	glickoCenter       = 1500
+	glickoMaxDeviation = 350
+	glickoConvergence  = 0.000001
+)
+
+const glickoSchedulerTick = time.Minute
+
+const glickoSchema = `
+	ALTER TABLE board_ratings ADD COLUMN IF NOT EXISTS deviation DOUBLE PRECISION NOT NULL DEFAULT 350;
+	ALTER TABLE board_ratings ADD COLUMN IF NOT EXISTS volatility DOUBLE PRECISION NOT NULL DEFAULT 0.06;
+
+	ALTER TABLE board_matches ADD COLUMN IF NOT EXISTS period_id BIGINT;
+	CREATE INDEX IF NOT EXISTS idx_board_matches_open ON board_matches (leaderboard_id) WHERE period_id IS NULL;
+
+	CREATE TABLE IF NOT EXISTS glicko_periods (
+		id BIGSERIAL PRIMARY KEY,
+		leaderboard_id INT NOT NULL REFERENCES leaderboards(id) ON DELETE CASCADE,
+		games INT NOT NULL,
+		players INT NOT NULL,
+		changed INT NOT NULL,
+		closed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_glicko_periods_board ON glicko_periods (leaderboard_id, closed_at DESC);
+`
+
+var (
+	glickoTau = getEnvFloat("GLICKO_TAU", 0.5)
+
+	errNotGlickoBoard = errors.New("board is not rated with glicko2")
+)
+
+type Glicko struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+type glickoResult struct {
+	Opponent Glicko
+	Score    float64
+}
+
+// GlickoPeriod summarizes one closed rating period on a board.
+type GlickoPeriod struct {
+	ID       int64     `json:"id"`
+	Board    string    `json:"board"`
+	Games    int       `json:"games"`
+	Players  int       `json:"players"`
+	Changed  int       `json:"changed"`
+	ClosedAt time.Time `json:"closed_at"`
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// glicko2Update rates one player over one period against their opponents'
+// ratings as they stood when it began (steps 2-8 of Glickman's paper).
+func glicko2Update(p Glicko, results []glickoResult, tau float64) Glicko {
+	mu := (p.Rating - glickoCenter) / glickoScale
+	phi := p.Deviation / glickoScale
+	sigma := p.Volatility
+
+	if len(results) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return Glicko{Rating: p.Rating, Deviation: math.Min(phi*glickoScale, glickoMaxDeviation), Volatility: sigma}
+	}
+
+	var vInv, sum float64
+	for _, r := range results {
+		muJ := (r.Opponent.Rating - glickoCenter) / glickoScale
+		g := glickoG(r.Opponent.Deviation / glickoScale)
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInv += g * g * e * (1 - e)
+		sum += g * (r.Score - e)
+	}
+	v := 1 / vInv
+	sigma = glickoVolatility(phi, sigma, v, v*sum, tau)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+	return Glicko{
+		Rating:     mu*glickoScale + glickoCenter,
+		Deviation:  math.Min(phi*glickoScale, glickoMaxDeviation),
+		Volatility: sigma,
+	}
+}
+
+// glickoVolatility finds the new volatility with the Illinois algorithm
+// (step 5).
+func glickoVolatility(phi, sigma, v, delta, tau float64) float64 {
+	x0 := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-x0)/(tau*tau)
+	}
+
+	a, b := x0, 0.0
+	if delta*delta > phi*phi+v {
+		b = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(x0-k*tau) < 0 {
+			k++
+		}
+		b = x0 - k*tau
+	}
+	fa, fb := f(a), f(b)
+	for math.Abs(b-a) > glickoConvergence {
+		c := a + (a-b)*fa/(fb-fa)
+		fc := f(c)
+		if fc*fb <= 0 {
+			a, fa = b, fb
+		} else {
+			fa /= 2
+		}
+		b, fb = c, fc
+	}
+	return math.Exp(a / 2)
+}
+
+// closeRatingPeriod rates every player on a glicko2 board from the matches
+// recorded since the last period.
+func closeRatingPeriod(b *Board) (*GlickoPeriod, error) {
+	if b.Algorithm != BoardAlgorithmGlicko2 {
+		return nil, errNotGlickoBoard
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin rating period: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT id FROM leaderboards WHERE id = $1 FOR UPDATE`, b.ID); err != nil {
+		return nil, fmt.Errorf("failed to lock board: %w", err)
+	}
+
+	// Ratings are locked before matches are read. A match holds its players'
+	// rows until it commits, so it either lands in this period or waits for
+	// the next one.
+	type glickoPlayer struct {
+		username string
+		before   Glicko
+		results  []glickoResult
+	}
+	players := map[int64]*glickoPlayer{}
+	rows, err := tx.Query(`
+		SELECT br.user_id, u.username, br.rating, br.deviation, br.volatility
+		FROM board_ratings br
+		JOIN users u ON u.id = br.user_id
+		WHERE br.leaderboard_id = $1
+		FOR UPDATE OF br
+	`, b.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock board ratings: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var rating int
+		p := &glickoPlayer{}
+		if err := rows.Scan(&id, &p.username, &rating, &p.before.Deviation, &p.before.Volatility); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan board rating: %w", err)
+		}
+		p.before.Rating = float64(rating)
+		players[id] = p
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating board ratings: %w", err)
+	}
+
+	rows, err = tx.Query(`
+		SELECT id, player_a_id, player_b_id, score_a
+		FROM board_matches
+		WHERE leaderboard_id = $1 AND period_id IS NULL
+		ORDER BY id
+	`, b.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read board matches: %w", err)
+	}
+	var games []int64
+	for rows.Next() {
+		var id, aID, bID int64
+		var scoreA float64
+		if err := rows.Scan(&id, &aID, &bID, &scoreA); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan board match: %w", err)
+		}
+		pa, pb := players[aID], players[bID]
+		if pa == nil || pb == nil {
+			continue // a player joined after the lock; rated next period
+		}
+		pa.results = append(pa.results, glickoResult{Opponent: pb.before, Score: scoreA})
+		pb.results = append(pb.results, glickoResult{Opponent: pa.before, Score: 1 - scoreA})
+		games = append(games, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating board matches: %w", err)
+	}
+
+	period := &GlickoPeriod{Board: b.Name, Games: len(games), Players: len(players)}
+	ids := make([]int64, 0, len(players))
+	ratings := make([]int64, 0, len(players))
+	deviations := make([]float64, 0, len(players))
+	volatilities := make([]float64, 0, len(players))
+	var events []BoardRatingUpdatedEvent
+	for id, p := range players {
+		after := glicko2Update(p.before, p.results, glickoTau)
+		old, rating := int(p.before.Rating), clampRating(int(math.Round(after.Rating)))
+		ids = append(ids, id)
+		ratings = append(ratings, int64(rating))
+		deviations = append(deviations, after.Deviation)
+		volatilities = append(volatilities, after.Volatility)
+		if rating != old {
+			events = append(events, BoardRatingUpdatedEvent{
+				Board:     b.Name,
+				UserID:    id,
+				Username:  p.username,
+				OldRating: &old,
+				NewRating: rating,
+			})
+		}
+	}
+	period.Changed = len(events)
+
+	if err := tx.QueryRow(`
+		INSERT INTO glicko_periods (leaderboard_id, games, players, changed)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, closed_at
+	`, b.ID, period.Games, period.Players, period.Changed).Scan(&period.ID, &period.ClosedAt); err != nil {
+		return nil, fmt.Errorf("failed to record rating period: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE board_ratings br
+		SET rating = v.rating, deviation = v.deviation, volatility = v.volatility,
+			updated_at = CASE WHEN br.rating <> v.rating THEN NOW() ELSE br.updated_at END
+		FROM unnest($2::BIGINT[], $3::INT[], $4::FLOAT8[], $5::FLOAT8[]) AS v(user_id, rating, deviation, volatility)
+		WHERE br.leaderboard_id = $1 AND br.user_id = v.user_id
+	`, b.ID, pq.Array(ids), pq.Array(ratings), pq.Array(deviations), pq.Array(volatilities)); err != nil {
+		return nil, fmt.Errorf("failed to update board ratings: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE board_matches SET period_id = $1 WHERE id = ANY($2::BIGINT[])
+	`, period.ID, pq.Array(games)); err != nil {
+		return nil, fmt.Errorf("failed to close board matches: %w", err)
+	}
+	for _, ev := range events {
+		if err := insertOutboxEvent(tx, EventBoardRatingUpdated, ev); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rating period: %w", err)
+	}
+	return period, nil
+}
+
+func glickoBoards() []*Board {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+	var list []*Board
+	for _, b := range boards {
+		if b.Algorithm == BoardAlgorithmGlicko2 {
+			list = append(list, b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// GlickoScheduler closes a rating period on every glicko2 board once
+// GLICKO_PERIOD_SEC has passed since its last one (or since the board was
+// created). Periods are timed from the database, so a restart doesn't reset
+// the clock; one that came due while the service was down closes on start.
+type GlickoScheduler struct {
+	period time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var glickoScheduler *GlickoScheduler
+
+func StartGlickoScheduler() {
+	sec := getEnvInt("GLICKO_PERIOD_SEC", 86400)
+	if sec <= 0 || isReplica() {
+		return
+	}
+	glickoScheduler = &GlickoScheduler{
+		period: time.Duration(sec) * time.Second,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go glickoScheduler.run()
+	slog.Info("✓ Glicko-2 rating periods scheduled", "period", glickoScheduler.period)
+}
+
+func StopGlickoScheduler() {
+	if glickoScheduler == nil {
+		return
+	}
+	close(glickoScheduler.stop)
+	<-glickoScheduler.done
+}
+
+func (s *GlickoScheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(glickoSchedulerTick)
+	defer ticker.Stop()
+
+	s.closeDue()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.closeDue()
+		}
+	}
+}
+
+func (s *GlickoScheduler) closeDue() {
+	for _, b := range glickoBoards() {
+		var last time.Time
+		if err := db.QueryRow(`
+			SELECT COALESCE(MAX(closed_at), $2) FROM glicko_periods WHERE leaderboard_id = $1
+		`, b.ID, b.CreatedAt).Scan(&last); err != nil {
+			slog.Error("Rating period check failed", "board", b.Name, "error", err)
+			continue
+		}
+		if time.Since(last) < s.period {
+			continue
+		}
+
+		release := writeSlots.acquire(WriteBackground)
+		period, err := closeRatingPeriod(b)
+		release()
+		if err != nil {
+			slog.Error("Closing rating period failed", "board", b.Name, "error", err)
+			continue
+		}
+		if period.Changed > 0 {
+			outboxRelay.Flush()
+		}
+		slog.Info("✓ Closed rating period", "period", period.ID, "board", b.Name, "games", period.Games,
+			"changed", period.Changed, "players", period.Players)
+	}
+}
+
+// HandleCloseRatingPeriod serves POST /admin/leaderboards/:name/rating-period,
+// closing the period now instead of waiting for the scheduler.
+func HandleCloseRatingPeriod(c *gin.Context) {
+	b, ok := getBoard(c.Param("name"))
+	if !ok {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Board %q not found", c.Param("name")))
+		return
+	}
+
+	release := writeSlots.acquire(WriteInteractive)
+	period, err := closeRatingPeriod(b)
+	release()
+	if errors.Is(err, errNotGlickoBoard) {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Board %s is rated with %s; only %s boards have rating periods", b.Name, b.Algorithm, BoardAlgorithmGlicko2))
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error closing rating period", "board", b.Name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to close rating period")
+		return
+	}
+	outboxRelay.Flush()
+
+	requestLog(c).Info("✓ Closed rating period", "period", period.ID, "board", b.Name, "games", period.Games,
+		"changed", period.Changed, "players", period.Players)
+	respond(c, http.StatusOK, gin.H{
+		"period": period,
+	})
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"log"
-	"math/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,9 +22,9 @@ import (
 
 
 
-const (
-	DefaultPageSize = 50
-	MaxPageSize     = 100
+var (
+	DefaultPageSize = getEnvInt("PAGE_SIZE_DEFAULT", 50)
+	MaxPageSize     = getEnvInt("PAGE_SIZE_MAX", 100)
 )
 
 
@@ -38,192 +41,231 @@ const (
 
 
 
-func HandleLeaderboard(c *gin.Context) {
-	
-	page := parseIntParam(c.Query("page"), 1)
-	limit := parseIntParam(c.Query("limit"), DefaultPageSize)
-	
-	
-	if page < 1 {
-		page = 1
+func (h *Handlers) HandleLeaderboard(c *gin.Context) {
+	if metric := c.Query("metric"); metric != "" && metric != MetricRating {
+		handleMetricLeaderboard(c, metric)
+		return
 	}
-	if limit < 1 {
-		limit = DefaultPageSize
+	if season := c.Query("season"); season != "" {
+		serveSeasonLeaderboard(c, season)
+		return
 	}
-	if limit > MaxPageSize {
-		limit = MaxPageSize
+
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
 	}
-	
-	
-	offset := (page - 1) * limit
 
-	
-	
-	users, err := GetTopUsers(limit+1, offset) 
-	if err != nil {
-		log.Printf("Error fetching leaderboard: %v", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to fetch leaderboard",
-		})
+	svc, ok := h.boardService(c)
+	if !ok {
 		return
 	}
-
-	
-	hasMore := len(users) > limit
-	if hasMore {
-		users = users[:limit] 
+	if snapshot := c.Query("snapshot"); snapshot != "" {
+		servePageSnapshot(c, svc, snapshot, req)
+		return
 	}
 
-	
-	if len(users) == 0 {
-		c.JSON(http.StatusOK, LeaderboardResponse{
-			Success: true,
-			Data:    []UserWithRank{},
-			Count:   0,
-			Page:    page,
-			Limit:   limit,
-			HasMore: false,
-		})
+	// Read before the page is built, so a change that lands meanwhile gives
+	// the next request a new ETag.
+	etag := engineETag(readVersion())
+	if notModified(c, etag) {
 		return
 	}
 
-	
-	
-	ratings := make([]int, len(users))
-	for i, u := range users {
-		ratings[i] = u.Rating
+	var after *Cursor
+	if raw := c.Query("after"); raw != "" {
+		cursor, err := parseCursor(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid after cursor")
+			return
+		}
+		after = &cursor
 	}
 
-	
-	re := GetRankingEngine()
-	ranks := re.GetRankBatch(ratings)
+	buf := getPageBuffers()
+	defer putPageBuffers(buf)
 
-	
-	result := make([]UserWithRank, len(users))
-	for i, u := range users {
-		result[i] = UserWithRank{
-			Rank:     ranks[i],
-			Username: u.Username,
-			Rating:   u.Rating,
-		}
+	var page Page
+	var err error
+	if after != nil {
+		page, err = svc.LeaderboardAfter(c.Request.Context(), *after, req.Limit, buf.rows)
+	} else {
+		page, err = svc.Leaderboard(c.Request.Context(), req, buf.rows)
+	}
+	if err == nil {
+		err = c.Request.Context().Err()
+	}
+	if isBudgetError(err) {
+		writeBudgetTimeout(c)
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error fetching leaderboard", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+	buf.rows = page.Rows
+	// A partial or degraded page isn't the one the version stands for.
+	if !page.Partial && !page.Degraded {
+		setETag(c, etag)
 	}
 
-	c.JSON(http.StatusOK, LeaderboardResponse{
-		Success: true,
-		Data:    result,
-		Count:   len(result),
-		Page:    page,
-		Limit:   limit,
-		HasMore: hasMore,
-	})
+	resp := LeaderboardResponse{
+		Success:  true,
+		Data:     page.Rows,
+		Count:    len(page.Rows),
+		Page:     page.Page,
+		Limit:    page.Limit,
+		HasMore:  page.HasMore,
+		Partial:  page.Partial,
+		Degraded: page.Degraded,
+	}
+	resp.Pagination = newPagination(page.Page, page.Limit, page.HasMore)
+	if page.HasMore && len(page.Rows) > 0 {
+		resp.NextCursor = page.Rows[len(page.Rows)-1].Cursor
+		resp.Pagination.NextCursor = resp.NextCursor
+	}
+	buf.writeJSON(c, http.StatusOK, resp)
 }
 
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-func HandleSearch(c *gin.Context) {
-	
+func (h *Handlers) HandleSearch(c *gin.Context) {
 	username := strings.TrimSpace(c.Query("username"))
 	if username == "" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Username query parameter is required",
-		})
+		respondError(c, http.StatusBadRequest, "Username query parameter is required")
 		return
 	}
-
-	
-	page := parseIntParam(c.Query("page"), 1)
-	limit := parseIntParam(c.Query("limit"), DefaultPageSize)
-	
-	
-	if page < 1 {
-		page = 1
+	if season := c.Query("season"); season != "" {
+		serveSeasonSearch(c, season, username)
+		return
 	}
-	if limit < 1 {
-		limit = DefaultPageSize
+
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
 	}
-	if limit > MaxPageSize {
-		limit = MaxPageSize
+
+	svc, ok := h.boardService(c)
+	if !ok {
+		return
 	}
-	
-	
-	offset := (page - 1) * limit
 
-	
-	
-	users, err := SearchUsersByUsername(username, limit+1, offset) 
+	buf := getPageBuffers()
+	defer putPageBuffers(buf)
+
+	page, err := svc.Search(c.Request.Context(), username, req, buf.rows)
+	if err == nil {
+		err = c.Request.Context().Err()
+	}
+	if isBudgetError(err) {
+		writeBudgetTimeout(c)
+		return
+	}
+	if errors.Is(err, errSearchTimeout) {
+		writeSearchTimeout(c)
+		return
+	}
 	if err != nil {
-		log.Printf("Error searching users: %v", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to search users",
-		})
+		requestLog(c).Error("Error searching users", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to search users")
 		return
 	}
+	buf.rows = page.Rows
+
+	buf.writeJSON(c, http.StatusOK, SearchResponse{
+		Success:    true,
+		Data:       page.Rows,
+		Count:      len(page.Rows),
+		Page:       page.Page,
+		Limit:      page.Limit,
+		HasMore:    page.HasMore,
+		Partial:    page.Partial,
+		Truncated:  page.Truncated,
+		Pagination: newPagination(page.Page, page.Limit, page.HasMore),
+	})
+}
 
-	
-	hasMore := len(users) > limit
-	if hasMore {
-		users = users[:limit] 
-	}
+type UserRankResponse struct {
+	Success    bool               `json:"success"`
+	Username   string             `json:"username"`
+	Rank       *int               `json:"rank"`
+	Rating     *int               `json:"rating"`
+	Percentile *float64           `json:"percentile"`
+	TotalUsers int                `json:"total_users"`
+	Placement  *PlacementProgress `json:"placement,omitempty"`
+	Version    int64              `json:"version"`
+}
 
-	
-	if len(users) == 0 {
-		c.JSON(http.StatusOK, SearchResponse{
-			Success: true,
-			Data:    []UserWithRank{},
-			Count:   0,
-			Page:    page,
-			Limit:   limit,
-			HasMore: false,
-		})
+// HandleUserRank answers GET /users/:username/rank with one lookup and one
+// engine call, for clients that only need a user's position. Percentile is
+// the share of ranked users at or below the user's rating. Bots are left out
+// of both unless ?include_bots=true.
+func HandleUserRank(c *gin.Context) {
+	// Read before anything else, so a change that lands while the rank is
+	// computed is reported as newer on the next poll.
+	re := GetRankingEngine()
+	version := readVersion()
+	c.Header("X-Engine-Version", strconv.FormatInt(version, 10))
+
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
 		return
 	}
-
-	
-	ratings := make([]int, len(users))
-	for i, u := range users {
-		ratings[i] = u.Rating
+	// Placement games change the user without touching the engine, so only a
+	// ranked user's answer is fully described by the version.
+	if known, err := strconv.ParseInt(c.Query("if_version_gt"), 10, 64); err == nil && version <= known && !user.InPlacement {
+		c.Status(http.StatusNotModified)
+		return
 	}
 
-	
-	re := GetRankingEngine()
-	ranks := re.GetRankBatch(ratings)
+	rank, total := placeUser(c, re, user)
 
-	
-	result := make([]UserWithRank, len(users))
-	for i, u := range users {
-		result[i] = UserWithRank{
-			Rank:     ranks[i],
-			Username: u.Username,
-			Rating:   u.Rating,
-		}
+	resp := UserRankResponse{
+		Success:    true,
+		Username:   user.Username,
+		TotalUsers: total,
+		Placement:  placementProgress(user),
+		Version:    version,
 	}
+	if !user.InPlacement {
+		resp.Rank = &rank
+		resp.Rating = &user.Rating
+		resp.Percentile = rankPercentile(rank, total)
+	}
+	respond(c, http.StatusOK, resp)
+}
 
-	c.JSON(http.StatusOK, SearchResponse{
-		Success: true,
-		Data:    result,
-		Count:   len(result),
-		Page:    page,
-		Limit:   limit,
-		HasMore: hasMore,
-	})
+// placeUser returns the user's rank and the number of ranked users from one
+// engine. Bots are left out of both unless ?include_bots=true.
+func placeUser(c *gin.Context, re RankEngine, user *User) (rank, total int) {
+	total, _, _, _ = re.GetStats()
+	if !user.InPlacement {
+		rank = re.GetRank(user.Rating)
+	}
+	if !includeBots(c) {
+		// Rank among real users; a bot is placed among them as one extra.
+		above := bots.above([]int{MinRating - 1, user.Rating})
+		total -= above[0]
+		if user.IsBot && !user.InPlacement {
+			total++
+		}
+		if !user.InPlacement {
+			rank -= above[1]
+		}
+	}
+	return rank, total
 }
 
+// rankPercentile is the share of ranked users at or below rank, to two
+// decimals.
+func rankPercentile(rank, total int) *float64 {
+	if total <= 0 {
+		return nil
+	}
+	p := math.Round(float64(total-rank+1)/float64(total)*10000) / 100
+	return &p
+}
 
 func parseIntParam(value string, defaultValue int) int {
 	if value == "" {
@@ -256,6 +298,10 @@ type SimulateUserRequest struct {
 
 
 func HandleSimulate(c *gin.Context) {
+	if board := c.Query("board"); board != "" && !strings.EqualFold(board, DefaultBoard) {
+		handleBoardSimulation(c, board)
+		return
+	}
 	
 	var req SimulateUserRequest
 	if err := c.ShouldBindJSON(&req); err == nil && req.Username != "" {
@@ -272,122 +318,207 @@ func HandleSimulate(c *gin.Context) {
 func handleSpecificUserSimulation(c *gin.Context, req SimulateUserRequest) {
 	
 	if req.NewRating < MinRating || req.NewRating > MaxRating {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Rating must be between 100 and 5000",
-		})
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Rating must be between %d and %d", MinRating, MaxRating))
 		return
 	}
 	
 	
 	user, err := GetUserByUsername(req.Username)
 	if err != nil {
-		log.Printf("Error finding user %s: %v", req.Username, err)
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "User not found",
-		})
+		requestLog(c).Error("Error finding user", "username", req.Username, "error", err)
+		respondError(c, http.StatusNotFound, "User not found")
 		return
 	}
 	
 	
+	if user.InPlacement {
+		respondError(c, http.StatusConflict, "User is still in placement and has no rating to update")
+		return
+	}
+
 	oldRating := user.Rating
+	s := Submission{Username: user.Username, Metric: MetricRating, Mode: SubmitLast, Value: req.NewRating, Current: &oldRating}
+	if screenSubmission(c, user.ID, s) {
+		return
+	}
 	
 	
-	err = UpdateUserRating(user.ID, req.NewRating)
+	release := writeSlots.acquire(WriteInteractive)
+	ev, changed, err := setUserRating(user.Username, req.NewRating, HistorySourceSimulate)
+	release()
+	var rejected *HookRejectedError
+	var limited *VolatilityError
+	if errors.As(err, &rejected) || errors.As(err, &limited) {
+		c.Error(err)
+		return
+	}
 	if err != nil {
-		log.Printf("Error updating user %s rating: %v", req.Username, err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to update rating",
-		})
+		requestLog(c).Error("Error updating user rating", "username", req.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update rating")
 		return
 	}
 	
 	
-	re := GetRankingEngine()
-	re.UpdateRating(oldRating, req.NewRating)
+	if changed {
+		outboxRelay.Flush()
+	}
+	observeSubmission(s)
 	
-	log.Printf("✓ Updated %s rating: %d -> %d", req.Username, oldRating, req.NewRating)
+	requestLog(c).Info("✓ Updated rating", "username", req.Username, "old_rating", ev.OldRating, "new_rating", ev.NewRating)
+	meterRatingUpdates(c, 1)
 	
-	c.JSON(http.StatusOK, SimulateResponse{
-		Success: true,
-		Message: "Rating updated successfully",
-		Updated: 1,
+	respond(c, http.StatusOK, SimulateResponse{
+		Success:   true,
+		Message:   "Rating updated successfully",
+		Updated:   1,
+		NewRating: ev.NewRating,
 	})
 }
 
 
 func handleBulkSimulation(c *gin.Context) {
+	resp, updates, err := simulateBatch()
+	if err != nil {
+		requestLog(c).Error("Error getting random users for simulation", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to start simulation")
+		return
+	}
+	if len(updates) == 0 {
+		respond(c, http.StatusOK, resp)
+		return
+	}
+
+	processRatingUpdatesAsync(updates)
+	meterRatingUpdates(c, len(updates))
+
+	respond(c, http.StatusOK, resp)
+}
+
+// simulateBatch applies one batch of population changes and picks the rating
+// updates for it, leaving the caller to process them.
+func simulateBatch() (SimulateResponse, []RatingUpdate, error) {
 	const usersToUpdate = 50
 
-	
-	users, err := GetRandomUsers(usersToUpdate)
+	batch := simProfile.batchSize(usersToUpdate, time.Now())
+	batchID := recorder.nextBatch()
+
+	// Registrations and churn run before selection so a churned user can't be
+	// picked for a rating update in the same batch.
+	registered, churned := simProfile.simulatePopulationChanges(batch, batchID)
+
+	users, err := simProfile.selectUsers(batch)
 	if err != nil {
-		log.Printf("Error getting random users for simulation: %v", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to start simulation",
-		})
-		return
+		return SimulateResponse{}, nil, err
 	}
 
 	if len(users) == 0 {
-		c.JSON(http.StatusOK, SimulateResponse{
-			Success: true,
-			Message: "No users available to simulate",
-			Updated: 0,
-		})
-		return
+		return SimulateResponse{
+			Success:    true,
+			Message:    "No users available to simulate",
+			Updated:    0,
+			Registered: registered,
+			Churned:    churned,
+		}, nil, nil
 	}
 
-	
 	updates := make([]RatingUpdate, len(users))
 	for i, u := range users {
-		newRating := generateNewRating(u.Rating)
 		updates[i] = RatingUpdate{
 			UserID:    u.ID,
+			Username:  u.Username,
 			OldRating: u.Rating,
-			NewRating: newRating,
+			NewRating: simProfile.nextRating(u.Rating),
 		}
 	}
+	updates, err = applyRatingRulesToUpdates(updates, HistorySourceSimulate)
+	if err != nil {
+		return SimulateResponse{}, nil, err
+	}
 
-	
-	go processRatingUpdates(updates)
-
-	c.JSON(http.StatusOK, SimulateResponse{
-		Success: true,
-		Message: "Rating simulation started asynchronously",
-		Updated: len(updates),
-	})
+	events := make([]SimEvent, len(updates))
+	for i, u := range updates {
+		events[i] = SimEvent{
+			Batch:     batchID,
+			Op:        SimOpUpdate,
+			Username:  u.Username,
+			OldRating: u.OldRating,
+			NewRating: u.NewRating,
+		}
+	}
+	recorder.record(events...)
+
+	return SimulateResponse{
+		Success:    true,
+		Message:    "Rating simulation started asynchronously",
+		Updated:    len(updates),
+		Registered: registered,
+		Churned:    churned,
+	}, updates, nil
 }
 
 
 
-func processRatingUpdates(updates []RatingUpdate) {
-	
-	
+func processRatingUpdates(updates []RatingUpdate) int {
+	total := len(updates)
 	re := GetRankingEngine()
-	re.BatchUpdateRatings(updates)
+	applyRatingBatch(re, updates, "simulation")
 
 	
 	
 	successCount := 0
-	for _, update := range updates {
-		err := UpdateUserRating(update.UserID, update.NewRating)
+	for len(updates) > 0 {
+		size, delay := ratingWritePacer.next()
+		time.Sleep(delay)
+
+		batch := updates[:min(size, len(updates))]
+		updates = updates[len(batch):]
+
+		// Keep buffered updates ahead of new ones until the WAL drains.
+		if ratingWAL.Pending() {
+			if ratingWAL.Append(batch) {
+				successCount += len(batch)
+				continue
+			}
+			slog.Error("Rating WAL full, dropping rating updates", "updates", len(batch))
+			for _, update := range batch {
+				applyRatingChange(re, update.Username, update.NewRating, update.OldRating, "simulation")
+			}
+			continue
+		}
+
+		release := writeSlots.acquire(WriteBackground)
+		start := time.Now()
+		changed, skipped, err := writeRatingUpdates(batch, HistorySourceSimulate, true)
+		ratingWritePacer.observe(time.Since(start))
+		release()
+		if err != nil && isConnectionError(err) && ratingWAL.Append(batch) {
+			slog.Warn("Database unreachable, buffered rating updates in WAL", "updates", len(batch))
+			successCount += len(batch)
+			continue
+		}
 		if err != nil {
-			log.Printf("Failed to update user %d rating: %v", update.UserID, err)
-			
-			
-			
-			re.UpdateRating(update.NewRating, update.OldRating) 
-		} else {
-			successCount++
+			slog.Error("Failed to update user ratings", "updates", len(batch), "error", err)
+			for _, update := range batch {
+				applyRatingChange(re, update.Username, update.NewRating, update.OldRating, "simulation")
+			}
+			continue
+		}
+		// A user whose rating moved since selection keeps the newer rating.
+		for _, update := range skipped {
+			applyRatingChange(re, update.Username, update.NewRating, update.OldRating, "simulation")
+		}
+		successCount += len(batch) - len(skipped)
+		if len(registeredHooks()) > 0 {
+			committed := make([]RatingUpdatedEvent, len(changed))
+			for i, u := range changed {
+				committed[i] = RatingUpdatedEvent{UserID: u.UserID, Username: u.Username, OldRating: u.OldRating, NewRating: u.NewRating, Source: HistorySourceSimulate}
+			}
+			runPostCommitHooks(committed)
 		}
 	}
 
-	log.Printf("✓ Simulation complete: %d/%d ratings updated successfully",
-		successCount, len(updates))
+	slog.Info("✓ Simulation complete", "updated", successCount, "total", total)
+	return successCount
 }
 
 
@@ -395,7 +526,7 @@ func processRatingUpdates(updates []RatingUpdate) {
 
 func generateNewRating(currentRating int) int {
 	
-	delta := rand.Intn(1001) - 500
+	delta := simRand.Intn(1001) - 500
 
 	newRating := currentRating + delta
 
@@ -416,25 +547,103 @@ func generateNewRating(currentRating int) int {
 
 
 func HandleHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "healthy",
+	if !ready.Load() {
+		respondErrorWith(c, http.StatusServiceUnavailable, "Service is warming up", gin.H{
+			"status":  "warming",
+			"service": "leaderboard-api",
+		})
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"status":  "healthy",
 		"service": "leaderboard-api",
 	})
 }
 
 
+// HandleStats serves GET /stats: the engine's figures, which only change
+// with the ratings, so the response carries an ETag. Counters that move on
+// every request are served untagged by HandleRuntimeStats.
 func HandleStats(c *gin.Context) {
 	re := GetRankingEngine()
+	version := readVersion()
 	totalUsers, uniqueRatings, minRating, maxRating := re.GetStats()
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"stats": gin.H{
-			"total_users":    totalUsers,
-			"unique_ratings": uniqueRatings,
-			"min_rating":     minRating,
-			"max_rating":     maxRating,
-			"rating_range":   "100-5000",
-		},
+	stats := gin.H{
+		"total_users":    totalUsers,
+		"unique_ratings": uniqueRatings,
+		"min_rating":     minRating,
+		"max_rating":     maxRating,
+		"rating_range":   fmt.Sprintf("%d-%d", MinRating, MaxRating),
+	}
+	etag := statsETag(version, stats)
+	if notModified(c, etag) {
+		return
+	}
+	setETag(c, etag)
+	respond(c, http.StatusOK, gin.H{
+		"stats": stats,
+	})
+}
+
+// HandleRuntimeStats serves GET /stats/runtime: the service's counters and
+// background jobs. They change on every request, so there is no ETag.
+func HandleRuntimeStats(c *gin.Context) {
+	stats := gin.H{}
+	if shadow, ok := GetRankingEngine().(*ShadowEngine); ok {
+		stats["shadow"] = shadow.Stats()
+	}
+	if rollout, ok := ratingCalculator.(*RolloutCalculator); ok {
+		stats["rollout"] = rollout.Stats()
+	}
+	if rankCoalescer != nil {
+		stats["coalescing"] = rankCoalescer.Stats()
+	}
+	if stabilityTracker != nil {
+		stats["stability"] = stabilityTracker.Stats()
+	}
+	if volatilityAction == VolatilityQueue {
+		stats["volatility_queued"] = volatilityQueued.Load()
+		stats["volatility_failed"] = volatilityFailed.Load()
+	}
+	if simulator != nil {
+		stats["simulator"] = simulator.Stats()
+	}
+	if backgroundSeeder != nil {
+		stats["seeding"] = backgroundSeeder.Stats()
+	}
+	stats["write_batching"] = ratingWritePacer.Stats()
+	stats["write_queues"] = writeSlots.Stats()
+	if ratingWAL != nil {
+		stats["rating_wal"] = ratingWAL.Stats()
+	}
+	stats["rank_events"] = rankEvents.Stats()
+	if len(eventSinks) > 0 {
+		stats["event_sinks"] = eventSinkStats()
+	}
+	stats["page_snapshots"] = pageSnapshots.Stats()
+	if len(submissionValidators) > 0 {
+		stats["anticheat"] = gin.H{
+			"validators":  submissionValidatorNames(),
+			"quarantined": quarantinedCount.Load(),
+		}
+	}
+	if len(submissionSigningKeys) > 0 {
+		stats["signed_submissions"] = gin.H{
+			"keys":     len(submissionSigningKeys),
+			"rejected": rejectedSubmissionCount.Load(),
+		}
+	}
+	if replicaFeed != nil {
+		stats["replication"] = replicaFeed.Stats()
+	}
+	if engineRebuilding.Load() {
+		stats["engine_rebuilding"] = true
+	}
+	stats["prepared_statements"] = preparedStatementStats()
+
+	respond(c, http.StatusOK, gin.H{
+		"stats": stats,
 	})
 }
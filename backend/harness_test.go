@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The engine harness is a property-based check for RankEngine backends. It
+// applies random sequences of registrations, updates, deletions, and queries
+// to an engine and to a naive reference model, and compares every answer.
+// A failing sequence is shrunk to a minimal reproduction before it is
+// reported. It runs with go test; before accepting a new engine, add it to
+// TestEngineHarness and run a longer search:
+//
+//	go test -run TestEngineHarness -harness.runs 2000 -harness.seed 42
+
+type harnessOpKind int
+
+const (
+	opRegister harnessOpKind = iota
+	opUpdate
+	opDelete
+	opRank
+	opRankBatch
+	opFillRanks
+	opStats
+)
+
+type harnessOp struct {
+	kind    harnessOpKind
+	slot    int   // which user an update/delete targets, modulo live users
+	rating  int   // register/update target or rank query
+	ratings []int // batch queries
+}
+
+func (op harnessOp) String() string {
+	switch op.kind {
+	case opRegister:
+		return fmt.Sprintf("register(%d)", op.rating)
+	case opUpdate:
+		return fmt.Sprintf("update(slot %d -> %d)", op.slot, op.rating)
+	case opDelete:
+		return fmt.Sprintf("delete(slot %d)", op.slot)
+	case opRank:
+		return fmt.Sprintf("rank(%d)", op.rating)
+	case opRankBatch:
+		return fmt.Sprintf("rankBatch(%v)", op.ratings)
+	case opFillRanks:
+		return fmt.Sprintf("fillRanks(%v)", op.ratings)
+	default:
+		return "stats()"
+	}
+}
+
+// referenceModel is the obviously-correct version: a flat list of ratings.
+type referenceModel struct {
+	ratings []int
+}
+
+func (m *referenceModel) rank(rating int) int {
+	rank := 1
+	for _, r := range m.ratings {
+		if r > rating {
+			rank++
+		}
+	}
+	return rank
+}
+
+func (m *referenceModel) stats() [4]int {
+	seen := map[int]bool{}
+	minR, maxR := -1, -1
+	for _, r := range m.ratings {
+		seen[r] = true
+		if minR == -1 || r < minR {
+			minR = r
+		}
+		if r > maxR {
+			maxR = r
+		}
+	}
+	return [4]int{len(m.ratings), len(seen), minR, maxR}
+}
+
+// runHarnessOps replays ops against a fresh engine and the model, returning
+// the index of the first divergent op and a description, or -1.
+func runHarnessOps(kind string, ops []harnessOp) (int, string) {
+	engine, err := newRankEngine(kind, map[int]int{})
+	if err != nil {
+		return 0, err.Error()
+	}
+	model := &referenceModel{}
+
+	for i, op := range ops {
+		switch op.kind {
+		case opRegister:
+			engine.AddUser(op.rating)
+			model.ratings = append(model.ratings, op.rating)
+
+		case opUpdate:
+			if len(model.ratings) == 0 {
+				continue
+			}
+			slot := op.slot % len(model.ratings)
+			engine.UpdateRating(model.ratings[slot], op.rating)
+			model.ratings[slot] = op.rating
+
+		case opDelete:
+			if len(model.ratings) == 0 {
+				continue
+			}
+			slot := op.slot % len(model.ratings)
+			engine.RemoveUser(model.ratings[slot])
+			model.ratings = append(model.ratings[:slot], model.ratings[slot+1:]...)
+
+		case opRank:
+			if got, want := engine.GetRank(op.rating), model.rank(op.rating); got != want {
+				return i, fmt.Sprintf("GetRank(%d) = %d, want %d", op.rating, got, want)
+			}
+
+		case opRankBatch:
+			got := engine.GetRankBatch(op.ratings)
+			for j, r := range op.ratings {
+				if want := model.rank(r); got[j] != want {
+					return i, fmt.Sprintf("GetRankBatch[%d] (rating %d) = %d, want %d", j, r, got[j], want)
+				}
+			}
+
+		case opFillRanks:
+			rows := make([]UserWithRank, len(op.ratings))
+			for j, r := range op.ratings {
+				rows[j].Rating = r
+			}
+			engine.FillRanks(rows)
+			for j, row := range rows {
+				if want := model.rank(row.Rating); row.Rank != want {
+					return i, fmt.Sprintf("FillRanks[%d] (rating %d) = %d, want %d", j, row.Rating, row.Rank, want)
+				}
+			}
+
+		case opStats:
+			t, u, lo, hi := engine.GetStats()
+			if got, want := [4]int{t, u, lo, hi}, model.stats(); got != want {
+				return i, fmt.Sprintf("GetStats() = %v, want %v", got, want)
+			}
+		}
+	}
+	return -1, ""
+}
+
+// generateHarnessOps draws ratings from a small pool so ties are common.
+func generateHarnessOps(rng *rand.Rand, steps int) []harnessOp {
+	pool := make([]int, 1+rng.Intn(40))
+	for i := range pool {
+		pool[i] = MinRating + rng.Intn(MaxRating-MinRating+1)
+	}
+	pick := func() int { return pool[rng.Intn(len(pool))] }
+	picks := func(sorted bool) []int {
+		rs := make([]int, rng.Intn(8))
+		for i := range rs {
+			rs[i] = pick()
+		}
+		if sorted {
+			for i := 1; i < len(rs); i++ {
+				for j := i; j > 0 && rs[j] > rs[j-1]; j-- {
+					rs[j], rs[j-1] = rs[j-1], rs[j]
+				}
+			}
+		}
+		return rs
+	}
+
+	ops := make([]harnessOp, steps)
+	for i := range ops {
+		switch p := rng.Intn(100); {
+		case p < 30:
+			ops[i] = harnessOp{kind: opRegister, rating: pick()}
+		case p < 55:
+			ops[i] = harnessOp{kind: opUpdate, slot: rng.Intn(1 << 16), rating: pick()}
+		case p < 65:
+			ops[i] = harnessOp{kind: opDelete, slot: rng.Intn(1 << 16)}
+		case p < 80:
+			ops[i] = harnessOp{kind: opRank, rating: pick()}
+		case p < 88:
+			ops[i] = harnessOp{kind: opRankBatch, ratings: picks(false)}
+		case p < 96:
+			ops[i] = harnessOp{kind: opFillRanks, ratings: picks(rng.Intn(2) == 0)}
+		default:
+			ops[i] = harnessOp{kind: opStats}
+		}
+	}
+	return ops
+}
+
+// shrinkHarnessOps removes chunks of ops, halving the chunk size down to
+// single ops, for as long as the sequence keeps failing.
+func shrinkHarnessOps(kind string, ops []harnessOp) []harnessOp {
+	if at, _ := runHarnessOps(kind, ops); at >= 0 {
+		ops = ops[:at+1]
+	}
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops); {
+			candidate := append(append([]harnessOp{}, ops[:start]...), ops[start+chunk:]...)
+			if at, _ := runHarnessOps(kind, candidate); at >= 0 {
+				ops = candidate[:at+1]
+				continue
+			}
+			start += chunk
+		}
+	}
+	return ops
+}
+
+var (
+	harnessRuns  = flag.Int("harness.runs", 200, "random sequences per engine")
+	harnessSteps = flag.Int("harness.steps", 300, "operations per sequence")
+	harnessSeed  = flag.Int64("harness.seed", 0, "random seed, 0 for the current time")
+)
+
+// TestEngineHarness checks the exact engines. sql needs a database, and
+// approx is skipped because its ranks are approximate by design.
+func TestEngineHarness(t *testing.T) {
+	seed := *harnessSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	runs := *harnessRuns
+	if testing.Short() {
+		runs = min(runs, 20)
+	}
+
+	for _, kind := range []string{EngineBucket, EngineFenwick} {
+		t.Run(kind, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(seed))
+			for run := 0; run < runs; run++ {
+				ops := generateHarnessOps(rng, *harnessSteps)
+				if at, _ := runHarnessOps(kind, ops); at < 0 {
+					continue
+				}
+
+				minimal := shrinkHarnessOps(kind, ops)
+				_, reason := runHarnessOps(kind, minimal)
+				var b strings.Builder
+				for i, op := range minimal {
+					fmt.Fprintf(&b, "\n  %3d  %s", i, op)
+				}
+				t.Fatalf("run %d with -harness.seed=%d, shrunk to %d ops: %s%s", run, seed, len(minimal), reason, b.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The rating histogram is read from the rank engine: the users rated in
+// [lo, hi] are those ranked below hi but not below lo-1, so any engine can
+// answer it with one GetRankBatch call and wide ranges never touch the
+// database. Buckets are paged so a chart can fetch a wide range piece by
+// piece, and each page is downsampled to at most max_points points by
+// merging neighbouring buckets.
+const (
+	defaultHistogramBucketSize = 50
+	defaultHistogramMaxPoints  = 200
+	maxHistogramMaxPoints      = 1000
+	maxHistogramPageBuckets    = 10000
+)
+
+type HistogramPoint struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+type HistogramResponse struct {
+	Success      bool             `json:"success"`
+	Min          int              `json:"min"`
+	Max          int              `json:"max"`
+	BucketSize   int              `json:"bucket_size"`
+	PointSize    int              `json:"point_size"`
+	TotalBuckets int              `json:"total_buckets"`
+	Page         int              `json:"page"`
+	Limit        int              `json:"limit"`
+	HasMore      bool             `json:"hasMore"`
+	Pagination   *Pagination      `json:"pagination"`
+	Points       []HistogramPoint `json:"points"`
+}
+
+// HandleStatsHistogram serves GET /stats/histogram?min=&max=&bucket_size=
+// &page=&limit=&max_points=. limit counts buckets, so without paging a
+// whole range fits on one page and max_points alone bounds the response.
+func HandleStatsHistogram(c *gin.Context) {
+	lo := max(parseIntParam(c.Query("min"), MinRating), MinRating)
+	hi := min(parseIntParam(c.Query("max"), MaxRating), MaxRating)
+	bucketSize := parseIntParam(c.Query("bucket_size"), defaultHistogramBucketSize)
+	maxPoints := parseIntParam(c.Query("max_points"), defaultHistogramMaxPoints)
+	if lo > hi || bucketSize < 1 || maxPoints < 1 || maxPoints > maxHistogramMaxPoints {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("min must not exceed max, bucket_size must be positive, and max_points must be 1-%d", maxHistogramMaxPoints))
+		return
+	}
+
+	totalBuckets := (hi-lo)/bucketSize + 1
+	page := max(parseIntParam(c.Query("page"), 1), 1)
+	limit := min(max(parseIntParam(c.Query("limit"), totalBuckets), 1), maxHistogramPageBuckets)
+	first := (page - 1) * limit
+	n := max(min(limit, totalBuckets-first), 0)
+
+	// Merge groups of neighbouring buckets so the page has at most maxPoints.
+	group := max((n+maxPoints-1)/maxPoints, 1)
+
+	// Edges are the last rating below each point; the engine ranks a rating
+	// at 1 + the number of users above it.
+	var edges []int
+	for b := first; b < first+n; b += group {
+		edges = append(edges, lo+b*bucketSize-1)
+	}
+	pageHi := min(lo+(first+n)*bucketSize-1, hi)
+	edges = append(edges, pageHi)
+
+	re := GetRankingEngine()
+	totalUsers, _, _, _ := re.GetStats()
+	ranks := re.GetRankBatch(edges)
+	above := func(i int) int {
+		if edges[i] < MinRating {
+			return totalUsers
+		}
+		return ranks[i] - 1
+	}
+
+	points := make([]HistogramPoint, 0, len(edges)-1)
+	for i := 0; i+1 < len(edges); i++ {
+		points = append(points, HistogramPoint{
+			Min:   edges[i] + 1,
+			Max:   edges[i+1],
+			Count: max(above(i)-above(i+1), 0),
+		})
+	}
+
+	respond(c, http.StatusOK, HistogramResponse{
+		Success:      true,
+		Min:          lo,
+		Max:          hi,
+		BucketSize:   bucketSize,
+		PointSize:    group * bucketSize,
+		TotalBuckets: totalBuckets,
+		Page:         page,
+		Limit:        limit,
+		HasMore:      first+limit < totalBuckets,
+		Pagination:   newPagination(page, limit, first+limit < totalBuckets),
+		Points:       points,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HistorySourceMatch      = "match"
+	HistorySourceSimulate   = "simulate"
+	HistorySourceQuarantine = "quarantine"
+)
+
+// gameHistorySources are the sources of changes game servers submit, as
+// opposed to admin changes such as resets, rollbacks, and season rollovers.
+// Volatility limits and the gainers report count only these.
+var gameHistorySources = []string{HistorySourceMatch, HistorySourceTeamMatch, HistorySourceSimulate, HistorySourceQuarantine}
+
+const historySchema = `
+	CREATE TABLE IF NOT EXISTS rating_history (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		old_rating INT NOT NULL,
+		new_rating INT NOT NULL,
+		source TEXT NOT NULL,
+		match_id BIGINT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rating_history_user ON rating_history(user_id, created_at DESC);
+
+	ALTER TABLE rating_history ADD COLUMN IF NOT EXISTS rolled_back_at TIMESTAMPTZ;
+	CREATE INDEX IF NOT EXISTS idx_rating_history_match ON rating_history(match_id) WHERE match_id IS NOT NULL;
+`
+
+type RatingHistoryEntry struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+	Source    string `json:"source"`
+	MatchID   *int64 `json:"match_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func insertRatingHistory(tx *sql.Tx, userID int64, oldRating, newRating int, source string, matchID *int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO rating_history (user_id, old_rating, new_rating, source, match_id)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, oldRating, newRating, source, matchID)
+	if err != nil {
+		return fmt.Errorf("failed to insert rating history: %w", err)
+	}
+	return nil
+}
+
+type RatingHistoryResponse struct {
+	Success    bool                 `json:"success"`
+	Username   string               `json:"username"`
+	Data       []RatingHistoryEntry `json:"data"`
+	Count      int                  `json:"count"`
+	Page       int                  `json:"page"`
+	Limit      int                  `json:"limit"`
+	HasMore    bool                 `json:"hasMore"`
+	Pagination *Pagination          `json:"pagination"`
+}
+
+// GetRatingHistory returns a user's most recent rating changes, newest first.
+func GetRatingHistory(userID int64, limit int) ([]RatingHistoryEntry, error) {
+	return GetRatingHistoryPage(userID, limit, 0)
+}
+
+func GetRatingHistoryPage(userID int64, limit, offset int) ([]RatingHistoryEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, old_rating, new_rating, source, match_id, created_at
+		FROM rating_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rating history: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []RatingHistoryEntry{}
+	for rows.Next() {
+		var e RatingHistoryEntry
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.UserID, &e.OldRating, &e.NewRating, &e.Source, &e.MatchID, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rating history: %w", err)
+		}
+		e.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rating history: %w", err)
+	}
+	return entries, nil
+}
+
+// HandleRatingHistory serves GET /users/:username/history, newest change
+// first.
+func HandleRatingHistory(c *gin.Context) {
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
+	}.normalize()
+
+	entries, err := GetRatingHistoryPage(user.ID, req.Limit+1, req.offset())
+	if err != nil {
+		requestLog(c).Error("Error fetching rating history", "username", user.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch rating history")
+		return
+	}
+	hasMore := len(entries) > req.Limit
+	if hasMore {
+		entries = entries[:req.Limit]
+	}
+
+	respond(c, http.StatusOK, RatingHistoryResponse{
+		Success:    true,
+		Username:   user.Username,
+		Data:       entries,
+		Count:      len(entries),
+		Page:       req.Page,
+		Limit:      req.Limit,
+		HasMore:    hasMore,
+		Pagination: newPagination(req.Page, req.Limit, hasMore),
+	})
+}
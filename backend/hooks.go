@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"plugin"
+	"strings"
+	"sync"
+)
+
+// Update hooks let custom builds add logic to the rating update lifecycle,
+// such as awarding badges or syncing to another system, without patching the
+// handlers:
+//
+//   - PreValidate sees the rating changes a match is about to write, before
+//     the volatility checks, and rejects the match by returning an error.
+//   - PostCommit sees rating changes once they are committed. It runs on the
+//     primary, in commit order.
+//   - PostRankChange sees every rank change, as /events clients do. It also
+//     runs on the primary only.
+//
+// Hooks are compiled in by a file that calls RegisterUpdateHooks from init(),
+// usually behind a build tag (see hooks_example.go), or loaded at startup
+// from the Go plugins listed in UPDATE_HOOK_PLUGINS. A hook that panics is
+// logged and skipped.
+type UpdateHooks struct {
+	Name           string
+	PreValidate    func(changes []RatingUpdatedEvent) error
+	PostCommit     func(changes []RatingUpdatedEvent)
+	PostRankChange func(e RankChangeEvent)
+}
+
+// HookRejectedError is a PreValidate hook or a rating rule turning a write
+// down. Hook says which, e.g. "update hook badges" or "rule daily-cap".
+type HookRejectedError struct {
+	Hook   string
+	Reason string
+}
+
+func (e *HookRejectedError) Error() string {
+	return fmt.Sprintf("rejected by %s: %s", e.Hook, e.Reason)
+}
+
+var (
+	hooksMu     sync.RWMutex
+	updateHooks []UpdateHooks
+
+	unsubscribeRankHooks func()
+)
+
+// RegisterUpdateHooks adds a set of hooks. Compiled-in extensions call it
+// from init().
+func RegisterUpdateHooks(h UpdateHooks) {
+	if h.Name == "" {
+		panic("update hooks need a name")
+	}
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	updateHooks = append(updateHooks, h)
+}
+
+func registeredHooks() []UpdateHooks {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return updateHooks
+}
+
+// InitUpdateHooks loads the plugins in UPDATE_HOOK_PLUGINS. A plugin can't
+// import this package, so it exports plain functions instead of UpdateHooks:
+//
+//	var Name = "badges"
+//	func PreValidate(username string, oldRating, newRating int, source string) error
+//	func PostCommit(username string, oldRating, newRating int, source string)
+//	func PostRankChange(username string, oldRank, newRank, rating int, source string)
+//
+// Each function is optional. Plugins need a cgo build of the service.
+func InitUpdateHooks() error {
+	for _, path := range strings.Split(getEnv("UPDATE_HOOK_PLUGINS", ""), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		h, err := loadHookPlugin(path)
+		if err != nil {
+			return fmt.Errorf("update hook plugin %s: %w", path, err)
+		}
+		RegisterUpdateHooks(h)
+	}
+	if hooks := registeredHooks(); len(hooks) > 0 {
+		names := make([]string, len(hooks))
+		for i, h := range hooks {
+			names[i] = h.Name
+		}
+		slog.Info("✓ Update hooks", "hooks", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func loadHookPlugin(path string) (UpdateHooks, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return UpdateHooks{}, err
+	}
+	h := UpdateHooks{Name: path}
+	if sym, err := p.Lookup("Name"); err == nil {
+		name, ok := sym.(*string)
+		if !ok {
+			return h, errors.New("Name must be a string")
+		}
+		h.Name = *name
+	}
+
+	if sym, err := p.Lookup("PreValidate"); err == nil {
+		fn, ok := sym.(func(string, int, int, string) error)
+		if !ok {
+			return h, errors.New("PreValidate has the wrong signature")
+		}
+		h.PreValidate = func(changes []RatingUpdatedEvent) error {
+			for _, c := range changes {
+				if err := fn(c.Username, c.OldRating, c.NewRating, c.Source); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if sym, err := p.Lookup("PostCommit"); err == nil {
+		fn, ok := sym.(func(string, int, int, string))
+		if !ok {
+			return h, errors.New("PostCommit has the wrong signature")
+		}
+		h.PostCommit = func(changes []RatingUpdatedEvent) {
+			for _, c := range changes {
+				fn(c.Username, c.OldRating, c.NewRating, c.Source)
+			}
+		}
+	}
+	if sym, err := p.Lookup("PostRankChange"); err == nil {
+		fn, ok := sym.(func(string, int, int, int, string))
+		if !ok {
+			return h, errors.New("PostRankChange has the wrong signature")
+		}
+		h.PostRankChange = func(e RankChangeEvent) {
+			fn(e.Username, e.OldRank, e.NewRank, e.Rating, e.Source)
+		}
+	}
+	if h.PreValidate == nil && h.PostCommit == nil && h.PostRankChange == nil {
+		return h, errors.New("exports none of PreValidate, PostCommit, PostRankChange")
+	}
+	return h, nil
+}
+
+// runPreValidateHooks returns the first rejection. A hook that panics
+// rejects the write.
+func runPreValidateHooks(changes []RatingUpdatedEvent) error {
+	for _, h := range registeredHooks() {
+		if h.PreValidate == nil {
+			continue
+		}
+		if err := callHook(h.Name, "PreValidate", func() error { return h.PreValidate(changes) }); err != nil {
+			return &HookRejectedError{Hook: "update hook " + h.Name, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+func runPostCommitHooks(changes []RatingUpdatedEvent) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, h := range registeredHooks() {
+		if h.PostCommit != nil {
+			callHook(h.Name, "PostCommit", func() error { h.PostCommit(changes); return nil })
+		}
+	}
+}
+
+func callHook(name, stage string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Update hook panicked", "hook", name, "stage", stage, "panic", r)
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// StartRankChangeHooks feeds rank changes to the PostRankChange hooks. Like
+// an /events client, it makes every write compute ranks, so it only
+// subscribes when such a hook is registered.
+func StartRankChangeHooks() {
+	var hooks []UpdateHooks
+	for _, h := range registeredHooks() {
+		if h.PostRankChange != nil {
+			hooks = append(hooks, h)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	events, unsubscribe := rankEvents.subscribe()
+	stop := make(chan struct{})
+	unsubscribeRankHooks = func() {
+		unsubscribe()
+		close(stop)
+	}
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				for _, h := range hooks {
+					callHook(h.Name, "PostRankChange", func() error { h.PostRankChange(e); return nil })
+				}
+			}
+		}
+	}()
+}
+
+func StopRankChangeHooks() {
+	if unsubscribeRankHooks != nil {
+		unsubscribeRankHooks()
+	}
+}
@@ -0,0 +1,23 @@
+//go:build hooks_example
+
+package main
+
+import "log/slog"
+
+// An example compiled-in extension, built with -tags hooks_example (or
+// BUILD_TAGS=hooks_example in Docker). It logs a milestone badge whenever a
+// committed change carries a user past a multiple of 500.
+const milestoneStep = 500
+
+func init() {
+	RegisterUpdateHooks(UpdateHooks{
+		Name: "milestones",
+		PostCommit: func(changes []RatingUpdatedEvent) {
+			for _, c := range changes {
+				if m := c.NewRating / milestoneStep * milestoneStep; c.NewRating > c.OldRating && m > c.OldRating {
+					slog.Info("Milestone reached", "username", c.Username, "milestone", m, "source", c.Source)
+				}
+			}
+		},
+	})
+}
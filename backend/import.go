@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// POST /admin/import migrates an existing player base from a CSV of
+// username,rating rows. The upload is validated before anything is written;
+// the job then inserts the players in batches inside one transaction, so an
+// import lands whole or not at all. A name that is already taken, ignoring
+// case or normalization, is skipped and reported rather than failing the
+// import. Imported players are ranked straight away with their rating: each
+// gets a user.placed outbox event, and a leaderboard.refreshed event
+// follows them in the same transaction.
+const (
+	JobKindImport = "import"
+
+	importErrorsListed    = 20
+	importConflictsListed = 1000
+)
+
+var (
+	importMaxBytes  = int64(getEnvInt("IMPORT_MAX_BYTES", 64<<20))
+	importBatchSize = getEnvInt("IMPORT_BATCH_SIZE", 1000)
+)
+
+type importRow struct {
+	line     int
+	username string
+	rating   int
+	key      string
+}
+
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type ImportConflict struct {
+	Line     int    `json:"line"`
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+type ImportResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Rows      int              `json:"rows"`
+	Imported  int              `json:"imported"`
+	Skipped   int              `json:"skipped"`
+	Conflicts []ImportConflict `json:"conflicts"`
+}
+
+// parseImportCSV reads username,rating rows, with an optional header row. A
+// name repeated in the file, ignoring case or normalization, is kept the
+// first time and reported as a conflict after that.
+func parseImportCSV(r io.Reader) ([]importRow, []ImportConflict, []ImportRowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows []importRow
+	var dups []ImportConflict
+	var bad []ImportRowError
+	seen := map[string]int{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, nil, err
+			}
+			bad = append(bad, ImportRowError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "username") {
+			continue
+		}
+		if len(record) != 2 {
+			bad = append(bad, ImportRowError{Line: line, Error: fmt.Sprintf("expected 2 fields (username,rating), got %d", len(record))})
+			continue
+		}
+
+		username := strings.TrimSpace(record[0])
+		if !usernamePattern.MatchString(username) {
+			bad = append(bad, ImportRowError{Line: line, Error: "username must be 3-32 letters, digits, underscores, or hyphens"})
+			continue
+		}
+		rating, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil || rating < MinRating || rating > MaxRating {
+			bad = append(bad, ImportRowError{Line: line, Error: fmt.Sprintf("rating must be a whole number between %d and %d", MinRating, MaxRating)})
+			continue
+		}
+
+		key := usernameKey(username)
+		if first, ok := seen[key]; ok {
+			dups = append(dups, ImportConflict{Line: line, Username: username, Reason: fmt.Sprintf("same name as line %d", first)})
+			continue
+		}
+		seen[key] = line
+		rows = append(rows, importRow{line: line, username: username, rating: rating, key: key})
+	}
+	return rows, dups, bad, nil
+}
+
+// runImport inserts rows in batches in one transaction, rolled back at the
+// end of a dry run.
+func runImport(job *Job, rows []importRow, dups []ImportConflict, dryRun bool) (*ImportResult, error) {
+	result := &ImportResult{DryRun: dryRun, Rows: len(rows) + len(dups), Conflicts: []ImportConflict{}}
+	conflict := func(c ImportConflict) {
+		result.Skipped++
+		if len(result.Conflicts) < importConflictsListed {
+			result.Conflicts = append(result.Conflicts, c)
+		}
+	}
+	for _, d := range dups {
+		conflict(d)
+	}
+
+	defer writeSlots.acquire(WriteBackground)()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin import: %w", err)
+	}
+	defer tx.Rollback()
+
+	job.progress("importing", 0, len(rows))
+	batchSize := max(importBatchSize, 1)
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+		usernames := make([]string, len(batch))
+		ratings := make([]int, len(batch))
+		keys := make([]string, len(batch))
+		for i, r := range batch {
+			usernames[i], ratings[i], keys[i] = r.username, r.rating, r.key
+		}
+
+		inserted, err := tx.Query(`
+			INSERT INTO users (username, rating, in_placement, is_bot, username_key)
+			SELECT t.username, t.rating, FALSE, FALSE, t.key
+			FROM unnest($1::text[], $2::int[], $3::text[]) AS t(username, rating, key)
+			WHERE NOT EXISTS (SELECT 1 FROM users u WHERE LOWER(u.username) = LOWER(t.username))
+			ON CONFLICT DO NOTHING
+			RETURNING id, username, rating
+		`, pq.Array(usernames), pq.Array(ratings), pq.Array(keys))
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert import batch: %w", err)
+		}
+		var placed []UserPlacedEvent
+		for inserted.Next() {
+			var ev UserPlacedEvent
+			if err := inserted.Scan(&ev.UserID, &ev.Username, &ev.Rating); err != nil {
+				inserted.Close()
+				return nil, fmt.Errorf("failed to scan imported user: %w", err)
+			}
+			placed = append(placed, ev)
+		}
+		inserted.Close()
+		if err := inserted.Err(); err != nil {
+			return nil, fmt.Errorf("failed to insert import batch: %w", err)
+		}
+
+		added := make(map[string]bool, len(placed))
+		for _, ev := range placed {
+			added[ev.Username] = true
+			if err := insertOutboxEvent(tx, EventUserPlaced, ev); err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range batch {
+			if !added[r.username] {
+				conflict(ImportConflict{Line: r.line, Username: r.username, Reason: errUsernameTaken.Error()})
+			}
+		}
+		result.Imported += len(placed)
+		job.progress("importing", start+len(batch), len(rows))
+	}
+
+	if dryRun || result.Imported == 0 {
+		return result, nil
+	}
+	if err := insertLeaderboardRefresh(tx, RefreshImport); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	outboxRelay.Notify()
+	return result, nil
+}
+
+// importBody returns the CSV from a multipart "file" field or, for any other
+// content type, the raw request body.
+func importBody(c *gin.Context) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return c.Request.Body, nil
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	return fh.Open()
+}
+
+// HandleImport validates the upload, then starts the import as a background
+// job. Poll GET /admin/jobs/:id for progress and the result.
+func HandleImport(c *gin.Context) {
+	dryRun := dryRunRequested(c)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, importMaxBytes)
+	body, err := importBody(c)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import is larger than %d bytes", importMaxBytes))
+			return
+		}
+		respondError(c, http.StatusBadRequest, "Multipart upload must have a file field")
+		return
+	}
+	rows, dups, bad, err := parseImportCSV(body)
+	body.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import is larger than %d bytes", importMaxBytes))
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Failed to read the CSV upload")
+		return
+	}
+	if len(bad) > 0 {
+		respondErrorWith(c, http.StatusBadRequest, fmt.Sprintf("%d rows are invalid; nothing was imported", len(bad)), gin.H{
+			"errors": bad[:min(len(bad), importErrorsListed)],
+		})
+		return
+	}
+	if len(rows) == 0 {
+		respondError(c, http.StatusBadRequest, "CSV has no rows to import")
+		return
+	}
+
+	// The job outlives the request, so it keeps the logger rather than c.
+	logger := requestLog(c)
+	job, started := jobs.start(JobKindImport, dryRun, func(job *Job) (any, error) {
+		result, err := runImport(job, rows, dups, dryRun)
+		if err != nil {
+			logger.Error("Import failed", "error", err)
+			return nil, err
+		}
+		logger.Info("✓ Import finished", "dry_run", dryRun, "imported", result.Imported, "skipped", result.Skipped)
+		return result, nil
+	})
+	if !started {
+		respondErrorWith(c, http.StatusConflict, "An import is already running", gin.H{
+			"job": job.snapshot(),
+		})
+		return
+	}
+
+	respond(c, http.StatusAccepted, gin.H{
+		"job": job.snapshot(),
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultIntegrationTop = 10
+	MaxIntegrationTop     = 25
+)
+
+var tierEmoji = map[string]string{
+	"Grandmaster": "👑",
+	"Diamond":     "💎",
+	"Platinum":    "💠",
+	"Gold":        "🥇",
+	"Silver":      "🥈",
+	"Bronze":      "🥉",
+}
+
+// Discord marks bold with ** and Slack with *, so callers pass their own.
+const (
+	discordBold = "**"
+	slackBold   = "*"
+)
+
+// formatTopTable renders a chat-friendly monospace table wrapped in a code
+// fence, which both Discord and Slack display verbatim.
+func formatTopTable(n int, rows []UserWithRank, bold string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🏆 %sTop %d%s\n```\n", bold, n, bold)
+	fmt.Fprintf(&b, "%-6s %-18s %6s\n", "RANK", "PLAYER", "RATING")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "#%-5d %-18s %6d %s\n", r.Rank, truncateCardText(r.Username, 18), r.Rating, tierEmoji[tierForRating(r.Rating)])
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+func formatUserRank(user *User, rank int, bold string) string {
+	if user.InPlacement {
+		return fmt.Sprintf("🆕 %s%s%s is still in placement (%d/%d games)",
+			bold, user.Username, bold, user.PlacementGames, PlacementGames)
+	}
+	tier := tierForRating(user.Rating)
+	return fmt.Sprintf("%s %s%s%s is ranked %s#%d%s with a rating of %s%d%s (%s)",
+		tierEmoji[tier], bold, user.Username, bold, bold, rank, bold, bold, user.Rating, bold, tier)
+}
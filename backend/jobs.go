@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+
+	jobHistoryLimit = 20
+)
+
+// Job tracks a long-running admin operation so its progress can be polled
+// with GET /admin/jobs/:id. Jobs live in memory and are lost on restart, so
+// job bodies should be safe to run again from scratch.
+type Job struct {
+	mu     sync.Mutex
+	status JobStatus
+}
+
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	DryRun     bool       `json:"dry_run"`
+	Status     string     `json:"status"`
+	Phase      string     `json:"phase"`
+	Processed  int        `json:"processed"`
+	Total      int        `json:"total"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Result     any        `json:"result,omitempty"`
+}
+
+type jobRegistry struct {
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	nextID atomic.Int64
+}
+
+var jobs = &jobRegistry{jobs: map[string]*Job{}}
+
+// start registers a job and runs fn in the background. Only one job of a
+// kind runs at a time; a second start returns the running one and false.
+func (r *jobRegistry) start(kind string, dryRun bool, fn func(*Job) (any, error)) (*Job, bool) {
+	r.mu.Lock()
+	for _, j := range r.jobs {
+		if st := j.snapshot(); st.Kind == kind && st.Status == JobRunning {
+			r.mu.Unlock()
+			return j, false
+		}
+	}
+	job := &Job{status: JobStatus{
+		ID:        fmt.Sprintf("%s-%d", kind, r.nextID.Add(1)),
+		Kind:      kind,
+		DryRun:    dryRun,
+		Status:    JobRunning,
+		StartedAt: time.Now().UTC(),
+	}}
+	r.jobs[job.status.ID] = job
+	r.pruneLocked()
+	r.mu.Unlock()
+
+	go func() {
+		result, err := fn(job)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		now := time.Now().UTC()
+		job.status.FinishedAt = &now
+		job.status.Result = result
+		if err != nil {
+			job.status.Status = JobFailed
+			job.status.Error = err.Error()
+		} else {
+			job.status.Status = JobCompleted
+		}
+	}()
+	return job, true
+}
+
+// pruneLocked drops the oldest finished jobs beyond the history limit.
+func (r *jobRegistry) pruneLocked() {
+	if len(r.jobs) <= jobHistoryLimit {
+		return
+	}
+	finished := make([]JobStatus, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		if st := j.snapshot(); st.Status != JobRunning {
+			finished = append(finished, st)
+		}
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].StartedAt.Before(finished[k].StartedAt) })
+	for _, st := range finished[:min(len(finished), len(r.jobs)-jobHistoryLimit)] {
+		delete(r.jobs, st.ID)
+	}
+}
+
+func (r *jobRegistry) get(id string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+func (r *jobRegistry) list() []JobStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]JobStatus, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		list = append(list, j.snapshot())
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].StartedAt.After(list[k].StartedAt) })
+	return list
+}
+
+// progress updates the job's phase and counters.
+func (j *Job) progress(phase string, processed, total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Phase = phase
+	j.status.Processed = processed
+	j.status.Total = total
+}
+
+func (j *Job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func HandleListJobs(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{
+		"jobs": jobs.list(),
+	})
+}
+
+func HandleGetJob(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok {
+		respondError(c, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"job": job.snapshot(),
+	})
+}
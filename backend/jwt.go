@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Callers can authenticate with an HS256 JWT in "Authorization: Bearer"
+// instead of an API key. The token's role claim ("role", or a "roles" list)
+// decides what it may do: writer submits ratings and scores, admin can also
+// run destructive admin calls, and player acts on the account named by the
+// token's subject through the /me routes. Setting JWT_SIGNING_KEY turns tokens on and
+// makes those admin calls require an admin token. Tokens are issued by an
+// identity provider sharing the key; this service only validates them.
+const (
+	JWTRoleAdmin  = "admin"
+	JWTRoleWriter = "writer"
+	JWTRolePlayer = "player"
+
+	jwtLeeway = 30 * time.Second
+)
+
+var (
+	jwtSigningKey = []byte(getEnv("JWT_SIGNING_KEY", ""))
+	jwtIssuer     = getEnv("JWT_ISSUER", "")
+	jwtAudience   = getEnv("JWT_AUDIENCE", "")
+
+	errJWTMalformed = errors.New("malformed token")
+	errJWTSignature = errors.New("bad signature")
+	errJWTExpired   = errors.New("token expired or not yet valid")
+	errJWTClaims    = errors.New("issuer or audience mismatch")
+)
+
+type JWTClaims struct {
+	Subject   string          `json:"sub"`
+	Role      string          `json:"role"`
+	Roles     []string        `json:"roles"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+func jwtEnabled() bool {
+	return len(jwtSigningKey) > 0
+}
+
+// hasRole reports whether the token may act as role; admin includes writer.
+func (cl *JWTClaims) hasRole(role string) bool {
+	roles := append([]string{cl.Role}, cl.Roles...)
+	return slices.Contains(roles, JWTRoleAdmin) || slices.Contains(roles, role)
+}
+
+func (cl *JWTClaims) audienceMatches(want string) bool {
+	var one string
+	if json.Unmarshal(cl.Audience, &one) == nil {
+		return one == want
+	}
+	var many []string
+	return json.Unmarshal(cl.Audience, &many) == nil && slices.Contains(many, want)
+}
+
+// parseJWT validates an HS256 token and returns its claims. Tokens must
+// carry exp; any other algorithm, including "none", is rejected.
+func parseJWT(token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil || header.Alg != "HS256" {
+		return nil, errJWTMalformed
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	mac := hmac.New(sha256.New, jwtSigningKey)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errJWTSignature
+	}
+
+	var claims JWTClaims
+	raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(raw, &claims) != nil {
+		return nil, errJWTMalformed
+	}
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) ||
+		(claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-jwtLeeway))) {
+		return nil, errJWTExpired
+	}
+	if (jwtIssuer != "" && claims.Issuer != jwtIssuer) || (jwtAudience != "" && !claims.audienceMatches(jwtAudience)) {
+		return nil, errJWTClaims
+	}
+	return &claims, nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// authorizeJWT checks the request's bearer token for role and aborts the
+// request if it falls short. It reports whether the request may continue.
+func authorizeJWT(c *gin.Context, role string) bool {
+	token, ok := bearerToken(c)
+	if !ok {
+		abortWithError(c, http.StatusUnauthorized, "A bearer token is required")
+		return false
+	}
+	claims, err := parseJWT(token, time.Now())
+	if err != nil {
+		requestLog(c).Warn("Rejected bearer token", "path", c.Request.URL.Path, "error", err)
+		abortWithError(c, http.StatusUnauthorized, "A valid bearer token is required")
+		return false
+	}
+	if !claims.hasRole(role) {
+		abortWithError(c, http.StatusForbidden, "This token's role can't perform this action")
+		return false
+	}
+	c.Set("jwt_subject", claims.Subject)
+	return true
+}
+
+// jwtRoleMiddleware requires a bearer token with role on the routes it
+// guards. It does nothing when JWT_SIGNING_KEY is unset, and lets approved
+// actions replay, since their requester was checked when they were parked.
+func jwtRoleMiddleware(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !jwtEnabled() {
+			c.Next()
+			return
+		}
+		if _, ok := c.Request.Context().Value(approvedActionKey{}).(*PendingAction); ok {
+			c.Next()
+			return
+		}
+		if authorizeJWT(c, role) {
+			c.Next()
+		}
+	}
+}
+
+// playerAuthMiddleware requires a player token on the /me routes. Without
+// JWT_SIGNING_KEY there is no way to tell who a player is, so they are off.
+func playerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !jwtEnabled() {
+			abortWithError(c, http.StatusServiceUnavailable, "Player accounts need JWT_SIGNING_KEY to be set")
+			return
+		}
+		if authorizeJWT(c, JWTRolePlayer) {
+			c.Next()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Logs are structured with log/slog. LOG_FORMAT picks text (the default) or
+// json output and LOG_LEVEL the minimum level (debug, info, warn, error).
+// Records logged while serving a request carry its request_id, method, and
+// route; an error attribute that wraps a Postgres error is expanded into the
+// server's code, detail, constraint, and table.
+
+const requestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// InitLogging installs the default logger. It runs first in main, so it
+// reports a bad setting on stderr and keeps the default rather than failing.
+func InitLogging() {
+	opts := &slog.HandlerOptions{ReplaceAttr: expandErrorAttr}
+	var bad []string
+	switch level := strings.ToLower(getEnv("LOG_LEVEL", "info")); level {
+	case "debug":
+		opts.Level = slog.LevelDebug
+	case "info":
+		opts.Level = slog.LevelInfo
+	case "warn", "warning":
+		opts.Level = slog.LevelWarn
+	case "error":
+		opts.Level = slog.LevelError
+	default:
+		bad = append(bad, fmt.Sprintf("LOG_LEVEL %q", level))
+	}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(getEnv("LOG_FORMAT", "text")); format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		bad = append(bad, fmt.Sprintf("LOG_FORMAT %q", format))
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	// SetDefault also routes the standard log package, which net/http
+	// writes to, through the handler.
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	for _, b := range bad {
+		slog.Warn("Ignoring unknown logging setting", "setting", b)
+	}
+}
+
+// fatal logs at error level and exits, in place of log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// expandErrorAttr replaces an error wrapping a *pq.Error with a group holding
+// the message and the fields Postgres reported.
+func expandErrorAttr(groups []string, a slog.Attr) slog.Attr {
+	err, ok := a.Value.Any().(error)
+	if !ok || a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return a
+	}
+	attrs := []any{slog.String("message", err.Error()), slog.String("db_code", string(pqErr.Code))}
+	for _, f := range []struct{ key, value string }{
+		{"db_detail", pqErr.Detail},
+		{"db_hint", pqErr.Hint},
+		{"db_table", pqErr.Table},
+		{"db_column", pqErr.Column},
+		{"db_constraint", pqErr.Constraint},
+		{"db_where", pqErr.Where},
+	} {
+		if f.value != "" {
+			attrs = append(attrs, slog.String(f.key, f.value))
+		}
+	}
+	return slog.Group(a.Key, attrs...)
+}
+
+// requestLogMiddleware replaces gin.Logger. It gives each request an ID,
+// taken from X-Request-ID when the caller or a proxy set one, echoes it in
+// the response, and logs the request with its status and latency when it
+// finishes: server errors at error level, client errors at warn, and the
+// rest at info.
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(requestIDHeader, id)
+		c.Request.Header.Set(requestIDHeader, id)
+
+		logger := slog.Default().With(
+			"request_id", id,
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+		)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.Last().Err)
+		}
+		logger.Log(c.Request.Context(), level, "Request", attrs...)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loggerFrom returns the request's logger, or the default logger outside a
+// request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+func requestLog(c *gin.Context) *slog.Logger {
+	return loggerFrom(c.Request.Context())
+}
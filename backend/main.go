@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,42 +10,95 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	codecjson "github.com/gin-gonic/gin/codec/json"
 )
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Starting Leaderboard Service...")
+	InitLogging()
+	if err := CheckConfigFile(); err != nil {
+		fatal("Invalid config file", "error", err)
+	}
+
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+	slog.Info("Starting Leaderboard Service...")
 
 
 
 
+	if err := validateRatingBounds(); err != nil {
+		fatal("Invalid rating bounds", "error", err)
+	}
+	if err := validatePageSizes(); err != nil {
+		fatal("Invalid page sizes", "error", err)
+	}
+	if err := validateDeploymentMode(); err != nil {
+		fatal("Invalid deployment mode", "error", err)
+	}
+	if err := validateAdminKeys(); err != nil {
+		fatal("Invalid admin keys", "error", err)
+	}
+	if err := ApplyBootstrapManifest(); err != nil {
+		fatal("Invalid bootstrap manifest", "error", err)
+	}
+	if err := ValidateMetrics(); err != nil {
+		fatal("Invalid metrics", "error", err)
+	}
+	if err := ValidateEventSchemas(); err != nil {
+		fatal("Invalid event schemas", "error", err)
+	}
+
 	if err := InitDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", "error", err)
 	}
 	defer CloseDB()
+	defer recorder.Close()
+	if err := InitUsernameCollation(); err != nil {
+		fatal("Invalid username collation", "error", err)
+	}
+	if err := InitSchemaChanges(); err != nil {
+		fatal("Failed to load schema changes", "error", err)
+	}
+	if err := InitAPIKeys(); err != nil {
+		fatal("Failed to initialize API keys", "error", err)
+	}
 
 
 
 
 
 
-	seedCount := 10000
-	if envSeed := os.Getenv("SEED_COUNT"); envSeed != "" {
-	
-		log.Printf("Seed count override not implemented, using default: %d", seedCount)
+	if isReplica() {
+		if err := InitReplicaEngine(); err != nil {
+			fatal("Failed to initialize ranking engine", "error", err)
+		}
+		StartReplicaFeed()
+	} else {
+		startPrimary()
 	}
 
-	if err := SeedUsersWithTransaction(seedCount); err != nil {
-		log.Printf("Warning: Seeding failed: %v", err)
-	
+	StartStabilityTracker()
+	StartUsageMeter()
+	StartRatingSnapshotter()
+	if err := StartSeasonScheduler(); err != nil {
+		fatal("Failed to start season scheduler", "error", err)
+	}
+	StartGlickoScheduler()
+	if err := StartGainersReporter(); err != nil {
+		fatal("Failed to start gainers report", "error", err)
 	}
 
-
-
-
-
-
-	if err := InitRankingEngine(); err != nil {
-		log.Fatalf("Failed to initialize ranking engine: %v", err)
+	if err := InitRatingCalculator(); err != nil {
+		fatal("Failed to initialize rating calculator", "error", err)
+	}
+	if err := InitSubmissionValidators(); err != nil {
+		fatal("Failed to initialize submission validators", "error", err)
+	}
+	if err := InitUpdateHooks(); err != nil {
+		fatal("Failed to initialize update hooks", "error", err)
+	}
+	if err := LoadRatingRules(); err != nil {
+		fatal("Failed to load rating rules", "error", err)
 	}
 
 
@@ -53,6 +106,7 @@ func main() {
 
 
 	router := setupRouter()
+	slog.Info("✓ JSON codec", "package", codecjson.Package)
 
 
 	server := &http.Server{
@@ -67,53 +121,223 @@ func main() {
 
 
 
+	server.RegisterOnShutdown(closeStreams)
+
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 
 	go func() {
-		log.Printf("🚀 Server starting on %s", server.Addr)
-		log.Println("Available endpoints:")
-		log.Println("  GET  /health           - Health check")
-		log.Println("  GET  /stats            - Ranking engine stats")
-		log.Println("  GET  /leaderboard      - Top 100 users")
-		log.Println("  GET  /search?username= - Search users")
-		log.Println("  POST /simulate         - Simulate rating updates")
+		slog.Info("🚀 Server starting", "addr", server.Addr)
+		slog.Info("Available endpoints:")
+		slog.Info("  GET  /health           - Health check")
+		slog.Info("  GET  /stats            - Ranking engine stats")
+		slog.Info("  GET  /stats/histogram  - Rating distribution (paged, downsampled)")
+		slog.Info("  GET  /stats/runtime    - Service counters and background jobs")
+		slog.Info("  GET  /tiers            - Tier cutoffs and population")
+		slog.Info("  GET  /events           - SSE stream of rank changes (?schema_version=, ?format=cloudevents)")
+		slog.Info("  GET  /events/schemas   - JSON schemas of emitted events (?version=)")
+		slog.Info("  GET  /events/schemas/:type - Every version of one event's schema")
+		slog.Info("  GET  /leaderboard      - Top 100 users (?board=, ?metric=, ?season=, ?include_bots=, ?snapshot=, ?after=)")
+		slog.Info("  GET  /leaderboard/around?username= - Users ranked just above and below (?window=, ?board=, ?include_bots=)")
+		slog.Info("  GET  /search?username= - Search users (?board= or ?season=)")
+		slog.Info("  GET  /export?format=   - Stream the full leaderboard as CSV or NDJSON (?include_bots=)")
+		slog.Info("  GET  /leaderboards     - Named boards")
+		slog.Info("  GET  /leaderboards/:name/users/:username - Board rating, rank, and deviation")
+		slog.Info("  POST /users            - Create a user")
+		slog.Info("  GET  /users/:username  - User rating, rank, and placement (?season=)")
+		slog.Info("  DELETE /users/:username - Delete a user and their scores")
+		slog.Info("  GET  /users/:username/rank       - Rank, rating, and percentile")
+		slog.Info("  GET  /leaderboard/card.png       - Top N share card")
+		slog.Info("  GET  /users/:username/card.png   - User share card")
+		slog.Info("  GET  /users/:username/metrics    - Metric values and ranks")
+		slog.Info("  POST /users/:username/metrics    - Set a metric value")
+		slog.Info("  GET  /users/:username/rating?at= - Rating at a past moment")
+		slog.Info("  GET  /users/:username/history    - Rating changes, newest first")
+		slog.Info("  GET  /users/:username/seasons    - Final standing per season")
+		slog.Info("  GET  /users/:username/badges     - Badges awarded by rating rules")
+		slog.Info("  POST /me/reset                   - Reset your own rating (player token, confirmation step)")
+		slog.Info("  GET  /seasons                    - Archived seasons")
+		slog.Info("  GET  /seasons/:id/leaderboard    - Archived season standings")
+		slog.Info("  GET  /seasons/:id/users/:username - Archived season standing")
+		slog.Info("  GET  /integrations/discord/top   - Discord-formatted top N")
+		slog.Info("  GET  /integrations/discord/rank/:username - Discord-formatted rank")
+		slog.Info("  POST /integrations/slack/command - Slack /rank and /top commands")
+		slog.Info("  GET  /embed/top?n=&theme=        - Embeddable live widget")
+		slog.Info("  GET  /admin/rating-bounds?min=&max= - Dry-run rating bounds report")
+		slog.Info("  GET  /admin/users?limit=&offset=   - Streamed user listing")
+		slog.Info("  GET  /admin/debug/user/:username   - Everything known about a user")
+		slog.Info("  GET  /admin/consistency?sample=    - Engine vs SQL rank self-check")
+		slog.Info("  POST /admin/engine/rebuild         - Reload the engine from the database")
+		slog.Info("  GET  /admin/replication/conflicts  - Replica event conflicts")
+		slog.Info("  POST /admin/replication/reconcile  - Resync users with conflicts")
+		slog.Info("  GET  /admin/usage?from=&to=&key=   - Daily API usage per key")
+		slog.Info("  POST /admin/ratings/:event_id/rollback?dry_run= - Revert a rating change")
+		slog.Info("  POST /admin/seasons/archive?dry_run= - Archive the current board as a season")
+		slog.Info("  POST /admin/rerate?dry_run=        - Recompute ratings from the match log")
+		slog.Info("  POST /admin/import?dry_run=        - Import players from a username,rating CSV")
+		slog.Info("  GET  /admin/jobs/:id               - Background job progress")
+		slog.Info("  GET  /admin/schema-changes         - Soft-launched columns and backfill state")
+		slog.Info("  GET  /admin/usernames/collisions   - Users whose names collide after normalization")
+		slog.Info("  GET  /admin/backfills              - Backfill progress")
+		slog.Info("  POST /admin/backfills/:name/start?restart= - Start or resume a backfill")
+		slog.Info("  POST /admin/backfills/:name/pause  - Pause a backfill after its current chunk")
+		slog.Info("  GET  /admin/config/export?format=  - Effective configuration")
+		slog.Info("  POST /admin/config/import          - Apply tiers, boards, API keys, and webhooks")
+		slog.Info("  GET  /admin/approvals              - Actions awaiting a second admin")
+		slog.Info("  POST /admin/approvals/:id/approve  - Approve and execute an action")
+		slog.Info("  POST /admin/approvals/:id/reject   - Reject an action")
+		slog.Info("  POST /admin/leaderboards           - Create a named board")
+		slog.Info("  POST /admin/leaderboards/:name/rating-period - Close a Glicko-2 rating period now")
+		slog.Info("  GET  /admin/quarantine?status=     - Submissions flagged by validators")
+		slog.Info("  POST /admin/quarantine/:id/approve - Apply a flagged submission")
+		slog.Info("  POST /admin/quarantine/:id/reject  - Discard a flagged submission")
+		slog.Info("  GET  /admin/api-keys               - Issued API keys")
+		slog.Info("  POST /admin/api-keys               - Issue an API key")
+		slog.Info("  DELETE /admin/api-keys/:name       - Revoke an API key")
+		slog.Info("  PUT  /admin/api-keys/:name/quota   - Set an API key's quotas")
+		slog.Info("  GET  /admin/quotas                 - Quotas and usage per API key")
+		slog.Info("  GET  /admin/volatility             - Volatility limits per leaderboard")
+		slog.Info("  PUT  /admin/volatility/:scope      - Set a leaderboard's volatility limits")
+		slog.Info("  GET  /admin/volatility/queue?status= - Matches queued by volatility limits")
+		slog.Info("  GET  /admin/webhooks               - Webhook subscriptions with delivery stats")
+		slog.Info("  POST /admin/webhooks               - Create webhook subscriptions in bulk")
+		slog.Info("  PATCH /admin/webhooks              - Update webhook subscriptions in bulk")
+		slog.Info("  DELETE /admin/webhooks?ids=        - Delete webhook subscriptions in bulk")
+		slog.Info("  POST /admin/webhooks/:id/test      - Send a test delivery")
+		slog.Info("  POST /admin/webhooks/:id/rotate-secret - Issue a new signing secret")
+		slog.Info("  GET  /admin/rules                  - Rating rules")
+		slog.Info("  PUT  /admin/rules/:name            - Create or replace a rating rule")
+		slog.Info("  DELETE /admin/rules/:name          - Delete a rating rule")
+		slog.Info("  POST /admin/rules/reload           - Reload rating rules from the database")
+		slog.Info("  POST /admin/rules/test             - Evaluate rating rules against a change")
+		slog.Info("  GET  /admin/reports/gainers?week=&format= - Weekly gainers report")
+		slog.Info("  GET  /admin/reports/gainers/deliveries - Stored weekly reports and their delivery")
+		slog.Info("  POST /simulate         - Simulate rating updates (?board= on any board)")
+		slog.Info("  POST /simulate/replay  - Replay a recorded simulation")
+		slog.Info("  POST /matches          - Record a match result")
+		slog.Info("  POST /matches/team     - Record a team match result")
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			fatal("Failed to start server", "error", err)
 		}
 	}()
 
 
+	go warmUp(router)
+	StartSimulator()
+
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server...")
+	StopSimulator()
+	StopBackgroundSeeder()
+	StopBackfills()
+	StopWebhooks()
+	StopEventSinks()
+	StopRankChangeHooks()
+	StopVolatilityQueue()
 
+	report := beginShutdownReport()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		slog.Warn("Server forced to shut down", "error", err)
+	}
+	report.drainRequests()
+	report.drainUpdates(ctx)
+
+	StopStabilityTracker()
+	StopUsageMeter()
+	StopRatingSnapshotter()
+	StopSeasonScheduler()
+	StopGlickoScheduler()
+	StopGainersReporter()
+	StopEngineCheckpointer()
+	StopRatingWAL()
+	report.OutboxFlushed = StopOutboxRelay()
+	StopReplicaFeed()
+	report.finish()
+
+	slog.Info("Server exited gracefully")
+}
+
+// startPrimary seeds the database when SEED_MODE asks for it, loads the
+// engine, and starts the outbox relay. Replicas skip all of it: their
+// database is read-only.
+func startPrimary() {
+	seedCount := getEnvInt("SEED_COUNT", 10000)
+
+	if seedMode == SeedBlocking {
+		if err := SeedUsersWithTransaction(seedCount); err != nil {
+			slog.Warn("Seeding failed", "error", err)
+		}
+	}
+
+	if err := PrepareComposites(); err != nil {
+		fatal("Failed to prepare composite leaderboards", "error", err)
+	}
+	if err := MarkOutboxCaughtUp(); err != nil {
+		fatal("Failed to prepare outbox", "error", err)
+	}
+
+	if err := PrepareEngineCheckpoints(); err != nil {
+		fatal("Failed to prepare engine checkpoints", "error", err)
+	}
+	if err := StartRatingWAL(); err != nil {
+		fatal("Failed to start rating WAL", "error", err)
+	}
+	if err := InitRankingEngine(); err != nil {
+		fatal("Failed to initialize ranking engine", "error", err)
+	}
+	if err := loadMetricEngines(db); err != nil {
+		fatal("Failed to initialize metric engines", "error", err)
+	}
+	if err := loadBoards(db); err != nil {
+		fatal("Failed to initialize boards", "error", err)
+	}
+	if err := ApplyBootstrapResources(); err != nil {
+		fatal("Failed to apply bootstrap manifest", "error", err)
 	}
 
-	log.Println("Server exited gracefully")
+	StartOutboxRelay()
+	StartEngineCheckpointer()
+	ResumeBackfills()
+	StartWebhooks()
+	if err := StartEventSinks(); err != nil {
+		fatal("Failed to start event sinks", "error", err)
+	}
+	StartRankChangeHooks()
+	StartVolatilityQueue()
+	if seedMode == SeedBackground {
+		StartBackgroundSeeder(seedCount)
+	}
 }
 
 func setupRouter() *gin.Engine {
 
-	if os.Getenv("GIN_MODE") == "" {
-		gin.SetMode(gin.ReleaseMode)
-	}
+	gin.SetMode(getEnv("GIN_MODE", gin.ReleaseMode))
 
 	router := gin.New()
+	h := NewHandlers(postgresUserStore{}, engineRankStore{})
+	h.Humans = NewLeaderboardService(humanUserStore{}, humanRankStore{})
 
 
-	router.Use(gin.Recovery())
-	router.Use(gin.Logger())  
+	router.Use(requestLogMiddleware())
+	router.Use(panicRecovery())
+	router.Use(inFlightMiddleware())
+	router.Use(forwardMutations())
+	router.Use(auditMiddleware())
+	router.Use(usageMiddleware())
 
 
 	router.Use(corsMiddleware())
+	router.Use(errorMiddleware())
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(handleNoRoute)
+	router.NoMethod(handleNoMethod)
 
 
 
@@ -124,13 +348,120 @@ func setupRouter() *gin.Engine {
 
 
 	router.GET("/stats", HandleStats)
-
-
-	router.GET("/leaderboard", HandleLeaderboard)
-	router.GET("/search", HandleSearch)
-
-
-	router.POST("/simulate", HandleSimulate)
+	router.GET("/stats/histogram", HandleStatsHistogram)
+	router.GET("/stats/runtime", HandleRuntimeStats)
+	router.GET("/tiers", HandleListTiers)
+	router.GET("/events", HandleRankEvents)
+	router.GET("/events/schemas", HandleEventSchemas)
+	router.GET("/events/schemas/:type", HandleEventSchemaVersions)
+
+
+	write := apiKeyMiddleware(APIKeyRoleWrite)
+	signed := signedSubmissionMiddleware()
+	limited := updateQuotaMiddleware()
+
+	router.GET("/leaderboard", budgetMiddleware(), h.HandleLeaderboard)
+	router.GET("/leaderboard/around", budgetMiddleware(), h.HandleLeaderboardAround)
+	router.GET("/search", budgetMiddleware(), h.HandleSearch)
+	router.GET("/export", HandleExport)
+	router.GET("/leaderboards", HandleListBoards)
+	router.GET("/leaderboards/:name/users/:username", HandleBoardUser)
+
+
+	router.GET("/leaderboard/card.png", h.HandleLeaderboardCard)
+	router.POST("/users", write, signed, h.HandleCreateUser)
+	router.GET("/users/:username", budgetMiddleware(), h.HandleGetUser)
+	router.DELETE("/users/:username", requireAPIKeyMiddleware(APIKeyRoleWrite), signed, HandleDeleteUser)
+	router.GET("/users/:username/rank", HandleUserRank)
+	router.GET("/users/:username/card.png", h.HandleUserCard)
+	router.GET("/users/:username/metrics", HandleGetUserMetrics)
+	router.POST("/users/:username/metrics", write, limited, signed, HandleSetUserMetric)
+	router.GET("/users/:username/rating", HandleRatingAt)
+	router.GET("/users/:username/history", HandleRatingHistory)
+	router.GET("/users/:username/seasons", HandleUserSeasons)
+	router.GET("/users/:username/badges", HandleUserBadges)
+
+	router.POST("/me/reset", playerAuthMiddleware(), resetRateMiddleware(), HandleResetRating)
+
+	router.GET("/seasons", HandleListSeasons)
+	router.GET("/seasons/:id/leaderboard", HandleSeasonLeaderboard)
+	router.GET("/seasons/:id/users/:username", HandleSeasonUser)
+
+
+	discord := router.Group("/integrations/discord", discordAuthMiddleware())
+	discord.GET("/top", h.HandleDiscordTop)
+	discord.GET("/rank/:username", h.HandleDiscordRank)
+	router.POST("/integrations/slack/command", slackAuthMiddleware(), h.HandleSlackCommand)
+
+
+	router.GET("/embed/top", HandleEmbedTop)
+	router.GET("/embed/top/stream", h.HandleEmbedTopStream)
+
+
+	approvalRouter = router
+	admin := router.Group("/admin", adminAuthMiddleware())
+	destructive := jwtRoleMiddleware(JWTRoleAdmin)
+	admin.GET("/rating-bounds", HandleRatingBoundsReport)
+	admin.GET("/users", HandleAdminListUsers)
+	admin.GET("/debug/user/:username", HandleDebugUser)
+	admin.GET("/consistency", HandleConsistencyCheck)
+	admin.POST("/engine/rebuild", destructive, requireApproval("engine.rebuild"), HandleRebuildEngine)
+	admin.GET("/replication/conflicts", HandleReplicationConflicts)
+	admin.POST("/replication/reconcile", destructive, requireApproval("replication.reconcile"), HandleReplicationReconcile)
+	admin.GET("/usage", HandleUsage)
+	admin.POST("/ratings/:event_id/rollback", destructive, requireApproval("rating.rollback"), HandleRollbackRating)
+	admin.POST("/seasons/archive", destructive, requireApproval("season.archive"), HandleArchiveSeason)
+	admin.POST("/rerate", destructive, requireApproval("rerate"), HandleRerate)
+	admin.POST("/import", destructive, requireApprovalUpTo("import", importMaxBytes), HandleImport)
+	admin.GET("/jobs", HandleListJobs)
+	admin.GET("/jobs/:id", HandleGetJob)
+	admin.GET("/schema-changes", HandleListSchemaChanges)
+	admin.GET("/usernames/collisions", HandleUsernameCollisions)
+	admin.GET("/backfills", HandleListBackfills)
+	admin.GET("/backfills/:name", HandleGetBackfill)
+	admin.POST("/backfills/:name/start", HandleStartBackfill)
+	admin.POST("/backfills/:name/pause", HandlePauseBackfill)
+	admin.GET("/config/export", HandleConfigExport)
+	admin.GET("/approvals", HandleListApprovals)
+	admin.POST("/approvals/:id/approve", destructive, HandleApproveAction)
+	admin.POST("/approvals/:id/reject", HandleRejectAction)
+	admin.POST("/leaderboards", HandleCreateBoard)
+	admin.POST("/leaderboards/:name/rating-period", HandleCloseRatingPeriod)
+	admin.GET("/quarantine", HandleListQuarantine)
+	admin.POST("/quarantine/:id/approve", HandleApproveQuarantine)
+	admin.POST("/quarantine/:id/reject", HandleRejectQuarantine)
+	keyAdmin := apiKeyMiddleware(APIKeyRoleAdmin)
+	admin.GET("/api-keys", keyAdmin, HandleListAPIKeys)
+	admin.POST("/api-keys", keyAdmin, HandleCreateAPIKey)
+	admin.DELETE("/api-keys/:name", keyAdmin, HandleRevokeAPIKey)
+	admin.PUT("/api-keys/:name/quota", keyAdmin, HandleSetQuota)
+	admin.POST("/config/import", keyAdmin, HandleConfigImport)
+	admin.GET("/quotas", HandleListQuotas)
+	admin.GET("/volatility", HandleListVolatilityLimits)
+	admin.PUT("/volatility/:scope", HandleSetVolatilityLimits)
+	admin.GET("/volatility/queue", HandleListVolatilityQueue)
+	admin.GET("/webhooks", HandleListWebhooks)
+	admin.POST("/webhooks", HandleCreateWebhooks)
+	admin.PATCH("/webhooks", HandleUpdateWebhooks)
+	admin.DELETE("/webhooks", HandleDeleteWebhooks)
+	admin.GET("/webhooks/:id", HandleGetWebhook)
+	admin.POST("/webhooks/:id/test", HandleTestWebhook)
+	admin.POST("/webhooks/:id/rotate-secret", HandleRotateWebhookSecret)
+	admin.GET("/rules", HandleListRules)
+	admin.PUT("/rules/:name", HandlePutRule)
+	admin.DELETE("/rules/:name", HandleDeleteRule)
+	admin.POST("/rules/reload", HandleReloadRules)
+	admin.POST("/rules/test", HandleTestRule)
+	admin.GET("/reports/gainers", HandleGainersReport)
+	admin.GET("/reports/gainers/deliveries", HandleListGainersReports)
+
+
+	router.POST("/simulate", write, limited, signed, HandleSimulate)
+	router.POST("/simulate/replay", write, limited, signed, HandleSimulateReplay)
+
+
+	router.POST("/matches", write, limited, signed, HandleCreateMatch)
+	router.POST("/matches/team", write, limited, signed, HandleCreateTeamMatch)
 
 	return router
 }
@@ -138,8 +469,9 @@ func setupRouter() *gin.Engine {
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Admin-Key, X-Signature, X-Signature-Key, X-Signature-Timestamp, X-Signature-Nonce, If-None-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -151,9 +483,5 @@ func corsMiddleware() gin.HandlerFunc {
 }
 
 func getServerAddr() string {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	return ":" + port
+	return ":" + getEnv("PORT", "8080")
 }
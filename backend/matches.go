@@ -0,0 +1,388 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	OutcomePlayerA = "a"
+	OutcomePlayerB = "b"
+	OutcomeDraw    = "draw"
+)
+
+const matchesSchema = `
+	CREATE TABLE IF NOT EXISTS matches (
+		id BIGSERIAL PRIMARY KEY,
+		player_a_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		player_b_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		outcome TEXT NOT NULL CHECK (outcome IN ('a', 'b', 'draw')),
+		player_a_old_rating INT NOT NULL,
+		player_a_new_rating INT NOT NULL,
+		player_b_old_rating INT NOT NULL,
+		player_b_new_rating INT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	-- Game servers supply their own match id; the unique index is what stops
+	-- a retried submission from applying Elo changes twice.
+	ALTER TABLE matches ADD COLUMN IF NOT EXISTS external_id TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_matches_external_id ON matches(external_id);
+
+	CREATE INDEX IF NOT EXISTS idx_matches_player_a ON matches(player_a_id, created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_matches_player_b ON matches(player_b_id, created_at DESC);
+`
+
+var (
+	errMatchUserNotFound = errors.New("match player not found")
+	errDuplicateMatch    = errors.New("match already recorded")
+)
+
+type MatchRequest struct {
+	MatchID string `json:"match_id"`
+	PlayerA string `json:"player_a"`
+	PlayerB string `json:"player_b"`
+	Outcome string `json:"outcome"`
+	Board   string `json:"board"`
+
+	// approved skips the submission validators for a match an admin released
+	// from quarantine.
+	approved bool
+}
+
+type MatchPlayerResult struct {
+	Username  string `json:"username"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+	Delta     int    `json:"delta"`
+	Rank      int    `json:"rank,omitempty"`
+
+	Placement *PlacementProgress `json:"placement,omitempty"`
+}
+
+type MatchResponse struct {
+	Success bool   `json:"success"`
+	ID      int64  `json:"id"`
+	MatchID string `json:"match_id"`
+	Board   string `json:"board,omitempty"`
+	Outcome string `json:"outcome"`
+	// Pending is set on glicko2 boards, where ratings move when the rating
+	// period closes.
+	Pending bool                `json:"pending,omitempty"`
+	Players []MatchPlayerResult `json:"players"`
+}
+
+func outcomeScore(outcome string) (float64, bool) {
+	switch outcome {
+	case OutcomePlayerA:
+		return 1, true
+	case OutcomePlayerB:
+		return 0, true
+	case OutcomeDraw:
+		return 0.5, true
+	default:
+		return 0, false
+	}
+}
+
+// HandleCreateMatch records a match result. Both rating updates, the match
+// row, the history rows, and the outbox events commit in one transaction; the
+// engine only changes once the outbox relay sees the committed events.
+func HandleCreateMatch(c *gin.Context) {
+	var req MatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.MatchID = strings.TrimSpace(req.MatchID)
+	req.PlayerA = strings.TrimSpace(req.PlayerA)
+	req.PlayerB = strings.TrimSpace(req.PlayerB)
+	scoreA, ok := outcomeScore(req.Outcome)
+	if req.MatchID == "" || req.PlayerA == "" || req.PlayerB == "" || !ok {
+		respondError(c, http.StatusBadRequest, "match_id, player_a, player_b, and outcome (a, b, or draw) are required")
+		return
+	}
+	if strings.EqualFold(req.PlayerA, req.PlayerB) {
+		respondError(c, http.StatusBadRequest, "A player cannot play against themselves")
+		return
+	}
+	if req.Board != "" && !strings.EqualFold(req.Board, DefaultBoard) {
+		handleBoardMatch(c, req, scoreA)
+		return
+	}
+
+	matchID, players, err := recordMatch(req, scoreA)
+	var flagged *flaggedSubmissionError
+	if errors.As(err, &flagged) {
+		respondQuarantined(c, flagged, &QuarantinedMatch{Match: &req})
+		return
+	}
+	var limited *VolatilityError
+	if errors.As(err, &limited) && volatilityAction == VolatilityQueue {
+		// A match that can't be stored is refused with 429 instead.
+		queued, qerr := queueMatch(req, limited.RetryAfter)
+		if qerr != nil {
+			requestLog(c).Error("Error queueing match", "match_id", req.MatchID, "error", qerr)
+		}
+		if queued {
+			respond(c, http.StatusAccepted, gin.H{
+				"queued":              true,
+				"match_id":            req.MatchID,
+				"reason":              limited.Reason,
+				"retry_after_seconds": int(math.Ceil(limited.RetryAfter.Seconds())),
+			})
+			return
+		}
+	}
+	if err != nil {
+		c.Error(matchError(err, req.MatchID))
+		return
+	}
+
+	outboxRelay.Flush()
+
+	updated := 0
+	for _, p := range players {
+		if p.Delta != 0 {
+			updated++
+		}
+	}
+	meterRatingUpdates(c, updated)
+
+	re := GetRankingEngine()
+	for i := range players {
+		if p := players[i].Placement; p != nil && !p.Placed {
+			continue
+		}
+		players[i].Rank = re.GetRank(players[i].NewRating)
+	}
+
+	respond(c, http.StatusCreated, MatchResponse{
+		Success: true,
+		ID:      matchID,
+		MatchID: req.MatchID,
+		Outcome: req.Outcome,
+		Players: players,
+	})
+}
+
+// matchError maps the errors recording a match can fail with. Volatility
+// limits and rejections by hooks or rules pass through to errorMiddleware.
+func matchError(err error, matchID string) error {
+	switch {
+	case errors.Is(err, errDuplicateMatch):
+		return &apiError{Status: http.StatusConflict, Message: fmt.Sprintf("Match %s has already been recorded", matchID)}
+	case errors.Is(err, errCalculatorUnavailable):
+		return &apiError{Status: http.StatusServiceUnavailable, Message: "Rating calculator unavailable, please retry", Err: err}
+	case errors.Is(err, errMatchUserNotFound):
+		return &apiError{Status: http.StatusNotFound, Message: "User not found"}
+	}
+	return &apiError{Status: http.StatusInternalServerError, Message: "Failed to record match", Err: err}
+}
+
+func recordMatch(req MatchRequest, scoreA float64) (int64, []MatchPlayerResult, error) {
+	defer writeSlots.acquire(WriteInteractive)()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin match transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock both rows in id order so concurrent matches between the same
+	// players can't deadlock.
+	rows, err := tx.Query(`
+		SELECT id, username, rating, in_placement, placement_games
+		FROM users
+		WHERE LOWER(username) IN (LOWER($1), LOWER($2))
+		ORDER BY id
+		FOR UPDATE
+	`, req.PlayerA, req.PlayerB)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to lock match players: %w", err)
+	}
+	var a, b *User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating, &u.InPlacement, &u.PlacementGames); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan match player: %w", err)
+		}
+		if strings.EqualFold(u.Username, req.PlayerA) {
+			a = &u
+		} else {
+			b = &u
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating match players: %w", err)
+	}
+	if a == nil || b == nil {
+		return 0, nil, errMatchUserNotFound
+	}
+	if err := checkMatchRecorded(tx, `SELECT EXISTS (SELECT 1 FROM matches WHERE external_id = $1)`, req.MatchID); err != nil {
+		return 0, nil, err
+	}
+
+	gamesA, err := countMatchesTx(tx, a.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	gamesB, err := countMatchesTx(tx, b.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	newA, newB, err := ratingCalculator.Calculate(MatchInput{
+		MatchID: req.MatchID,
+		PlayerA: a.Username,
+		PlayerB: b.Username,
+		RatingA: a.Rating,
+		RatingB: b.Rating,
+		ScoreA:  scoreA,
+		GamesA:  gamesA,
+		GamesB:  gamesB,
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("rating calculator %s failed: %w", ratingCalculator.Name(), err)
+	}
+
+	// Players in placement keep their seed rating until placement completes;
+	// their opponents are still rated against it.
+	if a.InPlacement {
+		newA = a.Rating
+	}
+	if b.InPlacement {
+		newB = b.Rating
+	}
+	proposed := []RatingUpdatedEvent{
+		{UserID: a.ID, Username: a.Username, OldRating: a.Rating, NewRating: newA, Source: HistorySourceMatch},
+		{UserID: b.ID, Username: b.Username, OldRating: b.Rating, NewRating: newB, Source: HistorySourceMatch},
+	}
+	if err := applyRatingRules(tx, proposed); err != nil {
+		return 0, nil, err
+	}
+	newA, newB = proposed[0].NewRating, proposed[1].NewRating
+	if err := runPreValidateHooks(proposed); err != nil {
+		return 0, nil, err
+	}
+	// Players in placement have no rating to screen yet.
+	var screened []RatingUpdatedEvent
+	for i, u := range []*User{a, b} {
+		if !u.InPlacement && !req.approved {
+			screened = append(screened, proposed[i])
+		}
+	}
+	if err := screenRatingUpdates(screened); err != nil {
+		return 0, nil, err
+	}
+	limits, err := loadVolatilityLimit(tx, DefaultBoard)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := limits.check(tx, a, newA-a.Rating); err != nil {
+		return 0, nil, err
+	}
+	if err := limits.check(tx, b, newB-b.Rating); err != nil {
+		return 0, nil, err
+	}
+
+	var matchID int64
+	err = tx.QueryRow(`
+		INSERT INTO matches (external_id, player_a_id, player_b_id, outcome,
+			player_a_old_rating, player_a_new_rating, player_b_old_rating, player_b_new_rating)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, req.MatchID, a.ID, b.ID, req.Outcome, a.Rating, newA, b.Rating, newB).Scan(&matchID)
+	if isUniqueViolation(err) {
+		return 0, nil, errDuplicateMatch
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to insert match: %w", err)
+	}
+	if err := recordMatchResult(tx, a.ID, scoreA); err != nil {
+		return 0, nil, err
+	}
+	if err := recordMatchResult(tx, b.ID, 1-scoreA); err != nil {
+		return 0, nil, err
+	}
+
+	players := []MatchPlayerResult{
+		{Username: a.Username, OldRating: a.Rating, NewRating: newA, Delta: newA - a.Rating},
+		{Username: b.Username, OldRating: b.Rating, NewRating: newB, Delta: newB - b.Rating},
+	}
+	for i, u := range []*User{a, b} {
+		p := &players[i]
+		if u.InPlacement {
+			if err := advancePlacement(tx, u, matchID, p); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE users SET rating = $1 WHERE id = $2`, p.NewRating, u.ID); err != nil {
+			return 0, nil, fmt.Errorf("failed to update match player rating: %w", err)
+		}
+		if err := insertRatingHistory(tx, u.ID, p.OldRating, p.NewRating, HistorySourceMatch, &matchID); err != nil {
+			return 0, nil, err
+		}
+		if err := insertOutboxEvent(tx, EventRatingUpdated, RatingUpdatedEvent{
+			UserID:    u.ID,
+			Username:  p.Username,
+			OldRating: p.OldRating,
+			NewRating: p.NewRating,
+			Source:    HistorySourceMatch,
+		}); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit match: %w", err)
+	}
+	for _, s := range ratingSubmissions(screened) {
+		observeSubmission(s)
+	}
+	return matchID, players, nil
+}
+
+// checkMatchRecorded answers a retried submission with errDuplicateMatch
+// before rules, hooks, validators, and volatility limits see it again, so a
+// retry can't come back queued or quarantined. It runs with the players
+// locked: a concurrent retry of the same match waits on those locks, and by
+// then the first submission has committed. The unique index still backs it.
+func checkMatchRecorded(tx *sql.Tx, query string, args ...any) error {
+	var recorded bool
+	if err := tx.QueryRow(query, args...).Scan(&recorded); err != nil {
+		return fmt.Errorf("failed to check for a recorded match: %w", err)
+	}
+	if recorded {
+		return errDuplicateMatch
+	}
+	return nil
+}
+
+func countMatchesTx(tx *sql.Tx, userID int64) (int, error) {
+	var n int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM matches WHERE player_a_id = $1 OR player_b_id = $1
+	`, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count matches: %w", err)
+	}
+	return n, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
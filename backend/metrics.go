@@ -0,0 +1,520 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricRating names the built-in rating board; every other metric is
+// declared in METRICS as name:max pairs, e.g. "kills:100000,playtime:1000000".
+// Metric values run from 0 to max and each metric gets its own Fenwick
+// engine, so ranks on every board are O(log n).
+const (
+	MetricRating = "rating"
+
+	EventMetricUpdated = "metric.updated"
+)
+
+// Submission modes decide how a submitted value combines with the stored
+// one, set per metric as a third METRICS field ("kills:100000:increment").
+const (
+	SubmitLast      = "last"      // overwrite
+	SubmitMax       = "max"       // keep the best
+	SubmitIncrement = "increment" // add to the total
+)
+
+const metricsSchema = `
+	CREATE TABLE IF NOT EXISTS user_metrics (
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		metric TEXT NOT NULL,
+		value INT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, metric)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_metrics_board ON user_metrics (metric, value DESC, user_id);
+`
+
+type MetricDef struct {
+	Name    string `json:"name"`
+	Min     int    `json:"min"`
+	Max     int    `json:"max"`
+	Formula string `json:"formula,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+type MetricUpdatedEvent struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Metric   string `json:"metric"`
+	OldValue *int   `json:"old_value"`
+	NewValue int    `json:"new_value"`
+}
+
+type MetricRow struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Value    int    `json:"value"`
+}
+
+type MetricLeaderboardResponse struct {
+	Success    bool        `json:"success"`
+	Metric     string      `json:"metric"`
+	Data       []MetricRow `json:"data"`
+	Count      int         `json:"count"`
+	Page       int         `json:"page"`
+	Limit      int         `json:"limit"`
+	HasMore    bool        `json:"has_more"`
+	Pagination *Pagination `json:"pagination"`
+}
+
+type UserMetricsResponse struct {
+	Success  bool                  `json:"success"`
+	Username string                `json:"username"`
+	Metrics  map[string]MetricRank `json:"metrics"`
+}
+
+type MetricRank struct {
+	Value int `json:"value"`
+	Rank  int `json:"rank"`
+}
+
+type SetMetricRequest struct {
+	Metric string `json:"metric"`
+	Value  *int   `json:"value"`
+}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+	metricDefs    = map[string]MetricDef{}
+	metricEngines = map[string]*FenwickEngine{}
+
+	errMetricOverflow = errors.New("metric total would exceed its max")
+)
+
+// ValidateMetrics parses METRICS and COMPOSITES. It runs before the database
+// is opened so a typo fails startup instead of silently dropping a board.
+func ValidateMetrics() error {
+	raw := getEnv("METRICS", "")
+	if raw == "" {
+		return parseComposites(getEnv("COMPOSITES", ""))
+	}
+	for _, pair := range parseKeyValueList(raw) {
+		name := strings.ToLower(pair[0])
+		if !metricNamePattern.MatchString(name) || name == MetricRating {
+			return fmt.Errorf("invalid metric name %q", pair[0])
+		}
+		if _, dup := metricDefs[name]; dup {
+			return fmt.Errorf("metric %q is declared twice", name)
+		}
+		maxField, mode, _ := strings.Cut(pair[1], ":")
+		maxValue, err := strconv.Atoi(maxField)
+		if err != nil || maxValue < 1 {
+			return fmt.Errorf("metric %q needs a positive max, got %q", name, maxField)
+		}
+		switch mode {
+		case "":
+			mode = SubmitLast
+		case SubmitLast, SubmitMax, SubmitIncrement:
+		default:
+			return fmt.Errorf("metric %q has unknown submission mode %q (use last, max, or increment)", name, mode)
+		}
+		metricDefs[name] = MetricDef{Name: name, Max: maxValue, Mode: mode}
+	}
+	if len(metricDefs) == 0 {
+		return fmt.Errorf("METRICS is set but declares no name:max pairs")
+	}
+	return parseComposites(getEnv("COMPOSITES", ""))
+}
+
+// loadMetricEngines builds one engine per metric from q, which lets the
+// replica load them from the same snapshot as its rating engine.
+func loadMetricEngines(q queryer) error {
+	for _, def := range metricDefs {
+		rows, err := q.Query(`SELECT value, COUNT(*) FROM user_metrics WHERE metric = $1 GROUP BY value`, def.Name)
+		if err != nil {
+			return fmt.Errorf("failed to load metric %s: %w", def.Name, err)
+		}
+		counts := map[int]int{}
+		for rows.Next() {
+			var value, count int
+			if err := rows.Scan(&value, &count); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan metric %s: %w", def.Name, err)
+			}
+			counts[value] = count
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("error iterating metric %s: %w", def.Name, err)
+		}
+
+		metricEngines[def.Name] = newFenwickRange(def.Min, def.Max, counts)
+		slog.Info("✓ Metric engine initialized", "metric", def.Name, "total_users", metricEngines[def.Name].totalUsers)
+	}
+	return nil
+}
+
+func applyMetricEvent(ev MetricUpdatedEvent) {
+	engine, ok := metricEngines[ev.Metric]
+	if !ok {
+		return
+	}
+	if ev.OldValue == nil {
+		engine.AddUser(ev.NewValue)
+		return
+	}
+	engine.UpdateRating(*ev.OldValue, ev.NewValue)
+}
+
+// submitUserMetric combines a submitted value with the stored one according
+// to the metric's mode, stores the result, and queues its engine update in
+// the same transaction. The user row is locked so concurrent submissions for
+// one user apply one after another. changed is false when the stored value
+// stays the same, e.g. a max-mode score that doesn't beat the best.
+func submitUserMetric(username string, def MetricDef, value int) (ev *MetricUpdatedEvent, changed bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin metric update: %w", err)
+	}
+	defer tx.Rollback()
+
+	ev = &MetricUpdatedEvent{Metric: def.Name, NewValue: value}
+	err = tx.QueryRow(`
+		SELECT id, username FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1 FOR UPDATE
+	`, username).Scan(&ev.UserID, &ev.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, errMatchUserNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	var old int
+	err = tx.QueryRow(`SELECT value FROM user_metrics WHERE user_id = $1 AND metric = $2`, ev.UserID, def.Name).Scan(&old)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to read metric: %w", err)
+	default:
+		ev.OldValue = &old
+	}
+
+	if ev.OldValue != nil {
+		switch def.Mode {
+		case SubmitMax:
+			ev.NewValue = max(old, value)
+		case SubmitIncrement:
+			ev.NewValue = old + value
+		}
+		if ev.NewValue > def.Max {
+			return nil, false, errMetricOverflow
+		}
+		if ev.NewValue == old {
+			return ev, false, nil
+		}
+	}
+
+	// Rules see the metric's values as old and new, and may cap the gain.
+	changes := []RatingUpdatedEvent{{UserID: ev.UserID, Username: ev.Username, NewRating: ev.NewValue, Source: ruleMetricSource + def.Name}}
+	if ev.OldValue != nil {
+		changes[0].OldRating = old
+	}
+	if err := applyRatingRules(tx, changes); err != nil {
+		return nil, false, err
+	}
+	ev.NewValue = changes[0].NewRating
+	if ev.OldValue != nil && ev.NewValue == old {
+		return ev, false, tx.Commit()
+	}
+	if err := checkVolatility(tx, volatilityMetricScope+def.Name, &User{ID: ev.UserID, Username: ev.Username}, ev.NewValue-old); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_metrics (user_id, metric, value) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, metric) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+	`, ev.UserID, def.Name, ev.NewValue); err != nil {
+		return nil, false, fmt.Errorf("failed to store metric: %w", err)
+	}
+	if err := insertOutboxEvent(tx, EventMetricUpdated, ev); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit metric update: %w", err)
+	}
+	return ev, true, nil
+}
+
+// lookupUserMetric returns the user's id and stored value for metric, nil
+// when they have none.
+func lookupUserMetric(username, metric string) (int64, *int, error) {
+	var userID int64
+	var value sql.NullInt64
+	err := db.QueryRow(`
+		SELECT u.id, m.value
+		FROM users u
+		LEFT JOIN user_metrics m ON m.user_id = u.id AND m.metric = $2
+		WHERE LOWER(u.username) = LOWER($1)
+		LIMIT 1
+	`, username, metric).Scan(&userID, &value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, errMatchUserNotFound
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read metric: %w", err)
+	}
+	if !value.Valid {
+		return userID, nil, nil
+	}
+	v := int(value.Int64)
+	return userID, &v, nil
+}
+
+// deleteUserScores deletes a user's metric values and board ratings,
+// returning them by metric and board name.
+func deleteUserScores(tx *sql.Tx, userID int64) (metrics, ratings map[string]int, err error) {
+	metrics, err = deleteReturning(tx, `DELETE FROM user_metrics WHERE user_id = $1 RETURNING metric, value`, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to delete user metrics: %w", err)
+	}
+	ratings, err = deleteReturning(tx, `
+		DELETE FROM board_ratings br USING leaderboards l
+		WHERE br.user_id = $1 AND l.id = br.leaderboard_id
+		RETURNING l.name, br.rating
+	`, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to delete user board ratings: %w", err)
+	}
+	return metrics, ratings, nil
+}
+
+// forgetUserScores takes deleted metric values and board ratings out of
+// their engines.
+func forgetUserScores(metrics, ratings map[string]int) {
+	for metric, value := range metrics {
+		if engine, ok := metricEngines[metric]; ok {
+			engine.RemoveUser(value)
+		}
+	}
+	for name, rating := range ratings {
+		if b, ok := getBoard(name); ok {
+			b.engine.RemoveUser(rating)
+		}
+	}
+}
+
+// deleteReturning runs a DELETE ... RETURNING name, value for one user.
+func deleteReturning(tx *sql.Tx, query string, userID int64) (map[string]int, error) {
+	rows, err := tx.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	removed := map[string]int{}
+	for rows.Next() {
+		var name string
+		var value int
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		removed[name] = value
+	}
+	return removed, rows.Err()
+}
+
+func handleMetricLeaderboard(c *gin.Context, metric string) {
+	engine, ok := metricEngines[metric]
+	if !ok {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown metric %q", metric))
+		return
+	}
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
+	}.normalize()
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT u.username, m.value
+		FROM user_metrics m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.metric = $1
+		ORDER BY m.value DESC, `+collateUsername("u.username")+`
+		LIMIT $2 OFFSET $3
+	`, metric, req.Limit+1, req.offset())
+	if err != nil {
+		requestLog(c).Error("Error fetching leaderboard", "metric", metric, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+	defer rows.Close()
+
+	data := make([]MetricRow, 0, req.Limit+1)
+	for rows.Next() {
+		var row MetricRow
+		if err := rows.Scan(&row.Username, &row.Value); err != nil {
+			requestLog(c).Error("Error scanning leaderboard", "metric", metric, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+			return
+		}
+		data = append(data, row)
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating leaderboard", "metric", metric, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+
+	hasMore := len(data) > req.Limit
+	if hasMore {
+		data = data[:req.Limit]
+	}
+	for i := range data {
+		data[i].Rank = engine.GetRank(data[i].Value)
+	}
+
+	respond(c, http.StatusOK, MetricLeaderboardResponse{
+		Success:    true,
+		Metric:     metric,
+		Data:       data,
+		Count:      len(data),
+		Page:       req.Page,
+		Limit:      req.Limit,
+		HasMore:    hasMore,
+		Pagination: newPagination(req.Page, req.Limit, hasMore),
+	})
+}
+
+func HandleGetUserMetrics(c *gin.Context) {
+	user, err := GetUserByUsernameContext(c.Request.Context(), c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `SELECT metric, value FROM user_metrics WHERE user_id = $1`, user.ID)
+	if err != nil {
+		requestLog(c).Error("Error fetching metrics", "username", user.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch metrics")
+		return
+	}
+	defer rows.Close()
+
+	metrics := map[string]MetricRank{}
+	for rows.Next() {
+		var metric string
+		var value int
+		if err := rows.Scan(&metric, &value); err != nil {
+			requestLog(c).Error("Error scanning metrics", "username", user.Username, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to fetch metrics")
+			return
+		}
+		// Values of metrics no longer in METRICS stay stored but aren't ranked.
+		if engine, ok := metricEngines[metric]; ok {
+			metrics[metric] = MetricRank{Value: value, Rank: engine.GetRank(value)}
+		}
+	}
+
+	respond(c, http.StatusOK, UserMetricsResponse{
+		Success:  true,
+		Username: user.Username,
+		Metrics:  metrics,
+	})
+}
+
+func HandleSetUserMetric(c *gin.Context) {
+	var req SetMetricRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Metric == "" || req.Value == nil {
+		respondError(c, http.StatusBadRequest, "metric and value are required")
+		return
+	}
+	def, ok := metricDefs[strings.ToLower(req.Metric)]
+	if !ok {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown metric %q, configured metrics: %s", req.Metric, strings.Join(metricNames(), ", ")))
+		return
+	}
+	if def.Formula != "" {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("%s is computed from %s and can't be set directly", def.Name, def.Formula))
+		return
+	}
+	if *req.Value < 0 || *req.Value > def.Max {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("%s must be between 0 and %d", def.Name, def.Max))
+		return
+	}
+
+	s := Submission{Username: c.Param("username"), Metric: def.Name, Mode: def.Mode, Value: *req.Value}
+	if len(submissionValidators) > 0 {
+		userID, current, err := lookupUserMetric(c.Param("username"), def.Name)
+		if errors.Is(err, errMatchUserNotFound) {
+			respondError(c, http.StatusNotFound, "User not found")
+			return
+		}
+		if err != nil {
+			requestLog(c).Error("Error reading metric", "metric", def.Name, "username", c.Param("username"), "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to update metric")
+			return
+		}
+		s.Current = current
+		if screenSubmission(c, userID, s) {
+			return
+		}
+	}
+
+	release := writeSlots.acquire(WriteInteractive)
+	ev, changed, err := submitUserMetric(c.Param("username"), def, *req.Value)
+	release()
+	if errors.Is(err, errMatchUserNotFound) {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, errMetricOverflow) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("%s total would exceed %d", def.Name, def.Max))
+		return
+	}
+	var rejected *HookRejectedError
+	var limited *VolatilityError
+	if errors.As(err, &rejected) || errors.As(err, &limited) {
+		c.Error(err)
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error setting metric", "metric", def.Name, "username", c.Param("username"), "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update metric")
+		return
+	}
+
+	if changed {
+		outboxRelay.Flush()
+	}
+	observeSubmission(s)
+
+	respond(c, http.StatusOK, gin.H{
+		"username": ev.Username,
+		"metric":   def.Name,
+		"mode":     def.Mode,
+		"value":    ev.NewValue,
+		"changed":  changed,
+		"rank":     metricEngines[def.Name].GetRank(ev.NewValue),
+	})
+}
+
+func metricNames() []string {
+	names := make([]string, 0, len(metricDefs))
+	for name := range metricDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
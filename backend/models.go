@@ -5,36 +5,54 @@ type User struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	Rating   int    `json:"rating"`
+
+	InPlacement    bool `json:"-"`
+	PlacementGames int  `json:"-"`
+	IsBot          bool `json:"-"`
 }
 
 type UserWithRank struct {
-	Rank     int    `json:"rank"`
+	Rank     int    `json:"rank,omitempty"`
 	Username string `json:"username"`
 	Rating   int    `json:"rating"`
+	Tier     string `json:"tier,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
 }
 
 type LeaderboardResponse struct {
-	Success bool           `json:"success"`
-	Data    []UserWithRank `json:"data"`
-	Count   int            `json:"count"`
-	Page    int            `json:"page"`
-	Limit   int            `json:"limit"`
-	HasMore bool           `json:"hasMore"`
+	Success  bool           `json:"success"`
+	Data     []UserWithRank `json:"data"`
+	Count    int            `json:"count"`
+	Page     int            `json:"page"`
+	Limit    int            `json:"limit"`
+	HasMore  bool           `json:"hasMore"`
+	Partial  bool           `json:"partial,omitempty"`
+	Degraded bool           `json:"degraded,omitempty"`
+	// NextCursor continues the board with ?after= when HasMore is set.
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Pagination *Pagination `json:"pagination"`
 }
 
 type SearchResponse struct {
-	Success bool           `json:"success"`
-	Data    []UserWithRank `json:"data"`
-	Count   int            `json:"count"`
-	Page    int            `json:"page"`
-	Limit   int            `json:"limit"`
-	HasMore bool           `json:"hasMore"`
+	Success    bool           `json:"success"`
+	Data       []UserWithRank `json:"data"`
+	Count      int            `json:"count"`
+	Page       int            `json:"page"`
+	Limit      int            `json:"limit"`
+	HasMore    bool           `json:"hasMore"`
+	Pagination *Pagination    `json:"pagination"`
+	Partial    bool           `json:"partial,omitempty"`
+	Truncated  bool           `json:"truncated,omitempty"`
 }
 
 type SimulateResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Updated int    `json:"updated"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	Updated    int    `json:"updated"`
+	Registered int    `json:"registered,omitempty"`
+	Churned    int    `json:"churned,omitempty"`
+	// NewRating is the rating a single-user simulation wrote, after rules.
+	NewRating int `json:"new_rating,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -44,6 +62,7 @@ type ErrorResponse struct {
 
 type RatingUpdate struct {
 	UserID    int64
+	Username  string
 	OldRating int
 	NewRating int
 }
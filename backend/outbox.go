@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	EventRatingUpdated  = "rating.updated"
+	EventRatingsUpdated = "ratings.updated"
+
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 500
+
+	outboxSweepInterval = 10 * time.Minute
+	outboxSweepChunk    = 10000
+
+	// outboxPositionSource is the source of the empty events the relay adds
+	// to move the outbox position past a late event.
+	outboxPositionSource = "outbox_position"
+)
+
+// outboxRetention is how long processed events are kept. The newest
+// replicaLookback ids are always kept, so a replica's tail and its
+// duplicate check still find them however quiet the service is.
+var outboxRetention = time.Duration(getEnvInt("OUTBOX_RETENTION_HOURS", 24)) * time.Hour
+
+const outboxSchema = `
+	CREATE TABLE IF NOT EXISTS outbox (
+		id BIGSERIAL PRIMARY KEY,
+		event_type TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		processed_at TIMESTAMPTZ
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(id) WHERE processed_at IS NULL;
+`
+
+type RatingUpdatedEvent struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+	Source    string `json:"source"`
+}
+
+// RatingsUpdatedEvent is a set of rating changes committed together, which
+// the engine applies as one batch.
+type RatingsUpdatedEvent struct {
+	Source  string               `json:"source"`
+	Updates []RatingUpdatedEvent `json:"updates"`
+}
+
+type OutboxEvent struct {
+	ID      int64
+	Type    string
+	Payload json.RawMessage
+}
+
+func insertOutboxEvent(tx *sql.Tx, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox event: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (event_type, payload) VALUES ($1, $2)`, eventType, data)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+	return nil
+}
+
+// insertAppliedOutboxEvent records a change the caller applies to this
+// instance's engine itself (simulation, seeding). It is inserted already
+// processed, so the relay skips it and only replicas, which tail every row,
+// apply it. The caller passes the returned id to noteOutboxApplied once the
+// transaction commits.
+func insertAppliedOutboxEvent(tx *sql.Tx, eventType string, payload any) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode outbox event: %w", err)
+	}
+	var id int64
+	err = tx.QueryRow(`INSERT INTO outbox (event_type, payload, processed_at) VALUES ($1, $2, NOW()) RETURNING id`, eventType, data).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+	return id, nil
+}
+
+// OutboxRelay moves committed outbox events into the in-memory engine. Events
+// are marked processed in their own transaction before being applied, so a
+// write that rolled back never reaches the engine and a committed one is
+// applied exactly once per process.
+type OutboxRelay struct {
+	mu   sync.Mutex
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	// finalFlushed is how many events the last flush before stopping applied.
+	finalFlushed int
+}
+
+var outboxRelay *OutboxRelay
+
+// MarkOutboxCaughtUp must run before the engine loads its counts from the
+// users table: those rows already reflect every committed event, so replaying
+// still-pending events on top would apply them twice.
+func MarkOutboxCaughtUp() error {
+	result, err := db.Exec(`UPDATE outbox SET processed_at = NOW() WHERE processed_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox caught up: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		slog.Info("Marked pending outbox events as reflected in the engine snapshot", "events", n)
+	}
+	var last int64
+	if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM outbox`).Scan(&last); err != nil {
+		return fmt.Errorf("failed to read outbox position: %w", err)
+	}
+	noteOutboxApplied(last)
+	return nil
+}
+
+func StartOutboxRelay() {
+	outboxRelay = &OutboxRelay{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go outboxRelay.run()
+	slog.Info("✓ Outbox relay started")
+}
+
+func (r *OutboxRelay) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	sweep := time.NewTicker(outboxSweepInterval)
+	defer sweep.Stop()
+
+	r.sweep()
+	for {
+		select {
+		case <-r.stop:
+			r.finalFlushed = r.Flush()
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		case <-sweep.C:
+			r.sweep()
+			continue
+		}
+		r.Flush()
+	}
+}
+
+// sweep deletes processed events older than OUTBOX_RETENTION_HOURS, in
+// chunks so no single statement holds locks for long. Pending events are
+// never deleted.
+func (r *OutboxRelay) sweep() {
+	if outboxRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-outboxRetention)
+	total := int64(0)
+	for {
+		result, err := db.Exec(`
+			DELETE FROM outbox WHERE id IN (
+				SELECT id FROM outbox
+				WHERE processed_at < $1 AND id <= (SELECT MAX(id) FROM outbox) - $2
+				ORDER BY id
+				LIMIT $3
+			)
+		`, cutoff, replicaLookback, outboxSweepChunk)
+		if err != nil {
+			slog.Error("Outbox sweep failed", "error", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		total += n
+		if n < outboxSweepChunk {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("✓ Swept processed outbox events", "events", total, "older_than", cutoff)
+	}
+}
+
+// Flush drains every pending event synchronously and returns how many it
+// applied. Handlers call it right after committing so their response reflects
+// the engine's new state.
+func (r *OutboxRelay) Flush() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for {
+		n, err := r.processBatch()
+		total += n
+		if err != nil {
+			slog.Error("Outbox relay error", "error", err)
+			return total
+		}
+		if n < outboxBatchSize {
+			return total
+		}
+	}
+}
+
+func (r *OutboxRelay) processBatch() (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin outbox transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, event_type, payload
+		FROM outbox
+		WHERE processed_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating outbox: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	if _, err := tx.Exec(`UPDATE outbox SET processed_at = NOW() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to mark outbox events processed: %w", err)
+	}
+	// Ids are assigned at insert but become visible at commit, so a slow
+	// write can surface below the position, and applying it wouldn't move
+	// the position. An empty ratings.updated event then gives the change a
+	// new id; replicas reach it after the late event too.
+	position := ids[len(ids)-1]
+	if ids[0] <= outboxPosition.Load() {
+		id, err := insertAppliedOutboxEvent(tx, EventRatingsUpdated, RatingsUpdatedEvent{Source: outboxPositionSource, Updates: []RatingUpdatedEvent{}})
+		if err != nil {
+			return 0, err
+		}
+		position = id
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox batch: %w", err)
+	}
+
+	for _, e := range events {
+		applyOutboxEvent(e)
+	}
+	noteOutboxApplied(position)
+	return len(events), nil
+}
+
+func applyOutboxEvent(e OutboxEvent) {
+	switch e.Type {
+	case EventRatingUpdated:
+		var ev RatingUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyRatingChange(GetRankingEngine(), ev.Username, ev.OldRating, ev.NewRating, ev.Source)
+		runPostCommitHooks([]RatingUpdatedEvent{ev})
+
+	case EventRatingsUpdated:
+		var ev RatingsUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		updates := make([]RatingUpdate, len(ev.Updates))
+		for i, u := range ev.Updates {
+			updates[i] = RatingUpdate{UserID: u.UserID, Username: u.Username, OldRating: u.OldRating, NewRating: u.NewRating}
+		}
+		applyRatingBatch(GetRankingEngine(), updates, ev.Source)
+		runPostCommitHooks(ev.Updates)
+
+	case EventUserPlaced:
+		var ev UserPlacedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		if ev.IsBot {
+			bots.register(ev.Username)
+		}
+		GetRankingEngine().AddUser(ev.Rating)
+		bots.add(ev.Username, ev.Rating)
+
+	case EventUserDeleted:
+		var ev UserDeletedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyUserDeletedEvent(ev)
+
+	case EventMetricUpdated:
+		var ev MetricUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyMetricEvent(ev)
+
+	case EventBoardRatingUpdated:
+		var ev BoardRatingUpdatedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyBoardEvent(ev)
+
+	case EventLeaderboardRefreshed:
+		var ev LeaderboardRefreshedEvent
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			slog.Error("Outbox event has invalid payload", "event_id", e.ID, "error", err)
+			return
+		}
+		applyLeaderboardRefresh(ev)
+	}
+}
+
+// Notify wakes the relay without blocking the caller.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StopOutboxRelay returns how many pending events were flushed on the way out.
+func StopOutboxRelay() int {
+	if outboxRelay == nil {
+		return 0
+	}
+	close(outboxRelay.stop)
+	<-outboxRelay.done
+	slog.Info("✓ Outbox relay stopped")
+	return outboxRelay.finalFlushed
+}
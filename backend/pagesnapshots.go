@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Paging a live board can repeat or skip users whose ratings change between
+// requests. /leaderboard?snapshot=latest freezes the top PAGE_SNAPSHOT_ROWS of
+// the board and returns the snapshot's id; passing ?snapshot=<id> on later
+// pages serves them from the same frozen copy until it expires. Browses that
+// start within PAGE_SNAPSHOT_REUSE_SEC of each other share one snapshot.
+const pageSnapshotLatest = "latest"
+
+var errPageSnapshotExpired = errors.New("snapshot expired")
+
+type pageSnapshot struct {
+	id        int64
+	svc       *LeaderboardService
+	rows      []UserWithRank
+	truncated bool // the board had more users than the snapshot holds
+	degraded  bool
+	takenAt   time.Time
+	expiresAt time.Time
+}
+
+type pageSnapshotCache struct {
+	maxRows int
+	ttl     time.Duration
+	reuse   time.Duration
+
+	mu     sync.Mutex
+	nextID int64
+	byID   map[int64]*pageSnapshot
+	latest map[*LeaderboardService]*pageSnapshot
+}
+
+var pageSnapshots = &pageSnapshotCache{
+	maxRows: max(getEnvInt("PAGE_SNAPSHOT_ROWS", 5000), 1),
+	ttl:     time.Duration(getEnvInt("PAGE_SNAPSHOT_TTL_SEC", 300)) * time.Second,
+	reuse:   time.Duration(getEnvInt("PAGE_SNAPSHOT_REUSE_SEC", 30)) * time.Second,
+	byID:    map[int64]*pageSnapshot{},
+	latest:  map[*LeaderboardService]*pageSnapshot{},
+}
+
+// lookup resolves a ?snapshot= value against svc's board: "latest" returns a
+// recent snapshot or takes one, an id returns that snapshot while it lives.
+func (pc *pageSnapshotCache) lookup(svc *LeaderboardService, value string) (*pageSnapshot, error) {
+	if value == pageSnapshotLatest {
+		return pc.current(svc)
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errPageSnapshotExpired
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.expireLocked(time.Now())
+	snap := pc.byID[id]
+	if snap == nil || snap.svc != svc {
+		return nil, errPageSnapshotExpired
+	}
+	return snap, nil
+}
+
+func (pc *pageSnapshotCache) current(svc *LeaderboardService) (*pageSnapshot, error) {
+	pc.mu.Lock()
+	if snap := pc.latest[svc]; snap != nil && time.Since(snap.takenAt) < pc.reuse {
+		pc.mu.Unlock()
+		return snap, nil
+	}
+	pc.mu.Unlock()
+
+	// Not tied to the request: the snapshot outlives it and is shared.
+	rows, err := svc.Top(context.Background(), pc.maxRows+1)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	snap := &pageSnapshot{svc: svc, takenAt: now, expiresAt: now.Add(pc.ttl)}
+	if len(rows) > pc.maxRows {
+		rows = rows[:pc.maxRows]
+		snap.truncated = true
+	}
+	if svc.ranks.Rebuilding() || hasUnranked(rows) {
+		fillPositions(rows, 0)
+		snap.degraded = true
+	}
+	snap.rows = rows
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.expireLocked(now)
+	pc.nextID++
+	snap.id = pc.nextID
+	pc.byID[snap.id] = snap
+	pc.latest[svc] = snap
+	return snap, nil
+}
+
+func (pc *pageSnapshotCache) expireLocked(now time.Time) {
+	for id, snap := range pc.byID {
+		if now.After(snap.expiresAt) {
+			delete(pc.byID, id)
+			if pc.latest[snap.svc] == snap {
+				delete(pc.latest, snap.svc)
+			}
+		}
+	}
+}
+
+// invalidate drops every snapshot, so pages of a board that has since been
+// rewritten wholesale answer 410 and the next browse starts fresh.
+func (pc *pageSnapshotCache) invalidate() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	n := len(pc.byID)
+	clear(pc.byID)
+	clear(pc.latest)
+	return n
+}
+
+func (pc *pageSnapshotCache) Stats() gin.H {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	rows := 0
+	for _, snap := range pc.byID {
+		rows += len(snap.rows)
+	}
+	return gin.H{"live": len(pc.byID), "rows": rows}
+}
+
+// servePageSnapshot answers /leaderboard?snapshot= for svc's board.
+func servePageSnapshot(c *gin.Context, svc *LeaderboardService, value string, req PageRequest) {
+	snap, err := pageSnapshots.lookup(svc, value)
+	if errors.Is(err, errPageSnapshotExpired) {
+		respondError(c, http.StatusGone, "Snapshot expired, start again with snapshot=latest")
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error taking leaderboard snapshot", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch leaderboard")
+		return
+	}
+
+	req = req.normalize()
+	start := min(req.offset(), len(snap.rows))
+	end := min(start+req.Limit, len(snap.rows))
+	rows := snap.rows[start:end]
+
+	respond(c, http.StatusOK, SnapshotLeaderboardResponse{
+		LeaderboardResponse: LeaderboardResponse{
+			Success:    true,
+			Data:       rows,
+			Count:      len(rows),
+			Page:       req.Page,
+			Limit:      req.Limit,
+			HasMore:    end < len(snap.rows),
+			Pagination: newPagination(req.Page, req.Limit, end < len(snap.rows)),
+			Degraded:   snap.degraded,
+		},
+		Snapshot:          strconv.FormatInt(snap.id, 10),
+		SnapshotTakenAt:   snap.takenAt.UTC(),
+		SnapshotExpiresAt: snap.expiresAt.UTC(),
+		Truncated:         snap.truncated && end == len(snap.rows),
+	})
+}
+
+type SnapshotLeaderboardResponse struct {
+	LeaderboardResponse
+	Snapshot          string    `json:"snapshot"`
+	SnapshotTakenAt   time.Time `json:"snapshot_taken_at"`
+	SnapshotExpiresAt time.Time `json:"snapshot_expires_at"`
+	// Truncated marks the last page of a snapshot that stopped short of the
+	// whole board.
+	Truncated bool `json:"truncated,omitempty"`
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PanicReport is what the generic webhook receives; Sentry gets the same data
+// mapped onto its event format.
+type PanicReport struct {
+	EventID   string         `json:"event_id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Message   string         `json:"message"`
+	Stack     string         `json:"stack"`
+	Request   PanicRequest   `json:"request"`
+	Engine    map[string]any `json:"engine"`
+}
+
+type PanicRequest struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Query     string `json:"query,omitempty"`
+	Route     string `json:"route,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// panicReporter forwards recovered panics to Sentry (SENTRY_DSN) and/or a
+// generic JSON webhook (PANIC_WEBHOOK_URL). Delivery is best effort and off
+// the request path; the local log line is always written.
+type panicReporter struct {
+	webhookURL string
+	sentry     *sentryTarget
+	client     *http.Client
+}
+
+type sentryTarget struct {
+	storeURL string
+	auth     string
+}
+
+var panics = newPanicReporter()
+
+func newPanicReporter() *panicReporter {
+	pr := &panicReporter{
+		webhookURL: getEnv("PANIC_WEBHOOK_URL", ""),
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+	if dsn := getEnv("SENTRY_DSN", ""); dsn != "" {
+		target, err := parseSentryDSN(dsn)
+		if err != nil {
+			slog.Warn("Ignoring SENTRY_DSN", "error", err)
+		} else {
+			pr.sentry = target
+		}
+	}
+	return pr
+}
+
+// parseSentryDSN turns https://<key>@<host>/<project> into the project's
+// store endpoint and auth header.
+func parseSentryDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("expected https://<key>@<host>/<project>")
+	}
+	return &sentryTarget{
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=leaderboard/1.0, sentry_key=%s", u.User.Username()),
+	}, nil
+}
+
+// panicRecovery replaces gin.Recovery: the client still gets a 500, and the
+// panic is reported with its stack, the request, and the engine's state.
+func panicRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		report := PanicReport{
+			EventID:   newEventID(),
+			Timestamp: time.Now().UTC(),
+			Message:   fmt.Sprint(recovered),
+			Stack:     string(debug.Stack()),
+			Request: PanicRequest{
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				Query:     c.Request.URL.RawQuery,
+				Route:     c.FullPath(),
+				ClientIP:  c.ClientIP(),
+				UserAgent: c.Request.UserAgent(),
+			},
+			Engine: engineSnapshot(),
+		}
+		requestLog(c).Error("Panic", "event_id", report.EventID, "path", report.Request.Path, "message", report.Message)
+		go panics.send(report)
+
+		abortWithError(c, http.StatusInternalServerError, "Internal server error")
+	})
+}
+
+// engineSnapshot is metadata only; a panic inside the engine must not be
+// able to panic again while being reported.
+func engineSnapshot() (snapshot map[string]any) {
+	snapshot = map[string]any{}
+	defer func() {
+		if r := recover(); r != nil {
+			snapshot["error"] = fmt.Sprint(r)
+		}
+	}()
+
+	re := GetRankingEngine()
+	if re == nil {
+		return snapshot
+	}
+	total, unique, minR, maxR := re.GetStats()
+	snapshot["type"] = fmt.Sprintf("%T", re)
+	snapshot["total_users"] = total
+	snapshot["unique_ratings"] = unique
+	snapshot["min_rating"] = minR
+	snapshot["max_rating"] = maxR
+	snapshot["rating_range"] = fmt.Sprintf("%d-%d", MinRating, MaxRating)
+	if shadow, ok := re.(*ShadowEngine); ok {
+		snapshot["shadow"] = shadow.Stats()
+	}
+	return snapshot
+}
+
+func (pr *panicReporter) send(report PanicReport) {
+	if pr.webhookURL != "" {
+		pr.post(pr.webhookURL, nil, report)
+	}
+	if pr.sentry != nil {
+		pr.post(pr.sentry.storeURL, map[string]string{"X-Sentry-Auth": pr.sentry.auth}, sentryEvent(report))
+	}
+}
+
+func (pr *panicReporter) post(target string, headers map[string]string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("Failed to encode panic report", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Failed to build panic report request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := pr.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to deliver panic report", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Panic report rejected", "status", resp.StatusCode)
+	}
+}
+
+func sentryEvent(r PanicReport) map[string]any {
+	return map[string]any{
+		"event_id":  r.EventID,
+		"timestamp": r.Timestamp.Format(time.RFC3339),
+		"level":     "fatal",
+		"platform":  "go",
+		"logger":    "panic",
+		"exception": map[string]any{
+			"values": []map[string]any{{"type": "panic", "value": r.Message}},
+		},
+		"request": map[string]any{
+			"method":       r.Request.Method,
+			"url":          r.Request.Path,
+			"query_string": r.Request.Query,
+		},
+		"tags": map[string]string{
+			"route": r.Request.Route,
+		},
+		"extra": map[string]any{
+			"stack":     r.Stack,
+			"client_ip": r.Request.ClientIP,
+			"engine":    r.Engine,
+		},
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
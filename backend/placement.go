@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HistorySourcePlacement = "placement"
+
+	EventUserPlaced = "user.placed"
+)
+
+// PlacementGames is how many matches a new player records before receiving a
+// public rank. Zero disables placement and new users are ranked immediately.
+var PlacementGames = getEnvInt("PLACEMENT_GAMES", 0)
+
+const placementSchema = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS in_placement BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS placement_games INT NOT NULL DEFAULT 0;
+`
+
+type PlacementProgress struct {
+	Required  int  `json:"required"`
+	Completed int  `json:"completed"`
+	Placed    bool `json:"placed"`
+}
+
+type UserPlacedEvent struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	IsBot    bool   `json:"is_bot,omitempty"`
+}
+
+type UserResponse struct {
+	Success    bool               `json:"success"`
+	Username   string             `json:"username"`
+	Rating     *int               `json:"rating"`
+	Rank       *int               `json:"rank"`
+	Percentile *float64           `json:"percentile,omitempty"`
+	TotalUsers int                `json:"total_users,omitempty"`
+	Placement  *PlacementProgress `json:"placement,omitempty"`
+	Record     *UserRecord        `json:"record,omitempty"`
+}
+
+func placementProgress(u *User) *PlacementProgress {
+	if !u.InPlacement {
+		return nil
+	}
+	return &PlacementProgress{
+		Required:  PlacementGames,
+		Completed: u.PlacementGames,
+		Placed:    false,
+	}
+}
+
+// placementRating is the classic performance rating over every match the
+// user has played so far: average opponent rating + 400 * (wins - losses) / n.
+func placementRating(tx *sql.Tx, userID int64) (int, error) {
+	rows, err := tx.Query(`
+		SELECT
+			CASE WHEN player_a_id = $1 THEN player_b_old_rating ELSE player_a_old_rating END,
+			CASE
+				WHEN outcome = 'draw' THEN 0
+				WHEN (outcome = 'a') = (player_a_id = $1) THEN 1
+				ELSE -1
+			END
+		FROM matches
+		WHERE player_a_id = $1 OR player_b_id = $1
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load placement matches: %w", err)
+	}
+	defer rows.Close()
+
+	var n, opponentSum, net int
+	for rows.Next() {
+		var opponent, result int
+		if err := rows.Scan(&opponent, &result); err != nil {
+			return 0, fmt.Errorf("failed to scan placement match: %w", err)
+		}
+		n++
+		opponentSum += opponent
+		net += result
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating placement matches: %w", err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no placement matches recorded for user %d", userID)
+	}
+
+	return clampRating(int(math.Round(float64(opponentSum)/float64(n) + 400*float64(net)/float64(n)))), nil
+}
+
+// advancePlacement counts a match toward a player's placement and, on the
+// last one, assigns their performance rating and queues them for the engine.
+func advancePlacement(tx *sql.Tx, u *User, matchID int64, result *MatchPlayerResult) error {
+	games := u.PlacementGames + 1
+	result.Placement = &PlacementProgress{Required: PlacementGames, Completed: games}
+
+	if games < PlacementGames {
+		if _, err := tx.Exec(`UPDATE users SET placement_games = $1 WHERE id = $2`, games, u.ID); err != nil {
+			return fmt.Errorf("failed to update placement progress: %w", err)
+		}
+		return nil
+	}
+
+	rating, err := placementRating(tx, u.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE users SET rating = $1, placement_games = $2, in_placement = FALSE WHERE id = $3
+	`, rating, games, u.ID); err != nil {
+		return fmt.Errorf("failed to complete placement: %w", err)
+	}
+	if err := insertRatingHistory(tx, u.ID, u.Rating, rating, HistorySourcePlacement, &matchID); err != nil {
+		return err
+	}
+	if err := insertOutboxEvent(tx, EventUserPlaced, UserPlacedEvent{
+		UserID:   u.ID,
+		Username: u.Username,
+		Rating:   rating,
+		IsBot:    u.IsBot,
+	}); err != nil {
+		return err
+	}
+
+	result.NewRating = rating
+	result.Delta = rating - result.OldRating
+	result.Placement.Placed = true
+	return nil
+}
+
+func (h *Handlers) HandleGetUser(c *gin.Context) {
+	if season := c.Query("season"); season != "" {
+		serveSeasonUser(c, season, c.Param("username"))
+		return
+	}
+	standing, err := h.publicService(c).Standing(c.Request.Context(), c.Param("username"))
+	if isBudgetError(err) {
+		writeBudgetTimeout(c)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	record, err := userRecord(c.Request.Context(), standing.Username)
+	if err != nil {
+		requestLog(c).Error("Error fetching record", "username", standing.Username, "error", err)
+	}
+
+	respond(c, http.StatusOK, UserResponse{
+		Success:   true,
+		Username:  standing.Username,
+		Rating:    standing.Rating,
+		Rank:      standing.Rank,
+		Placement: standing.Placement,
+		Record:    record,
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	codecjson "github.com/gin-gonic/gin/codec/json"
+)
+
+// Buffers larger than this are dropped instead of pooled so one oversized
+// response doesn't pin its memory for the life of the process.
+const maxPooledBodyBytes = 64 * 1024
+
+// pageBuffers holds the per-request scratch space for leaderboard and search
+// pages. Everything is reset, not freed, when it goes back to the pool.
+type pageBuffers struct {
+	rows []UserWithRank
+	body bytes.Buffer
+}
+
+var pageBufferPool = sync.Pool{
+	New: func() any {
+		return &pageBuffers{
+			rows: make([]UserWithRank, 0, MaxPageSize),
+		}
+	},
+}
+
+func getPageBuffers() *pageBuffers {
+	return pageBufferPool.Get().(*pageBuffers)
+}
+
+func putPageBuffers(b *pageBuffers) {
+	if b.body.Cap() > maxPooledBodyBytes {
+		return
+	}
+	// Clear the whole backing array, not just the last page, so pooled rows
+	// don't keep old usernames and cursors alive.
+	clear(b.rows[:cap(b.rows)])
+	b.rows = b.rows[:0]
+	b.body.Reset()
+	pageBufferPool.Put(b)
+}
+
+// writeJSON encodes v into the pooled body buffer with gin's JSON codec, which
+// is encoding/json by default and jsoniter, sonic, or go-json when built with
+// -tags=jsoniter, -tags=sonic, or -tags=go_json.
+func (b *pageBuffers) writeJSON(c *gin.Context, status int, v any) {
+	if err := codecjson.API.NewEncoder(&b.body).Encode(v); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+	c.Data(status, "application/json; charset=utf-8", b.body.Bytes())
+}
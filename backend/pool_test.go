@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The page benchmarks build and encode a full leaderboard or search page the
+// way the handlers do, once with pooled buffers and once with fresh ones, so
+// the pool's effect shows up in allocs/op:
+//
+//	go test -run '^$' -bench Page -benchmem
+
+// discardWriter is a ResponseWriter that throws the body away, so the
+// benchmarks measure building the page rather than collecting it.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func benchmarkContext() *gin.Context {
+	gin.SetMode(gin.ReleaseMode)
+	c, _ := gin.CreateTestContext(&discardWriter{header: http.Header{}})
+	c.Request, _ = http.NewRequest(http.MethodGet, "/leaderboard", nil)
+	return c
+}
+
+// benchmarkRows is a page's worth of users, made once so filling a page
+// copies them instead of allocating.
+func benchmarkRows() []UserWithRank {
+	rows := make([]UserWithRank, MaxPageSize)
+	for i := range rows {
+		rows[i] = UserWithRank{
+			Rank:     i + 1,
+			Username: fmt.Sprintf("player_%05d", i),
+			Rating:   4000 - i*7,
+			Tier:     "gold",
+			Cursor:   fmt.Sprintf("c%d.%d", 4000-i*7, i),
+		}
+	}
+	return rows
+}
+
+func pageBuffersFor(pooled bool) *pageBuffers {
+	if pooled {
+		return getPageBuffers()
+	}
+	return pageBufferPool.New().(*pageBuffers)
+}
+
+func releasePageBuffers(b *pageBuffers, pooled bool) {
+	if pooled {
+		putPageBuffers(b)
+	}
+}
+
+func benchmarkLeaderboardPage(b *testing.B, pooled bool) {
+	c := benchmarkContext()
+	source := benchmarkRows()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := pageBuffersFor(pooled)
+		buf.rows = append(buf.rows, source...)
+		resp := LeaderboardResponse{
+			Success:    true,
+			Data:       buf.rows,
+			Count:      len(buf.rows),
+			Page:       1,
+			Limit:      len(buf.rows),
+			HasMore:    true,
+			NextCursor: buf.rows[len(buf.rows)-1].Cursor,
+		}
+		resp.Pagination = newPagination(1, len(buf.rows), true)
+		buf.writeJSON(c, http.StatusOK, resp)
+		releasePageBuffers(buf, pooled)
+	}
+}
+
+func benchmarkSearchPage(b *testing.B, pooled bool) {
+	c := benchmarkContext()
+	source := benchmarkRows()[:DefaultPageSize]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := pageBuffersFor(pooled)
+		buf.rows = append(buf.rows, source...)
+		buf.writeJSON(c, http.StatusOK, SearchResponse{
+			Success:    true,
+			Data:       buf.rows,
+			Count:      len(buf.rows),
+			Page:       1,
+			Limit:      len(buf.rows),
+			Pagination: newPagination(1, len(buf.rows), false),
+		})
+		releasePageBuffers(buf, pooled)
+	}
+}
+
+func BenchmarkLeaderboardPagePooled(b *testing.B)   { benchmarkLeaderboardPage(b, true) }
+func BenchmarkLeaderboardPageUnpooled(b *testing.B) { benchmarkLeaderboardPage(b, false) }
+func BenchmarkSearchPagePooled(b *testing.B)        { benchmarkSearchPage(b, true) }
+func BenchmarkSearchPageUnpooled(b *testing.B)      { benchmarkSearchPage(b, false) }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Rating writes run through writeSlots in two priority classes. Interactive
+// writes (game-server matches, single-user updates) take a free slot first,
+// and background simulation batches only start when no interactive write is
+// waiting. Background work may hold at most WRITE_WORKERS-1 slots, so an
+// interactive write never queues behind a full pool of simulation batches.
+const (
+	WriteInteractive = "interactive"
+	WriteBackground  = "background"
+)
+
+type writeClass struct {
+	waiting   int
+	inFlight  int
+	completed int64
+	waited    time.Duration
+}
+
+type WriteClassStats struct {
+	QueueDepth int   `json:"queue_depth"`
+	InFlight   int   `json:"in_flight"`
+	Completed  int64 `json:"completed"`
+	AvgWaitMs  int64 `json:"avg_wait_ms"`
+}
+
+type writeScheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	workers int
+	classes map[string]*writeClass
+}
+
+var writeSlots = newWriteScheduler(getEnvInt("WRITE_WORKERS", 8))
+
+func newWriteScheduler(workers int) *writeScheduler {
+	s := &writeScheduler{
+		workers: max(workers, 1),
+		classes: map[string]*writeClass{
+			WriteInteractive: {},
+			WriteBackground:  {},
+		},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *writeScheduler) inFlightLocked() int {
+	return s.classes[WriteInteractive].inFlight + s.classes[WriteBackground].inFlight
+}
+
+func (s *writeScheduler) canStartLocked(class string) bool {
+	if s.inFlightLocked() >= s.workers {
+		return false
+	}
+	if class == WriteInteractive {
+		return true
+	}
+	background := s.classes[WriteBackground].inFlight
+	return s.classes[WriteInteractive].waiting == 0 && (s.workers == 1 || background < s.workers-1)
+}
+
+// acquire blocks until a write of class may run and returns the func that
+// releases its slot.
+func (s *writeScheduler) acquire(class string) func() {
+	start := time.Now()
+
+	s.mu.Lock()
+	c := s.classes[class]
+	c.waiting++
+	for !s.canStartLocked(class) {
+		s.cond.Wait()
+	}
+	c.waiting--
+	c.inFlight++
+	c.waited += time.Since(start)
+	s.mu.Unlock()
+	if class == WriteInteractive {
+		// Background writes held back for this one may be able to start.
+		s.cond.Broadcast()
+	}
+
+	return func() {
+		s.mu.Lock()
+		c.inFlight--
+		c.completed++
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+func (s *writeScheduler) Stats() map[string]WriteClassStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]WriteClassStats, len(s.classes))
+	for name, c := range s.classes {
+		var avg int64
+		if started := c.completed + int64(c.inFlight); started > 0 {
+			avg = (c.waited / time.Duration(started)).Milliseconds()
+		}
+		stats[name] = WriteClassStats{
+			QueueDepth: c.waiting,
+			InFlight:   c.inFlight,
+			Completed:  c.completed,
+			AvgWaitMs:  avg,
+		}
+	}
+	return stats
+}
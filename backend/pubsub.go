@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Pub/Sub sink publishes to PUBSUB_TOPIC (projects/<project>/topics/<topic>)
+// using the CloudEvents Pub/Sub binding: the message data is the event
+// payload and the CloudEvents attributes travel as ce-* message attributes.
+// Access tokens come from GOOGLE_APPLICATION_CREDENTIALS (a service account
+// key) or, on GCP, from the metadata server for the workload's service
+// account. With PUBSUB_EMULATOR_HOST set, requests go to the emulator
+// unauthenticated.
+const (
+	pubSubMaxBatch = 100
+	pubSubScope    = "https://www.googleapis.com/auth/pubsub"
+)
+
+type pubSubSink struct {
+	topic    string
+	endpoint string
+	client   *http.Client
+	tokens   *gcpTokenSource
+}
+
+func newPubSubSink() (*pubSubSink, error) {
+	topic := getEnv("PUBSUB_TOPIC", "")
+	if topic == "" {
+		return nil, nil
+	}
+	if parts := strings.Split(topic, "/"); len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return nil, fmt.Errorf("PUBSUB_TOPIC must look like projects/<project>/topics/<topic>")
+	}
+	client := &http.Client{Timeout: eventSinkTimeout}
+	s := &pubSubSink{topic: topic, endpoint: "https://pubsub.googleapis.com", client: client}
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		s.endpoint = "http://" + host
+		return s, nil
+	}
+	s.tokens = &gcpTokenSource{client: client}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		key, err := loadServiceAccountKey(path)
+		if err != nil {
+			return nil, err
+		}
+		s.tokens.key = key
+	}
+	return s, nil
+}
+
+func (s *pubSubSink) Name() string  { return "pubsub:" + s.topic }
+func (s *pubSubSink) MaxBatch() int { return pubSubMaxBatch }
+
+type pubSubMessage struct {
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func (s *pubSubSink) Publish(ctx context.Context, events []CloudEvent) ([]CloudEvent, error) {
+	messages := make([]pubSubMessage, len(events))
+	for i, ev := range events {
+		attrs := map[string]string{
+			"ce-specversion": ev.SpecVersion,
+			"ce-id":          ev.ID,
+			"ce-source":      ev.Source,
+			"ce-type":        ev.Type,
+			"ce-time":        ev.Time.Format(time.RFC3339Nano),
+			"ce-dataschema":  ev.DataSchema,
+			"content-type":   ev.DataContentType,
+		}
+		if ev.Subject != "" {
+			attrs["ce-subject"] = ev.Subject
+		}
+		messages[i] = pubSubMessage{Data: base64.StdEncoding.EncodeToString(ev.Data), Attributes: attrs}
+	}
+	body, err := json.Marshal(map[string]any{"messages": messages})
+	if err != nil {
+		return events, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/v1/"+s.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return events, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.tokens != nil {
+		token, err := s.tokens.get(ctx)
+		if err != nil {
+			return events, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return events, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		return events, sinkError("Pub/Sub", resp.StatusCode, respBody)
+	}
+	return nil, nil
+}
+
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	rsaKey *rsa.PrivateKey
+}
+
+func loadServiceAccountKey(path string) (*serviceAccountKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, errors.New("GOOGLE_APPLICATION_CREDENTIALS is not a service account key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("service account private_key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account private_key: %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account private_key is not an RSA key")
+	}
+	key.rsaKey = rsaKey
+	return &key, nil
+}
+
+// gcpTokenSource caches an OAuth access token until shortly before it
+// expires.
+type gcpTokenSource struct {
+	client *http.Client
+	key    *serviceAccountKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (ts *gcpTokenSource) get(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Until(ts.expires) > time.Minute {
+		return ts.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if ts.key != nil {
+		req, err = ts.key.tokenRequest(ctx, time.Now())
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+			"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GCP access token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return "", sinkError("GCP token endpoint", resp.StatusCode, body)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.AccessToken == "" {
+		return "", errors.New("GCP token response has no access_token")
+	}
+	ts.token = tok.AccessToken
+	ts.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// tokenRequest builds the JWT bearer grant for a service account key.
+func (k *serviceAccountKey) tokenRequest(ctx context.Context, now time.Time) (*http.Request, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   k.ClientEmail,
+		"scope": pubSubScope,
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, k.rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	QuarantinePending  = "pending"
+	QuarantineApproved = "approved"
+	QuarantineRejected = "rejected"
+)
+
+const quarantineSchema = `
+	CREATE TABLE IF NOT EXISTS score_quarantine (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		metric TEXT NOT NULL,
+		value INT NOT NULL,
+		previous INT,
+		validator TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		submitted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		reviewed_at TIMESTAMPTZ,
+		reviewed_by TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_score_quarantine_status ON score_quarantine(status, id);
+
+	ALTER TABLE score_quarantine ADD COLUMN IF NOT EXISTS match JSONB;
+`
+
+type QuarantinedSubmission struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Metric      string     `json:"metric"`
+	Value       int        `json:"value"`
+	Previous    *int       `json:"previous"`
+	Validator   string     `json:"validator"`
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+
+	// Match is set when the submission is one player's rating from a match.
+	// The whole match is held, and approving it records the match.
+	Match *QuarantinedMatch `json:"match,omitempty"`
+}
+
+// QuarantinedMatch is the request of a held match: a two-player match or a
+// team match.
+type QuarantinedMatch struct {
+	Match     *MatchRequest     `json:"match,omitempty"`
+	TeamMatch *TeamMatchRequest `json:"team_match,omitempty"`
+}
+
+var errQuarantineNotPending = errors.New("submission is not pending review")
+
+// flaggedSubmissionError is returned from inside a match transaction when a
+// validator flags one player's new rating, so nothing of the match commits.
+type flaggedSubmissionError struct {
+	UserID     int64
+	Submission Submission
+	Validator  string
+	Reason     string
+}
+
+func (e *flaggedSubmissionError) Error() string {
+	return fmt.Sprintf("submission for %s flagged by %s: %s", e.Submission.Username, e.Validator, e.Reason)
+}
+
+// ratingSubmissions describes proposed rating changes as submissions.
+func ratingSubmissions(updates []RatingUpdatedEvent) []Submission {
+	subs := make([]Submission, len(updates))
+	for i, u := range updates {
+		current := u.OldRating
+		subs[i] = Submission{Username: u.Username, Metric: MetricRating, Mode: SubmitLast, Value: u.NewRating, Current: &current}
+	}
+	return subs
+}
+
+// screenRatingUpdates runs the validators over each proposed rating and
+// returns a flaggedSubmissionError for the first one flagged.
+func screenRatingUpdates(updates []RatingUpdatedEvent) error {
+	for i, s := range ratingSubmissions(updates) {
+		if validator, reason := validateSubmission(s); validator != "" {
+			return &flaggedSubmissionError{UserID: updates[i].UserID, Submission: s, Validator: validator, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// quarantineSubmission stores a flagged submission for review.
+func quarantineSubmission(userID int64, s Submission, validator, reason string, match *QuarantinedMatch) (int64, error) {
+	var held any
+	if match != nil {
+		data, err := json.Marshal(match)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode quarantined match: %w", err)
+		}
+		held = string(data)
+	}
+
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO score_quarantine (user_id, metric, value, previous, validator, reason, match)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb)
+		RETURNING id
+	`, userID, s.Metric, s.Value, s.Current, validator, reason, held).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to quarantine submission: %w", err)
+	}
+	quarantinedCount.Add(1)
+	return id, nil
+}
+
+// screenSubmission runs the validators and, when one flags the submission,
+// parks it in quarantine and writes the 202 response. It reports whether the
+// caller should stop.
+func screenSubmission(c *gin.Context, userID int64, s Submission) bool {
+	validator, reason := validateSubmission(s)
+	if validator == "" {
+		return false
+	}
+	respondQuarantined(c, &flaggedSubmissionError{UserID: userID, Submission: s, Validator: validator, Reason: reason}, nil)
+	return true
+}
+
+// respondQuarantined quarantines a flagged submission, with the match it
+// came from if any, and writes the 202 response.
+func respondQuarantined(c *gin.Context, flagged *flaggedSubmissionError, match *QuarantinedMatch) {
+	s := flagged.Submission
+	id, err := quarantineSubmission(flagged.UserID, s, flagged.Validator, flagged.Reason, match)
+	if err != nil {
+		requestLog(c).Error("Error quarantining submission", "metric", s.Metric, "username", s.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to record submission")
+		return
+	}
+	requestLog(c).Warn("Quarantined submission", "metric", s.Metric, "id", id, "username", s.Username, "validator", flagged.Validator, "reason", flagged.Reason)
+
+	respond(c, http.StatusAccepted, gin.H{
+		"quarantined":   true,
+		"quarantine_id": id,
+		"reason":        flagged.Reason,
+	})
+}
+
+// decideQuarantine moves a pending submission to status and returns it.
+func decideQuarantine(id int64, status, reviewer string) (*QuarantinedSubmission, error) {
+	q := QuarantinedSubmission{ID: id}
+	var previous sql.NullInt64
+	var reviewedAt sql.NullTime
+	var match []byte
+	err := db.QueryRow(`
+		UPDATE score_quarantine q
+		SET status = $2, reviewed_at = NOW(), reviewed_by = NULLIF($3, '')
+		FROM users u
+		WHERE q.id = $1 AND q.status = 'pending' AND u.id = q.user_id
+		RETURNING u.username, q.metric, q.value, q.previous, q.validator, q.reason, q.status, q.submitted_at, q.reviewed_at, q.match
+	`, id, status, reviewer).Scan(&q.Username, &q.Metric, &q.Value, &previous, &q.Validator, &q.Reason, &q.Status, &q.SubmittedAt, &reviewedAt, &match)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errQuarantineNotPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update quarantined submission: %w", err)
+	}
+	if previous.Valid {
+		p := int(previous.Int64)
+		q.Previous = &p
+	}
+	if reviewedAt.Valid {
+		q.ReviewedAt = &reviewedAt.Time
+	}
+	if match != nil {
+		if err := json.Unmarshal(match, &q.Match); err != nil {
+			return nil, fmt.Errorf("failed to decode quarantined match: %w", err)
+		}
+	}
+	q.ReviewedBy = reviewer
+	return &q, nil
+}
+
+// applyQuarantined writes an approved submission through the normal write
+// path, skipping the validators. A held match is recorded against the
+// players' current ratings. Ratings go through the outbox, so the engine
+// moves from the rating the write actually replaced.
+func applyQuarantined(q *QuarantinedSubmission) error {
+	if m := q.Match; m != nil {
+		switch {
+		case m.Match != nil:
+			req := *m.Match
+			req.approved = true
+			scoreA, _ := outcomeScore(req.Outcome)
+			if _, _, err := recordMatch(req, scoreA); err != nil {
+				return err
+			}
+		case m.TeamMatch != nil:
+			req := *m.TeamMatch
+			req.approved = true
+			scoreA, _ := outcomeScore(req.Outcome)
+			if _, _, err := recordTeamMatch(req, scoreA); err != nil {
+				return err
+			}
+		default:
+			return errors.New("quarantined match has no request")
+		}
+		outboxRelay.Flush()
+		return nil
+	}
+
+	release := writeSlots.acquire(WriteInteractive)
+	defer release()
+
+	if q.Metric == MetricRating {
+		_, changed, err := setUserRating(q.Username, q.Value, HistorySourceQuarantine)
+		if err != nil {
+			return err
+		}
+		if changed {
+			outboxRelay.Flush()
+		}
+		return nil
+	}
+
+	def, ok := metricDefs[q.Metric]
+	if !ok || def.Formula != "" {
+		return fmt.Errorf("metric %s is no longer configured", q.Metric)
+	}
+	_, changed, err := submitUserMetric(q.Username, def, q.Value)
+	if err != nil {
+		return err
+	}
+	if changed {
+		outboxRelay.Flush()
+	}
+	return nil
+}
+
+func HandleListQuarantine(c *gin.Context) {
+	status := c.DefaultQuery("status", QuarantinePending)
+	limit := min(max(parseIntParam(c.Query("limit"), 100), 1), 1000)
+	offset := max(parseIntParam(c.Query("offset"), 0), 0)
+
+	rows, err := db.Query(`
+		SELECT q.id, u.username, q.metric, q.value, q.previous, q.validator, q.reason, q.status,
+			q.submitted_at, q.reviewed_at, COALESCE(q.reviewed_by, '')
+		FROM score_quarantine q
+		JOIN users u ON u.id = q.user_id
+		WHERE q.status = $1
+		ORDER BY q.id
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		requestLog(c).Error("Error listing quarantined submissions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list quarantined submissions")
+		return
+	}
+	defer rows.Close()
+
+	submissions := []QuarantinedSubmission{}
+	for rows.Next() {
+		var q QuarantinedSubmission
+		var previous sql.NullInt64
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&q.ID, &q.Username, &q.Metric, &q.Value, &previous, &q.Validator, &q.Reason, &q.Status,
+			&q.SubmittedAt, &reviewedAt, &q.ReviewedBy); err != nil {
+			requestLog(c).Error("Error scanning quarantined submission", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list quarantined submissions")
+			return
+		}
+		if previous.Valid {
+			p := int(previous.Int64)
+			q.Previous = &p
+		}
+		if reviewedAt.Valid {
+			q.ReviewedAt = &reviewedAt.Time
+		}
+		submissions = append(submissions, q)
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"submissions": submissions,
+		"count":       len(submissions),
+	})
+}
+
+func HandleApproveQuarantine(c *gin.Context) {
+	q, ok := decideQuarantineParam(c, QuarantineApproved)
+	if !ok {
+		return
+	}
+	if err := applyQuarantined(q); err != nil {
+		// Put it back so it can be retried or rejected.
+		if _, rerr := db.Exec(`
+			UPDATE score_quarantine SET status = 'pending', reviewed_at = NULL, reviewed_by = NULL WHERE id = $1
+		`, q.ID); rerr != nil {
+			requestLog(c).Error("Error reopening quarantined submission", "id", q.ID, "error", rerr)
+		}
+		requestLog(c).Error("Error applying quarantined submission", "id", q.ID, "error", err)
+		respondError(c, http.StatusConflict, fmt.Sprintf("Failed to apply submission: %v", err))
+		return
+	}
+
+	requestLog(c).Info("✓ Approved quarantined submission", "metric", q.Metric, "id", q.ID, "username", q.Username)
+	respond(c, http.StatusOK, gin.H{
+		"submission": q,
+	})
+}
+
+func HandleRejectQuarantine(c *gin.Context) {
+	q, ok := decideQuarantineParam(c, QuarantineRejected)
+	if !ok {
+		return
+	}
+	requestLog(c).Info("✓ Rejected quarantined submission", "metric", q.Metric, "id", q.ID, "username", q.Username)
+	respond(c, http.StatusOK, gin.H{
+		"submission": q,
+	})
+}
+
+// decideQuarantineParam decides the submission named by the :id path
+// parameter, writing the error response itself when that fails.
+func decideQuarantineParam(c *gin.Context, status string) (*QuarantinedSubmission, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "Quarantined submission not found")
+		return nil, false
+	}
+
+	q, err := decideQuarantine(id, status, c.GetString("admin"))
+	if errors.Is(err, errQuarantineNotPending) {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("No pending submission %d", id))
+		return nil, false
+	}
+	if err != nil {
+		requestLog(c).Error("Error deciding quarantined submission", "id", id, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update submission")
+		return nil, false
+	}
+	return q, true
+}
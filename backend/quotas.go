@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Quotas cap what one API key (one tenant) can use: how many users it may
+// create, how many rating writes per second it may send, and how many webhook
+// subscriptions it may own. API_KEY_MAX_USERS, API_KEY_MAX_UPDATES_PER_SEC and
+// API_KEY_MAX_WEBHOOKS are the defaults, 0 meaning unlimited, and each key can
+// override them. Callers without an API key (bearer tokens, or writes left
+// open) aren't tenants and have no quota.
+const quotaSchema = `
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS max_users INT;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS max_updates_per_sec INT;
+	ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS max_webhooks INT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS api_key TEXT;
+	CREATE INDEX IF NOT EXISTS idx_users_api_key ON users(api_key) WHERE api_key IS NOT NULL;
+	ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS api_key TEXT;
+`
+
+var (
+	defaultMaxUsers         = getEnvInt("API_KEY_MAX_USERS", 0)
+	defaultMaxUpdatesPerSec = getEnvInt("API_KEY_MAX_UPDATES_PER_SEC", 0)
+	defaultMaxWebhooks      = getEnvInt("API_KEY_MAX_WEBHOOKS", 0)
+
+	errUnknownQuotaKey = errors.New("no such API key")
+
+	updateLimiter = &quotaLimiter{buckets: map[string]*quotaBucket{}}
+	keyQuotas     = &quotaCache{quotas: map[string]apiKeyQuota{}}
+)
+
+// QuotaLimits holds a key's own overrides; nil means the default applies.
+type QuotaLimits struct {
+	MaxUsers         *int `json:"max_users"`
+	MaxUpdatesPerSec *int `json:"max_updates_per_sec"`
+	MaxWebhooks      *int `json:"max_webhooks"`
+}
+
+// apiKeyQuota is the effective limits of one key.
+type apiKeyQuota struct {
+	Name             string `json:"api_key"`
+	MaxUsers         int    `json:"max_users"`
+	MaxUpdatesPerSec int    `json:"max_updates_per_sec"`
+	MaxWebhooks      int    `json:"max_webhooks"`
+}
+
+func (l QuotaLimits) effective(name string) apiKeyQuota {
+	q := apiKeyQuota{Name: name, MaxUsers: defaultMaxUsers, MaxUpdatesPerSec: defaultMaxUpdatesPerSec, MaxWebhooks: defaultMaxWebhooks}
+	if l.MaxUsers != nil {
+		q.MaxUsers = *l.MaxUsers
+	}
+	if l.MaxUpdatesPerSec != nil {
+		q.MaxUpdatesPerSec = *l.MaxUpdatesPerSec
+	}
+	if l.MaxWebhooks != nil {
+		q.MaxWebhooks = *l.MaxWebhooks
+	}
+	return q
+}
+
+// quotaExceededError refuses a write that would take a key past one of its
+// limits. RetryAfter is set for the rate limit, which clears by itself.
+type quotaExceededError struct {
+	Quota      string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("API key quota exceeded: %s is %d", e.Quota, e.Limit)
+}
+
+// respondQuotaExceeded answers 403 for a count limit, and 429 with
+// Retry-After for the rate limit.
+func respondQuotaExceeded(c *gin.Context, err *quotaExceededError) {
+	status := http.StatusForbidden
+	if err.RetryAfter > 0 {
+		status = http.StatusTooManyRequests
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
+	respondErrorWith(c, status, err.Error(), gin.H{
+		"quota": err.Quota,
+		"limit": err.Limit,
+	})
+}
+
+// loadQuota reads the effective limits of an active key.
+func loadQuota(q rowQueryer, name string) (apiKeyQuota, error) {
+	var l QuotaLimits
+	err := q.QueryRow(`
+		SELECT max_users, max_updates_per_sec, max_webhooks FROM api_keys WHERE name = $1 AND revoked_at IS NULL
+	`, name).Scan(&l.MaxUsers, &l.MaxUpdatesPerSec, &l.MaxWebhooks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiKeyQuota{}, errUnknownQuotaKey
+	}
+	if err != nil {
+		return apiKeyQuota{}, fmt.Errorf("failed to load API key quota: %w", err)
+	}
+	return l.effective(name), nil
+}
+
+// claimQuota locks the key's quota for the rest of tx and fails if the key
+// already owns limit rows counted by countQuery. Concurrent creations for the
+// same key wait on the lock, so they can't both squeeze under the limit.
+func claimQuota(tx *sql.Tx, key, quota string, limit, adding int, countQuery string) error {
+	if limit <= 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, "quota:"+quota+":"+key); err != nil {
+		return fmt.Errorf("failed to lock %s quota: %w", quota, err)
+	}
+	var owned int
+	if err := tx.QueryRow(countQuery, key).Scan(&owned); err != nil {
+		return fmt.Errorf("failed to count %s: %w", quota, err)
+	}
+	if owned+adding > limit {
+		return &quotaExceededError{Quota: quota, Limit: limit}
+	}
+	return nil
+}
+
+// quotaCache keeps each key's effective limits, so rate-limited writes don't
+// read api_keys on every request. Changing a key's quota or revoking it drops
+// the key's entry.
+type quotaCache struct {
+	mu     sync.Mutex
+	quotas map[string]apiKeyQuota
+}
+
+func (qc *quotaCache) get(name string) (apiKeyQuota, error) {
+	qc.mu.Lock()
+	q, ok := qc.quotas[name]
+	qc.mu.Unlock()
+	if ok {
+		return q, nil
+	}
+	q, err := loadQuota(db, name)
+	if err != nil {
+		return q, err
+	}
+	qc.mu.Lock()
+	qc.quotas[name] = q
+	qc.mu.Unlock()
+	return q, nil
+}
+
+func (qc *quotaCache) forget(name string) {
+	qc.mu.Lock()
+	delete(qc.quotas, name)
+	qc.mu.Unlock()
+}
+
+// quotaLimiter is a token bucket per key, refilled at the key's
+// max_updates_per_sec with a burst of one second's worth. Each update a write
+// applies costs a token; a batch may take the bucket below zero, and the key
+// then waits until it has paid that back.
+type quotaLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*quotaBucket
+}
+
+type quotaBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill returns the key's bucket topped up to now. l.mu must be held.
+func (l *quotaLimiter) refill(key string, rate int, now time.Time) *quotaBucket {
+	b := l.buckets[key]
+	if b == nil {
+		b = &quotaBucket{tokens: float64(rate), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rate), b.tokens+now.Sub(b.last).Seconds()*float64(rate))
+	b.last = now
+	return b
+}
+
+// take spends one token, or reports how long until one is available.
+func (l *quotaLimiter) take(key string, rate int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, rate, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / float64(rate) * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// charge spends n more tokens, going below zero if it must. A negative n
+// gives tokens back.
+func (l *quotaLimiter) charge(key string, rate, n int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, rate, now)
+	b.tokens = math.Min(float64(rate), b.tokens-float64(n))
+}
+
+// updateQuotaMiddleware applies max_updates_per_sec to rating writes. It runs
+// after apiKeyMiddleware, which names the key, and spends one token up front;
+// the handler settles the rest with chargeUpdateQuota once it knows how many
+// updates it applied.
+func updateQuotaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetString("api_key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		quota, err := keyQuotas.get(key)
+		if err != nil {
+			requestLog(c).Error("Error loading API key quota", "api_key", key, "error", err)
+			abortWithError(c, http.StatusInternalServerError, "Failed to check API key quota")
+			return
+		}
+		if quota.MaxUpdatesPerSec <= 0 {
+			c.Next()
+			return
+		}
+		if ok, wait := updateLimiter.take(key, quota.MaxUpdatesPerSec, time.Now()); !ok {
+			respondQuotaExceeded(c, &quotaExceededError{Quota: "max_updates_per_sec", Limit: quota.MaxUpdatesPerSec, RetryAfter: wait})
+			c.Abort()
+			return
+		}
+		c.Set("update_quota", quota.MaxUpdatesPerSec)
+		c.Next()
+	}
+}
+
+// chargeUpdateQuota bills the caller's key for the n rating updates a write
+// applied, on top of the token updateQuotaMiddleware spent.
+func chargeUpdateQuota(c *gin.Context, n int) {
+	if rate := c.GetInt("update_quota"); rate > 0 {
+		updateLimiter.charge(c.GetString("api_key"), rate, n-1, time.Now())
+	}
+}
+
+// QuotaUsage is one key's limits next to what it currently uses.
+type QuotaUsage struct {
+	apiKeyQuota
+	Overrides QuotaLimits `json:"overrides"`
+	Users     int         `json:"users"`
+	Webhooks  int         `json:"webhooks"`
+}
+
+// HandleListQuotas serves GET /admin/quotas: every active key's effective
+// limits, its overrides, and the users and webhooks it owns.
+func HandleListQuotas(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT k.name, k.max_users, k.max_updates_per_sec, k.max_webhooks,
+			(SELECT COUNT(*) FROM users u WHERE u.api_key = k.name),
+			(SELECT COUNT(*) FROM webhook_subscriptions w WHERE w.api_key = k.name)
+		FROM api_keys k
+		WHERE k.revoked_at IS NULL
+		ORDER BY k.id
+	`)
+	if err != nil {
+		requestLog(c).Error("Error listing quotas", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list quotas")
+		return
+	}
+	defer rows.Close()
+
+	quotas := []QuotaUsage{}
+	for rows.Next() {
+		var u QuotaUsage
+		var name string
+		if err := rows.Scan(&name, &u.Overrides.MaxUsers, &u.Overrides.MaxUpdatesPerSec, &u.Overrides.MaxWebhooks, &u.Users, &u.Webhooks); err != nil {
+			requestLog(c).Error("Error scanning quota", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list quotas")
+			return
+		}
+		u.apiKeyQuota = u.Overrides.effective(name)
+		quotas = append(quotas, u)
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating quotas", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list quotas")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"quotas": quotas,
+	})
+}
+
+// HandleSetQuota serves PUT /admin/api-keys/:name/quota. Each limit is set
+// to the given value (0 for unlimited) or, when null or left out, back to its
+// default.
+func HandleSetQuota(c *gin.Context) {
+	var req QuotaLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	for _, v := range []*int{req.MaxUsers, req.MaxUpdatesPerSec, req.MaxWebhooks} {
+		if v != nil && *v < 0 {
+			respondError(c, http.StatusBadRequest, "Quota limits must be 0 (unlimited) or more")
+			return
+		}
+	}
+
+	name := c.Param("name")
+	res, err := db.Exec(`
+		UPDATE api_keys SET max_users = $2, max_updates_per_sec = $3, max_webhooks = $4
+		WHERE name = $1 AND revoked_at IS NULL
+	`, name, req.MaxUsers, req.MaxUpdatesPerSec, req.MaxWebhooks)
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		requestLog(c).Error("Error setting quota", "name", name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to set quota")
+		return
+	}
+	if n == 0 {
+		respondError(c, http.StatusNotFound, "API key not found or revoked")
+		return
+	}
+	keyQuotas.forget(name)
+	requestLog(c).Info("✓ API key quota set", "name", name, "by", c.GetString("api_key"))
+
+	respond(c, http.StatusOK, gin.H{
+		"quota": req.effective(name),
+	})
+}
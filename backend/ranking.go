@@ -1,25 +1,25 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 
-const (
-
-	MinRating = 100
-
-	MaxRating = 5000
-
-
-	RatingBucketSize = MaxRating + 1
+// Rating bounds default to 100-5000 and can be changed with RATING_MIN and
+// RATING_MAX; the users CHECK constraint is migrated to match at startup.
+var (
+	MinRating = getEnvInt("RATING_MIN", 100)
+	MaxRating = getEnvInt("RATING_MAX", 5000)
 )
 
 type RankingEngine struct {
 
 
-	ratingCount [RatingBucketSize]int
+	ratingCount []int
 
 
 
@@ -27,34 +27,137 @@ type RankingEngine struct {
 
 
 	totalUsers int
+
+	engineVersion
 }
 
-var rankingEngine *RankingEngine
+// engineSeq numbers engine mutations across every engine instance, so an
+// engine built to replace another continues past its version. It starts at
+// the startup time in microseconds, so versions keep growing across restarts.
+var engineSeq = func() *atomic.Int64 {
+	var seq atomic.Int64
+	seq.Store(time.Now().UnixMicro())
+	return &seq
+}()
+
+// engineVersion is embedded by the engines: bump after every change, and
+// Version reports the sequence number of the latest one.
+type engineVersion struct {
+	version atomic.Int64
+}
 
-func InitRankingEngine() error {
-	rankingEngine = &RankingEngine{}
+func (v *engineVersion) bump() {
+	v.version.Store(engineSeq.Add(1))
+}
+
+func (v *engineVersion) Version() int64 {
+	return v.version.Load()
+}
+
+// RankEngine answers rank queries from rating counts. RankingEngine is the
+// default bucket implementation; FenwickEngine, SQLEngine, ApproxEngine, and
+// ShadowEngine are selected with RANK_ENGINE and SHADOW_ENGINE.
+type RankEngine interface {
+	GetRank(rating int) int
+	GetRankBatch(ratings []int) []int
+	FillRanks(rows []UserWithRank)
+	UpdateRating(oldRating, newRating int)
+	BatchUpdateRatings(updates []RatingUpdate)
+	AddUser(rating int)
+	RemoveUser(rating int)
+	GetStats() (totalUsers int, uniqueRatings int, minRatingWithUsers int, maxRatingWithUsers int)
+	// Version grows whenever the engine changes.
+	Version() int64
+}
+
+const (
+	EngineBucket  = "bucket"
+	EngineFenwick = "fenwick"
+	EngineSQL     = "sql"
+	EngineApprox  = "approx"
+)
+
+// rankingEngine holds an engineRef so RebuildRankingEngine can swap in an
+// engine of a different concrete type.
+var rankingEngine atomic.Value
+
+type engineRef struct{ RankEngine }
 
+func InitRankingEngine() error {
+	counts, fromCheckpoint, err := loadCheckpointCounts()
+	if err != nil {
+		slog.Warn("Engine checkpoint unusable, loading the engine from the users table", "error", err)
+	}
+	if !fromCheckpoint {
+		counts, err = GetRatingCounts()
+		if err != nil {
+			return err
+		}
+	}
 
+	if err := loadBots(db); err != nil {
+		return err
+	}
 
-	counts, err := GetRatingCounts()
+	kind := getEnv("RANK_ENGINE", EngineBucket)
+	engine, err := buildRankingEngine(kind, counts)
 	if err != nil {
 		return err
 	}
+	if shadow, ok := engine.(*ShadowEngine); ok {
+		slog.Info("✓ Shadow mode enabled, comparing every read", "engine", kind, "shadow", shadow.shadowName)
+	}
+	rankingEngine.Store(engineRef{engine})
 
+	totalUsers, _, _, _ := engine.GetStats()
+	slog.Info("✓ Ranking engine initialized", "engine", kind, "total_users", totalUsers, "unique_ratings", len(counts))
 
-	totalUsers := 0
-	for rating, count := range counts {
-		if rating >= MinRating && rating <= MaxRating {
-			rankingEngine.ratingCount[rating] = count
-			totalUsers += count
+	return nil
+}
+
+// buildRankingEngine creates the primary engine, wrapped in a ShadowEngine
+// when SHADOW_ENGINE is set.
+func buildRankingEngine(kind string, counts map[int]int) (RankEngine, error) {
+	engine, err := newRankEngine(kind, counts)
+	if err != nil {
+		return nil, err
+	}
+
+	if shadowKind := getEnv("SHADOW_ENGINE", ""); shadowKind != "" {
+		shadow, err := newRankEngine(shadowKind, counts)
+		if err != nil {
+			return nil, err
 		}
+		engine = NewShadowEngine(kind, engine, shadowKind, shadow)
 	}
-	rankingEngine.totalUsers = totalUsers
+	return engine, nil
+}
 
-	log.Printf("✓ Ranking engine initialized with %d users across %d unique ratings",
-		totalUsers, len(counts))
+func newRankEngine(kind string, counts map[int]int) (RankEngine, error) {
+	switch kind {
+	case EngineBucket:
+		return NewRankingEngine(counts), nil
+	case EngineFenwick:
+		return NewFenwickEngine(counts), nil
+	case EngineSQL:
+		return NewSQLEngine(), nil
+	case EngineApprox:
+		return NewApproxEngine(counts), nil
+	default:
+		return nil, fmt.Errorf("unknown rank engine %q", kind)
+	}
+}
 
-	return nil
+func NewRankingEngine(counts map[int]int) *RankingEngine {
+	re := &RankingEngine{ratingCount: make([]int, MaxRating+1)}
+	re.bump()
+	for rating, count := range counts {
+		if rating >= MinRating && rating <= MaxRating {
+			re.ratingCount[rating] = count
+			re.totalUsers += count
+		}
+	}
+	return re
 }
 
 func (re *RankingEngine) GetRank(rating int) int {
@@ -75,7 +178,7 @@ func (re *RankingEngine) GetRankBatch(ratings []int) []int {
 
 
 
-	cumulativeAbove := make([]int, RatingBucketSize)
+	cumulativeAbove := make([]int, MaxRating+1)
 
 
 	sum := 0
@@ -98,6 +201,31 @@ func (re *RankingEngine) GetRankBatch(ratings []int) []int {
 	return ranks
 }
 
+// FillRanks sets each row's Rank from its Rating in place, without the
+// cumulative array GetRankBatch allocates. Rows ordered by rating descending,
+// as leaderboard and search pages are, cost one walk down the buckets; an
+// out-of-order row restarts the walk from the top.
+func (re *RankingEngine) FillRanks(rows []UserWithRank) {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+
+	above, r := 0, MaxRating
+	for i := range rows {
+		rating := rows[i].Rating
+		if rating < MinRating || rating > MaxRating {
+			rows[i].Rank = -1
+			continue
+		}
+		if rating > r {
+			above, r = 0, MaxRating
+		}
+		for ; r > rating; r-- {
+			above += re.ratingCount[r]
+		}
+		rows[i].Rank = 1 + above
+	}
+}
+
 func (re *RankingEngine) UpdateRating(oldRating, newRating int) {
 
 	if oldRating == newRating {
@@ -106,6 +234,7 @@ func (re *RankingEngine) UpdateRating(oldRating, newRating int) {
 
 	re.mu.Lock()
 	defer re.mu.Unlock()
+	re.bump()
 
 
 	if oldRating >= MinRating && oldRating <= MaxRating {
@@ -120,9 +249,38 @@ func (re *RankingEngine) UpdateRating(oldRating, newRating int) {
 	}
 }
 
+func (re *RankingEngine) AddUser(rating int) {
+	if rating < MinRating || rating > MaxRating {
+		return
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.bump()
+
+	re.ratingCount[rating]++
+	re.totalUsers++
+}
+
+func (re *RankingEngine) RemoveUser(rating int) {
+	if rating < MinRating || rating > MaxRating {
+		return
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.bump()
+
+	if re.ratingCount[rating] > 0 {
+		re.ratingCount[rating]--
+		re.totalUsers--
+	}
+}
+
 func (re *RankingEngine) BatchUpdateRatings(updates []RatingUpdate) {
 	re.mu.Lock()
 	defer re.mu.Unlock()
+	re.bump()
 
 	for _, update := range updates {
 	
@@ -165,6 +323,12 @@ func (re *RankingEngine) GetStats() (totalUsers int, uniqueRatings int, minRatin
 	return
 }
 
-func GetRankingEngine() *RankingEngine {
-	return rankingEngine
+// rankingEngineLoaded reports whether InitRankingEngine has run.
+func rankingEngineLoaded() bool {
+	_, ok := rankingEngine.Load().(engineRef)
+	return ok
+}
+
+func GetRankingEngine() RankEngine {
+	return rankingEngine.Load().(engineRef).RankEngine
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// A user's win/loss/draw record used to be counted from the matches table on
+// every read. The user_record change denormalizes it onto users; until its
+// backfill completes the counts still come from matches.
+const recordsSchema = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS wins INT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS losses INT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS draws INT;
+	ALTER TABLE users ALTER COLUMN wins SET DEFAULT 0;
+	ALTER TABLE users ALTER COLUMN losses SET DEFAULT 0;
+	ALTER TABLE users ALTER COLUMN draws SET DEFAULT 0;
+`
+
+var userRecordChange = &SchemaChange{
+	Name:    "user_record",
+	Table:   "users",
+	Pending: "wins IS NULL",
+	Backfill: `
+		UPDATE users u SET
+			wins = (SELECT COUNT(*) FROM matches m
+				WHERE (m.player_a_id = u.id AND m.outcome = 'a') OR (m.player_b_id = u.id AND m.outcome = 'b')),
+			losses = (SELECT COUNT(*) FROM matches m
+				WHERE (m.player_a_id = u.id AND m.outcome = 'b') OR (m.player_b_id = u.id AND m.outcome = 'a')),
+			draws = (SELECT COUNT(*) FROM matches m
+				WHERE (m.player_a_id = u.id OR m.player_b_id = u.id) AND m.outcome = 'draw')
+		WHERE u.id BETWEEN $1 AND $2 AND u.wins IS NULL
+	`,
+}
+
+type UserRecord struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+// recordMatchResult is the dual-write half of user_record: a match counts
+// towards the player's columns unless their row is still waiting for the
+// backfill, which will count it from matches instead.
+func recordMatchResult(tx *sql.Tx, userID int64, score float64) error {
+	var win, loss, draw int
+	switch {
+	case score > 0.5:
+		win = 1
+	case score < 0.5:
+		loss = 1
+	default:
+		draw = 1
+	}
+	_, err := tx.Exec(`
+		UPDATE users SET wins = wins + $2, losses = losses + $3, draws = draws + $4 WHERE id = $1
+	`, userID, win, loss, draw)
+	if err != nil {
+		return fmt.Errorf("failed to update match record: %w", err)
+	}
+	return nil
+}
+
+// userRecord reads a user's record from whichever representation is
+// authoritative.
+func userRecord(ctx context.Context, username string) (*UserRecord, error) {
+	var r UserRecord
+	var err error
+	if schemaChangeComplete(userRecordChange.Name) {
+		err = db.QueryRowContext(ctx, `
+			SELECT wins, losses, draws FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1
+		`, username).Scan(&r.Wins, &r.Losses, &r.Draws)
+	} else {
+		err = db.QueryRowContext(ctx, `
+			SELECT
+				COUNT(*) FILTER (WHERE (m.player_a_id = u.id AND m.outcome = 'a') OR (m.player_b_id = u.id AND m.outcome = 'b')),
+				COUNT(*) FILTER (WHERE (m.player_a_id = u.id AND m.outcome = 'b') OR (m.player_b_id = u.id AND m.outcome = 'a')),
+				COUNT(*) FILTER (WHERE m.outcome = 'draw')
+			FROM (SELECT id FROM users WHERE LOWER(username) = LOWER($1) LIMIT 1) u
+			JOIN matches m ON u.id IN (m.player_a_id, m.player_b_id)
+		`, username).Scan(&r.Wins, &r.Losses, &r.Draws)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read match record: %w", err)
+	}
+	return &r, nil
+}
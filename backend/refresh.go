@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Bulk operations (season archives, re-rating, background seeding, engine
+// rebuilds) move many standings at once. When one finishes it commits a
+// leaderboard.refreshed outbox event; the relay applies it after every rating
+// event the operation wrote, so by then the engine is current. Applying it
+// drops the leaderboard page snapshots, tells /events and embed subscribers
+// to reload the whole board, and re-warms the hot pages.
+const (
+	EventLeaderboardRefreshed = "leaderboard.refreshed"
+
+	RefreshSeasonArchive = "season_archive"
+	RefreshRerate        = "rerate"
+	RefreshSeed          = "seed"
+	RefreshEngineRebuild = "engine_rebuild"
+	RefreshImport        = "import"
+)
+
+type LeaderboardRefreshedEvent struct {
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// refreshHub fans refresh events out to streaming clients. Each subscriber
+// holds at most one pending refresh: a second one before the first is read
+// would ask for the same reload.
+type refreshHub struct {
+	mu   sync.Mutex
+	subs map[chan LeaderboardRefreshedEvent]struct{}
+}
+
+var leaderboardRefreshes = &refreshHub{subs: map[chan LeaderboardRefreshedEvent]struct{}{}}
+
+func (h *refreshHub) subscribe() (<-chan LeaderboardRefreshedEvent, func()) {
+	ch := make(chan LeaderboardRefreshedEvent, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *refreshHub) publish(ev LeaderboardRefreshedEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// insertLeaderboardRefresh queues the refresh in the operation's own
+// transaction.
+func insertLeaderboardRefresh(tx *sql.Tx, reason string) error {
+	return insertOutboxEvent(tx, EventLeaderboardRefreshed, LeaderboardRefreshedEvent{Reason: reason, At: time.Now().UTC()})
+}
+
+// commitLeaderboardRefresh queues the refresh for an operation that committed
+// in several transactions, after the last of them.
+func commitLeaderboardRefresh(reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin refresh event: %w", err)
+	}
+	defer tx.Rollback()
+	if err := insertLeaderboardRefresh(tx, reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh event: %w", err)
+	}
+	outboxRelay.Notify()
+	return nil
+}
+
+// applyLeaderboardRefresh runs on every instance once its engine has caught
+// up with the operation.
+func applyLeaderboardRefresh(ev LeaderboardRefreshedEvent) {
+	dropped := pageSnapshots.invalidate()
+	leaderboardRefreshes.publish(ev)
+	slog.Info("✓ Leaderboard refreshed", "reason", ev.Reason, "snapshots_dropped", dropped)
+	go rewarm()
+}
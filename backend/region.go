@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deployment modes. A primary owns the database and applies every write. A
+// replica runs in another region against a read-only PostgreSQL replica: it
+// serves reads locally, forwards every mutating request to PRIMARY_URL, and
+// keeps its own engine current by tailing the outbox the primary writes.
+const (
+	DeploymentPrimary = "primary"
+	DeploymentReplica = "replica"
+
+	// replicaLookback is how far behind the newest applied outbox id the tail
+	// re-reads. Ids are assigned at insert but become visible at commit, so a
+	// slow transaction can surface an id lower than one already applied.
+	replicaLookback = 1000
+)
+
+var (
+	deploymentMode = getEnv("DEPLOYMENT_MODE", DeploymentPrimary)
+	primaryURL     = getEnv("PRIMARY_URL", "")
+)
+
+func isReplica() bool {
+	return deploymentMode == DeploymentReplica
+}
+
+func validateDeploymentMode() error {
+	switch deploymentMode {
+	case DeploymentPrimary:
+		return nil
+	case DeploymentReplica:
+		if primaryURL == "" {
+			return fmt.Errorf("DEPLOYMENT_MODE=replica requires PRIMARY_URL")
+		}
+		if _, err := url.Parse(primaryURL); err != nil {
+			return fmt.Errorf("invalid PRIMARY_URL: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown DEPLOYMENT_MODE %q", deploymentMode)
+	}
+}
+
+// forwardMutations proxies every non-read request to the primary region when
+// running as a replica, except /admin/replication actions, which manage the
+// replica itself. It sits ahead of the audit middleware so the replica
+// never tries to write an audit row to its read-only database.
+func forwardMutations() gin.HandlerFunc {
+	if !isReplica() {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	target, _ := url.Parse(primaryURL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		loggerFrom(r.Context()).Error("Error forwarding to primary", "method", r.Method, "path", r.URL.Path, "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"success":false,"error":"Primary region unavailable"}`))
+	}
+
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if strings.HasPrefix(c.Request.URL.Path, "/admin/replication/") {
+			c.Next()
+			return
+		}
+		c.Header("X-Forwarded-To-Primary", "true")
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// ReplicaFeed applies outbox events to a replica's engine without marking
+// them processed, remembering which ids it has applied instead.
+type ReplicaFeed struct {
+	mu sync.Mutex
+
+	applied    map[int64]bool
+	maxApplied int64
+
+	users        map[int64]*replicaUser
+	affected     map[int64]bool
+	conflicts    []ReplicationConflict
+	sequenceGaps int64
+	outOfOrder   int64
+	reconciled   int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var replicaFeed *ReplicaFeed
+
+// InitReplicaEngine loads the engine and the outbox position from one
+// snapshot, so every event visible at load time is counted exactly once.
+func InitReplicaEngine() error {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin replica snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	counts, err := getRatingCounts(tx)
+	if err != nil {
+		return err
+	}
+	if err := loadMetricEngines(tx); err != nil {
+		return err
+	}
+	if err := loadBoards(tx); err != nil {
+		return err
+	}
+	if err := loadBots(tx); err != nil {
+		return err
+	}
+
+	feed := &ReplicaFeed{
+		applied:  map[int64]bool{},
+		users:    map[int64]*replicaUser{},
+		affected: map[int64]bool{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM outbox`).Scan(&feed.maxApplied); err != nil {
+		return fmt.Errorf("failed to read outbox position: %w", err)
+	}
+	rows, err := tx.Query(`SELECT id FROM outbox WHERE id > $1 AND id <= $2`, feed.maxApplied-replicaLookback, feed.maxApplied)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox position: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan outbox id: %w", err)
+		}
+		feed.applied[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating outbox ids: %w", err)
+	}
+
+	kind := getEnv("RANK_ENGINE", EngineBucket)
+	engine, err := buildRankingEngine(kind, counts)
+	if err != nil {
+		return err
+	}
+	rankingEngine.Store(engineRef{engine})
+	replicaFeed = feed
+	noteOutboxApplied(feed.maxApplied)
+
+	totalUsers, _, _, _ := engine.GetStats()
+	slog.Info("✓ Replica engine loaded", "engine", kind, "total_users", totalUsers, "outbox_position", feed.maxApplied)
+	return nil
+}
+
+func StartReplicaFeed() {
+	go replicaFeed.run()
+	slog.Info("✓ Replica feed started, forwarding writes", "primary_url", primaryURL)
+}
+
+func StopReplicaFeed() {
+	if replicaFeed == nil {
+		return
+	}
+	close(replicaFeed.stop)
+	<-replicaFeed.done
+	slog.Info("✓ Replica feed stopped")
+}
+
+func (f *ReplicaFeed) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := f.poll()
+			if err != nil {
+				slog.Error("Replica feed error", "error", err)
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// poll applies the next batch of unseen events and forgets ids that have
+// fallen out of the lookback window.
+func (f *ReplicaFeed) poll() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	low := f.maxApplied - replicaLookback
+	// At most replicaLookback of the rows read have been applied already, so
+	// this always reaches a full batch of new ones when there are that many.
+	rows, err := db.Query(`
+		SELECT id, event_type, payload
+		FROM outbox
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`, low, replicaLookback+outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() && n < outboxBatchSize {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload); err != nil {
+			return n, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		if f.applied[e.ID] {
+			continue
+		}
+		f.applyLocked(e)
+		f.applied[e.ID] = true
+		if e.ID > f.maxApplied {
+			f.maxApplied = e.ID
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("error iterating outbox: %w", err)
+	}
+	noteOutboxApplied(f.maxApplied)
+
+	low = f.maxApplied - replicaLookback
+	for id := range f.applied {
+		if id <= low {
+			delete(f.applied, id)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SimOpUpdate   = "update"
+	SimOpRegister = "register"
+	SimOpChurn    = "churn"
+)
+
+// lockedRand is a mutex-guarded *rand.Rand. All simulator and seeding
+// randomness goes through simRand so a fixed SIM_SEED reproduces a run.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (l *lockedRand) Float32() float32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float32()
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) NormFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.NormFloat64()
+}
+
+var (
+	simRand = newSimRand()
+	// simSeeded is set when SIM_SEED fixes simRand. Simulated users are then
+	// picked with simRand rather than ORDER BY RANDOM(), which Postgres seeds
+	// on its own.
+	simSeeded = getEnv("SIM_SEED", "") != ""
+)
+
+func newSimRand() *lockedRand {
+	seed := time.Now().UnixNano()
+	if v := getEnv("SIM_SEED", ""); v != "" {
+		seed = int64(getEnvInt("SIM_SEED", 0))
+		slog.Info("Simulator RNG seeded", "seed", seed)
+	}
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+type SimEvent struct {
+	Batch     int64  `json:"batch"`
+	Op        string `json:"op"`
+	Username  string `json:"username"`
+	OldRating int    `json:"old_rating,omitempty"`
+	NewRating int    `json:"new_rating"`
+}
+
+// simRecorder appends every generated simulator event as NDJSON when
+// SIM_RECORD_FILE is set. The file can be fed back to POST /simulate/replay.
+type simRecorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	f     *os.File
+	batch atomic.Int64
+}
+
+var recorder = openSimRecorder()
+
+func openSimRecorder() *simRecorder {
+	path := getEnv("SIM_RECORD_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		slog.Warn("Cannot open SIM_RECORD_FILE", "path", path, "error", err)
+		return nil
+	}
+	slog.Info("Recording simulator events", "path", path)
+	return &simRecorder{f: f, w: bufio.NewWriter(f)}
+}
+
+func (r *simRecorder) nextBatch() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.batch.Add(1)
+}
+
+func (r *simRecorder) record(events ...SimEvent) {
+	if r == nil || len(events) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(r.w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			slog.Warn("Failed to record simulator event", "error", err)
+			return
+		}
+	}
+	if err := r.w.Flush(); err != nil {
+		slog.Warn("Failed to flush simulator record", "error", err)
+	}
+}
+
+func (r *simRecorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+	r.f.Close()
+}
+
+type ReplayResponse struct {
+	Success    bool  `json:"success"`
+	Batches    int   `json:"batches"`
+	Updated    int   `json:"updated"`
+	Registered int   `json:"registered"`
+	Churned    int   `json:"churned"`
+	Skipped    int   `json:"skipped"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// HandleSimulateReplay applies a recorded NDJSON event stream (the request
+// body) batch by batch and synchronously, so its duration is comparable
+// across runs and engine implementations.
+func HandleSimulateReplay(c *gin.Context) {
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		resp    = ReplayResponse{Success: true}
+		pending []SimEvent
+		current int64 = -1
+		line    int
+	)
+
+	start := time.Now()
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e SimEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Invalid replay event on line %d", line))
+			return
+		}
+
+		if e.Batch != current && len(pending) > 0 {
+			applyReplayBatch(pending, &resp)
+			pending = pending[:0]
+		}
+		current = e.Batch
+		pending = append(pending, e)
+	}
+	if err := scanner.Err(); err != nil {
+		respondError(c, http.StatusBadRequest, "Failed to read replay stream")
+		return
+	}
+	if len(pending) > 0 {
+		applyReplayBatch(pending, &resp)
+	}
+
+	resp.DurationMs = time.Since(start).Milliseconds()
+	chargeUpdateQuota(c, resp.Updated)
+	requestLog(c).Info("✓ Replay complete", "batches", resp.Batches, "updates", resp.Updated, "registrations", resp.Registered,
+		"churned", resp.Churned, "skipped", resp.Skipped, "duration_ms", resp.DurationMs)
+
+	respond(c, http.StatusOK, resp)
+}
+
+func applyReplayBatch(events []SimEvent, resp *ReplayResponse) {
+	re := GetRankingEngine()
+	resp.Batches++
+
+	var updates []RatingUpdate
+	for _, e := range events {
+		switch e.Op {
+		case SimOpRegister:
+			u, err := CreateUser(e.Username, e.NewRating, true)
+			if err != nil {
+				resp.Skipped++
+				continue
+			}
+			if !u.InPlacement {
+				re.AddUser(e.NewRating)
+				bots.add(e.Username, e.NewRating)
+			}
+			resp.Registered++
+
+		case SimOpChurn:
+			u, err := GetUserByUsername(e.Username)
+			if err != nil {
+				resp.Skipped++
+				continue
+			}
+			deleted, err := DeleteBotByID(u.ID)
+			if err != nil {
+				resp.Skipped++
+				continue
+			}
+			if deleted.Ranked {
+				re.RemoveUser(deleted.Rating)
+			}
+			bots.remove(deleted.Username, deleted.Rating, deleted.Ranked)
+			resp.Churned++
+
+		case SimOpUpdate:
+			u, err := GetUserByUsername(e.Username)
+			if err != nil || !u.IsBot || u.InPlacement || e.NewRating < MinRating || e.NewRating > MaxRating {
+				resp.Skipped++
+				continue
+			}
+			updates = append(updates, RatingUpdate{
+				UserID:    u.ID,
+				Username:  u.Username,
+				OldRating: u.Rating,
+				NewRating: e.NewRating,
+			})
+
+		default:
+			resp.Skipped++
+		}
+	}
+
+	if len(updates) > 0 {
+		applied := processRatingUpdates(updates)
+		resp.Updated += applied
+		resp.Skipped += len(updates) - applied
+	}
+}
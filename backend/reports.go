@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// The weekly gainers report lists the players whose rating rose and fell the
+// most over a week, read from rating_history. Weeks run from Monday 00:00
+// UTC. Once a week ends the primary builds its report, stores it in
+// gainers_reports, and delivers it as JSON to GAINERS_REPORT_WEBHOOK_URL
+// and as HTML mail through GAINERS_REPORT_SMTP_ADDR, whichever are set. The
+// stored row is what makes it once a week: a restart neither repeats nor
+// skips a report, and a failed delivery is retried every tick until it has
+// had GAINERS_REPORT_MAX_ATTEMPTS tries. Only game submissions count (see
+// gameHistorySources), changes that were rolled back don't, and bots are
+// left out.
+const gainersReportSchema = `
+	CREATE TABLE IF NOT EXISTS gainers_reports (
+		week_start TIMESTAMPTZ PRIMARY KEY,
+		report JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		attempts INT NOT NULL DEFAULT 0,
+		delivered_at TIMESTAMPTZ,
+		last_error TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rating_history_created ON rating_history(created_at);
+`
+
+const (
+	WebhookEventGainersReport = "gainers_report"
+
+	gainersReportTick    = time.Minute
+	gainersReportTimeout = 10 * time.Second
+	gainersReportWeek    = 7 * 24 * time.Hour
+)
+
+var (
+	gainersReportSize        = max(getEnvInt("GAINERS_REPORT_SIZE", 10), 1)
+	gainersReportMaxAttempts = max(getEnvInt("GAINERS_REPORT_MAX_ATTEMPTS", 5), 1)
+
+	gainersReportWebhook       = getEnv("GAINERS_REPORT_WEBHOOK_URL", "")
+	gainersReportWebhookSecret = getEnv("GAINERS_REPORT_WEBHOOK_SECRET", "")
+
+	gainersReportSMTPAddr     = getEnv("GAINERS_REPORT_SMTP_ADDR", "")
+	gainersReportSMTPUsername = getEnv("GAINERS_REPORT_SMTP_USERNAME", "")
+	gainersReportSMTPPassword = getEnv("GAINERS_REPORT_SMTP_PASSWORD", "")
+	gainersReportFrom         = getEnv("GAINERS_REPORT_FROM", "")
+	gainersReportTo           = splitList(getEnv("GAINERS_REPORT_TO", ""))
+
+	gainersReporter *GainersReporter
+)
+
+// ReportMover is one player's net change over the report's week.
+type ReportMover struct {
+	Username string `json:"username"`
+	Change   int    `json:"change"`
+	Rating   int    `json:"rating"`
+	Updates  int    `json:"updates"`
+}
+
+type GainersReport struct {
+	WeekStart   time.Time     `json:"week_start"`
+	WeekEnd     time.Time     `json:"week_end"`
+	Players     int           `json:"players"`
+	Gainers     []ReportMover `json:"gainers"`
+	Losers      []ReportMover `json:"losers"`
+	GeneratedAt time.Time     `json:"generated_at"`
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// reportWeekStart is the Monday 00:00 UTC that starts t's week.
+func reportWeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// buildGainersReport reads the week starting at start.
+func buildGainersReport(start time.Time) (*GainersReport, error) {
+	report := &GainersReport{
+		WeekStart:   start,
+		WeekEnd:     start.Add(gainersReportWeek),
+		GeneratedAt: time.Now().UTC(),
+	}
+	if err := db.QueryRow(`
+		SELECT COUNT(DISTINCT h.user_id)
+		FROM rating_history h JOIN users u ON u.id = h.user_id
+		WHERE h.created_at >= $1 AND h.created_at < $2 AND h.rolled_back_at IS NULL
+			AND h.source = ANY($3) AND NOT u.is_bot
+	`, report.WeekStart, report.WeekEnd, pq.Array(gameHistorySources)).Scan(&report.Players); err != nil {
+		return nil, fmt.Errorf("failed to count report players: %w", err)
+	}
+	var err error
+	if report.Gainers, err = queryReportMovers(report.WeekStart, report.WeekEnd, true); err != nil {
+		return nil, err
+	}
+	if report.Losers, err = queryReportMovers(report.WeekStart, report.WeekEnd, false); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// queryReportMovers returns the biggest net gains, or losses, between start
+// and end.
+func queryReportMovers(start, end time.Time, gains bool) ([]ReportMover, error) {
+	having, order := "> 0", "DESC"
+	if !gains {
+		having, order = "< 0", "ASC"
+	}
+	rows, err := db.Query(`
+		SELECT u.username, SUM(h.new_rating - h.old_rating) AS change, u.rating, COUNT(*)
+		FROM rating_history h JOIN users u ON u.id = h.user_id
+		WHERE h.created_at >= $1 AND h.created_at < $2 AND h.rolled_back_at IS NULL
+			AND h.source = ANY($3) AND NOT u.is_bot
+		GROUP BY u.id
+		HAVING SUM(h.new_rating - h.old_rating) `+having+`
+		ORDER BY change `+order+`, u.username
+		LIMIT $4
+	`, start, end, pq.Array(gameHistorySources), gainersReportSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report movers: %w", err)
+	}
+	defer rows.Close()
+
+	movers := []ReportMover{}
+	for rows.Next() {
+		var m ReportMover
+		if err := rows.Scan(&m.Username, &m.Change, &m.Rating, &m.Updates); err != nil {
+			return nil, fmt.Errorf("failed to scan report mover: %w", err)
+		}
+		movers = append(movers, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating report movers: %w", err)
+	}
+	return movers, nil
+}
+
+var gainersReportTemplate = template.Must(template.New("gainers").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font: 14px/1.4 -apple-system, "Segoe UI", Roboto, sans-serif; color: #11181c; }
+  table { border-collapse: collapse; margin-bottom: 24px; }
+  th, td { padding: 6px 10px; text-align: left; }
+  th { font-size: 12px; text-transform: uppercase; opacity: 0.6; }
+  tr + tr td { border-top: 1px solid rgba(128, 128, 128, 0.2); }
+  td.num { text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Report.Players}} players' ratings moved in the week starting {{.Report.WeekStart.Format "Monday 2 January 2006"}} (UTC).</p>
+{{define "movers"}}
+<table>
+  <thead><tr><th>#</th><th>Player</th><th class="num">Change</th><th class="num">Rating</th><th class="num">Updates</th></tr></thead>
+  <tbody>
+  {{range $i, $m := .}}<tr><td>{{inc $i}}</td><td>{{$m.Username}}</td><td class="num">{{printf "%+d" $m.Change}}</td><td class="num">{{$m.Rating}}</td><td class="num">{{$m.Updates}}</td></tr>
+  {{else}}<tr><td colspan="5">Nobody</td></tr>
+  {{end}}
+  </tbody>
+</table>
+{{end}}
+<h2>Top gainers</h2>
+{{template "movers" .Report.Gainers}}
+<h2>Biggest losses</h2>
+{{template "movers" .Report.Losers}}
+</body>
+</html>
+`))
+
+func (r *GainersReport) title() string {
+	return "Top gainers, week of " + r.WeekStart.Format("2 January 2006")
+}
+
+func (r *GainersReport) renderHTML() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gainersReportTemplate.Execute(&buf, struct {
+		Title  string
+		Report *GainersReport
+	}{r.title(), r})
+	return buf.Bytes(), err
+}
+
+func gainersReportEnabled() bool {
+	return gainersReportWebhook != "" || gainersReportSMTPAddr != ""
+}
+
+// deliverGainersReport sends the report to every configured sink. Each sink
+// is tried even when another fails, so one bad receiver doesn't hold up the
+// other; a retry then sends to both again.
+func deliverGainersReport(r *GainersReport) error {
+	var errs []error
+	if gainersReportWebhook != "" {
+		if err := postGainersReport(r); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if gainersReportSMTPAddr != "" {
+		if err := mailGainersReport(r); err != nil {
+			errs = append(errs, fmt.Errorf("smtp: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postGainersReport posts the report as JSON, signed like webhook
+// deliveries when GAINERS_REPORT_WEBHOOK_SECRET is set.
+func postGainersReport(r *GainersReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, gainersReportWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", WebhookEventGainersReport)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if gainersReportWebhookSecret != "" {
+		req.Header.Set("X-Webhook-Signature", webhookSignature(gainersReportWebhookSecret, timestamp, body))
+	}
+	resp, err := (&http.Client{Timeout: gainersReportTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mailGainersReport mails the HTML report to GAINERS_REPORT_TO.
+func mailGainersReport(r *GainersReport) error {
+	html, err := r.renderHTML()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", gainersReportFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(gainersReportTo, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", r.title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(html)
+
+	var auth smtp.Auth
+	if gainersReportSMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(gainersReportSMTPAddr)
+		auth = smtp.PlainAuth("", gainersReportSMTPUsername, gainersReportSMTPPassword, host)
+	}
+	return smtp.SendMail(gainersReportSMTPAddr, auth, gainersReportFrom, gainersReportTo, msg.Bytes())
+}
+
+// GainersReporter builds and delivers the weekly report. Like the season
+// scheduler it runs on the primary only.
+type GainersReporter struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func StartGainersReporter() error {
+	if !gainersReportEnabled() || isReplica() {
+		return nil
+	}
+	if gainersReportSMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(gainersReportSMTPAddr); err != nil {
+			return fmt.Errorf("GAINERS_REPORT_SMTP_ADDR must be host:port: %w", err)
+		}
+		if gainersReportFrom == "" || len(gainersReportTo) == 0 {
+			return errors.New("GAINERS_REPORT_SMTP_ADDR needs GAINERS_REPORT_FROM and GAINERS_REPORT_TO")
+		}
+	}
+
+	gainersReporter = &GainersReporter{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go gainersReporter.run()
+	slog.Info("✓ Weekly gainers report scheduled", "webhook", gainersReportWebhook != "", "smtp", gainersReportSMTPAddr != "")
+	return nil
+}
+
+func StopGainersReporter() {
+	if gainersReporter == nil {
+		return
+	}
+	close(gainersReporter.stop)
+	<-gainersReporter.done
+}
+
+func (g *GainersReporter) run() {
+	defer close(g.done)
+
+	ticker := time.NewTicker(gainersReportTick)
+	defer ticker.Stop()
+
+	g.tick()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			g.tick()
+		}
+	}
+}
+
+// tick stores the report of the week that ended last, if it isn't stored
+// yet, then delivers whatever is still undelivered. A week missed while the
+// service was down is reported on the next start; older ones are not.
+func (g *GainersReporter) tick() {
+	week := reportWeekStart(time.Now()).Add(-gainersReportWeek)
+	var stored bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM gainers_reports WHERE week_start = $1)`, week).Scan(&stored); err != nil {
+		slog.Error("Gainers report check failed", "error", err)
+		return
+	}
+	if !stored {
+		report, err := buildGainersReport(week)
+		if err == nil {
+			err = storeGainersReport(report)
+		}
+		if err != nil {
+			slog.Error("Failed to build gainers report", "week", week.Format(time.DateOnly), "error", err)
+			return
+		}
+		slog.Info("✓ Built weekly gainers report", "week", week.Format(time.DateOnly), "players", report.Players)
+	}
+	g.deliverPending()
+}
+
+func storeGainersReport(r *GainersReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO gainers_reports (week_start, report) VALUES ($1, $2) ON CONFLICT (week_start) DO NOTHING
+	`, r.WeekStart, body); err != nil {
+		return fmt.Errorf("failed to store gainers report: %w", err)
+	}
+	return nil
+}
+
+func (g *GainersReporter) deliverPending() {
+	rows, err := db.Query(`
+		SELECT report FROM gainers_reports
+		WHERE delivered_at IS NULL AND attempts < $1
+		ORDER BY week_start
+	`, gainersReportMaxAttempts)
+	if err != nil {
+		slog.Error("Failed to load pending gainers reports", "error", err)
+		return
+	}
+	var pending []*GainersReport
+	for rows.Next() {
+		var body []byte
+		r := &GainersReport{}
+		if err := rows.Scan(&body); err == nil {
+			err = json.Unmarshal(body, r)
+		}
+		if err != nil {
+			slog.Error("Skipping unreadable gainers report", "error", err)
+			continue
+		}
+		pending = append(pending, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		slog.Error("Failed to load pending gainers reports", "error", err)
+		return
+	}
+
+	for _, r := range pending {
+		week := r.WeekStart.Format(time.DateOnly)
+		deliverErr := deliverGainersReport(r)
+		var lastError sql.NullString
+		if deliverErr != nil {
+			lastError = sql.NullString{String: deliverErr.Error(), Valid: true}
+		}
+		if _, err := db.Exec(`
+			UPDATE gainers_reports
+			SET attempts = attempts + 1, last_error = $2,
+				delivered_at = CASE WHEN $2::text IS NULL THEN NOW() END
+			WHERE week_start = $1
+		`, r.WeekStart, lastError); err != nil {
+			slog.Error("Failed to record gainers report delivery", "week", week, "error", err)
+			continue
+		}
+		if deliverErr != nil {
+			slog.Warn("Gainers report delivery failed", "week", week, "error", deliverErr)
+			continue
+		}
+		slog.Info("✓ Delivered weekly gainers report", "week", week)
+	}
+}
+
+// HandleGainersReport serves GET /admin/reports/gainers: the report of the
+// week containing ?week= (a date), by default the last complete week, as
+// JSON or, with ?format=html, as the page the mail carries. Nothing is sent.
+func HandleGainersReport(c *gin.Context) {
+	week := reportWeekStart(time.Now()).Add(-gainersReportWeek)
+	if raw := c.Query("week"); raw != "" {
+		day, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "week must be a date (YYYY-MM-DD)")
+			return
+		}
+		week = reportWeekStart(day)
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "html" {
+		respondError(c, http.StatusBadRequest, "format must be json or html")
+		return
+	}
+
+	report, err := buildGainersReport(week)
+	if err != nil {
+		requestLog(c).Error("Error building gainers report", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to build report")
+		return
+	}
+	if format == "html" {
+		page, err := report.renderHTML()
+		if err != nil {
+			requestLog(c).Error("Error rendering gainers report", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to render report")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+		return
+	}
+	respond(c, http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+type GainersReportDelivery struct {
+	WeekStart   time.Time  `json:"week_start"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Attempts    int        `json:"attempts"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// HandleListGainersReports serves GET /admin/reports/gainers/deliveries: the
+// stored weekly reports, newest first, with their delivery state.
+func HandleListGainersReports(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT week_start, created_at, attempts, delivered_at, COALESCE(last_error, '')
+		FROM gainers_reports
+		ORDER BY week_start DESC
+		LIMIT 52
+	`)
+	if err != nil {
+		requestLog(c).Error("Error listing gainers reports", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list reports")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []GainersReportDelivery{}
+	for rows.Next() {
+		var d GainersReportDelivery
+		if err := rows.Scan(&d.WeekStart, &d.CreatedAt, &d.Attempts, &d.DeliveredAt, &d.LastError); err != nil {
+			requestLog(c).Error("Error scanning gainers report", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list reports")
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating gainers reports", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list reports")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"reports":      deliveries,
+		"enabled":      gainersReportEnabled(),
+		"max_attempts": gainersReportMaxAttempts,
+	})
+}
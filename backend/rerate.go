@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HistorySourceRerate = "rerate"
+
+	JobKindRerate = "rerate"
+
+	rerateBatchSize    = 500
+	rerateProgressStep = 1000
+	rerateReportTop    = 20
+)
+
+type RerateChange struct {
+	Username  string `json:"username"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+	Delta     int    `json:"delta"`
+}
+
+type RerateResult struct {
+	Calculator        string         `json:"calculator"`
+	Matches           int            `json:"matches"`
+	UsersReplayed     int            `json:"users_replayed"`
+	UsersChanged      int            `json:"users_changed"`
+	Applied           int            `json:"applied"`
+	SkippedConcurrent int            `json:"skipped_concurrent"`
+	LargestChanges    []RerateChange `json:"largest_changes"`
+}
+
+type rerateUser struct {
+	username    string
+	current     int
+	inPlacement bool
+	seen        bool
+
+	rating int
+	games  int
+
+	// Placement runs through placementEnd (the match that placed the user),
+	// or through every match if the user is still placing.
+	placementEnd    int64
+	placementN      int
+	placementOppSum int
+	placementNet    int
+}
+
+func (u *rerateUser) placing(matchID int64) bool {
+	return u.inPlacement || (u.placementEnd != 0 && matchID <= u.placementEnd)
+}
+
+type matchUser struct {
+	matchID int64
+	userID  int64
+}
+
+// rerate recomputes every rating from the match log with the current rating
+// calculator. Each player starts from the rating they had before their first
+// match and the matches are replayed in the order they were recorded, with
+// placement handled as it was originally: frozen rating, then a performance
+// rating at the match that placed them. Changes that were rolled back stay
+// reverted; changes that never came from a match (direct /simulate updates)
+// are not part of the log and are discarded.
+//
+// The replay reads one consistent snapshot. When applying, a user whose rating
+// moved after the snapshot was taken is skipped rather than overwritten, so a
+// re-run picks them up.
+func rerate(job *Job, dryRun bool) (*RerateResult, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin rerate snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	users, err := loadRerateUsers(tx)
+	if err != nil {
+		return nil, err
+	}
+	rolledBack, err := loadRolledBackMatches(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM matches`).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count matches: %w", err)
+	}
+	job.progress("replaying", 0, total)
+
+	rows, err := tx.Query(`
+		SELECT id, COALESCE(external_id, id::text), player_a_id, player_b_id, outcome,
+			player_a_old_rating, player_b_old_rating
+		FROM matches
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load matches: %w", err)
+	}
+	defer rows.Close()
+
+	result := &RerateResult{Calculator: ratingCalculator.Name()}
+	for rows.Next() {
+		var id, aID, bID int64
+		var externalID, outcome string
+		var oldA, oldB int
+		if err := rows.Scan(&id, &externalID, &aID, &bID, &outcome, &oldA, &oldB); err != nil {
+			return nil, fmt.Errorf("failed to scan match: %w", err)
+		}
+		a, b := users[aID], users[bID]
+		if a == nil || b == nil {
+			continue
+		}
+		for _, p := range []struct {
+			u   *rerateUser
+			old int
+		}{{a, oldA}, {b, oldB}} {
+			if !p.u.seen {
+				p.u.seen = true
+				p.u.rating = p.old
+			}
+		}
+
+		scoreA, _ := outcomeScore(outcome)
+		newA, newB, err := ratingCalculator.Calculate(MatchInput{
+			MatchID: externalID,
+			PlayerA: a.username,
+			PlayerB: b.username,
+			RatingA: a.rating,
+			RatingB: b.rating,
+			ScoreA:  scoreA,
+			GamesA:  a.games,
+			GamesB:  b.games,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rating calculator %s failed on match %d: %w", ratingCalculator.Name(), id, err)
+		}
+
+		oppA, oppB := b.rating, a.rating
+		replayMatchSide(a, id, newA, oppA, scoreA, rolledBack[matchUser{id, aID}])
+		replayMatchSide(b, id, newB, oppB, 1-scoreA, rolledBack[matchUser{id, bID}])
+
+		result.Matches++
+		if result.Matches%rerateProgressStep == 0 {
+			job.progress("replaying", result.Matches, total)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating matches: %w", err)
+	}
+	rows.Close()
+	tx.Rollback()
+	job.progress("replaying", result.Matches, total)
+
+	changes := map[int64]RerateChange{}
+	for id, u := range users {
+		if !u.seen || u.inPlacement {
+			continue
+		}
+		result.UsersReplayed++
+		if u.rating != u.current {
+			changes[id] = RerateChange{
+				Username:  u.username,
+				OldRating: u.current,
+				NewRating: u.rating,
+				Delta:     u.rating - u.current,
+			}
+		}
+	}
+	result.UsersChanged = len(changes)
+	result.LargestChanges = largestRerateChanges(changes, rerateReportTop)
+
+	if dryRun {
+		return result, nil
+	}
+	if err := applyRerate(job, changes, result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// replayMatchSide advances one player's replay state past a match. score is
+// the player's own result: 1, 0.5, or 0.
+func replayMatchSide(u *rerateUser, matchID int64, newRating, opponent int, score float64, rolledBack bool) {
+	u.games++
+	if !u.placing(matchID) {
+		if !rolledBack {
+			u.rating = newRating
+		}
+		return
+	}
+
+	u.placementN++
+	u.placementOppSum += opponent
+	u.placementNet += int(math.Round(2*score)) - 1
+	if matchID == u.placementEnd {
+		u.rating = clampRating(int(math.Round(float64(u.placementOppSum)/float64(u.placementN) + 400*float64(u.placementNet)/float64(u.placementN))))
+	}
+}
+
+func loadRerateUsers(tx *sql.Tx) (map[int64]*rerateUser, error) {
+	rows, err := tx.Query(`
+		SELECT id, username, rating, in_placement FROM users
+		WHERE id IN (SELECT player_a_id FROM matches UNION SELECT player_b_id FROM matches)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load match players: %w", err)
+	}
+	defer rows.Close()
+
+	users := map[int64]*rerateUser{}
+	for rows.Next() {
+		var id int64
+		u := &rerateUser{}
+		if err := rows.Scan(&id, &u.username, &u.current, &u.inPlacement); err != nil {
+			return nil, fmt.Errorf("failed to scan match player: %w", err)
+		}
+		users[id] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating match players: %w", err)
+	}
+	rows.Close()
+
+	placed, err := tx.Query(`
+		SELECT user_id, MIN(match_id) FROM rating_history
+		WHERE source = $1 AND match_id IS NOT NULL
+		GROUP BY user_id
+	`, HistorySourcePlacement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load placements: %w", err)
+	}
+	defer placed.Close()
+	for placed.Next() {
+		var userID, matchID int64
+		if err := placed.Scan(&userID, &matchID); err != nil {
+			return nil, fmt.Errorf("failed to scan placement: %w", err)
+		}
+		if u := users[userID]; u != nil {
+			u.placementEnd = matchID
+		}
+	}
+	if err := placed.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating placements: %w", err)
+	}
+	return users, nil
+}
+
+func loadRolledBackMatches(tx *sql.Tx) (map[matchUser]bool, error) {
+	rows, err := tx.Query(`
+		SELECT match_id, user_id FROM rating_history
+		WHERE source = $1 AND match_id IS NOT NULL AND rolled_back_at IS NOT NULL
+	`, HistorySourceMatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rolled back matches: %w", err)
+	}
+	defer rows.Close()
+
+	rolledBack := map[matchUser]bool{}
+	for rows.Next() {
+		var key matchUser
+		if err := rows.Scan(&key.matchID, &key.userID); err != nil {
+			return nil, fmt.Errorf("failed to scan rolled back match: %w", err)
+		}
+		rolledBack[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rolled back matches: %w", err)
+	}
+	return rolledBack, nil
+}
+
+func largestRerateChanges(changes map[int64]RerateChange, n int) []RerateChange {
+	list := make([]RerateChange, 0, len(changes))
+	for _, c := range changes {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, k int) bool {
+		if abs(list[i].Delta) != abs(list[k].Delta) {
+			return abs(list[i].Delta) > abs(list[k].Delta)
+		}
+		return compareUsernames(list[i].Username, list[k].Username) < 0
+	})
+	return list[:min(n, len(list))]
+}
+
+// applyRerate writes the new ratings in batches, each with its history rows
+// and outbox events, so the engine follows through the outbox as usual.
+func applyRerate(job *Job, changes map[int64]RerateChange, result *RerateResult) error {
+	ids := make([]int64, 0, len(changes))
+	for id := range changes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, k int) bool { return ids[i] < ids[k] })
+
+	job.progress("applying", 0, len(ids))
+	for start := 0; start < len(ids); start += rerateBatchSize {
+		batch := ids[start:min(start+rerateBatchSize, len(ids))]
+		applied, err := applyRerateBatch(batch, changes)
+		if err != nil {
+			return err
+		}
+		result.Applied += applied
+		result.SkippedConcurrent += len(batch) - applied
+		outboxRelay.Notify()
+		job.progress("applying", start+len(batch), len(ids))
+	}
+	if result.Applied > 0 {
+		if err := commitLeaderboardRefresh(RefreshRerate); err != nil {
+			slog.Warn("Leaderboard refresh after rerate failed", "error", err)
+		}
+	}
+	return nil
+}
+
+func applyRerateBatch(ids []int64, changes map[int64]RerateChange) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin rerate batch: %w", err)
+	}
+	defer tx.Rollback()
+
+	applied := 0
+	for _, id := range ids {
+		c := changes[id]
+		res, err := tx.Exec(`
+			UPDATE users SET rating = $1 WHERE id = $2 AND rating = $3 AND NOT in_placement
+		`, c.NewRating, id, c.OldRating)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update rating for %s: %w", c.Username, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := insertRatingHistory(tx, id, c.OldRating, c.NewRating, HistorySourceRerate, nil); err != nil {
+			return 0, err
+		}
+		if err := insertOutboxEvent(tx, EventRatingUpdated, RatingUpdatedEvent{
+			UserID:    id,
+			Username:  c.Username,
+			OldRating: c.OldRating,
+			NewRating: c.NewRating,
+			Source:    HistorySourceRerate,
+		}); err != nil {
+			return 0, err
+		}
+		applied++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rerate batch: %w", err)
+	}
+	return applied, nil
+}
+
+// HandleRerate starts POST /admin/rerate?dry_run=true as a background job.
+// Poll GET /admin/jobs/:id for progress and the result.
+func HandleRerate(c *gin.Context) {
+	dryRun := dryRunRequested(c)
+
+	// The job outlives the request, so it keeps the logger rather than c.
+	logger := requestLog(c)
+	job, started := jobs.start(JobKindRerate, dryRun, func(job *Job) (any, error) {
+		result, err := rerate(job, dryRun)
+		switch {
+		case result == nil:
+			logger.Error("Rerate failed", "error", err)
+			return nil, err
+		case err != nil:
+			logger.Error("Rerate failed", "applied", result.Applied, "error", err)
+		case dryRun:
+			logger.Info("✓ Rerate dry run", "users_changed", result.UsersChanged, "users_replayed", result.UsersReplayed)
+		default:
+			logger.Info("✓ Rerate applied", "applied", result.Applied, "skipped_concurrent", result.SkippedConcurrent)
+		}
+		return result, err
+	})
+	if !started {
+		respondErrorWith(c, http.StatusConflict, "A rerate is already running", gin.H{
+			"job": job.snapshot(),
+		})
+		return
+	}
+
+	respond(c, http.StatusAccepted, gin.H{
+		"job": job.snapshot(),
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// POST /me/reset lets a player start over at NEW_USER_RATING. The player is
+// the subject of their bearer token. It takes two calls: the first returns a
+// confirmation token, and the second sends it back to do the reset. The
+// confirmation token is bound to the rating it was issued for, so it stops
+// working once that rating changes. The reset is recorded in the rating
+// history with source "reset", which is also how RATING_RESET_COOLDOWN_HOURS
+// is enforced, and reaches the engine through the outbox. Each player may
+// call it RATING_RESET_REQUESTS_PER_SEC times a second; both calls count.
+const (
+	HistorySourceReset = "reset"
+
+	resetConfirmTTL = 5 * time.Minute
+)
+
+var (
+	ratingResetCooldown = time.Duration(getEnvInt("RATING_RESET_COOLDOWN_HOURS", 168)) * time.Hour
+	resetRequestsPerSec = getEnvInt("RATING_RESET_REQUESTS_PER_SEC", 1)
+
+	resetLimiter = &quotaLimiter{buckets: map[string]*quotaBucket{}}
+
+	errResetPlacement  = errors.New("user is in placement")
+	errResetAtBaseline = errors.New("rating is already at the baseline")
+	errResetToken      = errors.New("confirmation token is invalid or expired")
+)
+
+// resetCooldownError refuses a reset that comes too soon after the last one.
+type resetCooldownError struct {
+	Until time.Time
+}
+
+func (e *resetCooldownError) Error() string {
+	return fmt.Sprintf("rating was reset recently; next reset at %s", e.Until.Format(time.RFC3339))
+}
+
+type ResetRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+type ResetResult struct {
+	Username    string    `json:"username"`
+	OldRating   int       `json:"old_rating"`
+	NewRating   int       `json:"new_rating"`
+	NextResetAt time.Time `json:"next_reset_at"`
+	userID      int64
+	isBot       bool
+}
+
+func resetBaseline() int {
+	return clampRating(newUserRating)
+}
+
+// resetConfirmToken signs the user and rating a reset was offered for.
+func resetConfirmToken(userID int64, rating int, expires time.Time) string {
+	mac := hmac.New(sha256.New, jwtSigningKey)
+	fmt.Fprintf(mac, "rating-reset:%d:%d:%d", userID, rating, expires.Unix())
+	return strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func checkResetConfirmToken(token string, userID int64, rating int, now time.Time) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if !ok || err != nil || now.After(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(resetConfirmToken(userID, rating, time.Unix(unix, 0))))
+}
+
+// checkResetAllowed applies the rules both calls share.
+func checkResetAllowed(q rowQueryer, user *User, now time.Time) error {
+	if user.InPlacement {
+		return errResetPlacement
+	}
+	if user.Rating == resetBaseline() {
+		return errResetAtBaseline
+	}
+	if ratingResetCooldown <= 0 {
+		return nil
+	}
+	var last sql.NullTime
+	if err := q.QueryRow(`
+		SELECT MAX(created_at) FROM rating_history WHERE user_id = $1 AND source = $2
+	`, user.ID, HistorySourceReset).Scan(&last); err != nil {
+		return fmt.Errorf("failed to load last reset: %w", err)
+	}
+	if until := last.Time.Add(ratingResetCooldown); last.Valid && now.Before(until) {
+		return &resetCooldownError{Until: until}
+	}
+	return nil
+}
+
+// resetRating resets the user's rating if token still confirms it. The user
+// row is locked, so the token and the cooldown are checked against the
+// rating and history the reset is applied to.
+func resetRating(username, token string, now time.Time) (*ResetResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin rating reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.QueryRow(`
+		SELECT id, username, rating, in_placement, is_bot FROM users WHERE LOWER(username) = LOWER($1) FOR UPDATE
+	`, username).Scan(&user.ID, &user.Username, &user.Rating, &user.InPlacement, &user.IsBot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMatchUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+	if err := checkResetAllowed(tx, &user, now); err != nil {
+		return nil, err
+	}
+	if !checkResetConfirmToken(token, user.ID, user.Rating, now) {
+		return nil, errResetToken
+	}
+
+	baseline := resetBaseline()
+	if _, err := tx.Exec(`UPDATE users SET rating = $1 WHERE id = $2`, baseline, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to update rating: %w", err)
+	}
+	if err := insertRatingHistory(tx, user.ID, user.Rating, baseline, HistorySourceReset, nil); err != nil {
+		return nil, err
+	}
+	if err := insertOutboxEvent(tx, EventRatingUpdated, RatingUpdatedEvent{
+		UserID:    user.ID,
+		Username:  user.Username,
+		OldRating: user.Rating,
+		NewRating: baseline,
+		Source:    HistorySourceReset,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rating reset: %w", err)
+	}
+	return &ResetResult{
+		Username:    user.Username,
+		OldRating:   user.Rating,
+		NewRating:   baseline,
+		NextResetAt: now.Add(ratingResetCooldown).UTC(),
+		userID:      user.ID,
+		isBot:       user.IsBot,
+	}, nil
+}
+
+// respondResetError answers the refusals both calls can hit. It reports
+// whether err was one of them.
+func respondResetError(c *gin.Context, err error) bool {
+	var cooldown *resetCooldownError
+	switch {
+	case errors.Is(err, errMatchUserNotFound):
+		respondError(c, http.StatusNotFound, "No player matches this token")
+	case errors.Is(err, errResetPlacement):
+		respondError(c, http.StatusConflict, "Players in placement have no rating to reset")
+	case errors.Is(err, errResetAtBaseline):
+		respondError(c, http.StatusConflict, fmt.Sprintf("Rating is already %d", resetBaseline()))
+	case errors.Is(err, errResetToken):
+		respondError(c, http.StatusConflict, "Confirmation token is invalid or expired; request a new one")
+	case errors.As(err, &cooldown):
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(cooldown.Until).Seconds())+1))
+		respondErrorWith(c, http.StatusTooManyRequests, "Rating was reset recently", gin.H{
+			"next_reset_at": cooldown.Until.UTC(),
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// resetRateMiddleware limits each token subject to resetRequestsPerSec calls
+// of /me/reset, so a player can't spin on the confirmation step. It runs
+// after playerAuthMiddleware, which names the subject.
+func resetRateMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := c.GetString("jwt_subject")
+		if resetRequestsPerSec <= 0 || subject == "" {
+			c.Next()
+			return
+		}
+		if ok, wait := resetLimiter.take(strings.ToLower(subject), resetRequestsPerSec, time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, "Too many reset requests; slow down")
+			return
+		}
+		c.Next()
+	}
+}
+
+// HandleResetRating serves POST /me/reset for the token's subject.
+func HandleResetRating(c *gin.Context) {
+	username := c.GetString("jwt_subject")
+	if username == "" {
+		respondError(c, http.StatusForbidden, "Token has no subject naming a player")
+		return
+	}
+	var req ResetRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	now := time.Now()
+
+	if req.ConfirmationToken == "" {
+		user, err := GetUserByUsername(username)
+		if err != nil {
+			respondResetError(c, errMatchUserNotFound)
+			return
+		}
+		if err := checkResetAllowed(db, user, now); err != nil {
+			if !respondResetError(c, err) {
+				requestLog(c).Error("Error checking rating reset", "username", username, "error", err)
+				respondError(c, http.StatusInternalServerError, "Failed to check rating reset")
+			}
+			return
+		}
+		expires := now.Add(resetConfirmTTL)
+		respond(c, http.StatusOK, gin.H{
+			"username":           user.Username,
+			"rating":             user.Rating,
+			"reset_to":           resetBaseline(),
+			"confirmation_token": resetConfirmToken(user.ID, user.Rating, expires),
+			"expires_at":         expires.UTC(),
+		})
+		return
+	}
+
+	release := writeSlots.acquire(WriteInteractive)
+	result, err := resetRating(username, req.ConfirmationToken, now)
+	release()
+	if err != nil {
+		if !respondResetError(c, err) {
+			requestLog(c).Error("Error resetting rating", "username", username, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to reset rating")
+		}
+		return
+	}
+	outboxRelay.Flush()
+
+	user := &User{ID: result.userID, Username: result.Username, Rating: result.NewRating, IsBot: result.isBot}
+	rank, total := placeUser(c, GetRankingEngine(), user)
+	requestLog(c).Info("✓ Reset rating", "username", result.Username, "old_rating", result.OldRating, "new_rating", result.NewRating)
+	respond(c, http.StatusOK, gin.H{
+		"reset":       result,
+		"rank":        rank,
+		"percentile":  rankPercentile(rank, total),
+		"total_users": total,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every JSON response goes through the writers in this file, so endpoints
+// can't drift apart in shape:
+//
+//	{"success": true, ...}                  respond
+//	{"success": false, "error": "..."}      respondError, abortWithError
+//	{"success": true, "data": [...], "pagination": {...}, ...}
+//
+// Handlers can also hand an error to errorMiddleware with c.Error and return;
+// it answers the errors shared across endpoints (see errorStatus) and
+// anything else as a 500, and the request log records the error.
+//
+// Three writers elsewhere produce the same shapes without going through
+// gin's renderer: pageBuffers.writeJSON encodes pages into pooled buffers,
+// streamJSONRows streams long listings, and the replica's forwarding proxy
+// reports an unreachable primary. The Slack and Discord integrations answer
+// in the shapes those platforms expect and are the only exceptions.
+
+// Pagination is the block every paged list carries. The page, limit, and
+// hasMore fields some lists also have at the top level predate it.
+type Pagination struct {
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func newPagination(page, limit int, hasMore bool) *Pagination {
+	return &Pagination{Page: page, Limit: limit, HasMore: hasMore}
+}
+
+// respond writes a success response. A gin.H body gets "success": true;
+// response structs carry their own Success field.
+func respond(c *gin.Context, status int, body any) {
+	switch b := body.(type) {
+	case gin.H:
+		b["success"] = true
+	case map[string]any:
+		b["success"] = true
+	}
+	c.JSON(status, body)
+}
+
+func respondError(c *gin.Context, status int, message string) {
+	c.JSON(status, ErrorResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// respondErrorWith adds fields to an error, such as the job in the way of
+// the request.
+func respondErrorWith(c *gin.Context, status int, message string, fields gin.H) {
+	fields["success"] = false
+	fields["error"] = message
+	c.JSON(status, fields)
+}
+
+// abortWithError is respondError for middleware.
+func abortWithError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// apiError is an error that carries the status and message to answer with.
+type apiError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error { return e.Err }
+
+// errorMiddleware answers a request whose handler recorded an error with
+// c.Error instead of writing a response. It runs innermost, so the audit and
+// usage middleware see the status it writes.
+func errorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		status, message := errorStatus(c, c.Errors.Last().Err)
+		respondError(c, status, message)
+	}
+}
+
+// errorStatus checks the shared errors first, so they keep their status
+// even when wrapped in an apiError.
+func errorStatus(c *gin.Context, err error) (int, string) {
+	var api *apiError
+	var limited *VolatilityError
+	var rejected *HookRejectedError
+	switch {
+	case isBudgetError(err):
+		return http.StatusGatewayTimeout, "Request exceeded its deadline"
+	case errors.As(err, &limited):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		return http.StatusTooManyRequests, fmt.Sprintf("Rating volatility limit for %s: %s", limited.Username, limited.Reason)
+	case errors.As(err, &rejected):
+		return http.StatusUnprocessableEntity, fmt.Sprintf("Write rejected by %s: %s", rejected.Hook, rejected.Reason)
+	case errors.As(err, &api):
+		return api.Status, api.Message
+	}
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+func handleNoRoute(c *gin.Context) {
+	respondError(c, http.StatusNotFound, fmt.Sprintf("No endpoint %s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+func handleNoMethod(c *gin.Context) {
+	respondError(c, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s", c.Request.Method, c.Request.URL.Path))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const HistorySourceRollback = "rollback"
+
+var (
+	errRollbackNotFound    = errors.New("rating event not found")
+	errAlreadyRolledBack   = errors.New("rating event already rolled back")
+	errRollbackUnsupported = errors.New("rating event cannot be rolled back")
+)
+
+type RollbackResult struct {
+	EventID        int64   `json:"event_id"`
+	Username       string  `json:"username"`
+	RevertedDelta  int     `json:"reverted_delta"`
+	LaterEvents    int     `json:"later_events"`
+	OldRating      int     `json:"old_rating"`
+	NewRating      int     `json:"new_rating"`
+	RollbackID     int64   `json:"rollback_event_id,omitempty"`
+	RelatedEventID []int64 `json:"related_event_ids"`
+}
+
+// rollbackRatingEvent reverts one rating_history entry. The user's rating is
+// recomputed by replaying every later change's delta on top of the event's
+// old rating, clamped to the rating bounds at each step as the original
+// updates were, plus any drift from changes that left no history. The change
+// is recorded as a new "rollback" history entry; history itself is never
+// rewritten. Opponents in the same match are not touched: their events are
+// listed as related so they can be rolled back separately if needed. A dry
+// run stops before the first write.
+func rollbackRatingEvent(eventID int64, dryRun bool) (*RollbackResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin rollback transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var event RatingHistoryEntry
+	var rolledBack bool
+	err = tx.QueryRow(`
+		SELECT user_id, old_rating, new_rating, source, match_id, rolled_back_at IS NOT NULL
+		FROM rating_history
+		WHERE id = $1
+	`, eventID).Scan(&userID, &event.OldRating, &event.NewRating, &event.Source, &event.MatchID, &rolledBack)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRollbackNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rating event: %w", err)
+	}
+
+	var user User
+	err = tx.QueryRow(`
+		SELECT id, username, rating, in_placement FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&user.ID, &user.Username, &user.Rating, &user.InPlacement)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRollbackNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	// Re-check under the user lock so two rollbacks of one event can't race.
+	if err := tx.QueryRow(`
+		SELECT rolled_back_at IS NOT NULL FROM rating_history WHERE id = $1 FOR UPDATE
+	`, eventID).Scan(&rolledBack); err != nil {
+		return nil, fmt.Errorf("failed to lock rating event: %w", err)
+	}
+	if rolledBack {
+		return nil, errAlreadyRolledBack
+	}
+	if event.Source != HistorySourceMatch || user.InPlacement {
+		return nil, errRollbackUnsupported
+	}
+
+	rows, err := tx.Query(`
+		SELECT new_rating - old_rating, new_rating
+		FROM rating_history
+		WHERE user_id = $1 AND id > $2
+		ORDER BY id
+	`, userID, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load later rating events: %w", err)
+	}
+	rating, chainEnd, later := event.OldRating, event.NewRating, 0
+	for rows.Next() {
+		var delta int
+		if err := rows.Scan(&delta, &chainEnd); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan later rating event: %w", err)
+		}
+		rating = clampRating(rating + delta)
+		later++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating later rating events: %w", err)
+	}
+	rating = clampRating(rating + user.Rating - chainEnd)
+
+	result := &RollbackResult{
+		EventID:        eventID,
+		Username:       user.Username,
+		RevertedDelta:  event.NewRating - event.OldRating,
+		LaterEvents:    later,
+		OldRating:      user.Rating,
+		NewRating:      rating,
+		RelatedEventID: []int64{},
+	}
+
+	if event.MatchID != nil {
+		related, err := tx.Query(`
+			SELECT id FROM rating_history WHERE match_id = $1 AND id <> $2 ORDER BY id
+		`, *event.MatchID, eventID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load related rating events: %w", err)
+		}
+		for related.Next() {
+			var id int64
+			if err := related.Scan(&id); err != nil {
+				related.Close()
+				return nil, fmt.Errorf("failed to scan related rating event: %w", err)
+			}
+			result.RelatedEventID = append(result.RelatedEventID, id)
+		}
+		related.Close()
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET rating = $1 WHERE id = $2`, rating, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to update rating: %w", err)
+	}
+	if err := tx.QueryRow(`
+		INSERT INTO rating_history (user_id, old_rating, new_rating, source, match_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, user.ID, user.Rating, rating, HistorySourceRollback, event.MatchID).Scan(&result.RollbackID); err != nil {
+		return nil, fmt.Errorf("failed to insert rating history: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE rating_history SET rolled_back_at = NOW() WHERE id = $1`, eventID); err != nil {
+		return nil, fmt.Errorf("failed to mark rating event rolled back: %w", err)
+	}
+	if err := insertOutboxEvent(tx, EventRatingUpdated, RatingUpdatedEvent{
+		UserID:    user.ID,
+		Username:  user.Username,
+		OldRating: user.Rating,
+		NewRating: rating,
+		Source:    HistorySourceRollback,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rollback: %w", err)
+	}
+	return result, nil
+}
+
+func HandleRollbackRating(c *gin.Context) {
+	eventID, err := strconv.ParseInt(c.Param("event_id"), 10, 64)
+	if err != nil || eventID < 1 {
+		respondError(c, http.StatusBadRequest, "event_id must be a positive integer")
+		return
+	}
+
+	dryRun := dryRunRequested(c)
+	result, err := rollbackRatingEvent(eventID, dryRun)
+	switch {
+	case errors.Is(err, errRollbackNotFound):
+		respondError(c, http.StatusNotFound, "Rating event not found")
+		return
+	case errors.Is(err, errAlreadyRolledBack):
+		respondError(c, http.StatusConflict, "Rating event has already been rolled back")
+		return
+	case errors.Is(err, errRollbackUnsupported):
+		respondError(c, http.StatusConflict, "Only match rating changes of ranked users can be rolled back")
+		return
+	case err != nil:
+		requestLog(c).Error("Error rolling back rating event", "event_id", eventID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to roll back rating event")
+		return
+	}
+
+	if dryRun {
+		respond(c, http.StatusOK, gin.H{
+			"dry_run":  true,
+			"rollback": result,
+		})
+		return
+	}
+
+	outboxRelay.Flush()
+	requestLog(c).Info("✓ Rolled back rating event", "event_id", eventID, "username", result.Username, "old_rating", result.OldRating, "new_rating", result.NewRating)
+
+	respond(c, http.StatusOK, gin.H{
+		"rollback": result,
+	})
+}
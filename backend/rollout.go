@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync/atomic"
+)
+
+// RolloutCalculator soft-launches a candidate calculator: every match is
+// scored by both, a stable hash of the match id decides which result is
+// applied, and any disagreement is logged so the two can be compared on live
+// traffic before the candidate becomes the default.
+type RolloutCalculator struct {
+	stable    RatingCalculator
+	candidate RatingCalculator
+	percent   int
+
+	stableServed    atomic.Int64
+	candidateServed atomic.Int64
+	candidateErrors atomic.Int64
+	divergences     atomic.Int64
+}
+
+type RolloutStats struct {
+	Stable          string `json:"stable"`
+	Candidate       string `json:"candidate"`
+	Percent         int    `json:"percent"`
+	StableServed    int64  `json:"stable_served"`
+	CandidateServed int64  `json:"candidate_served"`
+	CandidateErrors int64  `json:"candidate_errors"`
+	Divergences     int64  `json:"divergences"`
+}
+
+func NewRolloutCalculator(stable, candidate RatingCalculator, percent int) *RolloutCalculator {
+	return &RolloutCalculator{
+		stable:    stable,
+		candidate: candidate,
+		percent:   max(0, min(percent, 100)),
+	}
+}
+
+func (r *RolloutCalculator) Name() string {
+	return fmt.Sprintf("%s+%s@%d%%", r.stable.Name(), r.candidate.Name(), r.percent)
+}
+
+// inCandidate buckets by match id so a retried submission lands on the same
+// side of the split.
+func (r *RolloutCalculator) inCandidate(matchID string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(matchID))
+	return int(h.Sum32()%100) < r.percent
+}
+
+func (r *RolloutCalculator) Calculate(in MatchInput) (int, int, error) {
+	stableA, stableB, stableErr := r.stable.Calculate(in)
+	candA, candB, candErr := r.candidate.Calculate(in)
+
+	if candErr != nil {
+		r.candidateErrors.Add(1)
+		slog.Error("Rollout candidate failed", "match_id", in.MatchID, "candidate", r.candidate.Name(), "error", candErr)
+	} else if stableErr == nil && (stableA != candA || stableB != candB) {
+		r.divergences.Add(1)
+		slog.Info("Rollout match", "match_id", in.MatchID, "player_a", in.PlayerA, "player_b", in.PlayerB,
+			"stable", r.stable.Name(), "stable_a", stableA, "stable_b", stableB,
+			"candidate", r.candidate.Name(), "candidate_a", candA, "candidate_b", candB)
+	}
+
+	// A failing candidate never fails the match; it falls back to stable.
+	if candErr == nil && r.inCandidate(in.MatchID) {
+		r.candidateServed.Add(1)
+		return candA, candB, nil
+	}
+	r.stableServed.Add(1)
+	return stableA, stableB, stableErr
+}
+
+func (r *RolloutCalculator) Stats() RolloutStats {
+	return RolloutStats{
+		Stable:          r.stable.Name(),
+		Candidate:       r.candidate.Name(),
+		Percent:         r.percent,
+		StableServed:    r.stableServed.Load(),
+		CandidateServed: r.candidateServed.Load(),
+		CandidateErrors: r.candidateErrors.Load(),
+		Divergences:     r.divergences.Load(),
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// At season rollover ratings can be left alone, reset to one value, or
+// squashed toward it so last season's spread carries over in part.
+const (
+	SeasonResetNone   = "none"
+	SeasonResetFull   = "reset"
+	SeasonResetSquash = "squash"
+
+	HistorySourceSeason = "season"
+)
+
+const seasonSchedulerTick = time.Minute
+
+type SeasonReset struct {
+	Mode   string  `json:"mode"`
+	Rating int     `json:"rating"`
+	Factor float64 `json:"factor"`
+}
+
+var defaultSeasonReset = SeasonReset{
+	Mode:   getEnv("SEASON_RESET", SeasonResetNone),
+	Rating: getEnvInt("SEASON_RESET_RATING", 1200),
+	Factor: getEnvFloat("SEASON_SQUASH_FACTOR", 0.5),
+}
+
+func (r SeasonReset) validate() error {
+	switch r.Mode {
+	case SeasonResetNone, SeasonResetFull, SeasonResetSquash:
+	default:
+		return fmt.Errorf("reset must be %s, %s, or %s", SeasonResetNone, SeasonResetFull, SeasonResetSquash)
+	}
+	if r.Rating < MinRating || r.Rating > MaxRating {
+		return fmt.Errorf("reset rating must be between %d and %d", MinRating, MaxRating)
+	}
+	if r.Factor < 0 || r.Factor > 1 {
+		return errors.New("squash factor must be between 0 and 1")
+	}
+	return nil
+}
+
+// resetRatings moves every placed user's rating to the reset target, or the
+// given fraction of the way back from it, recording history and outbox events
+// in the same statement. It returns how many ratings changed.
+func resetRatings(tx *sql.Tx, r SeasonReset) (int, error) {
+	factor := r.Factor
+	switch r.Mode {
+	case SeasonResetNone:
+		return 0, nil
+	case SeasonResetFull:
+		factor = 0
+	}
+
+	result, err := tx.Exec(`
+		WITH old AS (
+			SELECT id, rating FROM users WHERE NOT in_placement FOR UPDATE
+		), changed AS (
+			UPDATE users u
+			SET rating = GREATEST($1, LEAST($2, ROUND($3 + (old.rating - $3) * $4::numeric)::INT))
+			FROM old
+			WHERE u.id = old.id
+				AND GREATEST($1, LEAST($2, ROUND($3 + (old.rating - $3) * $4::numeric)::INT)) <> old.rating
+			RETURNING u.id, u.username, old.rating AS old_rating, u.rating AS new_rating
+		), history AS (
+			INSERT INTO rating_history (user_id, old_rating, new_rating, source)
+			SELECT id, old_rating, new_rating, $5 FROM changed
+		)
+		INSERT INTO outbox (event_type, payload)
+		SELECT $6, jsonb_build_object(
+			'user_id', id, 'username', username, 'old_rating', old_rating, 'new_rating', new_rating, 'source', $5::text)
+		FROM changed
+	`, MinRating, MaxRating, r.Rating, factor, HistorySourceSeason, EventRatingUpdated)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset ratings: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// SeasonScheduler archives a season automatically every SEASON_LENGTH_DAYS,
+// counting from SEASON_ANCHOR, applying the configured reset. A boundary
+// missed while the service was down is archived on the next start.
+type SeasonScheduler struct {
+	anchor time.Time
+	length time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var seasonScheduler *SeasonScheduler
+
+func StartSeasonScheduler() error {
+	if err := defaultSeasonReset.validate(); err != nil {
+		return fmt.Errorf("invalid season reset config: %w", err)
+	}
+	days := getEnvInt("SEASON_LENGTH_DAYS", 0)
+	if days <= 0 || isReplica() {
+		return nil
+	}
+	anchor, err := time.Parse(time.RFC3339, getEnv("SEASON_ANCHOR", ""))
+	if err != nil {
+		return fmt.Errorf("SEASON_ANCHOR must be an RFC3339 time when SEASON_LENGTH_DAYS is set: %w", err)
+	}
+
+	seasonScheduler = &SeasonScheduler{
+		anchor: anchor,
+		length: time.Duration(days) * 24 * time.Hour,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go seasonScheduler.run()
+	slog.Info("✓ Season scheduler started", "every_days", days, "anchor", anchor.UTC().Format(time.RFC3339), "reset", defaultSeasonReset.Mode)
+	return nil
+}
+
+func StopSeasonScheduler() {
+	if seasonScheduler == nil {
+		return
+	}
+	close(seasonScheduler.stop)
+	<-seasonScheduler.done
+}
+
+func (s *SeasonScheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(seasonSchedulerTick)
+	defer ticker.Stop()
+
+	s.rollover()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.rollover()
+		}
+	}
+}
+
+// boundary is the most recent season boundary at or before now, or the
+// zero time when the first season hasn't ended yet.
+func (s *SeasonScheduler) boundary(now time.Time) time.Time {
+	if now.Before(s.anchor.Add(s.length)) {
+		return time.Time{}
+	}
+	n := now.Sub(s.anchor) / s.length
+	return s.anchor.Add(n * s.length)
+}
+
+func (s *SeasonScheduler) rollover() {
+	boundary := s.boundary(time.Now())
+	if boundary.IsZero() {
+		return
+	}
+
+	var count int
+	var lastEnded sql.NullTime
+	if err := db.QueryRow(`SELECT COUNT(*), MAX(ended_at) FROM seasons`).Scan(&count, &lastEnded); err != nil {
+		slog.Error("Season rollover check failed", "error", err)
+		return
+	}
+	if lastEnded.Valid && !lastEnded.Time.Before(boundary) {
+		return
+	}
+
+	release := writeSlots.acquire(WriteBackground)
+	defer release()
+
+	season, _, err := ArchiveSeason(fmt.Sprintf("Season %d", count+1), defaultSeasonReset, false)
+	if err != nil {
+		slog.Error("Season rollover failed", "error", err)
+		return
+	}
+	if season.ResetUsers > 0 {
+		outboxRelay.Flush()
+	}
+	slog.Info("✓ Rolled over to a new season", "archived", season.Name, "total_users", season.TotalUsers,
+		"reset", season.Reset, "reset_users", season.ResetUsers)
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Rating rules are small Starlark scripts operators manage through
+// /admin/rules and that run inside every rating write made on request:
+// matches, team matches, simulations, metric submissions, and quarantine
+// approvals, e.g.
+//
+//	if change.delta > 0 and change.gain_today + change.delta > 300:
+//	    cap_gain(300 - change.gain_today)
+//	if change.old < 4000 and change.new >= 4000:
+//	    badge("4k")
+//
+// A script sees the change being written as change and acts on it through
+// reject, cap_gain, and badge. It can't load modules or call builtins other
+// than abs, min, and max, and it runs under a step limit, so a rule always
+// finishes and can only touch the change it's given. Scripts are compiled
+// when they're saved, and saving one swaps it in without a restart.
+const ratingRulesSchema = `
+	CREATE TABLE IF NOT EXISTS rating_rules (
+		name TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS user_badges (
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		badge TEXT NOT NULL,
+		rule TEXT NOT NULL,
+		awarded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, badge)
+	);
+`
+
+const (
+	ruleMaxSource   = 4096
+	ruleMaxSteps    = 10000
+	ruleMaxBadgeLen = 64
+
+	RuleActionReject  = "reject"
+	RuleActionCapGain = "cap_gain"
+	RuleActionBadge   = "badge"
+
+	// ruleMetricSource prefixes the metric's name in source on metric writes.
+	ruleMetricSource = "metric:"
+)
+
+var (
+	ruleNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+	ratingRules atomic.Pointer[[]*RatingRule]
+
+	// ruleFileOptions allows if and for at the top of a script, which is
+	// where rules do their work. while, recursion, and global reassignment
+	// stay off.
+	ruleFileOptions = &syntax.FileOptions{TopLevelControl: true}
+
+	// ruleUniversals are the only builtins a script may use.
+	ruleUniversals = map[string]bool{"True": true, "False": true, "None": true, "abs": true, "min": true, "max": true}
+
+	// ruleActions are the functions a script acts through; it is also given
+	// the change.
+	ruleActions = starlark.StringDict{
+		RuleActionReject:  starlark.NewBuiltin(RuleActionReject, ruleReject),
+		RuleActionCapGain: starlark.NewBuiltin(RuleActionCapGain, ruleCapGain),
+		RuleActionBadge:   starlark.NewBuiltin(RuleActionBadge, ruleBadge),
+	}
+)
+
+func isRuleName(name string) bool {
+	return name == "change" || ruleActions.Has(name)
+}
+
+type RatingRule struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Error is set when a stored rule no longer compiles; it is skipped.
+	Error string `json:"error,omitempty"`
+
+	program *starlark.Program
+}
+
+type RuleBadge struct {
+	Username string `json:"username"`
+	Badge    string `json:"badge"`
+	Rule     string `json:"rule"`
+
+	userID int64
+}
+
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// compileRule parses and resolves a script. Names are checked here, so a
+// typo or a forbidden builtin is refused when the rule is saved; values are
+// only checked when it runs.
+func compileRule(source string) (*starlark.Program, error) {
+	if len(source) > ruleMaxSource {
+		return nil, fmt.Errorf("rule is longer than %d bytes", ruleMaxSource)
+	}
+	f, err := ruleFileOptions.Parse("rule", source, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Stmts) == 0 {
+		return nil, errors.New("rule has no statements")
+	}
+	program, err := starlark.FileProgram(f, isRuleName)
+	if err != nil {
+		return nil, err
+	}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *syntax.LoadStmt:
+			err = fmt.Errorf("%s: rules can't load modules", n.Load)
+		case *syntax.Ident:
+			if b, ok := n.Binding.(*resolve.Binding); ok && b.Scope == resolve.Universal && !ruleUniversals[n.Name] {
+				err = fmt.Errorf("%s: %s is not available to rules", n.NamePos, n.Name)
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// rulePass says which actions a run of a script carries out. Every script
+// runs once to reject and cap, and again once all of them have, to award
+// badges on the final rating.
+type rulePass int
+
+const (
+	ruleLimitPass rulePass = iota
+	ruleAwardPass
+)
+
+// ruleRun is the state of one script running over one change.
+type ruleRun struct {
+	q      rowQueryer
+	rule   *RatingRule
+	change *RatingUpdatedEvent
+	pass   rulePass
+
+	gainToday *int
+	err       error // a database error, which fails the write
+	rejected  *HookRejectedError
+	badges    []RuleBadge
+}
+
+// errRuleRejected stops a script once it has rejected the change.
+var errRuleRejected = errors.New("change rejected")
+
+func ruleRunOf(thread *starlark.Thread) *ruleRun {
+	return thread.Local("rule").(*ruleRun)
+}
+
+// loadGainToday sums the user's rating gains since midnight UTC, not counting
+// the change being written. Metrics keep no history, so it is 0 for them.
+func (r *ruleRun) loadGainToday() (int, error) {
+	if r.gainToday == nil && strings.HasPrefix(r.change.Source, ruleMetricSource) {
+		r.gainToday = new(int)
+	}
+	if r.gainToday == nil {
+		var gain int
+		err := r.q.QueryRow(`
+			SELECT COALESCE(SUM(GREATEST(new_rating - old_rating, 0)), 0)
+			FROM rating_history
+			WHERE user_id = $1 AND rolled_back_at IS NULL
+				AND created_at >= date_trunc('day', NOW() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+		`, r.change.UserID).Scan(&gain)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read today's rating gain: %w", err)
+		}
+		r.gainToday = &gain
+	}
+	return *r.gainToday, nil
+}
+
+// ruleChange is the change value scripts see. Its fields are read when the
+// script asks for them, so a cap shows up in later reads of new and delta.
+type ruleChange struct {
+	run *ruleRun
+}
+
+var ruleChangeFields = []string{"delta", "gain_today", "new", "old", "source", "username"}
+
+func (ch ruleChange) String() string        { return "change" }
+func (ch ruleChange) Type() string          { return "change" }
+func (ch ruleChange) Freeze()               {}
+func (ch ruleChange) Truth() starlark.Bool  { return starlark.True }
+func (ch ruleChange) AttrNames() []string   { return ruleChangeFields }
+func (ch ruleChange) Hash() (uint32, error) { return 0, errors.New("unhashable type: change") }
+
+func (ch ruleChange) Attr(name string) (starlark.Value, error) {
+	c := ch.run.change
+	switch name {
+	case "old":
+		return starlark.MakeInt(c.OldRating), nil
+	case "new":
+		return starlark.MakeInt(c.NewRating), nil
+	case "delta":
+		return starlark.MakeInt(c.NewRating - c.OldRating), nil
+	case "gain_today":
+		gain, err := ch.run.loadGainToday()
+		if err != nil {
+			ch.run.err = err
+			return nil, err
+		}
+		return starlark.MakeInt(gain), nil
+	case "username":
+		return starlark.String(c.Username), nil
+	case "source":
+		return starlark.String(c.Source), nil
+	}
+	return nil, nil
+}
+
+// ruleText unpacks the quoted text reject and badge take.
+func ruleText(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (string, error) {
+	var text string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &text); err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("%s needs a non-empty text", b.Name())
+	}
+	return text, nil
+}
+
+func ruleReject(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	reason, err := ruleText(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	run := ruleRunOf(thread)
+	if run.pass != ruleLimitPass {
+		return starlark.None, nil
+	}
+	run.rejected = &HookRejectedError{Hook: "rule " + run.rule.Name, Reason: reason}
+	return nil, errRuleRejected
+}
+
+// ruleCapGain limits the change's gain, rounding down and never below zero.
+// A loss is left alone.
+func ruleCapGain(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	limit, ok := starlark.AsFloat(v)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a number, got %s", b.Name(), v.Type())
+	}
+	run := ruleRunOf(thread)
+	if run.pass != ruleLimitPass {
+		return starlark.None, nil
+	}
+	gain := max(int(math.Floor(limit)), 0)
+	if c := run.change; c.NewRating-c.OldRating > gain {
+		c.NewRating = c.OldRating + gain
+	}
+	return starlark.None, nil
+}
+
+func ruleBadge(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	name, err := ruleText(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if len(name) > ruleMaxBadgeLen {
+		return nil, fmt.Errorf("badge names are at most %d bytes", ruleMaxBadgeLen)
+	}
+	run := ruleRunOf(thread)
+	if run.pass != ruleAwardPass {
+		return starlark.None, nil
+	}
+	run.badges = append(run.badges, RuleBadge{Username: run.change.Username, Badge: name, Rule: run.rule.Name, userID: run.change.UserID})
+	return starlark.None, nil
+}
+
+// exec runs the rule's script once. A script that fails or runs out of steps
+// rejects the change, so a broken rule holds writes back rather than
+// letting through what it was written to stop.
+func (r *ruleRun) exec() error {
+	thread := &starlark.Thread{Name: "rule " + r.rule.Name}
+	thread.SetMaxExecutionSteps(ruleMaxSteps)
+	thread.SetLocal("rule", r)
+
+	names := starlark.StringDict{"change": ruleChange{run: r}}
+	for k, v := range ruleActions {
+		names[k] = v
+	}
+	_, err := r.rule.program.Init(thread, names)
+	switch {
+	case r.err != nil:
+		return r.err
+	case r.rejected != nil:
+		return r.rejected
+	case err != nil:
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			err = errors.New(evalErr.Msg)
+		}
+		return &HookRejectedError{Hook: "rule " + r.rule.Name, Reason: "rule failed: " + err.Error()}
+	}
+	return nil
+}
+
+// evaluateRules applies rules to changes in place. Every rule runs first to
+// reject and cap, in name order, each seeing the caps before it; every rule
+// then runs again to award badges on the final ratings.
+func evaluateRules(q rowQueryer, rules []*RatingRule, changes []RatingUpdatedEvent) ([]RuleBadge, error) {
+	var badges []RuleBadge
+	for i := range changes {
+		var gainToday *int
+		for _, pass := range []rulePass{ruleLimitPass, ruleAwardPass} {
+			for _, r := range rules {
+				run := &ruleRun{q: q, rule: r, change: &changes[i], pass: pass, gainToday: gainToday}
+				if err := run.exec(); err != nil {
+					return nil, err
+				}
+				gainToday = run.gainToday
+				badges = append(badges, run.badges...)
+			}
+		}
+	}
+	return badges, nil
+}
+
+// applyRatingRules runs the enabled rules over the changes a write is about
+// to make, inside its transaction, and awards the badges they earn.
+func applyRatingRules(tx *sql.Tx, changes []RatingUpdatedEvent) error {
+	rules := activeRatingRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	badges, err := evaluateRules(tx, rules, changes)
+	if err != nil {
+		return err
+	}
+	for _, b := range badges {
+		if _, err := tx.Exec(`
+			INSERT INTO user_badges (user_id, badge, rule) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, badge) DO NOTHING
+		`, b.userID, b.Badge, b.Rule); err != nil {
+			return fmt.Errorf("failed to award badge: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyRatingRulesToUpdates runs the enabled rules over simulation updates,
+// which reach the engine before the database and so have no transaction to
+// run in. Each update is checked on its own: one a rule rejects is dropped,
+// not the batch, and capped ones come back with their capped rating.
+func applyRatingRulesToUpdates(updates []RatingUpdate, source string) ([]RatingUpdate, error) {
+	rules := activeRatingRules()
+	if len(rules) == 0 {
+		return updates, nil
+	}
+	kept := updates[:0]
+	for _, u := range updates {
+		changes := []RatingUpdatedEvent{{UserID: u.UserID, Username: u.Username, OldRating: u.OldRating, NewRating: u.NewRating, Source: source}}
+		badges, err := evaluateRules(db, rules, changes)
+		var rejected *HookRejectedError
+		if errors.As(err, &rejected) {
+			slog.Debug("Rating rule rejected simulated update", "username", u.Username, "rule", rejected.Hook, "reason", rejected.Reason)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range badges {
+			if _, err := db.Exec(`
+				INSERT INTO user_badges (user_id, badge, rule) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, badge) DO NOTHING
+			`, b.userID, b.Badge, b.Rule); err != nil {
+				return nil, fmt.Errorf("failed to award badge: %w", err)
+			}
+		}
+		u.NewRating = changes[0].NewRating
+		kept = append(kept, u)
+	}
+	return kept, nil
+}
+
+func activeRatingRules() []*RatingRule {
+	rules := ratingRules.Load()
+	if rules == nil {
+		return nil
+	}
+	var active []*RatingRule
+	for _, r := range *rules {
+		if r.Enabled && r.Error == "" {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// LoadRatingRules swaps in the rules stored in the database. A stored rule
+// that no longer compiles is kept with its error and skipped.
+func LoadRatingRules() error {
+	rows, err := db.Query(`SELECT name, source, enabled, updated_at FROM rating_rules ORDER BY name`)
+	if err != nil {
+		return fmt.Errorf("failed to load rating rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []*RatingRule{}
+	for rows.Next() {
+		r := &RatingRule{}
+		if err := rows.Scan(&r.Name, &r.Source, &r.Enabled, &r.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to scan rating rule: %w", err)
+		}
+		if r.program, err = compileRule(r.Source); err != nil {
+			r.Error = err.Error()
+			slog.Warn("Rating rule is skipped", "rule", r.Name, "error", err)
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rating rules: %w", err)
+	}
+	ratingRules.Store(&rules)
+	if active := activeRatingRules(); len(active) > 0 {
+		slog.Info("✓ Loaded rating rules", "rules", len(active))
+	}
+	return nil
+}
+
+// HandleListRules serves GET /admin/rules.
+func HandleListRules(c *gin.Context) {
+	rules := []*RatingRule{}
+	if loaded := ratingRules.Load(); loaded != nil {
+		rules = *loaded
+	}
+	respond(c, http.StatusOK, gin.H{
+		"rules": rules,
+	})
+}
+
+type PutRuleRequest struct {
+	Source  string `json:"source" binding:"required"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// HandlePutRule serves PUT /admin/rules/:name, creating or replacing a rule.
+// It takes effect for the next write.
+func HandlePutRule(c *gin.Context) {
+	name := c.Param("name")
+	if !ruleNamePattern.MatchString(name) {
+		respondError(c, http.StatusBadRequest, "Rule names are 1-64 letters, digits, - or _")
+		return
+	}
+	var req PutRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := compileRule(req.Source); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid rule: "+err.Error())
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	if _, err := db.Exec(`
+		INSERT INTO rating_rules (name, source, enabled) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET source = EXCLUDED.source, enabled = EXCLUDED.enabled, updated_at = NOW()
+	`, name, req.Source, enabled); err != nil {
+		requestLog(c).Error("Error saving rating rule", "rule", name, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to save rule")
+		return
+	}
+	if !reloadRatingRules(c) {
+		return
+	}
+	requestLog(c).Info("✓ Rating rule saved", "rule", name, "enabled", enabled)
+	respond(c, http.StatusOK, gin.H{
+		"rule": findRatingRule(name),
+	})
+}
+
+// HandleDeleteRule serves DELETE /admin/rules/:name.
+func HandleDeleteRule(c *gin.Context) {
+	res, err := db.Exec(`DELETE FROM rating_rules WHERE name = $1`, c.Param("name"))
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		requestLog(c).Error("Error deleting rating rule", "rule", c.Param("name"), "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete rule")
+		return
+	}
+	if n == 0 {
+		respondError(c, http.StatusNotFound, "Rule not found")
+		return
+	}
+	if !reloadRatingRules(c) {
+		return
+	}
+	requestLog(c).Info("✓ Rating rule deleted", "rule", c.Param("name"))
+	respond(c, http.StatusOK, gin.H{
+		"deleted": c.Param("name"),
+	})
+}
+
+// HandleReloadRules serves POST /admin/rules/reload, for rules edited in the
+// database directly.
+func HandleReloadRules(c *gin.Context) {
+	if !reloadRatingRules(c) {
+		return
+	}
+	respond(c, http.StatusOK, gin.H{
+		"active": len(activeRatingRules()),
+	})
+}
+
+func reloadRatingRules(c *gin.Context) bool {
+	if err := LoadRatingRules(); err != nil {
+		requestLog(c).Error("Error reloading rating rules", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to reload rules")
+		return false
+	}
+	return true
+}
+
+func findRatingRule(name string) *RatingRule {
+	if rules := ratingRules.Load(); rules != nil {
+		for _, r := range *rules {
+			if r.Name == name {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+type TestRuleRequest struct {
+	// Rule is a script to try; empty tries the enabled rules.
+	Rule      string `json:"rule"`
+	Username  string `json:"username" binding:"required"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+	Source    string `json:"source"`
+}
+
+// HandleTestRule serves POST /admin/rules/test, evaluating rules against a
+// hypothetical change without writing anything.
+func HandleTestRule(c *gin.Context) {
+	var req TestRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	rules := activeRatingRules()
+	if req.Rule != "" {
+		program, err := compileRule(req.Rule)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid rule: "+err.Error())
+			return
+		}
+		rules = []*RatingRule{{Name: "test", Enabled: true, program: program}}
+	}
+	if req.Source == "" {
+		req.Source = HistorySourceMatch
+	}
+
+	change := RatingUpdatedEvent{Username: req.Username, OldRating: req.OldRating, NewRating: req.NewRating, Source: req.Source}
+	if user, err := GetUserByUsername(req.Username); err == nil {
+		change.UserID, change.Username = user.ID, user.Username
+	}
+	changes := []RatingUpdatedEvent{change}
+	badges, err := evaluateRules(db, rules, changes)
+	var rejected *HookRejectedError
+	if errors.As(err, &rejected) {
+		respond(c, http.StatusOK, gin.H{
+			"rejected": true,
+			"rule":     strings.TrimPrefix(rejected.Hook, "rule "),
+			"reason":   rejected.Reason,
+		})
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error testing rating rules", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to evaluate rules")
+		return
+	}
+	if badges == nil {
+		badges = []RuleBadge{}
+	}
+	respond(c, http.StatusOK, gin.H{
+		"rejected":   false,
+		"new_rating": changes[0].NewRating,
+		"badges":     badges,
+	})
+}
+
+type UserBadge struct {
+	Badge     string    `json:"badge"`
+	Rule      string    `json:"rule"`
+	AwardedAt time.Time `json:"awarded_at"`
+}
+
+// HandleUserBadges serves GET /users/:username/badges.
+func HandleUserBadges(c *gin.Context) {
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT badge, rule, awarded_at FROM user_badges WHERE user_id = $1 ORDER BY awarded_at, badge
+	`, user.ID)
+	if err != nil {
+		requestLog(c).Error("Error fetching badges", "username", user.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch badges")
+		return
+	}
+	defer rows.Close()
+	badges := []UserBadge{}
+	for rows.Next() {
+		var b UserBadge
+		if err := rows.Scan(&b.Badge, &b.Rule, &b.AwardedAt); err != nil {
+			requestLog(c).Error("Error scanning badge", "username", user.Username, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to fetch badges")
+			return
+		}
+		badges = append(badges, b)
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"username": user.Username,
+		"badges":   badges,
+	})
+}
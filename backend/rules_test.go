@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Rating rules are Starlark scripts run under a step limit with no builtins
+// beyond abs, min, and max, so these tables are their spec: what compiles,
+// what is refused and why, and what each action does to a change. None of
+// them read gain_today on a non-metric source, so they run without a
+// database.
+
+func TestCompileRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string // substring of the expected error; empty means it compiles
+	}{
+		{"reject", "if change.delta < -200:\n    reject(\"suspicious loss\")", ""},
+		{"badge", "if change.old < 4000 and change.new >= 4000:\n    badge(\"4k\")", ""},
+		{"cap gain", "if change.delta > 0 and change.gain_today + change.delta > 300:\n    cap_gain(300 - change.gain_today)", ""},
+		{"comments and blank lines", "# caps\n\nif change.delta > 50:\n    cap_gain(50)  # per match\n", ""},
+		{"allowed builtins", "if abs(change.delta) > max(100, min(change.old // 10, 400)):\n    reject(\"swing\")", ""},
+		{"for and def", "def big(x):\n    return x > 100\nfor limit in [50, 10]:\n    if big(change.delta):\n        cap_gain(limit)", ""},
+
+		{"empty", "", "rule has no statements"},
+		{"only comments", "# nothing here", "rule has no statements"},
+		{"too long", strings.Repeat(" ", ruleMaxSource+1), "longer than"},
+		{"syntax error", "if change.delta > 0\n    cap_gain(1)", "got newline, want ':'"},
+		{"unknown name", "if elo > 0:\n    cap_gain(1)", "undefined: elo"},
+		{"builtin outside the allowed ones", "print(change)", "print is not available to rules"},
+		{"builtin used as a value", "f = len", "len is not available to rules"},
+		{"load", "load(\"x.star\", \"y\")\ncap_gain(y)", "rules can't load modules"},
+		{"while", "while True:\n    pass", "does not support while loops"},
+		{"reassigning a global", "x = 1\nx = 2", "cannot reassign global x"},
+		{"error names the line", "cap_gain(1)\nif oops:\n    pass", "rule:2:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRule(tt.source)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("compileRule() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("compileRule() error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	type rule struct{ name, source string }
+	tests := []struct {
+		name       string
+		rules      []rule
+		old, new   int
+		source     string
+		wantNew    int
+		wantBadge  []string
+		rejectBy   string // rule name of the expected rejection
+		rejectWith string // substring of its reason
+	}{
+		{
+			name:    "condition not met leaves the change alone",
+			rules:   []rule{{"cap", "if change.delta > 100:\n    cap_gain(100)"}},
+			old:     1000,
+			new:     1050,
+			source:  "match",
+			wantNew: 1050,
+		},
+		{
+			name:    "cap_gain caps the gain",
+			rules:   []rule{{"cap", "if change.delta > 100:\n    cap_gain(100)"}},
+			old:     1000,
+			new:     1250,
+			source:  "match",
+			wantNew: 1100,
+		},
+		{
+			name:    "cap_gain floors fractions and never goes below zero",
+			rules:   []rule{{"cap", "cap_gain(2.9)"}, {"neg", "if change.delta > 0:\n    cap_gain(-10)"}},
+			old:     1000,
+			new:     1010,
+			source:  "match",
+			wantNew: 1000,
+		},
+		{
+			name:    "cap_gain doesn't touch losses",
+			rules:   []rule{{"cap", "cap_gain(0)"}},
+			old:     1000,
+			new:     900,
+			source:  "match",
+			wantNew: 900,
+		},
+		{
+			name:    "later caps see earlier ones",
+			rules:   []rule{{"a", "if change.delta > 50:\n    cap_gain(60)"}, {"b", "if change.delta > 80:\n    cap_gain(10)"}},
+			old:     1000,
+			new:     1100,
+			source:  "match",
+			wantNew: 1060,
+		},
+		{
+			name:    "a cap shows up in the same script",
+			rules:   []rule{{"a", "cap_gain(30)\nif change.new > 1040:\n    reject(\"not capped\")"}},
+			old:     1000,
+			new:     1100,
+			source:  "match",
+			wantNew: 1030,
+		},
+		{
+			name:       "reject names the rule",
+			rules:      []rule{{"team-loss", "if change.source == \"team_match\" and change.delta < -200:\n    reject(\"suspicious loss\")"}},
+			old:        1500,
+			new:        1200,
+			source:     "team_match",
+			rejectBy:   "team-loss",
+			rejectWith: "suspicious loss",
+		},
+		{
+			name:    "reject only fires on its source",
+			rules:   []rule{{"team-loss", "if change.source == \"team_match\" and change.delta < -200:\n    reject(\"suspicious loss\")"}},
+			old:     1500,
+			new:     1200,
+			source:  "match",
+			wantNew: 1200,
+		},
+		{
+			name:      "badges are decided on the capped rating",
+			rules:     []rule{{"b", "if change.old < 4000 and change.new >= 4000:\n    badge(\"4k\")"}, {"c", "if change.delta > 50:\n    cap_gain(50)"}},
+			old:       3980,
+			new:       4100,
+			source:    "match",
+			wantNew:   4030,
+			wantBadge: []string{"4k"},
+		},
+		{
+			name:    "a cap can take a change out of a badge",
+			rules:   []rule{{"b", "if change.new >= 4000:\n    badge(\"4k\")"}, {"c", "if change.delta > 10:\n    cap_gain(10)"}},
+			old:     3900,
+			new:     4100,
+			source:  "match",
+			wantNew: 3910,
+		},
+		{
+			name:      "functions, loops, and allowed builtins",
+			rules:     []rule{{"b", "def mover(d):\n    return abs(d) >= 20\nfor name in [\"mover\", \"up\"]:\n    if not (change.delta < 0) and (mover(change.delta) or change.username == \"zed\"):\n        badge(name)"}},
+			old:       1000,
+			new:       1020,
+			source:    "match",
+			wantNew:   1020,
+			wantBadge: []string{"mover", "up"},
+		},
+		{
+			name:      "gain_today is 0 for metrics",
+			rules:     []rule{{"m", "if change.gain_today == 0 and change.source == \"metric:kills\":\n    badge(\"first\")"}},
+			old:       10,
+			new:       20,
+			source:    ruleMetricSource + "kills",
+			wantNew:   20,
+			wantBadge: []string{"first"},
+		},
+		{
+			name:       "running out of steps rejects",
+			rules:      []rule{{"spin", "xs = [0] * 200\nfor a in xs:\n    for b in xs:\n        pass"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "spin",
+			rejectWith: "too many steps",
+		},
+		{
+			name:       "a script error rejects",
+			rules:      []rule{{"typo", "if change.username > 3:\n    cap_gain(1)"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "typo",
+			rejectWith: "rule failed",
+		},
+		{
+			name:       "cap_gain needs a number",
+			rules:      []rule{{"cap", "cap_gain(\"50\")"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "cap",
+			rejectWith: "cap_gain needs a number",
+		},
+		{
+			name:       "blank badge",
+			rules:      []rule{{"b", "badge(\" \")"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "b",
+			rejectWith: "badge needs a non-empty text",
+		},
+		{
+			name:       "long badge",
+			rules:      []rule{{"b", "badge(\"" + strings.Repeat("b", ruleMaxBadgeLen+1) + "\")"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "b",
+			rejectWith: "badge names are at most",
+		},
+		{
+			name:       "unknown change field",
+			rules:      []rule{{"f", "if change.elo > 0:\n    cap_gain(1)"}},
+			old:        1000,
+			new:        1010,
+			source:     "match",
+			rejectBy:   "f",
+			rejectWith: "has no .elo field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []*RatingRule
+			for _, r := range tt.rules {
+				program, err := compileRule(r.source)
+				if err != nil {
+					t.Fatalf("compileRule(%q) error = %v", r.source, err)
+				}
+				rules = append(rules, &RatingRule{Name: r.name, Source: r.source, Enabled: true, program: program})
+			}
+			changes := []RatingUpdatedEvent{{UserID: 1, Username: "ann", OldRating: tt.old, NewRating: tt.new, Source: tt.source}}
+			badges, err := evaluateRules(nil, rules, changes)
+
+			if tt.rejectBy != "" {
+				var rejected *HookRejectedError
+				if !errors.As(err, &rejected) || rejected.Hook != "rule "+tt.rejectBy || !strings.Contains(rejected.Reason, tt.rejectWith) {
+					t.Fatalf("evaluateRules() error = %v, want a rejection by %q containing %q", err, tt.rejectBy, tt.rejectWith)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluateRules() error = %v", err)
+			}
+			if changes[0].NewRating != tt.wantNew {
+				t.Errorf("new rating = %d, want %d", changes[0].NewRating, tt.wantNew)
+			}
+			var got []string
+			for _, b := range badges {
+				got = append(got, b.Badge)
+			}
+			if !reflect.DeepEqual(got, tt.wantBadge) {
+				t.Errorf("badges = %v, want %v", got, tt.wantBadge)
+			}
+		})
+	}
+}
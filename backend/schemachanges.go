@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// New columns on a large table are soft-launched so that neither the ALTER
+// nor the data migration blocks traffic:
+//
+//  1. The feature schema adds the columns nullable, then sets their default
+//     for new rows. Both are catalog-only changes, and existing rows read
+//     NULL, which marks them as not migrated yet.
+//  2. Every write updates the old representation and the new columns
+//     together (dual-write); the columns of an unmigrated row stay NULL.
+//  3. The change's backfill (see backfills.go) migrates the existing rows,
+//     and once none are left marks the change complete. Reads stay on the
+//     old representation until then.
+const (
+	SchemaChangeDualWrite = "dual_write"
+	SchemaChangeComplete  = "complete"
+)
+
+const schemaChangeSchema = `
+	CREATE TABLE IF NOT EXISTS schema_changes (
+		name TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		backfilled_rows BIGINT NOT NULL DEFAULT 0,
+		started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMPTZ
+	);
+`
+
+// SchemaChange describes one soft-launched change to Table.
+type SchemaChange struct {
+	Name  string
+	Table string
+	// Pending matches the rows the backfill still has to migrate.
+	Pending string
+	// Backfill migrates the pending rows with ids in [$1, $2].
+	Backfill string
+	// Apply, when set, migrates the locked rows in Go instead of Backfill.
+	Apply func(tx *sql.Tx, lo, hi int64) (int64, error)
+	// Unresolved explains rows the backfill leaves pending on purpose.
+	Unresolved string
+}
+
+var (
+	schemaChanges = []*SchemaChange{userRecordChange, usernameKeyChange}
+
+	schemaChangesMu    sync.RWMutex
+	schemaChangeStates = map[string]string{}
+
+	errBackfillIncomplete = errors.New("rows still pending after backfill")
+)
+
+type SchemaChangeStatus struct {
+	Name           string     `json:"name"`
+	Table          string     `json:"table"`
+	State          string     `json:"state"`
+	PendingRows    int        `json:"pending_rows"`
+	BackfilledRows int64      `json:"backfilled_rows"`
+	StartedAt      time.Time  `json:"started_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// InitSchemaChanges registers new changes in dual-write and loads the state
+// of every change. Replicas only load; they see a completed backfill on
+// their next restart.
+func InitSchemaChanges() error {
+	for _, sc := range schemaChanges {
+		if isReplica() {
+			break
+		}
+		if _, err := db.Exec(`
+			INSERT INTO schema_changes (name, state) VALUES ($1, $2)
+			ON CONFLICT (name) DO NOTHING
+		`, sc.Name, SchemaChangeDualWrite); err != nil {
+			return fmt.Errorf("failed to register schema change %s: %w", sc.Name, err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT name, state FROM schema_changes`)
+	if err != nil {
+		return fmt.Errorf("failed to load schema changes: %w", err)
+	}
+	defer rows.Close()
+
+	schemaChangesMu.Lock()
+	defer schemaChangesMu.Unlock()
+	for rows.Next() {
+		var name, state string
+		if err := rows.Scan(&name, &state); err != nil {
+			return fmt.Errorf("failed to scan schema change: %w", err)
+		}
+		schemaChangeStates[name] = state
+		slog.Info("✓ Schema change", "name", name, "state", state)
+	}
+	return rows.Err()
+}
+
+// schemaChangeComplete reports whether reads can use the change's new
+// columns.
+func schemaChangeComplete(name string) bool {
+	schemaChangesMu.RLock()
+	defer schemaChangesMu.RUnlock()
+	return schemaChangeStates[name] == SchemaChangeComplete
+}
+
+func schemaChangeBackfills() []*Backfill {
+	list := make([]*Backfill, 0, len(schemaChanges))
+	for _, sc := range schemaChanges {
+		list = append(list, &Backfill{
+			Name:        sc.Name,
+			Table:       sc.Table,
+			Description: fmt.Sprintf("Schema change %s: fill the new columns of existing rows", sc.Name),
+			Apply:       sc.backfillRange,
+			Done:        sc.complete,
+		})
+	}
+	return list
+}
+
+func (sc *SchemaChange) pendingRows() (int, error) {
+	var n int
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s`, sc.Table, sc.Pending)).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending rows: %w", err)
+	}
+	return n, nil
+}
+
+// backfillRange migrates one chunk. The pending rows are locked before the
+// backfill statement runs, so it reads everything committed by writes that
+// held them, and writes that come later find the row migrated.
+func (sc *SchemaChange) backfillRange(tx *sql.Tx, lo, hi int64) (int64, error) {
+	if _, err := tx.Exec(fmt.Sprintf(`
+		SELECT id FROM %s WHERE id BETWEEN $1 AND $2 AND %s FOR UPDATE
+	`, sc.Table, sc.Pending), lo, hi); err != nil {
+		return 0, fmt.Errorf("failed to lock rows: %w", err)
+	}
+	if sc.Apply != nil {
+		return sc.Apply(tx, lo, hi)
+	}
+	result, err := tx.Exec(sc.Backfill, lo, hi)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// complete switches reads to the new columns once no row is pending.
+func (sc *SchemaChange) complete() error {
+	remaining, err := sc.pendingRows()
+	if err != nil {
+		return err
+	}
+	if remaining > 0 && sc.Unresolved != "" {
+		return fmt.Errorf("%w: %d, %s", errBackfillIncomplete, remaining, sc.Unresolved)
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%w: %d", errBackfillIncomplete, remaining)
+	}
+	if _, err := db.Exec(`
+		UPDATE schema_changes
+		SET state = $2, completed_at = NOW(),
+			backfilled_rows = COALESCE((SELECT processed FROM backfills WHERE name = $1), 0)
+		WHERE name = $1
+	`, sc.Name, SchemaChangeComplete); err != nil {
+		return fmt.Errorf("failed to mark schema change complete: %w", err)
+	}
+	schemaChangesMu.Lock()
+	schemaChangeStates[sc.Name] = SchemaChangeComplete
+	schemaChangesMu.Unlock()
+	slog.Info("✓ Schema change complete; reads now use the new columns", "name", sc.Name)
+	return nil
+}
+
+func HandleListSchemaChanges(c *gin.Context) {
+	list := make([]SchemaChangeStatus, 0, len(schemaChanges))
+	for _, sc := range schemaChanges {
+		st := SchemaChangeStatus{Name: sc.Name, Table: sc.Table}
+		err := db.QueryRow(`
+			SELECT state, backfilled_rows, started_at, completed_at FROM schema_changes WHERE name = $1
+		`, sc.Name).Scan(&st.State, &st.BackfilledRows, &st.StartedAt, &st.CompletedAt)
+		if err == nil && st.State != SchemaChangeComplete {
+			st.PendingRows, err = sc.pendingRows()
+		}
+		if err != nil {
+			requestLog(c).Error("Error reading schema change", "name", sc.Name, "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to read schema changes")
+			return
+		}
+		list = append(list, st)
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"changes": list,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Search guardrails. A substring search can't use the username indexes, so a
+// short or common term scans and sorts every matching row before the page is
+// cut. SEARCH_SCAN_CAP stops the scan after that many matches and pages
+// through those only, flagging the response "truncated" when the cap was
+// hit; SEARCH_STATEMENT_TIMEOUT_MS has Postgres cancel a search query that
+// runs longer, so it can't hold a connection. Both are off at 0.
+var (
+	searchScanCap          = getEnvInt("SEARCH_SCAN_CAP", 0)
+	searchStatementTimeout = getEnvInt("SEARCH_STATEMENT_TIMEOUT_MS", 0)
+
+	errSearchTimeout = errors.New("search exceeded its statement timeout")
+)
+
+// searchQuery is one search: Match selects the matching rows, with the term
+// and any other arguments in Args, and Order sorts its output columns.
+type searchQuery struct {
+	Match  string
+	Order  string
+	Args   []any
+	Limit  int
+	Offset int
+}
+
+type contextQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// runSearch runs q, scanning each row into dest and calling each after it.
+// truncated reports that the scan stopped at SEARCH_SCAN_CAP, so later
+// matches are missing from every page.
+func runSearch(ctx context.Context, q searchQuery, dest []any, each func()) (truncated bool, err error) {
+	next := len(q.Args) + 1
+	query := fmt.Sprintf(`%s ORDER BY %s LIMIT $%d OFFSET $%d`, q.Match, q.Order, next, next+1)
+	var matched int
+	if searchScanCap > 0 {
+		// The window count sees every row the capped scan returned, before
+		// the page is cut.
+		query = fmt.Sprintf(`
+			SELECT *, COUNT(*) OVER () FROM (%s LIMIT %d) m
+			ORDER BY %s LIMIT $%d OFFSET $%d
+		`, q.Match, searchScanCap, q.Order, next, next+1)
+		dest = append(dest, &matched)
+	}
+	args := append(q.Args, q.Limit, q.Offset)
+
+	var qr contextQueryer = db
+	if searchStatementTimeout > 0 {
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return false, fmt.Errorf("failed to begin search: %w", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`SET LOCAL statement_timeout = %d`, searchStatementTimeout)); err != nil {
+			return false, fmt.Errorf("failed to set search timeout: %w", err)
+		}
+		qr = tx
+	}
+
+	rows, err := qr.QueryContext(ctx, query, args...)
+	if err != nil {
+		return false, searchError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return false, fmt.Errorf("failed to scan search row: %w", err)
+		}
+		each()
+	}
+	if err := rows.Err(); err != nil {
+		return false, searchError(ctx, err)
+	}
+	return searchScanCap > 0 && matched >= searchScanCap, nil
+}
+
+// searchError tells the statement timeout apart from the request's own
+// deadline, which cancels the query with the same error code.
+func searchError(ctx context.Context, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "57014" && ctx.Err() == nil {
+		return errSearchTimeout
+	}
+	return fmt.Errorf("failed to run search: %w", err)
+}
+
+// writeSearchTimeout answers a search cancelled by its statement timeout.
+func writeSearchTimeout(c *gin.Context) {
+	respondError(c, http.StatusGatewayTimeout, "Search took too long; try a longer search term")
+}
+
+// searchUsers runs a search whose Match selects id, username, and rating.
+func searchUsers(ctx context.Context, q searchQuery) ([]User, bool, error) {
+	users := make([]User, 0)
+	var u User
+	truncated, err := runSearch(ctx, q, []any{&u.ID, &u.Username, &u.Rating}, func() {
+		users = append(users, u)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return users, truncated, nil
+}
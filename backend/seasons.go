@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Season archives freeze the board at the end of a season into
+// season_standings, so past seasons can be browsed from the database without
+// keeping their engines in memory.
+const seasonSchema = `
+	CREATE TABLE IF NOT EXISTS seasons (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		started_at TIMESTAMPTZ,
+		ended_at TIMESTAMPTZ NOT NULL,
+		total_users INT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS season_standings (
+		season_id BIGINT NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL,
+		username TEXT NOT NULL,
+		rating INT NOT NULL,
+		rank INT NOT NULL,
+		tier TEXT NOT NULL,
+		PRIMARY KEY (season_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_season_standings_rank ON season_standings(season_id, rank, username);
+	CREATE INDEX IF NOT EXISTS idx_season_standings_username ON season_standings(season_id, LOWER(username));
+	CREATE INDEX IF NOT EXISTS idx_season_standings_user ON season_standings(user_id, season_id);
+
+	ALTER TABLE seasons ADD COLUMN IF NOT EXISTS reset TEXT NOT NULL DEFAULT 'none';
+	ALTER TABLE seasons ADD COLUMN IF NOT EXISTS reset_users INT NOT NULL DEFAULT 0;
+`
+
+var errSeasonNotFound = errors.New("season not found")
+
+type Season struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	StartedAt  *string `json:"started_at"`
+	EndedAt    string  `json:"ended_at"`
+	TotalUsers int     `json:"total_users"`
+	Reset      string  `json:"reset"`
+	ResetUsers int     `json:"reset_users"`
+}
+
+type SeasonStanding struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Tier     string `json:"tier"`
+}
+
+type SeasonLeaderboardResponse struct {
+	Success    bool             `json:"success"`
+	Season     Season           `json:"season"`
+	Data       []SeasonStanding `json:"data"`
+	Count      int              `json:"count"`
+	Page       int              `json:"page"`
+	Limit      int              `json:"limit"`
+	HasMore    bool             `json:"hasMore"`
+	Pagination *Pagination      `json:"pagination"`
+	Truncated  bool             `json:"truncated,omitempty"`
+}
+
+// tierCaseSQL mirrors tierForRating so archives can assign tiers in the same
+// statement that ranks users.
+func tierCaseSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	tiers := currentTiers()
+	for _, t := range tiers {
+		fmt.Fprintf(&b, " WHEN rating >= %d THEN %s", t.MinRating, pq.QuoteLiteral(t.Name))
+	}
+	fmt.Fprintf(&b, " ELSE %s END", pq.QuoteLiteral(tiers[len(tiers)-1].Name))
+	return b.String()
+}
+
+// ArchiveSeason records the current board as a finished season. The season
+// starts where the previous archive ended. Ratings are then reset or squashed
+// as reset says, in the same transaction. A dry run does all the work and
+// rolls it back, returning the top standings the archive would have stored.
+func ArchiveSeason(name string, reset SeasonReset, dryRun bool) (*Season, []SeasonStanding, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin archive transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize archives so two can't claim the same start.
+	if _, err := tx.Exec(`LOCK TABLE seasons IN EXCLUSIVE MODE`); err != nil {
+		return nil, nil, fmt.Errorf("failed to lock seasons: %w", err)
+	}
+
+	var s Season
+	var startedAt sql.NullTime
+	var endedAt time.Time
+	err = tx.QueryRow(`
+		INSERT INTO seasons (name, started_at, ended_at, total_users)
+		VALUES ($1, (SELECT MAX(ended_at) FROM seasons), NOW(), 0)
+		RETURNING id, name, started_at, ended_at
+	`, name).Scan(&s.ID, &s.Name, &startedAt, &endedAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create season: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO season_standings (season_id, user_id, username, rating, rank, tier)
+		SELECT $1, id, username, rating, RANK() OVER (ORDER BY rating DESC), `+tierCaseSQL()+`
+		FROM users
+		WHERE NOT in_placement
+	`, s.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to archive standings: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	s.TotalUsers = int(n)
+
+	s.Reset = reset.Mode
+	if s.ResetUsers, err = resetRatings(tx, reset); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE seasons SET total_users = $1, reset = $2, reset_users = $3 WHERE id = $4
+	`, s.TotalUsers, s.Reset, s.ResetUsers, s.ID); err != nil {
+		return nil, nil, fmt.Errorf("failed to update season: %w", err)
+	}
+	s.StartedAt = formatNullTime(startedAt)
+	s.EndedAt = endedAt.UTC().Format(time.RFC3339)
+
+	if dryRun {
+		sample, err := seasonStandingsPage(tx, s.ID, dryRunSampleSize, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &s, sample, nil
+	}
+	if s.ResetUsers > 0 {
+		if err := insertLeaderboardRefresh(tx, RefreshSeasonArchive); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit archive: %w", err)
+	}
+	return &s, nil, nil
+}
+
+func formatNullTime(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	s := t.Time.UTC().Format(time.RFC3339)
+	return &s
+}
+
+func scanSeason(row interface{ Scan(...any) error }) (Season, error) {
+	var s Season
+	var startedAt sql.NullTime
+	var endedAt time.Time
+	if err := row.Scan(&s.ID, &s.Name, &startedAt, &endedAt, &s.TotalUsers, &s.Reset, &s.ResetUsers); err != nil {
+		return Season{}, err
+	}
+	s.StartedAt = formatNullTime(startedAt)
+	s.EndedAt = endedAt.UTC().Format(time.RFC3339)
+	return s, nil
+}
+
+func GetSeasons() ([]Season, error) {
+	rows, err := db.Query(`
+		SELECT id, name, started_at, ended_at, total_users, reset, reset_users FROM seasons ORDER BY ended_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query seasons: %w", err)
+	}
+	defer rows.Close()
+
+	seasons := []Season{}
+	for rows.Next() {
+		s, err := scanSeason(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan season: %w", err)
+		}
+		seasons = append(seasons, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating seasons: %w", err)
+	}
+	return seasons, nil
+}
+
+func GetSeason(id int64) (*Season, error) {
+	s, err := scanSeason(db.QueryRow(`
+		SELECT id, name, started_at, ended_at, total_users, reset, reset_users FROM seasons WHERE id = $1
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSeasonNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query season: %w", err)
+	}
+	return &s, nil
+}
+
+func GetSeasonStandings(seasonID int64, limit, offset int) ([]SeasonStanding, error) {
+	return seasonStandingsPage(db, seasonID, limit, offset)
+}
+
+func seasonStandingsPage(q queryer, seasonID int64, limit, offset int) ([]SeasonStanding, error) {
+	rows, err := q.Query(`
+		SELECT rank, username, rating, tier
+		FROM season_standings
+		WHERE season_id = $1
+		ORDER BY rank, `+collateUsername("username")+`
+		LIMIT $2 OFFSET $3
+	`, seasonID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query season standings: %w", err)
+	}
+	defer rows.Close()
+
+	standings := []SeasonStanding{}
+	for rows.Next() {
+		var s SeasonStanding
+		if err := rows.Scan(&s.Rank, &s.Username, &s.Rating, &s.Tier); err != nil {
+			return nil, fmt.Errorf("failed to scan season standing: %w", err)
+		}
+		standings = append(standings, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating season standings: %w", err)
+	}
+	return standings, nil
+}
+
+func SearchSeasonStandings(ctx context.Context, seasonID int64, term string, limit, offset int) ([]SeasonStanding, bool, error) {
+	standings := []SeasonStanding{}
+	var s SeasonStanding
+	truncated, err := runSearch(ctx, searchQuery{
+		Match: `
+			SELECT rank, username, rating, tier
+			FROM season_standings
+			WHERE season_id = $1 AND username ILIKE $2
+		`,
+		Order:  `rank, ` + collateUsername("username"),
+		Args:   []any{seasonID, "%" + term + "%"},
+		Limit:  limit,
+		Offset: offset,
+	}, []any{&s.Rank, &s.Username, &s.Rating, &s.Tier}, func() {
+		standings = append(standings, s)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return standings, truncated, nil
+}
+
+func GetSeasonStanding(seasonID int64, username string) (*SeasonStanding, error) {
+	var s SeasonStanding
+	err := db.QueryRow(`
+		SELECT rank, username, rating, tier
+		FROM season_standings
+		WHERE season_id = $1 AND LOWER(username) = LOWER($2)
+	`, seasonID, username).Scan(&s.Rank, &s.Username, &s.Rating, &s.Tier)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func HandleListSeasons(c *gin.Context) {
+	seasons, err := GetSeasons()
+	if err != nil {
+		requestLog(c).Error("Error listing seasons", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list seasons")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"seasons": seasons,
+	})
+}
+
+// seasonParam loads the season with the given id, taken from the :id path
+// parameter or ?season=, writing the error response itself when there isn't
+// one.
+func seasonParam(c *gin.Context, raw string) (*Season, bool) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		respondError(c, http.StatusNotFound, "Season not found")
+		return nil, false
+	}
+
+	season, err := GetSeason(id)
+	if errors.Is(err, errSeasonNotFound) {
+		respondError(c, http.StatusNotFound, "Season not found")
+		return nil, false
+	}
+	if err != nil {
+		requestLog(c).Error("Error loading season", "season_id", id, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to load season")
+		return nil, false
+	}
+	return season, true
+}
+
+func HandleSeasonLeaderboard(c *gin.Context) {
+	serveSeasonLeaderboard(c, c.Param("id"))
+}
+
+// serveSeasonLeaderboard answers both /seasons/:id/leaderboard and
+// /leaderboard?season=.
+func serveSeasonLeaderboard(c *gin.Context, seasonID string) {
+	season, ok := seasonParam(c, seasonID)
+	if !ok {
+		return
+	}
+
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
+	}.normalize()
+
+	standings, err := GetSeasonStandings(season.ID, req.Limit+1, req.offset())
+	if err != nil {
+		requestLog(c).Error("Error fetching season leaderboard", "season_id", season.ID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch season leaderboard")
+		return
+	}
+	hasMore := len(standings) > req.Limit
+	if hasMore {
+		standings = standings[:req.Limit]
+	}
+
+	respond(c, http.StatusOK, SeasonLeaderboardResponse{
+		Success:    true,
+		Season:     *season,
+		Data:       standings,
+		Count:      len(standings),
+		Page:       req.Page,
+		Limit:      req.Limit,
+		HasMore:    hasMore,
+		Pagination: newPagination(req.Page, req.Limit, hasMore),
+	})
+}
+
+func HandleSeasonUser(c *gin.Context) {
+	serveSeasonUser(c, c.Param("id"), c.Param("username"))
+}
+
+// serveSeasonUser answers both /seasons/:id/users/:username and
+// /users/:username?season=.
+func serveSeasonUser(c *gin.Context, seasonID, username string) {
+	season, ok := seasonParam(c, seasonID)
+	if !ok {
+		return
+	}
+
+	standing, err := GetSeasonStanding(season.ID, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "User has no standing in this season")
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error fetching season standing", "season_id", season.ID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch season standing")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"season":   season,
+		"standing": standing,
+	})
+}
+
+// serveSeasonSearch answers /search?season=&username= from the season's
+// final standings.
+func serveSeasonSearch(c *gin.Context, seasonID, term string) {
+	season, ok := seasonParam(c, seasonID)
+	if !ok {
+		return
+	}
+
+	req := PageRequest{
+		Page:  parseIntParam(c.Query("page"), 1),
+		Limit: parseIntParam(c.Query("limit"), DefaultPageSize),
+	}.normalize()
+
+	standings, truncated, err := SearchSeasonStandings(c.Request.Context(), season.ID, term, req.Limit+1, req.offset())
+	if errors.Is(err, errSearchTimeout) {
+		writeSearchTimeout(c)
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error searching season", "season_id", season.ID, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to search season")
+		return
+	}
+	hasMore := len(standings) > req.Limit
+	if hasMore {
+		standings = standings[:req.Limit]
+	}
+
+	respond(c, http.StatusOK, SeasonLeaderboardResponse{
+		Success:    true,
+		Season:     *season,
+		Data:       standings,
+		Count:      len(standings),
+		Page:       req.Page,
+		Limit:      req.Limit,
+		HasMore:    hasMore,
+		Pagination: newPagination(req.Page, req.Limit, hasMore),
+		Truncated:  truncated,
+	})
+}
+
+type ArchiveSeasonRequest struct {
+	Name string `json:"name"`
+	// Reset, ResetRating, and SquashFactor override SEASON_RESET,
+	// SEASON_RESET_RATING, and SEASON_SQUASH_FACTOR for this archive.
+	Reset        string   `json:"reset"`
+	ResetRating  *int     `json:"reset_rating"`
+	SquashFactor *float64 `json:"squash_factor"`
+}
+
+// HandleArchiveSeason freezes the current board as a season.
+func HandleArchiveSeason(c *gin.Context) {
+	var req ArchiveSeasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		respondError(c, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	reset := defaultSeasonReset
+	if req.Reset != "" {
+		reset.Mode = req.Reset
+	}
+	if req.ResetRating != nil {
+		reset.Rating = *req.ResetRating
+	}
+	if req.SquashFactor != nil {
+		reset.Factor = *req.SquashFactor
+	}
+	if err := reset.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dryRun := dryRunRequested(c)
+	season, sample, err := ArchiveSeason(strings.TrimSpace(req.Name), reset, dryRun)
+	if err != nil {
+		requestLog(c).Error("Error archiving season", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to archive season")
+		return
+	}
+
+	if dryRun {
+		respond(c, http.StatusOK, gin.H{
+			"dry_run": true,
+			"season":  season,
+			"sample":  sample,
+		})
+		return
+	}
+
+	if season.ResetUsers > 0 {
+		outboxRelay.Flush()
+	}
+	requestLog(c).Info("✓ Archived season", "season", season.Name, "total_users", season.TotalUsers, "reset", season.Reset, "reset_users", season.ResetUsers)
+	respond(c, http.StatusCreated, gin.H{
+		"season": season,
+	})
+}
+
+type SeasonProgress struct {
+	Season Season `json:"season"`
+	Rank   int    `json:"rank"`
+	Rating int    `json:"rating"`
+	Tier   string `json:"tier"`
+}
+
+// GetUserSeasons returns a user's final standing in every archived season
+// they were ranked in, oldest first.
+func GetUserSeasons(userID int64) ([]SeasonProgress, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.name, s.started_at, s.ended_at, s.total_users, s.reset, s.reset_users, ss.rank, ss.rating, ss.tier
+		FROM season_standings ss
+		JOIN seasons s ON s.id = ss.season_id
+		WHERE ss.user_id = $1
+		ORDER BY s.ended_at, s.id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user seasons: %w", err)
+	}
+	defer rows.Close()
+
+	progress := []SeasonProgress{}
+	for rows.Next() {
+		var p SeasonProgress
+		var startedAt sql.NullTime
+		var endedAt time.Time
+		if err := rows.Scan(&p.Season.ID, &p.Season.Name, &startedAt, &endedAt, &p.Season.TotalUsers,
+			&p.Season.Reset, &p.Season.ResetUsers, &p.Rank, &p.Rating, &p.Tier); err != nil {
+			return nil, fmt.Errorf("failed to scan user season: %w", err)
+		}
+		p.Season.StartedAt = formatNullTime(startedAt)
+		p.Season.EndedAt = endedAt.UTC().Format(time.RFC3339)
+		progress = append(progress, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user seasons: %w", err)
+	}
+	return progress, nil
+}
+
+func HandleUserSeasons(c *gin.Context) {
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	seasons, err := GetUserSeasons(user.ID)
+	if err != nil {
+		requestLog(c).Error("Error fetching seasons", "username", user.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to fetch season history")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"username": user.Username,
+		"seasons":  seasons,
+	})
+}
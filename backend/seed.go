@@ -2,8 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 )
 
 
@@ -15,17 +14,17 @@ func SeedUsers(count int) error {
 	}
 
 	if existingCount > 0 {
-		log.Printf("Database already has %d users, skipping seed", existingCount)
+		slog.Info("Database already has users, skipping seed", "users", existingCount)
 		return nil
 	}
 
-	log.Printf("Seeding database with %d users...", count)
+	slog.Info("Seeding database", "users", count)
 
 
 	stmt, err := db.Prepare(`
-		INSERT INTO users (username, rating) 
-		VALUES ($1, $2) 
-		ON CONFLICT (username) DO NOTHING
+		INSERT INTO users (username, rating, is_bot, username_key) 
+		VALUES ($1, $2, TRUE, $3) 
+		ON CONFLICT DO NOTHING
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare insert statement: %w", err)
@@ -40,20 +39,20 @@ func SeedUsers(count int) error {
 		username := generateUsername(i)
 		rating := generateRandomRating()
 
-		_, err := stmt.Exec(username, rating)
+		_, err := stmt.Exec(username, rating, usernameKey(username))
 		if err != nil {
-			log.Printf("Warning: failed to insert user %s: %v", username, err)
+			slog.Warn("Failed to insert user", "username", username, "error", err)
 			continue
 		}
 		inserted++
 
 	
 		if inserted%batchSize == 0 {
-			log.Printf("  Inserted %d/%d users...", inserted, count)
+			slog.Info("Seeding progress", "inserted", inserted, "users", count)
 		}
 	}
 
-	log.Printf("✓ Seeded %d users successfully", inserted)
+	slog.Info("✓ Seeded users successfully", "users", inserted)
 	return nil
 }
 
@@ -65,11 +64,11 @@ func SeedUsersWithTransaction(count int) error {
 	}
 
 	if existingCount > 0 {
-		log.Printf("Database already has %d users, skipping seed", existingCount)
+		slog.Info("Database already has users, skipping seed", "users", existingCount)
 		return nil
 	}
 
-	log.Printf("Seeding database with %d users (batch mode)...", count)
+	slog.Info("Seeding database in batch mode", "users", count)
 
 
 	tx, err := db.Begin()
@@ -80,9 +79,9 @@ func SeedUsersWithTransaction(count int) error {
 
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO users (username, rating) 
-		VALUES ($1, $2) 
-		ON CONFLICT (username) DO NOTHING
+		INSERT INTO users (username, rating, is_bot, username_key) 
+		VALUES ($1, $2, TRUE, $3) 
+		ON CONFLICT DO NOTHING
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
@@ -94,14 +93,14 @@ func SeedUsersWithTransaction(count int) error {
 		username := generateUsername(i)
 		rating := generateRandomRating()
 
-		_, err := stmt.Exec(username, rating)
+		_, err := stmt.Exec(username, rating, usernameKey(username))
 		if err != nil {
-			log.Printf("Warning: failed to insert user %s: %v", username, err)
+			slog.Warn("Failed to insert user", "username", username, "error", err)
 		}
 
 	
 		if (i+1)%5000 == 0 {
-			log.Printf("  Prepared %d/%d users...", i+1, count)
+			slog.Info("Seeding progress", "prepared", i+1, "users", count)
 		}
 	}
 
@@ -110,7 +109,7 @@ func SeedUsersWithTransaction(count int) error {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	log.Printf("✓ Seeded %d users successfully", count)
+	slog.Info("✓ Seeded users successfully", "users", count)
 	return nil
 }
 
@@ -138,12 +137,12 @@ func generateRandomRating() int {
 
 
 	
-	if rand.Float32() < 0.7 {
+	if simRand.Float32() < 0.7 {
 	
 	
 		sum := 0
 		for i := 0; i < 6; i++ {
-			sum += rand.Intn(MaxRating-MinRating+1) + MinRating
+			sum += simRand.Intn(MaxRating-MinRating+1) + MinRating
 		}
 		rating := sum / 6
 		
@@ -158,7 +157,7 @@ func generateRandomRating() int {
 	}
 	
 
-	return rand.Intn(MaxRating-MinRating+1) + MinRating
+	return simRand.Intn(MaxRating-MinRating+1) + MinRating
 }
 
 func ClearAllUsers() error {
@@ -168,6 +167,6 @@ func ClearAllUsers() error {
 	}
 
 	rowsAffected, _ := result.RowsAffected()
-	log.Printf("✓ Cleared %d users from database", rowsAffected)
+	slog.Info("✓ Cleared users from database", "users", rowsAffected)
 	return nil
 }
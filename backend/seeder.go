@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// By default startup doesn't seed; the seed subcommand does it. With
+// SEED_MODE=blocking an empty database is seeded before the server listens.
+// With SEED_MODE=background, startup doesn't wait for the seed. The server
+// comes up on whatever is already in the database and the seeder trickles the
+// remaining bots in, adding each batch to the engine as it commits.
+const (
+	SeedBlocking   = "blocking"
+	SeedBackground = "background"
+	SeedOff        = "off"
+)
+
+var seedMode = getEnv("SEED_MODE", SeedOff)
+
+type BackgroundSeeder struct {
+	target    int
+	batchSize int
+	interval  time.Duration
+	next      int
+
+	startedAt time.Time
+	inserted  atomic.Int64
+	finished  atomic.Bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type SeedProgress struct {
+	Target   int     `json:"target"`
+	Inserted int64   `json:"inserted"`
+	Percent  float64 `json:"percent"`
+	Done     bool    `json:"done"`
+	Elapsed  string  `json:"elapsed"`
+}
+
+var backgroundSeeder *BackgroundSeeder
+
+// StartBackgroundSeeder tops the database up to count bots. Like the blocking
+// seed it leaves a database with real users alone. An interrupted seed
+// resumes where the bot count left off.
+func StartBackgroundSeeder(count int) {
+	var bots, humans int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE is_bot), COUNT(*) FILTER (WHERE NOT is_bot) FROM users
+	`).Scan(&bots, &humans)
+	if err != nil {
+		slog.Warn("Background seeding not started", "error", err)
+		return
+	}
+	if humans > 0 || bots >= count {
+		slog.Info("Database already has users, skipping seed", "users", bots+humans)
+		return
+	}
+
+	backgroundSeeder = &BackgroundSeeder{
+		target:    count,
+		batchSize: max(getEnvInt("SEED_BATCH_SIZE", 200), 1),
+		interval:  time.Duration(getEnvInt("SEED_INTERVAL_MS", 100)) * time.Millisecond,
+		next:      bots,
+		startedAt: time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	backgroundSeeder.inserted.Store(int64(bots))
+	go backgroundSeeder.run()
+	slog.Info("✓ Background seeding started", "bots", bots, "users", count,
+		"batch_size", backgroundSeeder.batchSize, "interval", backgroundSeeder.interval)
+}
+
+func StopBackgroundSeeder() {
+	if backgroundSeeder == nil {
+		return
+	}
+	close(backgroundSeeder.stop)
+	<-backgroundSeeder.done
+}
+
+func (s *BackgroundSeeder) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(max(s.interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for s.next < s.target {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := s.insertBatch(); err != nil {
+			slog.Error("Background seeding batch failed", "error", err)
+		}
+	}
+	s.finished.Store(true)
+	slog.Info("✓ Background seeding finished", "users", s.inserted.Load(), "duration", time.Since(s.startedAt).Round(time.Second))
+	if err := commitLeaderboardRefresh(RefreshSeed); err != nil {
+		slog.Warn("Leaderboard refresh after seeding failed", "error", err)
+	}
+}
+
+func (s *BackgroundSeeder) insertBatch() error {
+	n := min(s.batchSize, s.target-s.next)
+	usernames := make([]string, n)
+	ratings := make([]int, n)
+	keys := make([]string, n)
+	for i := range n {
+		usernames[i] = generateUsername(s.next + i)
+		ratings[i] = generateRandomRating()
+		keys[i] = usernameKey(usernames[i])
+	}
+
+	release := writeSlots.acquire(WriteBackground)
+	defer release()
+
+	// The engine is updated below; the user.placed events are for replicas.
+	rows, err := db.Query(`
+		WITH inserted AS (
+			INSERT INTO users (username, rating, is_bot, username_key)
+			SELECT username, rating, TRUE, key FROM unnest($1::text[], $2::int[], $3::text[]) AS t(username, rating, key)
+			ON CONFLICT DO NOTHING
+			RETURNING id, username, rating, in_placement
+		), events AS (
+			INSERT INTO outbox (event_type, payload, processed_at)
+			SELECT $4, jsonb_build_object('user_id', id, 'username', username, 'rating', rating, 'is_bot', TRUE), NOW()
+			FROM inserted
+			WHERE NOT in_placement
+			RETURNING id
+		)
+		SELECT username, rating, in_placement, (SELECT MAX(id) FROM events) FROM inserted
+	`, pq.Array(usernames), pq.Array(ratings), pq.Array(keys), EventUserPlaced)
+	if err != nil {
+		return fmt.Errorf("failed to insert seed users: %w", err)
+	}
+	defer rows.Close()
+
+	re := GetRankingEngine()
+	var eventID sql.NullInt64
+	for rows.Next() {
+		var username string
+		var rating int
+		var inPlacement bool
+		if err := rows.Scan(&username, &rating, &inPlacement, &eventID); err != nil {
+			return fmt.Errorf("failed to scan seed user: %w", err)
+		}
+		bots.register(username)
+		if !inPlacement {
+			re.AddUser(rating)
+			bots.add(username, rating)
+		}
+		s.inserted.Add(1)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating seed users: %w", err)
+	}
+	noteOutboxApplied(eventID.Int64)
+	s.next += n
+	return nil
+}
+
+func (s *BackgroundSeeder) Stats() SeedProgress {
+	inserted := s.inserted.Load()
+	return SeedProgress{
+		Target:   s.target,
+		Inserted: inserted,
+		Percent:  float64(int(float64(inserted)/float64(s.target)*1000)) / 10,
+		Done:     s.finished.Load(),
+		Elapsed:  time.Since(s.startedAt).Round(time.Second).String(),
+	}
+}
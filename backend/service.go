@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// LeaderboardService holds the read-side business logic: pagination, rank
+// enrichment, and placement handling. HTTP handlers are thin adapters over it
+// so other front ends (CLI, gRPC, GraphQL) can share the same behaviour.
+type LeaderboardService struct {
+	users UserStore
+	ranks RankStore
+}
+
+func NewLeaderboardService(users UserStore, ranks RankStore) *LeaderboardService {
+	return &LeaderboardService{users: users, ranks: ranks}
+}
+
+// PageRequest is a 1-based page; out-of-range values are normalized the way
+// the public API always has: page < 1 is 1, limit is 1..MaxPageSize.
+type PageRequest struct {
+	Page  int
+	Limit int
+}
+
+func (p PageRequest) normalize() PageRequest {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit < 1 {
+		p.Limit = DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+	return p
+}
+
+func validatePageSizes() error {
+	if DefaultPageSize < 1 {
+		return fmt.Errorf("PAGE_SIZE_DEFAULT must be >= 1, got %d", DefaultPageSize)
+	}
+	if MaxPageSize < DefaultPageSize {
+		return fmt.Errorf("PAGE_SIZE_MAX (%d) must be at least PAGE_SIZE_DEFAULT (%d)", MaxPageSize, DefaultPageSize)
+	}
+	return nil
+}
+
+func (p PageRequest) offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+type Page struct {
+	Rows      []UserWithRank
+	Page      int
+	Limit     int
+	HasMore   bool
+	Partial   bool // ranks were skipped because the request budget ran low
+	Degraded  bool // ranks are database-order positions, not engine ranks
+	Truncated bool // the search scan stopped at SEARCH_SCAN_CAP
+}
+
+// UserStanding is a single user's public position. Rating and Rank are nil
+// while the user is still in placement.
+type UserStanding struct {
+	Username  string
+	Rating    *int
+	Rank      *int
+	Placement *PlacementProgress
+}
+
+// Leaderboard returns one page of the board. Rows are appended to dst so
+// callers can pass a pooled slice.
+func (s *LeaderboardService) Leaderboard(ctx context.Context, req PageRequest, dst []UserWithRank) (Page, error) {
+	req = req.normalize()
+	users, err := s.users.TopUsers(ctx, req.Limit+1, req.offset())
+	if err != nil {
+		return Page{}, err
+	}
+	page, err := s.page(ctx, req, users, dst)
+	if err != nil || page.Partial || !degradedMode {
+		return page, err
+	}
+
+	// The board is ordered by rating, so a row's position stands in for its
+	// rank when the engine can't provide one.
+	if s.ranks.Rebuilding() || hasUnranked(page.Rows) {
+		fillPositions(page.Rows, req.offset())
+		page.Degraded = true
+	}
+	return page, nil
+}
+
+func (s *LeaderboardService) Search(ctx context.Context, term string, req PageRequest, dst []UserWithRank) (Page, error) {
+	req = req.normalize()
+	users, truncated, err := s.users.SearchUsers(ctx, term, req.Limit+1, req.offset())
+	if err != nil {
+		return Page{}, err
+	}
+	page, err := s.page(ctx, req, users, dst)
+	page.Truncated = truncated
+	return page, err
+}
+
+// page trims the look-ahead row used for HasMore and enriches the rest with
+// ranks in one pass. If the fetch used up the request's budget, enrichment is
+// skipped: the page is returned unranked and marked Partial when partial
+// results are allowed, and errBudgetExhausted otherwise.
+func (s *LeaderboardService) page(ctx context.Context, req PageRequest, users []User, dst []UserWithRank) (Page, error) {
+	hasMore := len(users) > req.Limit
+	if hasMore {
+		users = users[:req.Limit]
+	}
+
+	rows := dst[:0]
+	for _, u := range users {
+		rows = append(rows, UserWithRank{Username: u.Username, Rating: u.Rating, Cursor: cursorFor(u)})
+	}
+	page := Page{Rows: rows, Page: req.Page, Limit: req.Limit, HasMore: hasMore}
+
+	if budgetExhausted(ctx) {
+		if !budgetPartial {
+			return Page{}, errBudgetExhausted
+		}
+		page.Partial = true
+		return page, nil
+	}
+	if len(rows) > 0 {
+		s.ranks.FillRanks(rows)
+	}
+	return page, nil
+}
+
+// Top returns the first n users with ranks, for widgets and chat integrations.
+func (s *LeaderboardService) Top(ctx context.Context, n int) ([]UserWithRank, error) {
+	users, err := s.users.TopUsers(ctx, n, 0)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]UserWithRank, len(users))
+	for i, u := range users {
+		rows[i] = UserWithRank{Username: u.Username, Rating: u.Rating}
+	}
+	s.ranks.FillRanks(rows)
+	return rows, nil
+}
+
+// User returns the stored user and their rank. Rank is 0 for users still in
+// placement, who have none yet.
+func (s *LeaderboardService) User(ctx context.Context, username string) (*User, int, error) {
+	user, err := s.users.UserByUsername(ctx, username)
+	if err != nil {
+		return nil, 0, err
+	}
+	if user.InPlacement {
+		return user, 0, nil
+	}
+	return user, s.ranks.GetRank(user.Rating), nil
+}
+
+func (s *LeaderboardService) Standing(ctx context.Context, username string) (UserStanding, error) {
+	user, rank, err := s.User(ctx, username)
+	if err != nil {
+		return UserStanding{}, err
+	}
+
+	standing := UserStanding{Username: user.Username, Placement: placementProgress(user)}
+	if !user.InPlacement {
+		standing.Rating = &user.Rating
+		standing.Rank = &rank
+	}
+	return standing, nil
+}
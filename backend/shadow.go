@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// Divergences are logged individually at first and then sampled, so a
+// systematically broken shadow can't flood the logs.
+const (
+	shadowLogFirst = 10
+	shadowLogEvery = 1000
+)
+
+// ShadowEngine applies every write to both engines and compares their answers
+// on every read. The primary's answer is always the one returned. Writes are
+// not atomic across the pair, so a read racing a write may count a transient
+// divergence; persistent growth is what signals a bug.
+type ShadowEngine struct {
+	primaryName string
+	primary     RankEngine
+	shadowName  string
+	shadow      RankEngine
+
+	comparisons atomic.Int64
+	divergences atomic.Int64
+}
+
+type ShadowStats struct {
+	Primary     string `json:"primary"`
+	Shadow      string `json:"shadow"`
+	Comparisons int64  `json:"comparisons"`
+	Divergences int64  `json:"divergences"`
+}
+
+func NewShadowEngine(primaryName string, primary RankEngine, shadowName string, shadow RankEngine) *ShadowEngine {
+	return &ShadowEngine{
+		primaryName: primaryName,
+		primary:     primary,
+		shadowName:  shadowName,
+		shadow:      shadow,
+	}
+}
+
+func (se *ShadowEngine) diverged(op string, args any, want, got any) {
+	n := se.divergences.Add(1)
+	if n <= shadowLogFirst || n%shadowLogEvery == 0 {
+		slog.Warn("Shadow divergence", "count", n, "op", op, "args", args,
+			"primary", se.primaryName, "want", want, "shadow", se.shadowName, "got", got)
+	}
+}
+
+func (se *ShadowEngine) GetRank(rating int) int {
+	want := se.primary.GetRank(rating)
+	got := se.shadow.GetRank(rating)
+
+	se.comparisons.Add(1)
+	if want != got {
+		se.diverged("GetRank", rating, want, got)
+	}
+	return want
+}
+
+func (se *ShadowEngine) GetRankBatch(ratings []int) []int {
+	want := se.primary.GetRankBatch(ratings)
+	got := se.shadow.GetRankBatch(ratings)
+
+	se.comparisons.Add(1)
+	for i := range want {
+		if want[i] != got[i] {
+			se.diverged("GetRankBatch", ratings[i], want[i], got[i])
+			break
+		}
+	}
+	return want
+}
+
+// FillRanks checks the shadow one rating at a time so the primary's fast path
+// stays allocation-free.
+func (se *ShadowEngine) FillRanks(rows []UserWithRank) {
+	se.primary.FillRanks(rows)
+
+	se.comparisons.Add(1)
+	for _, row := range rows {
+		if row.Rank < 0 {
+			continue
+		}
+		if got := se.shadow.GetRank(row.Rating); got != row.Rank {
+			se.diverged("FillRanks", row.Rating, row.Rank, got)
+			break
+		}
+	}
+}
+
+func (se *ShadowEngine) UpdateRating(oldRating, newRating int) {
+	se.primary.UpdateRating(oldRating, newRating)
+	se.shadow.UpdateRating(oldRating, newRating)
+}
+
+func (se *ShadowEngine) BatchUpdateRatings(updates []RatingUpdate) {
+	se.primary.BatchUpdateRatings(updates)
+	se.shadow.BatchUpdateRatings(updates)
+}
+
+func (se *ShadowEngine) AddUser(rating int) {
+	se.primary.AddUser(rating)
+	se.shadow.AddUser(rating)
+}
+
+func (se *ShadowEngine) RemoveUser(rating int) {
+	se.primary.RemoveUser(rating)
+	se.shadow.RemoveUser(rating)
+}
+
+func (se *ShadowEngine) Version() int64 {
+	return se.primary.Version()
+}
+
+func (se *ShadowEngine) GetStats() (totalUsers int, uniqueRatings int, minRatingWithUsers int, maxRatingWithUsers int) {
+	totalUsers, uniqueRatings, minRatingWithUsers, maxRatingWithUsers = se.primary.GetStats()
+	st, su, smin, smax := se.shadow.GetStats()
+
+	se.comparisons.Add(1)
+	if st != totalUsers || su != uniqueRatings || smin != minRatingWithUsers || smax != maxRatingWithUsers {
+		se.diverged("GetStats", "",
+			[]int{totalUsers, uniqueRatings, minRatingWithUsers, maxRatingWithUsers},
+			[]int{st, su, smin, smax})
+	}
+	return
+}
+
+func (se *ShadowEngine) Stats() ShadowStats {
+	return ShadowStats{
+		Primary:     se.primaryName,
+		Shadow:      se.shadowName,
+		Comparisons: se.comparisons.Load(),
+		Divergences: se.divergences.Load(),
+	}
+}
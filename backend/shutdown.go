@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	inFlightRequests  atomic.Int64
+	backgroundUpdates atomic.Int64
+)
+
+// ShutdownReport summarizes a graceful shutdown so operators can tell whether
+// the shutdown timeout is long enough for the traffic they run.
+type ShutdownReport struct {
+	StartedAt         time.Time `json:"started_at"`
+	DurationMs        int64     `json:"duration_ms"`
+	RequestsInFlight  int64     `json:"requests_in_flight"`
+	RequestsDrained   int64     `json:"requests_drained"`
+	RequestsAbandoned int64     `json:"requests_abandoned"`
+	UpdatesPending    int64     `json:"updates_pending"`
+	UpdatesFlushed    int64     `json:"updates_flushed"`
+	UpdatesDropped    int64     `json:"updates_dropped"`
+	OutboxFlushed     int       `json:"outbox_flushed"`
+}
+
+func inFlightMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+		c.Next()
+	}
+}
+
+// processRatingUpdatesAsync runs a simulation batch in the background while
+// keeping count of it, so shutdown can wait for it before closing the database.
+func processRatingUpdatesAsync(updates []RatingUpdate) {
+	n := int64(len(updates))
+	backgroundUpdates.Add(n)
+	go func() {
+		defer backgroundUpdates.Add(-n)
+		processRatingUpdates(updates)
+	}()
+}
+
+func beginShutdownReport() *ShutdownReport {
+	return &ShutdownReport{
+		StartedAt:        time.Now(),
+		RequestsInFlight: inFlightRequests.Load(),
+		UpdatesPending:   backgroundUpdates.Load(),
+	}
+}
+
+// drainRequests records what server.Shutdown left behind. Call it after
+// Shutdown returns, whether or not it hit the deadline.
+func (r *ShutdownReport) drainRequests() {
+	r.RequestsAbandoned = inFlightRequests.Load()
+	r.RequestsDrained = max(0, r.RequestsInFlight-r.RequestsAbandoned)
+}
+
+// drainUpdates waits for background simulation batches until ctx expires.
+// Updates in batches that haven't finished by then count as dropped.
+func (r *ShutdownReport) drainUpdates(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for backgroundUpdates.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			r.UpdatesDropped = backgroundUpdates.Load()
+			r.UpdatesFlushed = max(0, r.UpdatesPending-r.UpdatesDropped)
+			return
+		case <-ticker.C:
+		}
+	}
+	r.UpdatesFlushed = r.UpdatesPending
+}
+
+func (r *ShutdownReport) finish() {
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+
+	slog.Info("Shutdown report", "duration_ms", r.DurationMs,
+		"requests_drained", r.RequestsDrained, "requests_abandoned", r.RequestsAbandoned,
+		"updates_flushed", r.UpdatesFlushed, "updates_dropped", r.UpdatesDropped, "outbox_flushed", r.OutboxFlushed)
+
+	path := getEnv("SHUTDOWN_REPORT_FILE", "")
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		slog.Warn("Failed to write shutdown report", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Score-writing endpoints can require game servers to sign their requests.
+// Each signing key id in SUBMISSION_SIGNING_KEYS has its own shared secret,
+// and the id travels in X-Signature-Key. A request made with an API key must
+// be signed with the secret listed under that key's name, so one tenant's
+// secret can't sign another's writes. The signature is hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" +
+// method + "\n" + path and query + "\n" + body)). A request is accepted once:
+// its timestamp must be recent and its nonce unused for that key. Nonces are
+// stored against a SHA-256 of the key id, like API keys.
+//
+// The table used to hold whatever X-API-Key carried, which could be an API
+// key itself, so those rows are hashed in place.
+const submissionNonceSchema = `
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns WHERE table_name = 'submission_nonces' AND column_name = 'api_key'
+		) THEN
+			UPDATE submission_nonces SET api_key = encode(sha256(convert_to(api_key, 'UTF8')), 'hex');
+			ALTER TABLE submission_nonces RENAME COLUMN api_key TO key_hash;
+		END IF;
+	END $$;
+
+	CREATE TABLE IF NOT EXISTS submission_nonces (
+		key_hash TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (key_hash, nonce)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_submission_nonces_seen ON submission_nonces(seen_at);
+`
+
+const maxSubmissionNonceLen = 128
+
+var (
+	submissionSigningKeys   = parseKeyValueList(getEnv("SUBMISSION_SIGNING_KEYS", ""))
+	submissionSignatureAge  = time.Duration(getEnvInt("SUBMISSION_SIGNATURE_MAX_AGE_SEC", 300)) * time.Second
+	submissionMaxBodyBytes  = int64(getEnvInt("SUBMISSION_MAX_BODY_BYTES", 1<<20))
+	submissionNoncesPruned  atomic.Int64
+	rejectedSubmissionCount atomic.Int64
+)
+
+func submissionSecret(keyID string) ([]byte, bool) {
+	for _, kv := range submissionSigningKeys {
+		if hmac.Equal([]byte(kv[0]), []byte(keyID)) {
+			return []byte(kv[1]), true
+		}
+	}
+	return nil, false
+}
+
+func submissionSignature(secret []byte, timestamp, nonce, method, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedSubmissionMiddleware verifies X-Signature on score writes. It does
+// nothing when SUBMISSION_SIGNING_KEYS is unset.
+func signedSubmissionMiddleware() gin.HandlerFunc {
+	if len(submissionSigningKeys) == 0 {
+		slog.Warn("SUBMISSION_SIGNING_KEYS not set, score submissions are unsigned")
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		reject := func(reason string) {
+			rejectedSubmissionCount.Add(1)
+			requestLog(c).Warn("Rejected unsigned submission", "path", c.Request.URL.Path, "reason", reason)
+			abortWithError(c, http.StatusUnauthorized, "A valid request signature is required")
+		}
+
+		keyID := c.GetHeader("X-Signature-Key")
+		secret, ok := submissionSecret(keyID)
+		if !ok {
+			reject("unknown signing key")
+			return
+		}
+		if apiKey := c.GetString("api_key"); apiKey != "" && keyID != apiKey {
+			reject("signing key belongs to another API key")
+			return
+		}
+
+		timestamp := c.GetHeader("X-Signature-Timestamp")
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || math.Abs(time.Since(time.Unix(ts, 0)).Seconds()) > submissionSignatureAge.Seconds() {
+			reject("stale or missing timestamp")
+			return
+		}
+		nonce := c.GetHeader("X-Signature-Nonce")
+		if nonce == "" || len(nonce) > maxSubmissionNonceLen {
+			reject("missing nonce")
+			return
+		}
+
+		// The whole body is buffered to be signed, so it's capped first.
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, submissionMaxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", submissionMaxBodyBytes))
+			return
+		}
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := submissionSignature(secret, timestamp, nonce, c.Request.Method, c.Request.URL.RequestURI(), body)
+		if !hmac.Equal([]byte(expected), []byte(c.GetHeader("X-Signature"))) {
+			reject("bad signature")
+			return
+		}
+
+		// Checked last so a forged request can't burn a real client's nonce.
+		fresh, err := claimSubmissionNonce(keyID, nonce)
+		if err != nil {
+			requestLog(c).Error("Error recording submission nonce", "error", err)
+			abortWithError(c, http.StatusInternalServerError, "Failed to verify request")
+			return
+		}
+		if !fresh {
+			reject("nonce reused")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// claimSubmissionNonce records a nonce and reports whether it was unused.
+// Nonces older than the signature window can't be replayed anyway, so they
+// are pruned at most once per window.
+func claimSubmissionNonce(keyID, nonce string) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO submission_nonces (key_hash, nonce) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, hashAPIKey(keyID), nonce)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+
+	now := time.Now()
+	last := submissionNoncesPruned.Load()
+	if now.Sub(time.Unix(0, last)) > submissionSignatureAge && submissionNoncesPruned.CompareAndSwap(last, now.UnixNano()) {
+		if _, err := db.Exec(`
+			DELETE FROM submission_nonces WHERE seen_at < NOW() - make_interval(secs => $1)
+		`, 2*submissionSignatureAge.Seconds()); err != nil {
+			slog.Error("Error pruning submission nonces", "error", err)
+		}
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SimProfileUniform   = "uniform"
+	SimProfileRealistic = "realistic"
+
+	// The realistic profile draws a larger random pool and then weights it, so
+	// mid-rated players are picked more often without starving the extremes.
+	simCandidatePoolFactor = 4
+	simMinSelectionWeight  = 0.05
+
+	// A seeded pick draws twice as many ids as it still needs, to allow for
+	// gaps, and gives up short after this many rounds.
+	simSeededPickRounds = 8
+)
+
+// Default hourly multipliers (UTC) applied to the batch size: a quiet night,
+// a lunchtime bump, and an evening peak.
+var defaultActivityCurve = [24]float64{
+	0.35, 0.25, 0.2, 0.15, 0.15, 0.2, 0.3, 0.45,
+	0.55, 0.6, 0.65, 0.75, 0.85, 0.8, 0.75, 0.75,
+	0.85, 1.0, 1.2, 1.4, 1.5, 1.4, 1.0, 0.6,
+}
+
+type SimulationProfile struct {
+	Name             string
+	MidRating        float64
+	Spread           float64
+	DeltaStdDev      float64
+	RegistrationRate float64
+	ChurnRate        float64
+	NewUserRating    int
+	ActivityCurve    [24]float64
+}
+
+var simProfile = loadSimulationProfile()
+
+func loadSimulationProfile() SimulationProfile {
+	p := SimulationProfile{
+		Name:             getEnv("SIM_PROFILE", SimProfileUniform),
+		MidRating:        getEnvFloat("SIM_MID_RATING", float64(MinRating+MaxRating)/2),
+		Spread:           getEnvFloat("SIM_RATING_SPREAD", 900),
+		DeltaStdDev:      getEnvFloat("SIM_DELTA_STDDEV", 120),
+		RegistrationRate: getEnvFloat("SIM_REGISTRATION_RATE", 0.02),
+		ChurnRate:        getEnvFloat("SIM_CHURN_RATE", 0.01),
+		NewUserRating:    getEnvInt("SIM_NEW_USER_RATING", 1200),
+		ActivityCurve:    defaultActivityCurve,
+	}
+
+	if curve := getEnv("SIM_ACTIVITY_CURVE", ""); curve != "" {
+		parsed, err := parseActivityCurve(curve)
+		if err != nil {
+			slog.Warn("Ignoring SIM_ACTIVITY_CURVE", "error", err)
+		} else {
+			p.ActivityCurve = parsed
+		}
+	}
+
+	if p.Name != SimProfileUniform && p.Name != SimProfileRealistic {
+		slog.Warn("Unknown SIM_PROFILE, using the default", "profile", p.Name, "default", SimProfileUniform)
+		p.Name = SimProfileUniform
+	}
+	return p
+}
+
+func parseActivityCurve(value string) ([24]float64, error) {
+	var curve [24]float64
+
+	parts := strings.Split(value, ",")
+	if len(parts) != len(curve) {
+		return curve, fmt.Errorf("expected 24 comma-separated hourly multipliers, got %d", len(parts))
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v < 0 {
+			return curve, fmt.Errorf("invalid multiplier %q for hour %d", part, i)
+		}
+		curve[i] = v
+	}
+	return curve, nil
+}
+
+func (p SimulationProfile) realistic() bool {
+	return p.Name == SimProfileRealistic
+}
+
+// batchSize scales the base batch by the activity curve for the current hour.
+func (p SimulationProfile) batchSize(base int, now time.Time) int {
+	if !p.realistic() {
+		return base
+	}
+	n := int(math.Round(float64(base) * p.ActivityCurve[now.UTC().Hour()]))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (p SimulationProfile) selectUsers(count int) ([]User, error) {
+	if !p.realistic() {
+		return GetRandomUsers(count)
+	}
+
+	pool, err := GetRandomUsers(count * simCandidatePoolFactor)
+	if err != nil {
+		return nil, err
+	}
+	if len(pool) <= count {
+		return pool, nil
+	}
+
+	// Weighted sampling without replacement (Efraimidis-Spirakis): each
+	// candidate gets key u^(1/w) and the largest keys win.
+	type keyed struct {
+		user User
+		key  float64
+	}
+	candidates := make([]keyed, len(pool))
+	for i, u := range pool {
+		d := (float64(u.Rating) - p.MidRating) / p.Spread
+		w := math.Max(math.Exp(-d*d/2), simMinSelectionWeight)
+		candidates[i] = keyed{user: u, key: math.Pow(simRand.Float64(), 1/w)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key > candidates[j].key })
+
+	users := make([]User, count)
+	for i := range users {
+		users[i] = candidates[i].user
+	}
+	return users, nil
+}
+
+// getSeededRandomUsers is GetRandomUsers with SIM_SEED set: it draws ids in
+// the ranked bots' range from simRand and keeps the ones that exist, so the
+// same seed against the same data picks the same users in the same order.
+func getSeededRandomUsers(count int) ([]User, error) {
+	var lo, hi sql.NullInt64
+	if err := db.QueryRow(`SELECT MIN(id), MAX(id) FROM users WHERE NOT in_placement AND is_bot`).Scan(&lo, &hi); err != nil {
+		return nil, fmt.Errorf("failed to get random users: %w", err)
+	}
+	users := make([]User, 0, count)
+	if !lo.Valid {
+		return users, nil
+	}
+
+	span := hi.Int64 - lo.Int64 + 1
+	drawn := map[int64]bool{}
+	for round := 0; round < simSeededPickRounds && len(users) < count && int64(len(drawn)) < span; round++ {
+		want := min(int64(2*(count-len(users))), span-int64(len(drawn)))
+		ids := make([]int64, 0, want)
+		for int64(len(ids)) < want {
+			if id := lo.Int64 + simRand.Int63n(span); !drawn[id] {
+				drawn[id] = true
+				ids = append(ids, id)
+			}
+		}
+
+		rows, err := db.Query(`
+			SELECT id, username, rating FROM users WHERE id = ANY($1) AND NOT in_placement AND is_bot
+		`, pq.Array(ids))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get random users: %w", err)
+		}
+		found := make(map[int64]User, len(ids))
+		for rows.Next() {
+			var u User
+			if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan user row: %w", err)
+			}
+			found[u.ID] = u
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating user rows: %w", err)
+		}
+
+		// Keep the draw order, not the order Postgres returned them in.
+		for _, id := range ids {
+			if u, ok := found[id]; ok && len(users) < count {
+				users = append(users, u)
+			}
+		}
+	}
+	return users, nil
+}
+
+func (p SimulationProfile) nextRating(currentRating int) int {
+	if !p.realistic() {
+		return generateNewRating(currentRating)
+	}
+	return clampRating(currentRating + int(math.Round(simRand.NormFloat64()*p.DeltaStdDev)))
+}
+
+// simulatePopulationChanges registers and churns a handful of users in
+// proportion to the batch size, keeping the engine counts in step with the DB.
+func (p SimulationProfile) simulatePopulationChanges(size int, batchID int64) (registered int, churned int) {
+	if !p.realistic() {
+		return 0, 0
+	}
+
+	re := GetRankingEngine()
+
+	for i := 0; i < probabilisticCount(float64(size)*p.RegistrationRate); i++ {
+		rating := clampRating(p.NewUserRating + simRand.Intn(201) - 100)
+		username := fmt.Sprintf("rookie_%08x", simRand.Intn(1<<32))
+
+		u, err := CreateUser(username, rating, true)
+		if err != nil {
+			slog.Error("Simulated registration failed", "username", username, "error", err)
+			continue
+		}
+		if !u.InPlacement {
+			re.AddUser(rating)
+			bots.add(username, rating)
+		}
+		recorder.record(SimEvent{Batch: batchID, Op: SimOpRegister, Username: username, NewRating: rating})
+		registered++
+	}
+
+	if n := probabilisticCount(float64(size) * p.ChurnRate); n > 0 {
+		users, err := GetRandomUsers(n)
+		if err != nil {
+			slog.Error("Simulated churn failed", "error", err)
+			return registered, churned
+		}
+		for _, u := range users {
+			deleted, err := DeleteBotByID(u.ID)
+			if err != nil {
+				slog.Error("Simulated churn failed", "user_id", u.ID, "error", err)
+				continue
+			}
+			if deleted.Ranked {
+				re.RemoveUser(deleted.Rating)
+			}
+			bots.remove(deleted.Username, deleted.Rating, deleted.Ranked)
+			recorder.record(SimEvent{Batch: batchID, Op: SimOpChurn, Username: deleted.Username, OldRating: deleted.Rating})
+			churned++
+		}
+	}
+
+	return registered, churned
+}
+
+// probabilisticCount turns an expected value such as 0.3 into 0 or 1 with the
+// right odds, so small batches still occasionally see events.
+func probabilisticCount(expected float64) int {
+	n := int(expected)
+	if simRand.Float64() < expected-float64(n) {
+		n++
+	}
+	return n
+}
+
+func clampRating(rating int) int {
+	if rating < MinRating {
+		return MinRating
+	}
+	if rating > MaxRating {
+		return MaxRating
+	}
+	return rating
+}
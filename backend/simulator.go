@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The continuous simulator runs bulk simulation batches on a timer when
+// SIMULATOR_AUTOSTART=true, so demos don't need a client polling /simulate.
+// It waits for warm-up and a healthy database before its first batch, and
+// pauses while the rating update error rate over the last few batches or the
+// database ping latency is above its threshold, resuming once both recover.
+const simulatorErrorWindow = 10
+
+type SimulatorStats struct {
+	Running      bool    `json:"running"`
+	Paused       bool    `json:"paused"`
+	PauseReason  string  `json:"pause_reason,omitempty"`
+	Batches      int64   `json:"batches"`
+	ErrorRate    float64 `json:"error_rate"`
+	DBLatencyMs  int64   `json:"db_latency_ms"`
+	IntervalMs   int64   `json:"interval_ms"`
+	MaxErrorRate float64 `json:"max_error_rate"`
+	MaxLatencyMs int64   `json:"max_db_latency_ms"`
+}
+
+type Simulator struct {
+	interval     time.Duration
+	maxErrorRate float64
+	maxLatency   time.Duration
+
+	mu        sync.Mutex
+	running   bool
+	paused    string
+	batches   int64
+	latency   time.Duration
+	slot      int
+	attempted [simulatorErrorWindow]int
+	failed    [simulatorErrorWindow]int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var simulator *Simulator
+
+func StartSimulator() {
+	if getEnv("SIMULATOR_AUTOSTART", "false") != "true" {
+		return
+	}
+	if isReplica() {
+		slog.Info("Simulator autostart ignored in replica mode")
+		return
+	}
+
+	interval := time.Duration(getEnvInt("SIMULATOR_INTERVAL_MS", 1000)) * time.Millisecond
+	if interval <= 0 {
+		slog.Info("Simulator autostart disabled: SIMULATOR_INTERVAL_MS must be positive")
+		return
+	}
+
+	simulator = &Simulator{
+		interval:     interval,
+		maxErrorRate: getEnvFloat("SIMULATOR_MAX_ERROR_RATE", 0.05),
+		maxLatency:   time.Duration(getEnvInt("SIMULATOR_MAX_DB_LATENCY_MS", 200)) * time.Millisecond,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go simulator.run()
+	slog.Info("✓ Simulator autostart enabled once healthy", "interval", simulator.interval)
+}
+
+func StopSimulator() {
+	if simulator == nil {
+		return
+	}
+	close(simulator.stop)
+	<-simulator.done
+}
+
+func (s *Simulator) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !ready.Load() {
+			continue
+		}
+		if reason := s.unhealthy(); reason != "" {
+			s.pause(reason)
+			continue
+		}
+		s.pause("")
+		s.runBatch()
+	}
+}
+
+// unhealthy returns why the simulator should hold off, or "" when it can run.
+func (s *Simulator) unhealthy() string {
+	ctx, cancel := context.WithTimeout(context.Background(), max(s.maxLatency*5, time.Second))
+	defer cancel()
+
+	start := time.Now()
+	err := db.PingContext(ctx)
+	latency := time.Since(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+
+	switch {
+	case err != nil:
+		return fmt.Sprintf("database unreachable: %v", err)
+	case s.maxLatency > 0 && latency > s.maxLatency:
+		return fmt.Sprintf("database latency %s above %s", latency.Round(time.Millisecond), s.maxLatency)
+	}
+	if rate := s.errorRateLocked(); s.maxErrorRate > 0 && rate > s.maxErrorRate {
+		// No batches run while paused, so age out the oldest one per tick
+		// to let the rate recover.
+		s.recordLocked(0, 0)
+		return fmt.Sprintf("update error rate %.1f%% above %.1f%%", rate*100, s.maxErrorRate*100)
+	}
+	return ""
+}
+
+// pause records the simulator's state, logging transitions. An empty reason
+// means running.
+func (s *Simulator) pause(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reason != "" && s.paused == "" {
+		slog.Info("Simulator paused", "reason", reason)
+	}
+	if reason == "" && s.paused != "" && s.running {
+		slog.Info("✓ Simulator resumed")
+	}
+	if reason == "" && !s.running {
+		slog.Info("✓ Simulator started")
+	}
+	if reason == "" {
+		s.running = true
+	}
+	s.paused = reason
+}
+
+func (s *Simulator) runBatch() {
+	_, updates, err := simulateBatch()
+	attempted, failed := len(updates), 0
+	if err != nil {
+		slog.Error("Simulator batch failed", "error", err)
+		attempted, failed = 1, 1
+	} else if len(updates) > 0 {
+		n := int64(len(updates))
+		backgroundUpdates.Add(n)
+		failed = len(updates) - processRatingUpdates(updates)
+		backgroundUpdates.Add(-n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordLocked(attempted, failed)
+	s.batches++
+}
+
+func (s *Simulator) recordLocked(attempted, failed int) {
+	s.attempted[s.slot], s.failed[s.slot] = attempted, failed
+	s.slot = (s.slot + 1) % simulatorErrorWindow
+}
+
+// errorRateLocked is the share of failed updates over the last batches.
+func (s *Simulator) errorRateLocked() float64 {
+	attempted, failed := 0, 0
+	for i := range s.attempted {
+		attempted += s.attempted[i]
+		failed += s.failed[i]
+	}
+	if attempted == 0 {
+		return 0
+	}
+	return float64(failed) / float64(attempted)
+}
+
+func (s *Simulator) Stats() SimulatorStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SimulatorStats{
+		Running:      s.running && s.paused == "",
+		Paused:       s.paused != "",
+		PauseReason:  s.paused,
+		Batches:      s.batches,
+		ErrorRate:    s.errorRateLocked(),
+		DBLatencyMs:  s.latency.Milliseconds(),
+		IntervalMs:   s.interval.Milliseconds(),
+		MaxErrorRate: s.maxErrorRate,
+		MaxLatencyMs: s.maxLatency.Milliseconds(),
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	slackSignatureMaxAge = 5 * time.Minute
+
+	slackEphemeral = "ephemeral"
+	slackInChannel = "in_channel"
+
+	// Appending this word to a command posts the answer to the whole channel
+	// instead of only to the caller.
+	slackShareKeyword = "public"
+)
+
+type SlackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// slackAuthMiddleware implements Slack's v0 request signing: the signature is
+// "v0=" + hex(HMAC-SHA256(secret, "v0:" + timestamp + ":" + rawBody)).
+// Verification is skipped when SLACK_SIGNING_SECRET is unset.
+func slackAuthMiddleware() gin.HandlerFunc {
+	secret := getEnv("SLACK_SIGNING_SECRET", "")
+	if secret == "" {
+		slog.Warn("SLACK_SIGNING_SECRET not set, Slack endpoint is unauthenticated")
+	}
+
+	return func(c *gin.Context) {
+		if secret == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		timestamp := c.GetHeader("X-Slack-Request-Timestamp")
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || math.Abs(time.Since(time.Unix(ts, 0)).Seconds()) > slackSignatureMaxAge.Seconds() {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":"))
+		mac.Write(body)
+		expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+		if !hmac.Equal([]byte(expected), []byte(c.GetHeader("X-Slack-Signature"))) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HandleSlackCommand serves the /rank <username> and /top [n] slash commands.
+// Slack shows any non-200 as a generic failure, so user errors are returned as
+// ephemeral messages with a 200.
+func (h *Handlers) HandleSlackCommand(c *gin.Context) {
+	command := strings.TrimSpace(c.PostForm("command"))
+	args := strings.Fields(c.PostForm("text"))
+
+	responseType := slackEphemeral
+	if len(args) > 0 && strings.EqualFold(args[len(args)-1], slackShareKeyword) {
+		responseType = slackInChannel
+		args = args[:len(args)-1]
+	}
+
+	switch command {
+	case "/rank":
+		if len(args) == 0 {
+			c.JSON(http.StatusOK, SlackResponse{
+				ResponseType: slackEphemeral,
+				Text:         "Usage: `/rank <username> [public]`",
+			})
+			return
+		}
+
+		user, rank, err := h.Service.User(c.Request.Context(), args[0])
+		if err != nil {
+			c.JSON(http.StatusOK, SlackResponse{
+				ResponseType: slackEphemeral,
+				Text:         fmt.Sprintf("❓ No player named `%s`", args[0]),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, SlackResponse{
+			ResponseType: responseType,
+			Text:         formatUserRank(user, rank, slackBold),
+		})
+
+	case "/top":
+		n := DefaultIntegrationTop
+		if len(args) > 0 {
+			n = parseIntParam(args[0], DefaultIntegrationTop)
+		}
+		if n < 1 {
+			n = DefaultIntegrationTop
+		}
+		if n > MaxIntegrationTop {
+			n = MaxIntegrationTop
+		}
+
+		rows, err := h.Service.Top(c.Request.Context(), n)
+		if err != nil {
+			requestLog(c).Error("Error fetching Slack top list", "error", err)
+			c.JSON(http.StatusOK, SlackResponse{
+				ResponseType: slackEphemeral,
+				Text:         "⚠️ Failed to fetch leaderboard, please try again",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, SlackResponse{
+			ResponseType: responseType,
+			Text:         formatTopTable(n, rows, slackBold),
+		})
+
+	default:
+		c.JSON(http.StatusOK, SlackResponse{
+			ResponseType: slackEphemeral,
+			Text:         fmt.Sprintf("Unknown command `%s`. Try `/rank <username>` or `/top [n]`.", command),
+		})
+	}
+}
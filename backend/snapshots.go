@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const ratingSnapshotSchema = `
+	CREATE TABLE IF NOT EXISTS rating_snapshots (
+		id BIGSERIAL PRIMARY KEY,
+		taken_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		ratings INT[] NOT NULL,
+		counts INT[] NOT NULL,
+		total_users INT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rating_snapshots_taken ON rating_snapshots(taken_at);
+`
+
+// The snapshotter stores the ranked rating distribution every
+// RATING_SNAPSHOT_INTERVAL_SEC and keeps RATING_SNAPSHOT_RETENTION_DAYS of
+// them, so past ranks can be approximated without replaying every change.
+type RatingSnapshotter struct {
+	interval  time.Duration
+	retention time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var ratingSnapshotter *RatingSnapshotter
+
+func StartRatingSnapshotter() {
+	interval := time.Duration(getEnvInt("RATING_SNAPSHOT_INTERVAL_SEC", 3600)) * time.Second
+	if interval <= 0 || isReplica() {
+		slog.Info("Rating snapshots disabled")
+		return
+	}
+
+	ratingSnapshotter = &RatingSnapshotter{
+		interval:  interval,
+		retention: time.Duration(getEnvInt("RATING_SNAPSHOT_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go ratingSnapshotter.run()
+	slog.Info("✓ Rating snapshots started", "interval", interval)
+}
+
+func StopRatingSnapshotter() {
+	if ratingSnapshotter == nil {
+		return
+	}
+	close(ratingSnapshotter.stop)
+	<-ratingSnapshotter.done
+}
+
+func (s *RatingSnapshotter) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.snapshot()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.snapshot()
+		}
+	}
+}
+
+func (s *RatingSnapshotter) snapshot() {
+	counts, err := GetRatingCounts()
+	if err != nil {
+		slog.Error("Rating snapshot failed", "error", err)
+		return
+	}
+
+	ratings := make([]int64, 0, len(counts))
+	values := make([]int64, 0, len(counts))
+	total := 0
+	for rating, count := range counts {
+		ratings = append(ratings, int64(rating))
+		values = append(values, int64(count))
+		total += count
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO rating_snapshots (ratings, counts, total_users) VALUES ($1, $2, $3)
+	`, pq.Array(ratings), pq.Array(values), total); err != nil {
+		slog.Error("Rating snapshot failed", "error", err)
+		return
+	}
+	if _, err := db.Exec(`
+		DELETE FROM rating_snapshots WHERE taken_at < $1
+	`, time.Now().Add(-s.retention)); err != nil {
+		slog.Error("Pruning rating snapshots failed", "error", err)
+	}
+}
+
+// rankInNearestSnapshot ranks rating against the snapshot taken closest to
+// at, returning the rank and when that snapshot was taken.
+func rankInNearestSnapshot(rating int, at time.Time) (int, time.Time, error) {
+	var rank int
+	var takenAt time.Time
+	err := db.QueryRow(`
+		WITH nearest AS (
+			SELECT id, taken_at, ratings, counts
+			FROM rating_snapshots
+			ORDER BY ABS(EXTRACT(EPOCH FROM taken_at - $1::timestamptz))
+			LIMIT 1
+		)
+		SELECT 1 + COALESCE((
+			SELECT SUM(c) FROM nearest, unnest(nearest.ratings, nearest.counts) AS t(r, c) WHERE r > $2
+		), 0), taken_at
+		FROM nearest
+	`, at, rating).Scan(&rank, &takenAt)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return rank, takenAt, nil
+}
+
+// ratingAt reconstructs a user's rating at a past moment from rating_history:
+// the newest change at or before it, or else the oldest change after it. A nil
+// rating means the user was still in placement. Changes that left no history
+// (direct /simulate updates) aren't visible, so the result is the rating as of
+// the recorded changes.
+func ratingAt(user *User, at time.Time) (*int, string, error) {
+	var newRating int
+	err := db.QueryRow(`
+		SELECT new_rating FROM rating_history
+		WHERE user_id = $1 AND created_at <= $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, user.ID, at).Scan(&newRating)
+	if err == nil {
+		return &newRating, "history", nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("failed to read rating history: %w", err)
+	}
+
+	var oldRating int
+	var source string
+	err = db.QueryRow(`
+		SELECT old_rating, source FROM rating_history
+		WHERE user_id = $1 AND created_at > $2
+		ORDER BY created_at, id
+		LIMIT 1
+	`, user.ID, at).Scan(&oldRating, &source)
+	if errors.Is(err, sql.ErrNoRows) {
+		if user.InPlacement {
+			return nil, "placement", nil
+		}
+		return &user.Rating, "current", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read rating history: %w", err)
+	}
+	if source == HistorySourcePlacement {
+		return nil, "placement", nil
+	}
+	return &oldRating, "history", nil
+}
+
+// HandleRatingAt answers GET /users/:username/rating?at=<RFC 3339 time>.
+func HandleRatingAt(c *gin.Context) {
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "at must be an RFC 3339 timestamp, e.g. 2024-05-01T12:00:00Z")
+		return
+	}
+	if at.After(time.Now()) {
+		respondError(c, http.StatusBadRequest, "at must not be in the future")
+		return
+	}
+
+	user, err := GetUserByUsername(c.Param("username"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	rating, source, err := ratingAt(user, at)
+	if err != nil {
+		requestLog(c).Error("Error reconstructing rating", "username", user.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to reconstruct rating")
+		return
+	}
+
+	resp := gin.H{
+		"username": user.Username,
+		"at":       at.UTC().Format(time.RFC3339),
+		"rating":   rating,
+		"source":   source,
+	}
+	if rating != nil {
+		rank, takenAt, err := rankInNearestSnapshot(*rating, at)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			requestLog(c).Error("Error ranking in snapshot", "username", user.Username, "error", err)
+		default:
+			resp["approx_rank"] = rank
+			resp["snapshot_at"] = takenAt.UTC().Format(time.RFC3339)
+		}
+	}
+	respond(c, http.StatusOK, resp)
+}
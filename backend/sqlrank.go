@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/lib/pq"
+)
+
+// SQLEngine answers every rank query from PostgreSQL instead of memory. A
+// rank is 1 + the number of ranked users rated strictly higher, which is what
+// RANK() OVER (ORDER BY rating DESC) assigns; counting against the rating
+// index also answers ratings nobody currently holds. Writes are no-ops since
+// the users table is already the source of truth.
+//
+// It is far slower than the in-memory engines and is meant as a correctness
+// oracle (SHADOW_ENGINE=sql) or an emergency fallback (RANK_ENGINE=sql) when
+// the in-memory state is suspect.
+// SQLEngine keeps no state, but callers still report every change to it,
+// which is what its version counts.
+type SQLEngine struct {
+	engineVersion
+}
+
+func NewSQLEngine() *SQLEngine {
+	se := &SQLEngine{}
+	se.bump()
+	return se
+}
+
+func (se *SQLEngine) GetRank(rating int) int {
+	var rank int
+	err := db.QueryRow(`
+		SELECT COUNT(*) + 1 FROM users WHERE rating > $1 AND NOT in_placement
+	`, rating).Scan(&rank)
+	if err != nil {
+		slog.Error("SQL engine: failed to compute rank", "rating", rating, "error", err)
+		return -1
+	}
+	return rank
+}
+
+func (se *SQLEngine) GetRankBatch(ratings []int) []int {
+	ranks := make([]int, len(ratings))
+	for i := range ranks {
+		ranks[i] = -1
+	}
+	if len(ratings) == 0 {
+		return ranks
+	}
+
+	rows, err := db.Query(`
+		SELECT q.ord, (SELECT COUNT(*) + 1 FROM users WHERE rating > q.rating AND NOT in_placement)
+		FROM unnest($1::int[]) WITH ORDINALITY AS q(rating, ord)
+	`, pq.Array(ratings))
+	if err != nil {
+		slog.Error("SQL engine: failed to compute rank batch", "error", err)
+		return ranks
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ord, rank int
+		if err := rows.Scan(&ord, &rank); err != nil {
+			slog.Error("SQL engine: failed to scan rank", "error", err)
+			return ranks
+		}
+		ranks[ord-1] = rank
+	}
+	return ranks
+}
+
+func (se *SQLEngine) FillRanks(rows []UserWithRank) {
+	ratings := make([]int, len(rows))
+	for i := range rows {
+		ratings[i] = rows[i].Rating
+	}
+	for i, rank := range se.GetRankBatch(ratings) {
+		rows[i].Rank = rank
+	}
+}
+
+func (se *SQLEngine) UpdateRating(oldRating, newRating int) { se.bump() }
+
+func (se *SQLEngine) BatchUpdateRatings(updates []RatingUpdate) { se.bump() }
+
+func (se *SQLEngine) AddUser(rating int) { se.bump() }
+
+func (se *SQLEngine) RemoveUser(rating int) { se.bump() }
+
+func (se *SQLEngine) GetStats() (totalUsers int, uniqueRatings int, minRatingWithUsers int, maxRatingWithUsers int) {
+	err := db.QueryRow(`
+		SELECT COUNT(*), COUNT(DISTINCT rating), COALESCE(MIN(rating), -1), COALESCE(MAX(rating), -1)
+		FROM users
+		WHERE NOT in_placement
+	`).Scan(&totalUsers, &uniqueRatings, &minRatingWithUsers, &maxRatingWithUsers)
+	if err != nil {
+		slog.Error("SQL engine: failed to compute stats", "error", err)
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const stabilityWindow = time.Hour
+
+// StabilityTracker snapshots the top of the board at a fixed interval and
+// compares the newest snapshot with the one taken about an hour earlier, so
+// operators can see when simulation or decay settings make the board churn.
+type StabilityTracker struct {
+	topN     int
+	interval time.Duration
+
+	mu        sync.RWMutex
+	snapshots []stabilitySnapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type stabilitySnapshot struct {
+	at      time.Time
+	members []int64 // user ids in rank order
+}
+
+type StabilityStats struct {
+	TopN          int        `json:"top_n"`
+	Snapshots     int        `json:"snapshots"`
+	WindowSeconds int        `json:"window_seconds"`
+	Entered       int        `json:"entered"`
+	Churn         float64    `json:"churn"`
+	ChurnPerHour  float64    `json:"churn_per_hour"`
+	KendallTau    *float64   `json:"kendall_tau"`
+	LastSnapshot  *time.Time `json:"last_snapshot"`
+}
+
+var stabilityTracker *StabilityTracker
+
+func StartStabilityTracker() {
+	interval := time.Duration(getEnvInt("STABILITY_INTERVAL_SEC", 300)) * time.Second
+	if interval <= 0 {
+		slog.Info("Rank stability tracking disabled")
+		return
+	}
+
+	stabilityTracker = &StabilityTracker{
+		topN:     getEnvInt("STABILITY_TOP_N", 100),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go stabilityTracker.run()
+	slog.Info("✓ Rank stability tracker started", "top_n", stabilityTracker.topN, "interval", interval)
+}
+
+func StopStabilityTracker() {
+	if stabilityTracker == nil {
+		return
+	}
+	close(stabilityTracker.stop)
+	<-stabilityTracker.done
+}
+
+func (t *StabilityTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	t.snapshot()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.snapshot()
+		}
+	}
+}
+
+func (t *StabilityTracker) snapshot() {
+	users, err := GetTopUsers(t.topN, 0)
+	if err != nil {
+		slog.Error("Stability snapshot failed", "error", err)
+		return
+	}
+
+	members := make([]int64, len(users))
+	for i, u := range users {
+		members[i] = u.ID
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.snapshots = append(t.snapshots, stabilitySnapshot{at: now, members: members})
+
+	// Keep one snapshot at or beyond the window edge as the baseline.
+	drop := 0
+	for drop+1 < len(t.snapshots) && now.Sub(t.snapshots[drop+1].at) >= stabilityWindow {
+		drop++
+	}
+	t.snapshots = t.snapshots[drop:]
+}
+
+func (t *StabilityTracker) Stats() StabilityStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := StabilityStats{TopN: t.topN, Snapshots: len(t.snapshots)}
+	if len(t.snapshots) == 0 {
+		return stats
+	}
+
+	latest := t.snapshots[len(t.snapshots)-1]
+	stats.LastSnapshot = &latest.at
+	if len(t.snapshots) < 2 {
+		return stats
+	}
+
+	base := t.snapshots[0]
+	window := latest.at.Sub(base.at)
+	stats.WindowSeconds = int(window.Seconds())
+
+	basePos := make(map[int64]int, len(base.members))
+	for i, id := range base.members {
+		basePos[id] = i
+	}
+
+	// Rank pairs of users present in both snapshots, in latest order.
+	var common [][2]int
+	for i, id := range latest.members {
+		if j, ok := basePos[id]; ok {
+			common = append(common, [2]int{i, j})
+		} else {
+			stats.Entered++
+		}
+	}
+
+	if len(latest.members) > 0 {
+		stats.Churn = float64(stats.Entered) / float64(len(latest.members))
+		stats.ChurnPerHour = stats.Churn * float64(time.Hour) / float64(window)
+	}
+	if tau, ok := kendallTau(common); ok {
+		stats.KendallTau = &tau
+	}
+	return stats
+}
+
+// kendallTau compares the two orderings of the same users: 1 means identical
+// order, -1 fully reversed.
+func kendallTau(pairs [][2]int) (float64, bool) {
+	n := len(pairs)
+	if n < 2 {
+		return 0, false
+	}
+
+	var concordant, discordant int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			a := pairs[i][0] - pairs[j][0]
+			b := pairs[i][1] - pairs[j][1]
+			if (a < 0) == (b < 0) {
+				concordant++
+			} else {
+				discordant++
+			}
+		}
+	}
+	return float64(concordant-discordant) / float64(n*(n-1)/2), true
+}
+
+// Position reports where a user stood in the latest snapshot: their 1-based
+// position, when it was taken, and whether they were in the top N at all.
+func (t *StabilityTracker) Position(userID int64) (int, time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(t.snapshots) == 0 {
+		return 0, time.Time{}, false
+	}
+	latest := t.snapshots[len(t.snapshots)-1]
+	for i, id := range latest.members {
+		if id == userID {
+			return i + 1, latest.at, true
+		}
+	}
+	return 0, latest.at, false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// The hottest queries run as prepared statements, so Postgres parses and
+// plans them once per connection instead of on every request. Each statement
+// is prepared on first use (its text can depend on settings read at startup,
+// such as the username collation) and database/sql re-prepares it on any
+// other connection it runs on. Set PREPARE_STATEMENTS=false behind a pooler
+// in transaction mode, such as PgBouncer, which can't keep a prepared
+// statement across transactions; the same queries then run unprepared.
+var preparedStatementsEnabled = getEnv("PREPARE_STATEMENTS", "true") == "true"
+
+type cachedStmt struct {
+	name  string
+	query func() string
+
+	mu   sync.Mutex
+	stmt atomic.Pointer[sql.Stmt]
+
+	failures atomic.Int64
+	execs    atomic.Int64
+}
+
+// StatementStats reports whether a statement is cached, not how often it was
+// prepared: database/sql prepares it again on each pool connection it runs
+// on without telling us.
+type StatementStats struct {
+	Cached   bool  `json:"cached"`
+	Failures int64 `json:"prepare_failures"`
+	Execs    int64 `json:"execs"`
+}
+
+// cachedStmts lists the prepared statements for /stats/runtime and shutdown.
+var cachedStmts = []*cachedStmt{topUsersStmt, topHumansStmt, userByUsernameStmt, updateRatingsStmt}
+
+// prepared returns the statement, preparing it if this is its first use. A
+// failed prepare is retried on the next call; until then callers run the
+// query unprepared.
+func (s *cachedStmt) prepared() *sql.Stmt {
+	if !preparedStatementsEnabled {
+		return nil
+	}
+	if stmt := s.stmt.Load(); stmt != nil {
+		return stmt
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stmt := s.stmt.Load(); stmt != nil {
+		return stmt
+	}
+	stmt, err := db.Prepare(s.query())
+	if err != nil {
+		// Only the first failure is logged, so a pooler that refuses
+		// every prepare doesn't log on every request.
+		if s.failures.Add(1) == 1 {
+			slog.Warn("Failed to prepare statement; running it unprepared", "statement", s.name, "error", err)
+		}
+		return nil
+	}
+	s.stmt.Store(stmt)
+	return stmt
+}
+
+func (s *cachedStmt) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+	s.execs.Add(1)
+	if stmt := s.prepared(); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return db.QueryContext(ctx, s.query(), args...)
+}
+
+func (s *cachedStmt) QueryRowContext(ctx context.Context, args ...any) *sql.Row {
+	s.execs.Add(1)
+	if stmt := s.prepared(); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return db.QueryRowContext(ctx, s.query(), args...)
+}
+
+func (s *cachedStmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	s.execs.Add(1)
+	if stmt := s.prepared(); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return db.ExecContext(ctx, s.query(), args...)
+}
+
+// preparedStatementStats feeds /stats/runtime.
+func preparedStatementStats() map[string]StatementStats {
+	stats := make(map[string]StatementStats, len(cachedStmts))
+	for _, s := range cachedStmts {
+		stats[s.name] = StatementStats{
+			Cached:   s.stmt.Load() != nil,
+			Failures: s.failures.Load(),
+			Execs:    s.execs.Load(),
+		}
+	}
+	return stats
+}
+
+// closeCachedStmts releases the statements before the pool closes.
+func closeCachedStmts() {
+	for _, s := range cachedStmts {
+		s.mu.Lock()
+		if stmt := s.stmt.Swap(nil); stmt != nil {
+			stmt.Close()
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import "context"
+
+// UserStore is the read side of user storage that handlers depend on.
+type UserStore interface {
+	TopUsers(ctx context.Context, limit, offset int) ([]User, error)
+	TopUsersAfter(ctx context.Context, after Cursor, limit int) ([]User, error)
+	// TopUsersBefore returns the users just above before, nearest first.
+	TopUsersBefore(ctx context.Context, before Cursor, limit int) ([]User, error)
+	// SearchUsers also reports whether the scan stopped at SEARCH_SCAN_CAP.
+	SearchUsers(ctx context.Context, term string, limit, offset int) ([]User, bool, error)
+	UserByUsername(ctx context.Context, username string) (*User, error)
+}
+
+// RankStore answers rank queries for handlers. Rebuilding reports that its
+// ranks are not currently trustworthy.
+type RankStore interface {
+	GetRank(rating int) int
+	FillRanks(rows []UserWithRank)
+	Rebuilding() bool
+}
+
+// Handlers adapts the read-side service to HTTP. Its stores are injected, so
+// it can be wired to fakes in tests or shared with alternate front ends.
+// Write paths (matches, simulation, admin) still use the package-level db and
+// engine.
+type Handlers struct {
+	Service *LeaderboardService
+	// Humans serves the default board without bots; nil serves everyone.
+	Humans *LeaderboardService
+}
+
+func NewHandlers(users UserStore, ranks RankStore) *Handlers {
+	return &Handlers{Service: NewLeaderboardService(users, ranks)}
+}
+
+// postgresUserStore is the production UserStore over the shared connection.
+type postgresUserStore struct{}
+
+func (postgresUserStore) TopUsers(ctx context.Context, limit, offset int) ([]User, error) {
+	return GetTopUsersContext(ctx, limit, offset)
+}
+
+func (postgresUserStore) TopUsersAfter(ctx context.Context, after Cursor, limit int) ([]User, error) {
+	return queryUsers(ctx, `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement
+			AND rating <= $1 AND (rating < $1 OR rating = $1 AND (`+collateUsername("username")+` > $2 OR username = $2 AND id > $3))
+		ORDER BY rating DESC, `+collateUsername("username")+` ASC, id ASC
+		LIMIT $4
+	`, after.Rating, after.Username, after.ID, limit)
+}
+
+func (postgresUserStore) TopUsersBefore(ctx context.Context, before Cursor, limit int) ([]User, error) {
+	return queryUsers(ctx, `
+		SELECT id, username, rating
+		FROM users
+		WHERE NOT in_placement
+			AND rating >= $1 AND (rating > $1 OR rating = $1 AND (`+collateUsername("username")+` < $2 OR username = $2 AND id < $3))
+		ORDER BY rating ASC, `+collateUsername("username")+` DESC, id DESC
+		LIMIT $4
+	`, before.Rating, before.Username, before.ID, limit)
+}
+
+func (postgresUserStore) SearchUsers(ctx context.Context, term string, limit, offset int) ([]User, bool, error) {
+	return SearchUsersByUsernameContext(ctx, term, limit, offset)
+}
+
+func (postgresUserStore) UserByUsername(ctx context.Context, username string) (*User, error) {
+	return GetUserByUsernameContext(ctx, username)
+}
+
+// engineRankStore resolves the current engine on every call so it follows the
+// engine InitRankingEngine installed, and routes page lookups through the
+// coalescer when one is configured.
+type engineRankStore struct{}
+
+func (engineRankStore) GetRank(rating int) int {
+	return GetRankingEngine().GetRank(rating)
+}
+
+func (engineRankStore) FillRanks(rows []UserWithRank) {
+	fillRanks(rows)
+}
+
+func (engineRankStore) Rebuilding() bool {
+	return engineRebuilding.Load()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	codecjson "github.com/gin-gonic/gin/codec/json"
+)
+
+const (
+	DefaultAdminListLimit = 1000
+	MaxAdminListLimit     = 100000
+)
+
+// streamFlushRows is how many rows are written between flushes.
+var streamFlushRows = getEnvInt("STREAM_FLUSH_ROWS", 500)
+
+type AdminUserRow struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Rating      int    `json:"rating"`
+	Rank        int    `json:"rank,omitempty"`
+	InPlacement bool   `json:"in_placement"`
+}
+
+// streamJSONRows writes {"success":true,"data":[...],"count":n} while rows are
+// still being scanned, so memory stays flat however many rows are requested.
+// Once the first byte is out the status can't change, so a failure mid-stream
+// closes the array and reports it in an "error" field instead.
+func streamJSONRows(c *gin.Context, rows *sql.Rows, scan func(*sql.Rows) (any, error)) {
+	defer rows.Close()
+
+	// Large listings can outlive the server-wide WriteTimeout.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		requestLog(c).Warn("Could not clear write deadline for streamed response", "error", err)
+	}
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	enc := codecjson.API.NewEncoder(w)
+	fmt.Fprint(w, `{"success":true,"data":[`)
+
+	count := 0
+	var streamErr error
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if count > 0 {
+			fmt.Fprint(w, ",")
+		}
+		if err := enc.Encode(item); err != nil {
+			streamErr = err
+			break
+		}
+		count++
+		if streamFlushRows > 0 && count%streamFlushRows == 0 {
+			w.Flush()
+		}
+	}
+	if streamErr == nil {
+		streamErr = rows.Err()
+	}
+
+	if streamErr != nil {
+		requestLog(c).Error("Error streaming rows", "rows_sent", count, "error", streamErr)
+		fmt.Fprintf(w, `],"count":%d,"error":"stream interrupted"}`, count)
+	} else {
+		fmt.Fprintf(w, `],"count":%d}`, count)
+	}
+	w.Flush()
+}
+
+// HandleAdminListUsers lists every user, placement included, by rating.
+func HandleAdminListUsers(c *gin.Context) {
+	limit := parseIntParam(c.Query("limit"), DefaultAdminListLimit)
+	offset := parseIntParam(c.Query("offset"), 0)
+	if limit < 1 || limit > MaxAdminListLimit || offset < 0 {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d and offset >= 0", MaxAdminListLimit))
+		return
+	}
+
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT id, username, rating, in_placement
+		FROM users
+		ORDER BY rating DESC, `+collateUsername("username")+` ASC, id ASC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		requestLog(c).Error("Error listing users", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list users")
+		return
+	}
+
+	re := GetRankingEngine()
+	streamJSONRows(c, rows, func(rows *sql.Rows) (any, error) {
+		var u AdminUserRow
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating, &u.InPlacement); err != nil {
+			return nil, err
+		}
+		if !u.InPlacement {
+			u.Rank = re.GetRank(u.Rating)
+		}
+		return u, nil
+	})
+}
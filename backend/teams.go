@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Team matches are rated with a two-team TrueSkill update. A player's
+// rating is their skill estimate μ, and team_skill keeps their uncertainty
+// σ. A team performs at the sum of its players' skills, each player's share
+// of the surprise is weighted by their σ², and σ shrinks with every match,
+// so new or rarely seen players move the most.
+const (
+	HistorySourceTeamMatch = "team_match"
+
+	maxTeamSize = 16
+)
+
+const teamSchema = `
+	CREATE TABLE IF NOT EXISTS team_matches (
+		id BIGSERIAL PRIMARY KEY,
+		external_id TEXT NOT NULL UNIQUE,
+		outcome TEXT NOT NULL CHECK (outcome IN ('a', 'b', 'draw')),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS team_match_players (
+		match_id BIGINT NOT NULL REFERENCES team_matches(id) ON DELETE CASCADE,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		team TEXT NOT NULL CHECK (team IN ('a', 'b')),
+		old_rating INT NOT NULL,
+		new_rating INT NOT NULL,
+		old_sigma DOUBLE PRECISION NOT NULL,
+		new_sigma DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (match_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_team_match_players_user ON team_match_players(user_id);
+
+	CREATE TABLE IF NOT EXISTS team_skill (
+		user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		sigma DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+`
+
+var (
+	teamModel = TrueSkillModel{
+		InitialSigma:    getEnvFloat("TEAM_SIGMA_INITIAL", 150),
+		Beta:            getEnvFloat("TEAM_BETA", 200),
+		Tau:             getEnvFloat("TEAM_TAU", 2),
+		DrawProbability: getEnvFloat("TEAM_DRAW_PROBABILITY", 0.1),
+	}
+
+	errTeamPlayerInPlacement = errors.New("player is still in placement")
+)
+
+// TrueSkillModel holds the model's constants in rating points. Beta is the
+// performance spread: a Beta lead wins about 76% of one-on-one games.
+type TrueSkillModel struct {
+	InitialSigma    float64
+	Beta            float64
+	Tau             float64
+	DrawProbability float64
+}
+
+type TeamPlayer struct {
+	Mu    float64
+	Sigma float64
+}
+
+func normPDF(x float64) float64 {
+	return math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+}
+
+func normCDF(x float64) float64 {
+	return math.Erfc(-x/math.Sqrt2) / 2
+}
+
+// The v and w functions give the mean and variance corrections for a
+// performance difference t (in units of c) against a draw margin e.
+func vWin(t, e float64) float64 {
+	x := t - e
+	if d := normCDF(x); d > 1e-160 {
+		return normPDF(x) / d
+	}
+	return -x
+}
+
+func wWin(t, e float64) float64 {
+	v := vWin(t, e)
+	return min(max(v*(v+t-e), 0), 1)
+}
+
+func vDraw(t, e float64) float64 {
+	a, b := e-math.Abs(t), -e-math.Abs(t)
+	v := a
+	if d := normCDF(a) - normCDF(b); d > 1e-160 {
+		v = (normPDF(b) - normPDF(a)) / d
+	}
+	if t < 0 {
+		return -v
+	}
+	return v
+}
+
+func wDraw(t, e float64) float64 {
+	a, b := e-math.Abs(t), -e-math.Abs(t)
+	d := normCDF(a) - normCDF(b)
+	if d <= 1e-160 {
+		return 1
+	}
+	v := vDraw(math.Abs(t), e)
+	return min(max(v*v+(a*normPDF(a)-b*normPDF(b))/d, 0), 1)
+}
+
+// Rate updates both teams for one result. first is the winning team, or
+// either team on a draw.
+func (m TrueSkillModel) Rate(first, second []TeamPlayer, draw bool) ([]TeamPlayer, []TeamPlayer) {
+	n := float64(len(first) + len(second))
+	varSum, muDiff := n*m.Beta*m.Beta, 0.0
+	for _, p := range first {
+		varSum += p.Sigma*p.Sigma + m.Tau*m.Tau
+		muDiff += p.Mu
+	}
+	for _, p := range second {
+		varSum += p.Sigma*p.Sigma + m.Tau*m.Tau
+		muDiff -= p.Mu
+	}
+	c := math.Sqrt(varSum)
+	margin := math.Sqrt2 * math.Erfinv(m.DrawProbability) * math.Sqrt(n) * m.Beta
+
+	t, e := muDiff/c, margin/c
+	v, w := vWin(t, e), wWin(t, e)
+	if draw {
+		v, w = vDraw(t, e), wDraw(t, e)
+	}
+
+	update := func(team []TeamPlayer, sign float64) []TeamPlayer {
+		out := make([]TeamPlayer, len(team))
+		for i, p := range team {
+			variance := p.Sigma*p.Sigma + m.Tau*m.Tau
+			out[i] = TeamPlayer{
+				Mu:    p.Mu + sign*variance/c*v,
+				Sigma: math.Sqrt(variance * max(1-variance/(c*c)*w, 0.0001)),
+			}
+		}
+		return out
+	}
+	return update(first, 1), update(second, -1)
+}
+
+type TeamMatchRequest struct {
+	MatchID string   `json:"match_id"`
+	TeamA   []string `json:"team_a"`
+	TeamB   []string `json:"team_b"`
+	Outcome string   `json:"outcome"`
+
+	approved bool
+}
+
+type TeamMatchPlayerResult struct {
+	Username  string  `json:"username"`
+	Team      string  `json:"team"`
+	OldRating int     `json:"old_rating"`
+	NewRating int     `json:"new_rating"`
+	Delta     int     `json:"delta"`
+	Sigma     float64 `json:"sigma"`
+	Rank      int     `json:"rank"`
+}
+
+type TeamMatchResponse struct {
+	Success bool                    `json:"success"`
+	ID      int64                   `json:"id"`
+	MatchID string                  `json:"match_id"`
+	Outcome string                  `json:"outcome"`
+	Players []TeamMatchPlayerResult `json:"players"`
+}
+
+// HandleCreateTeamMatch serves POST /matches/team. Every player's rating,
+// history row, and σ commit in one transaction with a single outbox event,
+// which the relay applies to the engine as one batch.
+func HandleCreateTeamMatch(c *gin.Context) {
+	var req TeamMatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.MatchID = strings.TrimSpace(req.MatchID)
+	scoreA, ok := outcomeScore(req.Outcome)
+	if req.MatchID == "" || len(req.TeamA) == 0 || len(req.TeamB) == 0 || !ok {
+		respondError(c, http.StatusBadRequest, "match_id, team_a, team_b, and outcome (a, b, or draw) are required")
+		return
+	}
+	if len(req.TeamA) > maxTeamSize || len(req.TeamB) > maxTeamSize {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Teams can have at most %d players", maxTeamSize))
+		return
+	}
+	seen := map[string]bool{}
+	for _, name := range append(append([]string{}, req.TeamA...), req.TeamB...) {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if key == "" || seen[key] {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Every player must be named once across both teams (%q)", name))
+			return
+		}
+		seen[key] = true
+	}
+
+	matchID, players, err := recordTeamMatch(req, scoreA)
+	var flagged *flaggedSubmissionError
+	if errors.As(err, &flagged) {
+		respondQuarantined(c, flagged, &QuarantinedMatch{TeamMatch: &req})
+		return
+	}
+	if errors.Is(err, errTeamPlayerInPlacement) {
+		respondError(c, http.StatusConflict, err.Error()+"; placement matches are played with POST /matches")
+		return
+	}
+	if err != nil {
+		c.Error(matchError(err, req.MatchID))
+		return
+	}
+
+	outboxRelay.Flush()
+
+	updated := 0
+	re := GetRankingEngine()
+	for i := range players {
+		if players[i].Delta != 0 {
+			updated++
+		}
+		players[i].Rank = re.GetRank(players[i].NewRating)
+	}
+	meterRatingUpdates(c, updated)
+
+	respond(c, http.StatusCreated, TeamMatchResponse{
+		Success: true,
+		ID:      matchID,
+		MatchID: req.MatchID,
+		Outcome: req.Outcome,
+		Players: players,
+	})
+}
+
+func recordTeamMatch(req TeamMatchRequest, scoreA float64) (int64, []TeamMatchPlayerResult, error) {
+	defer writeSlots.acquire(WriteInteractive)()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin team match transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	names := make([]string, 0, len(req.TeamA)+len(req.TeamB))
+	for _, name := range append(append([]string{}, req.TeamA...), req.TeamB...) {
+		names = append(names, strings.ToLower(strings.TrimSpace(name)))
+	}
+
+	// Lock every player in id order so overlapping matches can't deadlock.
+	rows, err := tx.Query(`
+		SELECT id, username, rating, in_placement
+		FROM users
+		WHERE LOWER(username) = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`, pq.Array(names))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to lock team match players: %w", err)
+	}
+	byName := map[string]*User{}
+	var ids []int64
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating, &u.InPlacement); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan team match player: %w", err)
+		}
+		byName[strings.ToLower(u.Username)] = &u
+		ids = append(ids, u.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating team match players: %w", err)
+	}
+	if len(byName) != len(names) {
+		return 0, nil, errMatchUserNotFound
+	}
+	for _, u := range byName {
+		if u.InPlacement {
+			return 0, nil, fmt.Errorf("%w: %s", errTeamPlayerInPlacement, u.Username)
+		}
+	}
+	if err := checkMatchRecorded(tx, `SELECT EXISTS (SELECT 1 FROM team_matches WHERE external_id = $1)`, req.MatchID); err != nil {
+		return 0, nil, err
+	}
+
+	sigmas := map[int64]float64{}
+	rows, err = tx.Query(`SELECT user_id, sigma FROM team_skill WHERE user_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read team skill: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var sigma float64
+		if err := rows.Scan(&id, &sigma); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan team skill: %w", err)
+		}
+		sigmas[id] = sigma
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating team skill: %w", err)
+	}
+
+	roster := func(team []string) ([]*User, []TeamPlayer) {
+		users := make([]*User, len(team))
+		skills := make([]TeamPlayer, len(team))
+		for i, name := range team {
+			u := byName[strings.ToLower(strings.TrimSpace(name))]
+			sigma, ok := sigmas[u.ID]
+			if !ok {
+				sigma = teamModel.InitialSigma
+			}
+			users[i], skills[i] = u, TeamPlayer{Mu: float64(u.Rating), Sigma: sigma}
+		}
+		return users, skills
+	}
+	usersA, before := roster(req.TeamA)
+	usersB, beforeB := roster(req.TeamB)
+	var afterA, afterB []TeamPlayer
+	if scoreA < 0.5 {
+		afterB, afterA = teamModel.Rate(beforeB, before, false)
+	} else {
+		afterA, afterB = teamModel.Rate(before, beforeB, scoreA == 0.5)
+	}
+
+	var players []TeamMatchPlayerResult
+	var oldSigmas, newSigmas []float64
+	var users []*User
+	for _, side := range []struct {
+		team          string
+		users         []*User
+		before, after []TeamPlayer
+	}{
+		{OutcomePlayerA, usersA, before, afterA},
+		{OutcomePlayerB, usersB, beforeB, afterB},
+	} {
+		for i, u := range side.users {
+			newRating := clampRating(int(math.Round(side.after[i].Mu)))
+			players = append(players, TeamMatchPlayerResult{
+				Username:  u.Username,
+				Team:      side.team,
+				OldRating: u.Rating,
+				NewRating: newRating,
+				Delta:     newRating - u.Rating,
+				Sigma:     math.Round(side.after[i].Sigma*100) / 100,
+			})
+			oldSigmas = append(oldSigmas, side.before[i].Sigma)
+			newSigmas = append(newSigmas, side.after[i].Sigma)
+			users = append(users, u)
+		}
+	}
+
+	proposed := make([]RatingUpdatedEvent, len(players))
+	for i, p := range players {
+		proposed[i] = RatingUpdatedEvent{UserID: users[i].ID, Username: p.Username, OldRating: p.OldRating, NewRating: p.NewRating, Source: HistorySourceTeamMatch}
+	}
+	if err := applyRatingRules(tx, proposed); err != nil {
+		return 0, nil, err
+	}
+	for i := range players {
+		players[i].NewRating = proposed[i].NewRating
+		players[i].Delta = proposed[i].NewRating - proposed[i].OldRating
+	}
+	if err := runPreValidateHooks(proposed); err != nil {
+		return 0, nil, err
+	}
+	if !req.approved {
+		if err := screenRatingUpdates(proposed); err != nil {
+			return 0, nil, err
+		}
+	}
+	limits, err := loadVolatilityLimit(tx, DefaultBoard)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i, p := range players {
+		if err := limits.check(tx, users[i], p.Delta); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var matchID int64
+	err = tx.QueryRow(`
+		INSERT INTO team_matches (external_id, outcome) VALUES ($1, $2) RETURNING id
+	`, req.MatchID, req.Outcome).Scan(&matchID)
+	if isUniqueViolation(err) {
+		return 0, nil, errDuplicateMatch
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to insert team match: %w", err)
+	}
+
+	batch := RatingsUpdatedEvent{Source: HistorySourceTeamMatch}
+	for i, p := range players {
+		u := users[i]
+		if _, err := tx.Exec(`
+			INSERT INTO team_match_players (match_id, user_id, team, old_rating, new_rating, old_sigma, new_sigma)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, matchID, u.ID, p.Team, p.OldRating, p.NewRating, oldSigmas[i], newSigmas[i]); err != nil {
+			return 0, nil, fmt.Errorf("failed to insert team match player: %w", err)
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO team_skill (user_id, sigma) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET sigma = EXCLUDED.sigma, updated_at = NOW()
+		`, u.ID, newSigmas[i]); err != nil {
+			return 0, nil, fmt.Errorf("failed to update team skill: %w", err)
+		}
+		if p.Delta == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE users SET rating = $1 WHERE id = $2`, p.NewRating, u.ID); err != nil {
+			return 0, nil, fmt.Errorf("failed to update team match player rating: %w", err)
+		}
+		if err := insertRatingHistory(tx, u.ID, p.OldRating, p.NewRating, HistorySourceTeamMatch, nil); err != nil {
+			return 0, nil, err
+		}
+		batch.Updates = append(batch.Updates, RatingUpdatedEvent{
+			UserID:    u.ID,
+			Username:  u.Username,
+			OldRating: p.OldRating,
+			NewRating: p.NewRating,
+			Source:    HistorySourceTeamMatch,
+		})
+	}
+	if len(batch.Updates) > 0 {
+		if err := insertOutboxEvent(tx, EventRatingsUpdated, batch); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit team match: %w", err)
+	}
+	if !req.approved {
+		for _, s := range ratingSubmissions(proposed) {
+			observeSubmission(s)
+		}
+	}
+	return matchID, players, nil
+}
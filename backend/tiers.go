@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A tier table is cut either by rating or by percentile. Percentile tiers
+// (min_percentile 99 is the top 1%) move with the board: their rating
+// cutoffs are resolved from the engine's counts and cached until the engine's
+// version changes. Percentile is the share of ranked users at or below a
+// rating, as /users/:username/rank reports it.
+type Tier struct {
+	Name          string   `json:"name"`
+	MinRating     int      `json:"min_rating"`
+	MinPercentile *float64 `json:"min_percentile,omitempty"`
+}
+
+// Ordered from highest to lowest so the first match wins.
+var defaultTiers = []Tier{
+	{Name: "Grandmaster", MinRating: 4200},
+	{Name: "Diamond", MinRating: 3400},
+	{Name: "Platinum", MinRating: 2600},
+	{Name: "Gold", MinRating: 1800},
+	{Name: "Silver", MinRating: 1000},
+	{Name: "Bronze", MinRating: MinRating},
+}
+
+var resolvedTiers struct {
+	mu      sync.Mutex
+	version int64
+	tiers   []Tier
+}
+
+func tiersByPercentile() bool {
+	return defaultTiers[0].MinPercentile != nil
+}
+
+// currentTiers returns the tier table with every cutoff as a rating.
+func currentTiers() []Tier {
+	if !tiersByPercentile() {
+		return defaultTiers
+	}
+	re := GetRankingEngine()
+	version := re.Version()
+
+	resolvedTiers.mu.Lock()
+	defer resolvedTiers.mu.Unlock()
+	if resolvedTiers.tiers != nil && resolvedTiers.version == version {
+		return resolvedTiers.tiers
+	}
+	total, _, _, _ := re.GetStats()
+	tiers := make([]Tier, len(defaultTiers))
+	for i, t := range defaultTiers {
+		t.MinRating = percentileCutoff(re, total, *t.MinPercentile)
+		tiers[i] = t
+	}
+	resolvedTiers.version, resolvedTiers.tiers = version, tiers
+	return tiers
+}
+
+// percentileCutoff finds the lowest rating at or above percentile p, or
+// MaxRating+1 when no rating is.
+func percentileCutoff(re RankEngine, total int, p float64) int {
+	if p <= 0 {
+		return MinRating
+	}
+	if total == 0 {
+		return MaxRating + 1
+	}
+	return MinRating + sort.Search(MaxRating-MinRating+1, func(i int) bool {
+		above := re.GetRank(MinRating+i) - 1
+		return float64(total-above)*100 >= p*float64(total)
+	})
+}
+
+func tierForRating(rating int) string {
+	return tierIn(currentTiers(), rating)
+}
+
+func tierIn(tiers []Tier, rating int) string {
+	for _, t := range tiers {
+		if rating >= t.MinRating {
+			return t.Name
+		}
+	}
+	return tiers[len(tiers)-1].Name
+}
+
+// fillTiers sets the tier of rows on the default board.
+func fillTiers(rows []UserWithRank) {
+	if len(rows) == 0 {
+		return
+	}
+	tiers := currentTiers()
+	for i := range rows {
+		rows[i].Tier = tierIn(tiers, rows[i].Rating)
+	}
+}
+
+type TierStats struct {
+	Name          string   `json:"name"`
+	MinRating     int      `json:"min_rating"`
+	MaxRating     int      `json:"max_rating"`
+	MinPercentile *float64 `json:"min_percentile,omitempty"`
+	Population    int      `json:"population"`
+	Share         float64  `json:"share"`
+}
+
+// HandleListTiers serves GET /tiers: the tier table with each tier's current
+// rating range and how many ranked users (bots included) fall in it, read
+// from the engine in one GetRankBatch call.
+func HandleListTiers(c *gin.Context) {
+	re := GetRankingEngine()
+	version := re.Version()
+	tiers := currentTiers()
+	total, _, _, _ := re.GetStats()
+
+	// The users rated at or above a cutoff are those above cutoff-1.
+	edges := make([]int, len(tiers))
+	for i, t := range tiers {
+		edges[i] = min(t.MinRating, MaxRating+1) - 1
+	}
+	ranks := re.GetRankBatch(edges)
+	atOrAbove := func(i int) int {
+		if edges[i] < MinRating {
+			return total
+		}
+		return ranks[i] - 1
+	}
+
+	stats := make([]TierStats, len(tiers))
+	for i, t := range tiers {
+		s := TierStats{Name: t.Name, MinRating: t.MinRating, MaxRating: MaxRating, MinPercentile: t.MinPercentile, Population: atOrAbove(i)}
+		if i > 0 {
+			s.MaxRating = tiers[i-1].MinRating - 1
+			s.Population -= atOrAbove(i - 1)
+		}
+		s.Population = max(s.Population, 0)
+		if total > 0 {
+			s.Share = math.Round(float64(s.Population)/float64(total)*10000) / 100
+		}
+		stats[i] = s
+	}
+
+	basis := "rating"
+	if tiersByPercentile() {
+		basis = "percentile"
+	}
+	respond(c, http.StatusOK, gin.H{
+		"basis":       basis,
+		"total_users": total,
+		"version":     version,
+		"tiers":       stats,
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const usageSchema = `
+	CREATE TABLE IF NOT EXISTS usage_daily (
+		day DATE NOT NULL,
+		api_key TEXT NOT NULL,
+		api_calls BIGINT NOT NULL DEFAULT 0,
+		rating_updates BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, api_key)
+	);
+`
+
+const (
+	usageAnonymous    = "anonymous"
+	usageKeyPrefixLen = 12
+	usageMaxDays      = 366
+)
+
+// UsageMeter counts API calls and rating updates per API key in memory and
+// adds them to usage_daily every USAGE_FLUSH_INTERVAL_SEC, so metering costs
+// one upsert per key per interval rather than a write per request.
+type UsageMeter struct {
+	mu      sync.Mutex
+	pending map[usageBucket]*usageCounts
+
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type usageBucket struct {
+	day string
+	key string
+}
+
+type usageCounts struct {
+	calls   int64
+	updates int64
+}
+
+type UsageRow struct {
+	Day           string `json:"day,omitempty"`
+	APIKey        string `json:"api_key"`
+	APICalls      int64  `json:"api_calls"`
+	RatingUpdates int64  `json:"rating_updates"`
+}
+
+var usageMeter *UsageMeter
+
+// StartUsageMeter enables metering when USAGE_METERING=true. Replicas forward
+// writes to the primary and can't write usage rows, so they never meter.
+func StartUsageMeter() {
+	if getEnv("USAGE_METERING", "false") != "true" || isReplica() {
+		return
+	}
+	interval := time.Duration(getEnvInt("USAGE_FLUSH_INTERVAL_SEC", 60)) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	usageMeter = &UsageMeter{
+		pending:  map[usageBucket]*usageCounts{},
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go usageMeter.run()
+	slog.Info("✓ Usage metering started", "flush_interval", interval)
+}
+
+// StopUsageMeter writes out any counts not yet flushed.
+func StopUsageMeter() {
+	if usageMeter == nil {
+		return
+	}
+	close(usageMeter.stop)
+	<-usageMeter.done
+}
+
+func (m *UsageMeter) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			if err := m.Flush(); err != nil {
+				slog.Error("Usage flush failed on shutdown", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				slog.Error("Usage flush failed", "error", err)
+			}
+		}
+	}
+}
+
+func (m *UsageMeter) add(key string, calls, updates int64) {
+	m.addTo(usageBucket{day: time.Now().UTC().Format("2006-01-02"), key: key}, calls, updates)
+}
+
+func (m *UsageMeter) addTo(b usageBucket, calls, updates int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := m.pending[b]
+	if counts == nil {
+		counts = &usageCounts{}
+		m.pending[b] = counts
+	}
+	counts.calls += calls
+	counts.updates += updates
+}
+
+// Flush adds the pending counts to usage_daily. Counts that fail to write are
+// put back and retried on the next flush.
+func (m *UsageMeter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[usageBucket]*usageCounts{}
+	m.mu.Unlock()
+
+	for b, counts := range pending {
+		_, err := db.Exec(`
+			INSERT INTO usage_daily (day, api_key, api_calls, rating_updates)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (day, api_key) DO UPDATE SET
+				api_calls = usage_daily.api_calls + EXCLUDED.api_calls,
+				rating_updates = usage_daily.rating_updates + EXCLUDED.rating_updates
+		`, b.day, b.key, counts.calls, counts.updates)
+		if err != nil {
+			for b, counts := range pending {
+				m.addTo(b, counts.calls, counts.updates)
+			}
+			return fmt.Errorf("failed to write usage: %w", err)
+		}
+		delete(pending, b)
+	}
+	return nil
+}
+
+// usageKey identifies the caller for metering. Keys are stored as a short
+// SHA-256 prefix so usage rows never hold a usable credential.
+func usageKey(c *gin.Context) string {
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		return usageAnonymous
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:])[:usageKeyPrefixLen]
+}
+
+// usageMiddleware counts every request except health checks and CORS
+// preflights against the caller's key.
+func usageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if usageMeter == nil || c.Request.Method == http.MethodOptions || c.FullPath() == "/health" {
+			return
+		}
+		usageMeter.add(usageKey(c), 1, 0)
+	}
+}
+
+// meterRatingUpdates records rating changes made on behalf of the caller,
+// for usage reports and the caller's update quota.
+func meterRatingUpdates(c *gin.Context, n int) {
+	chargeUpdateQuota(c, n)
+	if usageMeter == nil || n <= 0 {
+		return
+	}
+	usageMeter.add(usageKey(c), 0, int64(n))
+}
+
+// HandleUsage reports daily usage per key between from and to (inclusive,
+// YYYY-MM-DD, default the last 30 days), optionally for a single key.
+func HandleUsage(c *gin.Context) {
+	if usageMeter == nil {
+		respondError(c, http.StatusNotFound, "Usage metering is disabled")
+		return
+	}
+
+	now := time.Now().UTC()
+	from, err := time.Parse("2006-01-02", c.DefaultQuery("from", now.AddDate(0, 0, -29).Format("2006-01-02")))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "from must be YYYY-MM-DD")
+		return
+	}
+	to, err := time.Parse("2006-01-02", c.DefaultQuery("to", now.Format("2006-01-02")))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "to must be YYYY-MM-DD")
+		return
+	}
+	if to.Before(from) || to.Sub(from) > usageMaxDays*24*time.Hour {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("from must not be after to, and the range is limited to %d days", usageMaxDays))
+		return
+	}
+
+	if err := usageMeter.Flush(); err != nil {
+		requestLog(c).Error("Error flushing usage before report", "error", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT day::text, api_key, api_calls, rating_updates
+		FROM usage_daily
+		WHERE day BETWEEN $1 AND $2 AND ($3 = '' OR api_key = $3)
+		ORDER BY day, api_key
+	`, from, to, c.Query("key"))
+	if err != nil {
+		requestLog(c).Error("Error querying usage", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to load usage")
+		return
+	}
+	defer rows.Close()
+
+	days := []UsageRow{}
+	totals := map[string]*UsageRow{}
+	for rows.Next() {
+		var r UsageRow
+		if err := rows.Scan(&r.Day, &r.APIKey, &r.APICalls, &r.RatingUpdates); err != nil {
+			requestLog(c).Error("Error scanning usage", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to load usage")
+			return
+		}
+		days = append(days, r)
+		t := totals[r.APIKey]
+		if t == nil {
+			t = &UsageRow{APIKey: r.APIKey}
+			totals[r.APIKey] = t
+		}
+		t.APICalls += r.APICalls
+		t.RatingUpdates += r.RatingUpdates
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating usage", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to load usage")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+		"days":   days,
+		"totals": totals,
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Usernames are unique up to Unicode case and compatibility forms:
+// username_key holds the NFKC casefold of the name under a unique index, so
+// "Ninja", "ninja", and "ｎｉｎｊａ" can only be one player. The column is
+// soft-launched (see schemachanges.go). The index already covers every row
+// that has a key; the backfill keys the existing rows and leaves a row
+// pending when its key belongs to another user, so the change completes only
+// once GET /admin/usernames/collisions is empty.
+const usernameKeySchema = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS username_key TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_key ON users(username_key);
+`
+
+var usernameKeyChange = &SchemaChange{
+	Name:       "username_key",
+	Table:      "users",
+	Pending:    "username_key IS NULL",
+	Apply:      backfillUsernameKeys,
+	Unresolved: "usernames collide after normalization, see GET /admin/usernames/collisions",
+}
+
+// usernameKey is the NFKC casefold of a username. Folding can leave text
+// that isn't NFKC, so it is normalized again afterwards.
+func usernameKey(username string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(username)))
+}
+
+// backfillUsernameKeys keys the pending users in [lo, hi], lowest id first.
+// A user whose key is already held, by a migrated row or a lower id in the
+// chunk, is skipped and stays pending until the collision is resolved.
+func backfillUsernameKeys(tx *sql.Tx, lo, hi int64) (int64, error) {
+	rows, err := tx.Query(`
+		SELECT id, username FROM users
+		WHERE id BETWEEN $1 AND $2 AND username_key IS NULL
+		ORDER BY id
+	`, lo, hi)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read usernames: %w", err)
+	}
+	var ids []int64
+	var names, keys []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan username: %w", err)
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+		keys = append(keys, usernameKey(name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating usernames: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	taken := map[string]bool{}
+	held, err := tx.Query(`SELECT username_key FROM users WHERE username_key = ANY($1)`, pq.Array(keys))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read username keys: %w", err)
+	}
+	for held.Next() {
+		var key string
+		if err := held.Scan(&key); err != nil {
+			held.Close()
+			return 0, fmt.Errorf("failed to scan username key: %w", err)
+		}
+		taken[key] = true
+	}
+	held.Close()
+	if err := held.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating username keys: %w", err)
+	}
+
+	var keyIDs []int64
+	var keyed []string
+	for i, key := range keys {
+		if taken[key] {
+			slog.Warn("Username collides after normalization; leaving it pending",
+				"user_id", ids[i], "username", names[i], "key", key)
+			continue
+		}
+		taken[key] = true
+		keyIDs = append(keyIDs, ids[i])
+		keyed = append(keyed, key)
+	}
+	if len(keyIDs) == 0 {
+		return 0, nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users u SET username_key = t.key
+		FROM unnest($1::bigint[], $2::text[]) AS t(id, key)
+		WHERE u.id = t.id
+	`, pq.Array(keyIDs), pq.Array(keyed))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type UsernameCollision struct {
+	Key   string `json:"key"`
+	Users []User `json:"users"`
+}
+
+// findUsernameCollisions groups users whose names share a key. Keys are
+// computed from the names rather than read from username_key, so it also
+// finds the collisions the backfill has yet to reach.
+func findUsernameCollisions() ([]UsernameCollision, error) {
+	rows, err := db.Query(`SELECT id, username, rating FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usernames: %w", err)
+	}
+	defer rows.Close()
+
+	groups := map[string][]User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Rating); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		key := usernameKey(u.Username)
+		groups[key] = append(groups[key], u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+
+	collisions := make([]UsernameCollision, 0)
+	for key, users := range groups {
+		if len(users) > 1 {
+			collisions = append(collisions, UsernameCollision{Key: key, Users: users})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Key < collisions[j].Key })
+	return collisions, nil
+}
+
+// HandleUsernameCollisions serves GET /admin/usernames/collisions. Each group
+// lists the users, oldest first, that would share a key; all but one have to
+// be renamed or deleted before the username_key change can complete.
+func HandleUsernameCollisions(c *gin.Context) {
+	collisions, err := findUsernameCollisions()
+	if err != nil {
+		requestLog(c).Error("Error finding username collisions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to find username collisions")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"collisions": collisions,
+		"count":      len(collisions),
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const EventUserDeleted = "user.deleted"
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+	newUserRating   = getEnvInt("NEW_USER_RATING", 1200)
+
+	errUsernameTaken = errors.New("username is taken")
+)
+
+// ensureUsernameLowerIndex makes LOWER(username) unique, replacing the plain
+// index lookups used before. Names that already differ only in case keep the
+// plain index and are logged; they show up in GET /admin/usernames/collisions,
+// and the unique index is built on the first start after they're resolved.
+func ensureUsernameLowerIndex() error {
+	var unique bool
+	if err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'users' AND indexname = 'idx_users_username_lower_unique')
+	`).Scan(&unique); err != nil {
+		return fmt.Errorf("failed to check username index: %w", err)
+	}
+	if unique {
+		return nil
+	}
+
+	var duplicates int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM (SELECT 1 FROM users GROUP BY LOWER(username) HAVING COUNT(*) > 1) d
+	`).Scan(&duplicates); err != nil {
+		return fmt.Errorf("failed to check for duplicate usernames: %w", err)
+	}
+	if duplicates > 0 {
+		slog.Warn("Usernames differ only in case; LOWER(username) stays non-unique until they're resolved", "names", duplicates)
+		if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_username_lower ON users(LOWER(username))`); err != nil {
+			return fmt.Errorf("failed to create username index: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower_unique ON users(LOWER(username));
+		DROP INDEX IF EXISTS idx_users_username_lower;
+	`); err != nil {
+		return fmt.Errorf("failed to create unique username index: %w", err)
+	}
+	slog.Info("✓ Usernames are unique ignoring case")
+	return nil
+}
+
+// UserDeletedEvent carries everything the engines counted for a deleted user,
+// so the relay and replicas can take it all out.
+type UserDeletedEvent struct {
+	UserID   int64          `json:"user_id"`
+	Username string         `json:"username"`
+	Rating   int            `json:"rating"`
+	Ranked   bool           `json:"ranked"`
+	Metrics  map[string]int `json:"metrics,omitempty"`
+	Boards   map[string]int `json:"boards,omitempty"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Rating   *int   `json:"rating"`
+}
+
+// registerUser inserts a real user on behalf of apiKey, which may be empty. A
+// user who is ranked straight away gets a user.placed outbox event in the same
+// transaction, which is how the engine and replicas learn about them.
+func registerUser(username string, rating int, apiKey string) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin user creation: %w", err)
+	}
+	defer tx.Rollback()
+
+	if apiKey != "" {
+		quota, err := loadQuota(tx, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := claimQuota(tx, apiKey, "max_users", quota.MaxUsers, 1, `SELECT COUNT(*) FROM users WHERE api_key = $1`); err != nil {
+			return nil, err
+		}
+	}
+
+	u := User{Username: username, Rating: rating, InPlacement: PlacementGames > 0}
+	// Lookups ignore case, so a name that differs only in case is taken too,
+	// as is one that normalizes to another user's key. Two concurrent inserts
+	// can both pass the check; the unique indexes on LOWER(username) and
+	// username_key stop the second, and its violation is the same error.
+	err = tx.QueryRow(`
+		INSERT INTO users (username, rating, in_placement, is_bot, username_key, api_key)
+		SELECT $1::text, $2::int, $3::boolean, FALSE, $4::text, NULLIF($5, '')
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1) OR username_key = $4)
+		RETURNING id
+	`, username, rating, u.InPlacement, usernameKey(username), apiKey).Scan(&u.ID)
+	if errors.Is(err, sql.ErrNoRows) || isUniqueViolation(err) {
+		return nil, errUsernameTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if !u.InPlacement {
+		if err := insertOutboxEvent(tx, EventUserPlaced, UserPlacedEvent{
+			UserID:   u.ID,
+			Username: u.Username,
+			Rating:   u.Rating,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user creation: %w", err)
+	}
+	return &u, nil
+}
+
+// HandleCreateUser serves POST /users. The rating defaults to
+// NEW_USER_RATING; with placement enabled the user starts in placement and
+// has no rank yet. A ranked user gets their starting rank and percentile,
+// read from the engine once the relay has added them.
+func (h *Handlers) HandleCreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if !usernamePattern.MatchString(req.Username) {
+		respondError(c, http.StatusBadRequest, "username must be 3-32 letters, digits, underscores, or hyphens")
+		return
+	}
+	rating := newUserRating
+	if req.Rating != nil {
+		rating = *req.Rating
+	}
+	if rating < MinRating || rating > MaxRating {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("rating must be between %d and %d", MinRating, MaxRating))
+		return
+	}
+
+	release := writeSlots.acquire(WriteInteractive)
+	user, err := registerUser(req.Username, rating, c.GetString("api_key"))
+	release()
+	if errors.Is(err, errUsernameTaken) {
+		respondError(c, http.StatusConflict, fmt.Sprintf("Username %s is already taken", req.Username))
+		return
+	}
+	var quotaErr *quotaExceededError
+	if errors.As(err, &quotaErr) {
+		respondQuotaExceeded(c, quotaErr)
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error creating user", "username", req.Username, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	if !user.InPlacement {
+		outboxRelay.Flush()
+	}
+
+	resp := UserResponse{
+		Success:   true,
+		Username:  user.Username,
+		Placement: placementProgress(user),
+	}
+	if !user.InPlacement {
+		rank, total := placeUser(c, GetRankingEngine(), user)
+		resp.Rating = &user.Rating
+		resp.Rank = &rank
+		resp.Percentile = rankPercentile(rank, total)
+		resp.TotalUsers = total
+	}
+	requestLog(c).Info("✓ Created user with rating", "username", user.Username, "rating", user.Rating)
+	respond(c, http.StatusCreated, resp)
+}
+
+// removeUser deletes a user with their scores. The engines are updated by the
+// user.deleted event committed with the delete, so a rank never reflects a
+// half-finished removal.
+func removeUser(username string) (*UserDeletedEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin user deletion: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ev UserDeletedEvent
+	var inPlacement bool
+	err = tx.QueryRow(`
+		SELECT id, username, rating, in_placement FROM users WHERE LOWER(username) = LOWER($1) FOR UPDATE
+	`, username).Scan(&ev.UserID, &ev.Username, &ev.Rating, &inPlacement)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMatchUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	ev.Ranked = !inPlacement
+
+	if ev.Metrics, ev.Boards, err = deleteUserScores(tx, ev.UserID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = $1`, ev.UserID); err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err := insertOutboxEvent(tx, EventUserDeleted, ev); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return &ev, nil
+}
+
+func applyUserDeletedEvent(ev UserDeletedEvent) {
+	if ev.Ranked {
+		GetRankingEngine().RemoveUser(ev.Rating)
+	}
+	bots.remove(ev.Username, ev.Rating, ev.Ranked)
+	forgetUserScores(ev.Metrics, ev.Boards)
+}
+
+// HandleDeleteUser serves DELETE /users/:username. By the time it answers the
+// user is gone from the database and from every engine.
+func HandleDeleteUser(c *gin.Context) {
+	release := writeSlots.acquire(WriteInteractive)
+	ev, err := removeUser(c.Param("username"))
+	release()
+	if errors.Is(err, errMatchUserNotFound) {
+		respondError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error deleting user", "username", c.Param("username"), "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	outboxRelay.Flush()
+
+	requestLog(c).Info("✓ Deleted user", "username", ev.Username)
+	respond(c, http.StatusOK, gin.H{
+		"username": ev.Username,
+		"rating":   ev.Rating,
+	})
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Volatility limits protect ratings from a buggy game server that submits
+// the same players' matches in a tight loop. A write is refused when, for any
+// ranked player it touches, the last change on that leaderboard was less than
+// its minimum interval ago, or it would push the player's total movement over
+// the past hour beyond its hourly limit. Both read the player's recent changes
+// under the row locks the write already holds, so concurrent submissions
+// can't slip past together.
+//
+// Each leaderboard has its own limits in volatility_limits, keyed by scope:
+// "default", a named board, or "metric:<name>" for a metric.
+// VOLATILITY_MIN_INTERVAL_SEC and VOLATILITY_MAX_DELTA_PER_HOUR are the
+// defaults for the default board and named boards, 0 meaning unlimited;
+// metrics count in their own units, so they are only limited when set. The
+// default board's game changes are read from rating_history; every other
+// scope logs them to volatility_changes while it has limits, keeping an hour.
+//
+// VOLATILITY_ACTION=queue stores refused default-board matches in
+// volatility_queue and retries them once the limit allows, up to
+// VOLATILITY_QUEUE_MAX waiting at a time; the default, reject, returns 429
+// with Retry-After. A match that runs out of attempts, or fails for another
+// reason, stays in the table as failed with its error.
+const (
+	VolatilityReject = "reject"
+	VolatilityQueue  = "queue"
+
+	VolatilityQueued = "queued"
+	VolatilityFailed = "failed"
+
+	volatilityMaxAttempts = 5
+	volatilityMetricScope = "metric:"
+
+	volatilityQueuePoll  = time.Second
+	volatilityQueueLease = time.Minute
+	volatilityQueueBatch = 50
+)
+
+const volatilitySchema = `
+	CREATE TABLE IF NOT EXISTS volatility_limits (
+		scope TEXT PRIMARY KEY,
+		min_interval_sec INT,
+		max_delta_per_hour INT,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS volatility_changes (
+		scope TEXT NOT NULL,
+		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		delta INT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_volatility_changes_user ON volatility_changes (scope, user_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS volatility_queue (
+		id BIGSERIAL PRIMARY KEY,
+		match_id TEXT NOT NULL UNIQUE,
+		request JSONB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued',
+		attempts INT NOT NULL DEFAULT 1,
+		last_error TEXT,
+		run_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_volatility_queue_due ON volatility_queue (run_at) WHERE status = 'queued';
+`
+
+var (
+	defaultVolatilityMinInterval = getEnvInt("VOLATILITY_MIN_INTERVAL_SEC", 0)
+	defaultVolatilityMaxDelta    = getEnvInt("VOLATILITY_MAX_DELTA_PER_HOUR", 0)
+	volatilityAction             = getEnv("VOLATILITY_ACTION", VolatilityReject)
+	volatilityQueueMax           = int64(getEnvInt("VOLATILITY_QUEUE_MAX", 1000))
+
+	volatilityQueued atomic.Int64
+	volatilityFailed atomic.Int64
+
+	errUnknownVolatilityScope = errors.New("no such leaderboard")
+)
+
+// VolatilityLimits holds a leaderboard's own overrides; nil means the
+// default applies.
+type VolatilityLimits struct {
+	MinIntervalSec  *int `json:"min_interval_sec"`
+	MaxDeltaPerHour *int `json:"max_delta_per_hour"`
+}
+
+// volatilityLimit is the effective limits of one leaderboard.
+type volatilityLimit struct {
+	Scope           string `json:"scope"`
+	MinIntervalSec  int    `json:"min_interval_sec"`
+	MaxDeltaPerHour int    `json:"max_delta_per_hour"`
+}
+
+func (l VolatilityLimits) effective(scope string) volatilityLimit {
+	v := volatilityLimit{Scope: scope}
+	if !strings.HasPrefix(scope, volatilityMetricScope) {
+		v.MinIntervalSec, v.MaxDeltaPerHour = defaultVolatilityMinInterval, defaultVolatilityMaxDelta
+	}
+	if l.MinIntervalSec != nil {
+		v.MinIntervalSec = *l.MinIntervalSec
+	}
+	if l.MaxDeltaPerHour != nil {
+		v.MaxDeltaPerHour = *l.MaxDeltaPerHour
+	}
+	return v
+}
+
+func (v volatilityLimit) limited() bool {
+	return v.MinIntervalSec > 0 || v.MaxDeltaPerHour > 0
+}
+
+func (v volatilityLimit) minInterval() time.Duration {
+	return time.Duration(v.MinIntervalSec) * time.Second
+}
+
+type VolatilityError struct {
+	Username   string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *VolatilityError) Error() string {
+	return fmt.Sprintf("rating volatility limit for %s: %s", e.Username, e.Reason)
+}
+
+// volatilityScopes lists every leaderboard that can have limits.
+func volatilityScopes() []string {
+	scopes := []string{DefaultBoard}
+	boardsMu.RLock()
+	for name := range boards {
+		scopes = append(scopes, name)
+	}
+	boardsMu.RUnlock()
+	for _, name := range metricNames() {
+		if metricDefs[name].Formula == "" {
+			scopes = append(scopes, volatilityMetricScope+name)
+		}
+	}
+	sort.Strings(scopes[1:])
+	return scopes
+}
+
+func validVolatilityScope(scope string) bool {
+	if scope == DefaultBoard {
+		return true
+	}
+	if name, ok := strings.CutPrefix(scope, volatilityMetricScope); ok {
+		def, ok := metricDefs[name]
+		return ok && def.Formula == ""
+	}
+	_, ok := getBoard(scope)
+	return ok
+}
+
+// loadVolatilityLimit reads a leaderboard's effective limits.
+func loadVolatilityLimit(q rowQueryer, scope string) (volatilityLimit, error) {
+	var l VolatilityLimits
+	err := q.QueryRow(`
+		SELECT min_interval_sec, max_delta_per_hour FROM volatility_limits WHERE scope = $1
+	`, scope).Scan(&l.MinIntervalSec, &l.MaxDeltaPerHour)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return volatilityLimit{}, fmt.Errorf("failed to load volatility limits: %w", err)
+	}
+	return l.effective(scope), nil
+}
+
+// check runs inside the write's transaction, before any write, with the
+// user's row locked. Only game submissions that weren't rolled back count
+// on the default board; admin changes such as resets don't.
+func (v volatilityLimit) check(tx *sql.Tx, u *User, delta int) error {
+	if !v.limited() || u.InPlacement || delta == 0 {
+		return nil
+	}
+
+	var sinceLast sql.NullFloat64
+	var hourDelta int
+	var oldest sql.NullFloat64
+	var err error
+	if v.Scope == DefaultBoard {
+		err = tx.QueryRow(`
+			SELECT EXTRACT(EPOCH FROM NOW() - MAX(created_at)),
+				COALESCE(SUM(ABS(new_rating - old_rating)), 0),
+				EXTRACT(EPOCH FROM NOW() - MIN(created_at))
+			FROM rating_history
+			WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour'
+				AND rolled_back_at IS NULL AND source = ANY($2)
+		`, u.ID, pq.Array(gameHistorySources)).Scan(&sinceLast, &hourDelta, &oldest)
+	} else {
+		err = tx.QueryRow(`
+			SELECT EXTRACT(EPOCH FROM NOW() - MAX(created_at)),
+				COALESCE(SUM(ABS(delta)), 0),
+				EXTRACT(EPOCH FROM NOW() - MIN(created_at))
+			FROM volatility_changes
+			WHERE scope = $1 AND user_id = $2 AND created_at > NOW() - INTERVAL '1 hour'
+		`, v.Scope, u.ID).Scan(&sinceLast, &hourDelta, &oldest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check rating volatility: %w", err)
+	}
+
+	if interval := v.minInterval(); interval > 0 && sinceLast.Valid {
+		if elapsed := seconds(sinceLast.Float64); elapsed < interval {
+			return &VolatilityError{
+				Username:   u.Username,
+				Reason:     fmt.Sprintf("%s changed %s ago, minimum interval is %s", v.subject(), elapsed.Round(time.Second), interval),
+				RetryAfter: interval - elapsed,
+			}
+		}
+	}
+	if v.MaxDeltaPerHour > 0 && hourDelta+abs(delta) > v.MaxDeltaPerHour {
+		// The window frees up as the oldest change in it turns an hour old.
+		retry := time.Minute
+		if oldest.Valid {
+			retry = max(time.Hour-seconds(oldest.Float64), time.Second)
+		}
+		return &VolatilityError{
+			Username:   u.Username,
+			Reason:     fmt.Sprintf("%s moved %d in the last hour, this write would add %d (limit %d)", v.subject(), hourDelta, abs(delta), v.MaxDeltaPerHour),
+			RetryAfter: retry,
+		}
+	}
+	return nil
+}
+
+// note logs a change that check allowed, for scopes other than the default
+// board, and drops the user's changes that have aged out of the window.
+func (v volatilityLimit) note(tx *sql.Tx, userID int64, delta int) error {
+	if !v.limited() || v.Scope == DefaultBoard || delta == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM volatility_changes WHERE scope = $1 AND user_id = $2 AND created_at <= NOW() - INTERVAL '1 hour'
+	`, v.Scope, userID); err != nil {
+		return fmt.Errorf("failed to prune volatility changes: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO volatility_changes (scope, user_id, delta) VALUES ($1, $2, $3)
+	`, v.Scope, userID, delta); err != nil {
+		return fmt.Errorf("failed to log volatility change: %w", err)
+	}
+	return nil
+}
+
+func (v volatilityLimit) subject() string {
+	switch {
+	case v.Scope == DefaultBoard:
+		return "rating"
+	case strings.HasPrefix(v.Scope, volatilityMetricScope):
+		return strings.TrimPrefix(v.Scope, volatilityMetricScope)
+	}
+	return "rating on board " + v.Scope
+}
+
+// checkVolatility checks one change on a leaderboard and logs it if allowed.
+func checkVolatility(tx *sql.Tx, scope string, u *User, delta int) error {
+	v, err := loadVolatilityLimit(tx, scope)
+	if err != nil {
+		return err
+	}
+	if err := v.check(tx, u, delta); err != nil {
+		return err
+	}
+	return v.note(tx, u.ID, delta)
+}
+
+// HandleListVolatilityLimits serves GET /admin/volatility: every
+// leaderboard's effective limits and its overrides.
+func HandleListVolatilityLimits(c *gin.Context) {
+	overrides := map[string]VolatilityLimits{}
+	rows, err := db.Query(`SELECT scope, min_interval_sec, max_delta_per_hour FROM volatility_limits`)
+	if err != nil {
+		requestLog(c).Error("Error listing volatility limits", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list volatility limits")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var scope string
+		var l VolatilityLimits
+		if err := rows.Scan(&scope, &l.MinIntervalSec, &l.MaxDeltaPerHour); err != nil {
+			requestLog(c).Error("Error scanning volatility limits", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list volatility limits")
+			return
+		}
+		overrides[scope] = l
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating volatility limits", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list volatility limits")
+		return
+	}
+
+	type scopeLimits struct {
+		volatilityLimit
+		Overrides VolatilityLimits `json:"overrides"`
+	}
+	limits := []scopeLimits{}
+	for _, scope := range volatilityScopes() {
+		limits = append(limits, scopeLimits{overrides[scope].effective(scope), overrides[scope]})
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"action": volatilityAction,
+		"limits": limits,
+	})
+}
+
+// HandleSetVolatilityLimits serves PUT /admin/volatility/:scope. Each limit
+// is set to the given value (0 for unlimited) or, when null or left out,
+// back to its default.
+func HandleSetVolatilityLimits(c *gin.Context) {
+	var req VolatilityLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	for _, v := range []*int{req.MinIntervalSec, req.MaxDeltaPerHour} {
+		if v != nil && *v < 0 {
+			respondError(c, http.StatusBadRequest, "Volatility limits must be 0 (unlimited) or more")
+			return
+		}
+	}
+
+	scope := strings.ToLower(c.Param("scope"))
+	if !validVolatilityScope(scope) {
+		respondError(c, http.StatusNotFound, fmt.Sprintf("Leaderboard %q not found: use default, a board name, or metric:<name>", c.Param("scope")))
+		return
+	}
+	if _, err := db.Exec(`
+		INSERT INTO volatility_limits (scope, min_interval_sec, max_delta_per_hour) VALUES ($1, $2, $3)
+		ON CONFLICT (scope) DO UPDATE SET min_interval_sec = EXCLUDED.min_interval_sec,
+			max_delta_per_hour = EXCLUDED.max_delta_per_hour, updated_at = NOW()
+	`, scope, req.MinIntervalSec, req.MaxDeltaPerHour); err != nil {
+		requestLog(c).Error("Error setting volatility limits", "scope", scope, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to set volatility limits")
+		return
+	}
+	requestLog(c).Info("✓ Volatility limits set", "scope", scope, "by", c.GetString("api_key"))
+
+	respond(c, http.StatusOK, gin.H{
+		"limits": req.effective(scope),
+	})
+}
+
+// queueMatch stores a refused match to be retried after the limit's
+// Retry-After. A match already waiting stays queued, and one that ran out of
+// attempts is queued afresh. It returns false if the queue is full.
+func queueMatch(req MatchRequest, retryAfter time.Duration) (bool, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode queued match: %w", err)
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO volatility_queue (match_id, request, run_at)
+		SELECT $1, $2, NOW() + $3 * INTERVAL '1 millisecond'
+		WHERE (SELECT COUNT(*) FROM volatility_queue WHERE status = $5) < $4
+		ON CONFLICT (match_id) DO UPDATE SET request = EXCLUDED.request, run_at = EXCLUDED.run_at,
+			attempts = 1, status = $5, last_error = NULL, updated_at = NOW()
+		WHERE volatility_queue.status = $6
+		RETURNING id
+	`, req.MatchID, body, retryAfter.Milliseconds(), volatilityQueueMax, VolatilityQueued, VolatilityFailed).Scan(&id)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to queue match: %w", err)
+	}
+
+	// Nothing was written: the queue is full, or the match already waits.
+	var waiting bool
+	if err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM volatility_queue WHERE match_id = $1 AND status = $2)
+	`, req.MatchID, VolatilityQueued).Scan(&waiting); err != nil {
+		return false, fmt.Errorf("failed to check queued match: %w", err)
+	}
+	return waiting, nil
+}
+
+// VolatilityQueueWorker retries queued matches as they come due. Each claim
+// pushes run_at out by a lease, so a match whose retry was cut short by a
+// crash is picked up again once the lease runs out.
+type VolatilityQueueWorker struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+var volatilityWorker *VolatilityQueueWorker
+
+func StartVolatilityQueue() {
+	if volatilityAction != VolatilityQueue || isReplica() {
+		return
+	}
+	volatilityWorker = &VolatilityQueueWorker{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go volatilityWorker.run()
+	slog.Info("✓ Volatility queue started", "max", volatilityQueueMax)
+}
+
+func StopVolatilityQueue() {
+	if volatilityWorker == nil {
+		return
+	}
+	close(volatilityWorker.stop)
+	<-volatilityWorker.done
+}
+
+func (w *VolatilityQueueWorker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(volatilityQueuePoll)
+	defer ticker.Stop()
+
+	for {
+		w.retryDue()
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *VolatilityQueueWorker) retryDue() {
+	rows, err := db.Query(`
+		UPDATE volatility_queue SET run_at = NOW() + $2 * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM volatility_queue
+			WHERE status = $1 AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, request, attempts
+	`, VolatilityQueued, volatilityQueueLease.Milliseconds(), volatilityQueueBatch)
+	if err != nil {
+		slog.Error("Volatility queue poll failed", "error", err)
+		return
+	}
+	type due struct {
+		id       int64
+		req      MatchRequest
+		attempts int
+	}
+	var claimed []due
+	for rows.Next() {
+		var d due
+		var body []byte
+		if err := rows.Scan(&d.id, &body, &d.attempts); err != nil {
+			slog.Error("Volatility queue poll failed", "error", err)
+			break
+		}
+		if err := json.Unmarshal(body, &d.req); err != nil {
+			w.fail(d.id, d.req.MatchID, d.attempts, fmt.Errorf("undecodable request: %w", err))
+			continue
+		}
+		claimed = append(claimed, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("Volatility queue poll failed", "error", err)
+	}
+
+	for _, d := range claimed {
+		w.retry(d.id, d.req, d.attempts)
+	}
+	w.refreshCounts()
+}
+
+func (w *VolatilityQueueWorker) retry(id int64, req MatchRequest, attempt int) {
+	scoreA, _ := outcomeScore(req.Outcome)
+	_, _, err := recordMatch(req, scoreA)
+	var limited *VolatilityError
+	var flagged *flaggedSubmissionError
+	switch {
+	case err == nil:
+		outboxRelay.Notify()
+		slog.Info("✓ Queued match recorded", "match_id", req.MatchID, "attempts", attempt)
+	case errors.Is(err, errDuplicateMatch):
+		slog.Info("Queued match was already recorded", "match_id", req.MatchID, "attempts", attempt)
+	case errors.As(err, &flagged):
+		qid, qerr := quarantineSubmission(flagged.UserID, flagged.Submission, flagged.Validator, flagged.Reason, &QuarantinedMatch{Match: &req})
+		if qerr != nil {
+			w.fail(id, req.MatchID, attempt, fmt.Errorf("failed to quarantine: %w", qerr))
+			return
+		}
+		slog.Warn("Quarantined queued match", "match_id", req.MatchID, "id", qid, "username", flagged.Submission.Username, "validator", flagged.Validator, "reason", flagged.Reason)
+	case errors.As(err, &limited) && attempt < volatilityMaxAttempts:
+		if _, err := db.Exec(`
+			UPDATE volatility_queue SET attempts = attempts + 1, run_at = NOW() + $2 * INTERVAL '1 millisecond',
+				last_error = $3, updated_at = NOW()
+			WHERE id = $1
+		`, id, limited.RetryAfter.Milliseconds(), limited.Reason); err != nil {
+			slog.Error("Failed to reschedule queued match", "match_id", req.MatchID, "error", err)
+		}
+		return
+	default:
+		w.fail(id, req.MatchID, attempt, err)
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM volatility_queue WHERE id = $1`, id); err != nil {
+		slog.Error("Failed to remove queued match", "match_id", req.MatchID, "error", err)
+	}
+}
+
+// fail keeps a match that can't be recorded, with the reason, for an admin
+// to see in GET /admin/volatility/queue.
+func (w *VolatilityQueueWorker) fail(id int64, matchID string, attempt int, cause error) {
+	slog.Error("Queued match failed", "match_id", matchID, "attempts", attempt, "error", cause)
+	if _, err := db.Exec(`
+		UPDATE volatility_queue SET status = $2, last_error = $3, updated_at = NOW() WHERE id = $1
+	`, id, VolatilityFailed, cause.Error()); err != nil {
+		slog.Error("Failed to mark queued match failed", "match_id", matchID, "error", err)
+	}
+}
+
+func (w *VolatilityQueueWorker) refreshCounts() {
+	var queued, failed int64
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE status = $1), COUNT(*) FILTER (WHERE status = $2) FROM volatility_queue
+	`, VolatilityQueued, VolatilityFailed).Scan(&queued, &failed); err != nil {
+		slog.Error("Failed to count queued matches", "error", err)
+		return
+	}
+	volatilityQueued.Store(queued)
+	volatilityFailed.Store(failed)
+}
+
+type QueuedMatch struct {
+	ID        int64        `json:"id"`
+	Match     MatchRequest `json:"match"`
+	Status    string       `json:"status"`
+	Attempts  int          `json:"attempts"`
+	LastError *string      `json:"last_error"`
+	RunAt     time.Time    `json:"run_at"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// HandleListVolatilityQueue serves GET /admin/volatility/queue: matches
+// waiting for their players' limits (?status=queued, the default) or that
+// ran out of attempts (?status=failed).
+func HandleListVolatilityQueue(c *gin.Context) {
+	status := c.DefaultQuery("status", VolatilityQueued)
+	limit := min(max(parseIntParam(c.Query("limit"), 100), 1), 1000)
+	offset := max(parseIntParam(c.Query("offset"), 0), 0)
+
+	rows, err := db.Query(`
+		SELECT id, request, status, attempts, last_error, run_at, created_at, updated_at
+		FROM volatility_queue
+		WHERE status = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		requestLog(c).Error("Error listing queued matches", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list queued matches")
+		return
+	}
+	defer rows.Close()
+
+	matches := []QueuedMatch{}
+	for rows.Next() {
+		var m QueuedMatch
+		var body []byte
+		if err := rows.Scan(&m.ID, &body, &m.Status, &m.Attempts, &m.LastError, &m.RunAt, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			requestLog(c).Error("Error scanning queued match", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to list queued matches")
+			return
+		}
+		json.Unmarshal(body, &m.Match)
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		requestLog(c).Error("Error iterating queued matches", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list queued matches")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"status":  status,
+		"matches": matches,
+	})
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
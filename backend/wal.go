@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RatingWAL buffers simulation rating updates in a local append-only file
+// while Postgres is unreachable. The engine has already applied them, so
+// reads keep reflecting the updates during the outage; once the database
+// answers again the file is replayed in order and truncated. While entries
+// are pending, new batches are appended behind them rather than written
+// directly, so a replay never overwrites a newer rating with an older one.
+// Replay also leaves alone a user whose rating has moved since the update was
+// computed, e.g. by a match, and takes the update back out of the engine.
+// Enabled by RATING_WAL_FILE and bounded by RATING_WAL_MAX_ENTRIES; batches
+// that don't fit are reverted in the engine as before.
+type RatingWAL struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	entries    int
+	carried    int
+	maxEntries int
+	interval   time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type walEntry struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username,omitempty"`
+	OldRating int    `json:"old_rating"`
+	NewRating int    `json:"new_rating"`
+}
+
+type RatingWALStats struct {
+	Path       string `json:"path"`
+	Pending    int    `json:"pending"`
+	MaxEntries int    `json:"max_entries"`
+}
+
+const walReplayBatch = 500
+
+var ratingWAL *RatingWAL
+
+// StartRatingWAL opens the WAL, replays anything a previous run left behind
+// so the engine loads the buffered ratings, and starts the replay loop.
+func StartRatingWAL() error {
+	path := getEnv("RATING_WAL_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open rating WAL %s: %w", path, err)
+	}
+	w := &RatingWAL{
+		path:       path,
+		file:       file,
+		maxEntries: getEnvInt("RATING_WAL_MAX_ENTRIES", 10000),
+		interval:   time.Duration(max(getEnvInt("RATING_WAL_REPLAY_INTERVAL_SEC", 5), 1)) * time.Second,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	entries, err := w.read()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.entries = len(entries)
+	if w.entries > 0 {
+		slog.Info("Rating WAL has updates from a previous run, replaying", "updates", w.entries)
+		if err := w.Replay(); err != nil {
+			slog.Warn("Rating WAL replay failed", "error", err)
+			// The engine is about to load from the table, without these.
+			w.carried = w.entries
+		}
+	}
+
+	ratingWAL = w
+	go w.run()
+	slog.Info("✓ Rating WAL enabled", "path", path, "max_updates", w.maxEntries)
+	return nil
+}
+
+func StopRatingWAL() {
+	if ratingWAL == nil {
+		return
+	}
+	close(ratingWAL.stop)
+	<-ratingWAL.done
+
+	if ratingWAL.Pending() {
+		if err := ratingWAL.Replay(); err != nil {
+			slog.Warn("Rating updates left in WAL", "pending", ratingWAL.Stats().Pending, "path", ratingWAL.path, "error", err)
+		}
+	}
+	ratingWAL.file.Close()
+}
+
+func (w *RatingWAL) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		if !w.Pending() || db.Ping() != nil {
+			continue
+		}
+		if err := w.Replay(); err != nil {
+			slog.Error("Rating WAL replay failed", "error", err)
+		}
+	}
+}
+
+func (w *RatingWAL) Pending() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.entries > 0
+}
+
+// Append durably buffers updates. It returns false when the WAL is disabled,
+// full, or can't be written.
+func (w *RatingWAL) Append(updates []RatingUpdate) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.entries+len(updates) > w.maxEntries {
+		return false
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, u := range updates {
+		if err := enc.Encode(walEntry{UserID: u.UserID, Username: u.Username, OldRating: u.OldRating, NewRating: u.NewRating}); err != nil {
+			return false
+		}
+	}
+	if _, err := w.file.WriteString(b.String()); err != nil {
+		slog.Error("Failed to append to rating WAL", "error", err)
+		return false
+	}
+	if err := w.file.Sync(); err != nil {
+		slog.Error("Failed to sync rating WAL", "error", err)
+		return false
+	}
+	w.entries += len(updates)
+	return true
+}
+
+// Replay writes every buffered update in order and truncates the WAL. On
+// failure the WAL is kept whole; replaying it again is safe because an update
+// whose NewRating is already in place counts as written and changes nothing.
+//
+// Updates appended while running are already in the engine, so the ones
+// skipped because the user's rating moved are reverted there. Updates carried
+// over from a previous run that failed to replay at startup aren't in the
+// engine, so the ones that change a rating are applied to it.
+func (w *RatingWAL) Replay() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries, err := w.read()
+	if err != nil {
+		return err
+	}
+	carried := min(w.carried, len(entries))
+	if err := replayRatingUpdates(collapseRatingUpdates(entries[:carried]), false); err != nil {
+		return err
+	}
+	if err := replayRatingUpdates(collapseRatingUpdates(entries[carried:]), true); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate rating WAL: %w", err)
+	}
+	w.entries = 0
+	w.carried = 0
+	slog.Info("✓ Replayed buffered rating updates", "updates", len(entries))
+	return nil
+}
+
+// replayRatingUpdates writes collapsed updates in chunks and brings the
+// engine, once it is loaded, in line with what was written.
+func replayRatingUpdates(updates []RatingUpdate, inEngine bool) error {
+	for start := 0; start < len(updates); start += walReplayBatch {
+		changed, skipped, err := writeRatingUpdates(updates[start:min(start+walReplayBatch, len(updates))], HistorySourceSimulate, true)
+		if err != nil {
+			return err
+		}
+		if !rankingEngineLoaded() {
+			continue
+		}
+		re := GetRankingEngine()
+		if inEngine {
+			for _, u := range skipped {
+				applyRatingChange(re, u.Username, u.NewRating, u.OldRating, "simulation")
+			}
+		} else {
+			applyRatingBatch(re, changed, "simulation")
+		}
+	}
+	return nil
+}
+
+// read decodes the whole WAL. A torn last line from a crash mid-append is
+// dropped; those updates were never acknowledged.
+func (w *RatingWAL) read() ([]RatingUpdate, error) {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read rating WAL: %w", err)
+	}
+
+	var updates []RatingUpdate
+	scanner := bufio.NewScanner(w.file)
+	for scanner.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			slog.Warn("Skipping malformed rating WAL entry", "error", err)
+			continue
+		}
+		updates = append(updates, RatingUpdate{UserID: e.UserID, Username: e.Username, OldRating: e.OldRating, NewRating: e.NewRating})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rating WAL: %w", err)
+	}
+	return updates, nil
+}
+
+func (w *RatingWAL) Stats() RatingWALStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return RatingWALStats{Path: w.path, Pending: w.entries, MaxEntries: w.maxEntries}
+}
+
+// isConnectionError reports whether err means the database couldn't be
+// reached, as opposed to rejecting the statement.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P03"
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+// ready flips once warm-up finishes; /health reports 503 until then so load
+// balancers keep traffic on the previous deploy.
+var ready atomic.Bool
+
+var (
+	// warmHandler is the router warm-up ran against, kept for rewarm.
+	warmHandler atomic.Value
+	rewarming   atomic.Bool
+)
+
+// warmUp replays the hottest read paths through the router in-process so the
+// first real user after a deploy doesn't pay for cold database buffers, empty
+// connection and buffer pools, and first-call code paths.
+func warmUp(handler http.Handler) {
+	warmHandler.Store(handler)
+	start := time.Now()
+	n := warmPaths(handler)
+	ready.Store(true)
+	if n > 0 {
+		slog.Info("✓ Warm-up complete", "requests", n, "duration", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// rewarm repeats warm-up after a bulk operation has rewritten the board.
+// Overlapping calls collapse into the one already running.
+func rewarm() {
+	handler, ok := warmHandler.Load().(http.Handler)
+	if !ok || !rewarming.CompareAndSwap(false, true) {
+		return
+	}
+	defer rewarming.Store(false)
+	start := time.Now()
+	if n := warmPaths(handler); n > 0 {
+		slog.Info("✓ Re-warm complete", "requests", n, "duration", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func warmPaths(handler http.Handler) int {
+	pages := getEnvInt("WARMUP_PAGES", 5)
+	if pages <= 0 {
+		return 0
+	}
+
+	paths := make([]string, 0, pages+1)
+	for page := 1; page <= pages; page++ {
+		paths = append(paths, fmt.Sprintf("/leaderboard?page=%d", page))
+	}
+	paths = append(paths, "/stats")
+
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			slog.Warn("Warm-up request failed", "path", path, "status", rec.Code)
+		}
+	}
+	return len(paths)
+}
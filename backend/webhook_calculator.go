@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	CalculatorWebhook = "webhook"
+
+	// WebhookFallbackReject fails the match submission when the external
+	// calculator can't be reached; any other value names a built-in
+	// calculator to use instead.
+	WebhookFallbackReject = "reject"
+)
+
+var errCalculatorUnavailable = errors.New("rating calculator unavailable")
+
+type webhookPlayer struct {
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+}
+
+type webhookCalculatorRequest struct {
+	PlayerA webhookPlayer `json:"player_a"`
+	PlayerB webhookPlayer `json:"player_b"`
+	ScoreA  float64       `json:"score_a"`
+}
+
+type webhookCalculatorResponse struct {
+	NewRatingA *int `json:"new_rating_a"`
+	NewRatingB *int `json:"new_rating_b"`
+}
+
+// WebhookCalculator forwards match inputs to an operator-provided HTTP
+// service for games with proprietary rating math.
+type WebhookCalculator struct {
+	URL      string
+	Retries  int
+	Backoff  time.Duration
+	Fallback RatingCalculator
+	client   *http.Client
+}
+
+func init() {
+	calculatorFactories[CalculatorWebhook] = newWebhookCalculator
+}
+
+func newWebhookCalculator() RatingCalculator {
+	wc := &WebhookCalculator{
+		URL:     getEnv("RATING_WEBHOOK_URL", ""),
+		Retries: getEnvInt("RATING_WEBHOOK_RETRIES", 2),
+		Backoff: time.Duration(getEnvInt("RATING_WEBHOOK_BACKOFF_MS", 100)) * time.Millisecond,
+		client: &http.Client{
+			Timeout: time.Duration(getEnvInt("RATING_WEBHOOK_TIMEOUT_MS", 2000)) * time.Millisecond,
+		},
+	}
+
+	if wc.URL == "" {
+		slog.Warn("RATING_CALCULATOR=webhook but RATING_WEBHOOK_URL is not set")
+	}
+
+	fallback := getEnv("RATING_WEBHOOK_FALLBACK", WebhookFallbackReject)
+	if fallback != WebhookFallbackReject && fallback != CalculatorWebhook {
+		calc, err := newRatingCalculator(fallback)
+		if err != nil {
+			slog.Warn("Invalid RATING_WEBHOOK_FALLBACK, rejecting on failure", "error", err)
+		} else {
+			wc.Fallback = calc
+		}
+	}
+	return wc
+}
+
+func (wc *WebhookCalculator) Name() string { return CalculatorWebhook }
+
+func (wc *WebhookCalculator) Calculate(in MatchInput) (int, int, error) {
+	body, err := json.Marshal(webhookCalculatorRequest{
+		PlayerA: webhookPlayer{Username: in.PlayerA, Rating: in.RatingA},
+		PlayerB: webhookPlayer{Username: in.PlayerB, Rating: in.RatingB},
+		ScoreA:  in.ScoreA,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode calculator request: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= wc.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wc.Backoff * time.Duration(attempt))
+		}
+
+		newA, newB, err := wc.call(body)
+		if err == nil {
+			return newA, newB, nil
+		}
+		lastErr = err
+		slog.Error("Rating webhook attempt failed", "attempt", attempt+1, "max_attempts", wc.Retries+1, "error", err)
+	}
+
+	if wc.Fallback != nil {
+		slog.Warn("Rating webhook unavailable, falling back", "fallback", wc.Fallback.Name())
+		return wc.Fallback.Calculate(in)
+	}
+	return 0, 0, fmt.Errorf("%w: %v", errCalculatorUnavailable, lastErr)
+}
+
+func (wc *WebhookCalculator) call(body []byte) (int, int, error) {
+	if wc.URL == "" {
+		return 0, 0, errors.New("RATING_WEBHOOK_URL is not set")
+	}
+
+	resp, err := wc.client.Post(wc.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("calculator returned status %d", resp.StatusCode)
+	}
+
+	var out webhookCalculatorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, 0, fmt.Errorf("invalid calculator response: %w", err)
+	}
+	if out.NewRatingA == nil || out.NewRatingB == nil {
+		return 0, 0, errors.New("calculator response missing new_rating_a or new_rating_b")
+	}
+	if *out.NewRatingA < MinRating || *out.NewRatingA > MaxRating ||
+		*out.NewRatingB < MinRating || *out.NewRatingB > MaxRating {
+		return 0, 0, fmt.Errorf("calculator returned out-of-range ratings %d/%d", *out.NewRatingA, *out.NewRatingB)
+	}
+	return *out.NewRatingA, *out.NewRatingB, nil
+}
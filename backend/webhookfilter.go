@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Webhook filters are small boolean expressions over an event's fields, e.g.
+//
+//	new_rank <= 100 and source != "simulate"
+//	$.username in [alice, "bob"] or old_rank <= 10
+//
+// A condition compares one field with ==, !=, <, <=, >, >=, in, or not in;
+// "and" binds tighter than "or". Fields may be written JSONPath style
+// ($.new_rank). Numbers compare numerically, everything else as strings.
+var webhookFilterClause = regexp.MustCompile(`^\s*(?:\$\.)?([a-z_]+)\s*(==|!=|<=|>=|<|>|=|not in|in)\s*(.+?)\s*$`)
+
+var webhookFilterFields = map[string]bool{
+	"username":   true,
+	"old_rank":   true,
+	"new_rank":   true,
+	"old_rating": true,
+	"rating":     true,
+	"source":     true,
+}
+
+type webhookCondition struct {
+	field  string
+	op     string
+	values []string
+}
+
+// WebhookFilter is a parsed filter: any of its groups must hold, and a group
+// holds when all of its conditions do. The zero filter matches everything.
+type WebhookFilter struct {
+	groups [][]webhookCondition
+}
+
+func parseWebhookFilter(expr string) (*WebhookFilter, error) {
+	f := &WebhookFilter{}
+	if strings.TrimSpace(expr) == "" {
+		return f, nil
+	}
+	for _, group := range splitKeyword(expr, "or") {
+		var conds []webhookCondition
+		for _, clause := range splitKeyword(group, "and") {
+			m := webhookFilterClause.FindStringSubmatch(clause)
+			if m == nil {
+				return nil, fmt.Errorf("can't parse %q", strings.TrimSpace(clause))
+			}
+			cond := webhookCondition{field: m[1], op: m[2]}
+			if !webhookFilterFields[cond.field] {
+				return nil, fmt.Errorf("unknown field %q", cond.field)
+			}
+			if cond.op == "=" {
+				cond.op = "=="
+			}
+			if cond.op == "in" || cond.op == "not in" {
+				list, ok := strings.CutPrefix(m[3], "[")
+				if list, ok = strings.CutSuffix(list, "]"); !ok {
+					return nil, fmt.Errorf("%s needs a [list]", cond.op)
+				}
+				for _, v := range strings.Split(list, ",") {
+					cond.values = append(cond.values, unquote(v))
+				}
+			} else {
+				cond.values = []string{unquote(m[3])}
+				if strings.ContainsAny(cond.op, "<>") {
+					if _, err := strconv.ParseFloat(cond.values[0], 64); err != nil {
+						return nil, fmt.Errorf("%s needs a number", cond.op)
+					}
+				}
+			}
+			conds = append(conds, cond)
+		}
+		f.groups = append(f.groups, conds)
+	}
+	return f, nil
+}
+
+// splitKeyword splits on a whole-word keyword outside quotes and brackets.
+func splitKeyword(s, keyword string) []string {
+	var parts []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case depth == 0 && (i == 0 || s[i-1] == ' ') && strings.HasPrefix(s[i:], keyword+" "):
+			parts = append(parts, s[start:i])
+			start = i + len(keyword)
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// Match evaluates the filter against an event's fields.
+func (f *WebhookFilter) Match(fields map[string]string) bool {
+	if len(f.groups) == 0 {
+		return true
+	}
+	for _, group := range f.groups {
+		if !slices.ContainsFunc(group, func(c webhookCondition) bool { return !c.match(fields) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c webhookCondition) match(fields map[string]string) bool {
+	got, ok := fields[c.field]
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "in":
+		return slices.ContainsFunc(c.values, func(v string) bool { return equalValues(got, v) })
+	case "not in":
+		return !slices.ContainsFunc(c.values, func(v string) bool { return equalValues(got, v) })
+	case "==":
+		return equalValues(got, c.values[0])
+	case "!=":
+		return !equalValues(got, c.values[0])
+	}
+	a, errA := strconv.ParseFloat(got, 64)
+	b, errB := strconv.ParseFloat(c.values[0], 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func equalValues(a, b string) bool {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		return x == y
+	}
+	return strings.EqualFold(a, b)
+}
+
+func rankChangeFields(e RankChangeEvent) map[string]string {
+	return map[string]string{
+		"username":   e.Username,
+		"old_rank":   strconv.Itoa(e.OldRank),
+		"new_rank":   strconv.Itoa(e.NewRank),
+		"old_rating": strconv.Itoa(e.OldRating),
+		"rating":     strconv.Itoa(e.Rating),
+		"source":     e.Source,
+	}
+}
@@ -0,0 +1,874 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Webhook subscriptions push the /events stream to external URLs. Each
+// subscription picks event types and an optional filter (see
+// webhookfilter.go), and has its own queue and worker, so a slow receiver
+// only delays itself. Deliveries are signed with the subscription's secret:
+// X-Webhook-Signature is "sha256=" + hex(HMAC-SHA256(secret, timestamp + "."
+// + body)). After a rotation the previous secret also signs, in
+// X-Webhook-Signature-Previous, until its grace period ends. Only the primary
+// delivers; replicas see the same events and would send duplicates. A
+// subscription's schema_version pins its payloads like ?schema_version= on
+// /events; 0 follows the latest. Its format picks the native envelope or a
+// CloudEvent (see cloudevents.go).
+const (
+	WebhookEventRankChange = "rank_change"
+	WebhookEventRefresh    = "refresh"
+	WebhookEventTest       = "test"
+
+	maxWebhookBulk = 100
+)
+
+const webhookSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		filter TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		previous_secret TEXT,
+		previous_secret_expires_at TIMESTAMPTZ,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS schema_version INT NOT NULL DEFAULT 0;
+	ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'native';
+`
+
+var (
+	webhookTimeout     = time.Duration(getEnvInt("WEBHOOK_TIMEOUT_MS", 2000)) * time.Millisecond
+	webhookMaxAttempts = max(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 3), 1)
+	webhookQueueSize   = max(getEnvInt("WEBHOOK_QUEUE_SIZE", 1000), 1)
+	webhookSecretGrace = time.Duration(getEnvInt("WEBHOOK_SECRET_GRACE_SEC", 86400)) * time.Second
+
+	webhookEventTypes = []string{WebhookEventRankChange, WebhookEventRefresh}
+
+	webhooks *WebhookDispatcher
+
+	errWebhookNotFound = errors.New("webhook subscription not found")
+)
+
+type WebhookSubscription struct {
+	ID                      int64                 `json:"id"`
+	URL                     string                `json:"url"`
+	Events                  []string              `json:"events"`
+	Filter                  string                `json:"filter"`
+	SchemaVersion           int                   `json:"schema_version"`
+	Format                  string                `json:"format"`
+	Active                  bool                  `json:"active"`
+	APIKey                  string                `json:"api_key,omitempty"`
+	CreatedAt               time.Time             `json:"created_at"`
+	UpdatedAt               time.Time             `json:"updated_at"`
+	PreviousSecretExpiresAt *time.Time            `json:"previous_secret_expires_at,omitempty"`
+	Stats                   *WebhookDeliveryStats `json:"stats,omitempty"`
+
+	secret         string
+	previousSecret string
+}
+
+type WebhookDeliveryStats struct {
+	Queued        int64      `json:"queued"`
+	Delivered     int64      `json:"delivered"`
+	Failed        int64      `json:"failed"`
+	Retries       int64      `json:"retries"`
+	Dropped       int64      `json:"dropped"`
+	AvgLatencyMs  float64    `json:"avg_latency_ms"`
+	LastStatus    int        `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Data          json.RawMessage `json:"data"`
+
+	subject string
+}
+
+// webhookWorker delivers one subscription's queue in order. sub and filter
+// are read by dispatch under the dispatcher's lock; the worker itself reads
+// the subscription through current, so an edit reaches its next attempt.
+type webhookWorker struct {
+	sub     *WebhookSubscription
+	filter  *WebhookFilter
+	current atomic.Pointer[WebhookSubscription]
+	queue   chan WebhookDelivery
+	stop    chan struct{}
+	done    chan struct{}
+	stats   *webhookCounters
+}
+
+// webhookCounters outlive workers, so reloading subscriptions keeps stats.
+type webhookCounters struct {
+	queued, delivered, failed, retries, dropped atomic.Int64
+	latencyTotal                                atomic.Int64
+
+	mu            sync.Mutex
+	lastStatus    int
+	lastError     string
+	lastAttemptAt time.Time
+}
+
+type WebhookDispatcher struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	workers  map[int64]*webhookWorker
+	counters map[int64]*webhookCounters
+
+	// The dispatcher only listens to rank events while it has subscribers,
+	// since listening makes every write compute ranks.
+	unsubscribe func()
+}
+
+func StartWebhooks() {
+	webhooks = &WebhookDispatcher{
+		client:   &http.Client{Timeout: webhookTimeout},
+		workers:  map[int64]*webhookWorker{},
+		counters: map[int64]*webhookCounters{},
+	}
+	if err := webhooks.reload(); err != nil {
+		slog.Warn("Failed to load webhook subscriptions", "error", err)
+	}
+}
+
+func StopWebhooks() {
+	if webhooks == nil {
+		return
+	}
+	webhooks.mu.Lock()
+	webhooks.listenLocked(false)
+	workers := webhooks.workers
+	webhooks.workers = map[int64]*webhookWorker{}
+	webhooks.mu.Unlock()
+
+	// An attempt in flight finishes; its retries don't.
+	for _, w := range workers {
+		close(w.stop)
+		<-w.done
+	}
+	slog.Info("✓ Webhook deliveries stopped")
+}
+
+// reload replaces the workers with the subscriptions now in the database.
+// A changed subscription keeps its worker, which is handed the new settings,
+// so its queue is still delivered by one goroutine in order. Workers of
+// removed subscriptions are told to stop but not waited for, so a slow
+// receiver never holds up the dispatcher.
+func (d *WebhookDispatcher) reload() error {
+	subs, err := loadWebhookSubscriptions(`WHERE active`)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := map[int64]bool{}
+	for _, sub := range subs {
+		seen[sub.ID] = true
+		old := d.workers[sub.ID]
+		if old != nil && old.sub.UpdatedAt.Equal(sub.UpdatedAt) {
+			continue
+		}
+		filter, err := parseWebhookFilter(sub.Filter)
+		if err != nil {
+			slog.Warn("Webhook has an invalid filter and is skipped", "webhook_id", sub.ID, "error", err)
+			continue
+		}
+		if old != nil {
+			old.sub, old.filter = sub, filter
+			old.current.Store(sub)
+			continue
+		}
+		if d.counters[sub.ID] == nil {
+			d.counters[sub.ID] = &webhookCounters{}
+		}
+		w := &webhookWorker{
+			sub:    sub,
+			filter: filter,
+			queue:  make(chan WebhookDelivery, webhookQueueSize),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+			stats:  d.counters[sub.ID],
+		}
+		w.current.Store(sub)
+		d.workers[sub.ID] = w
+		go w.run(d.client)
+	}
+	for id, w := range d.workers {
+		if !seen[id] {
+			close(w.stop)
+			delete(d.workers, id)
+		}
+	}
+	d.listenLocked(len(d.workers) > 0)
+	return nil
+}
+
+func (d *WebhookDispatcher) listenLocked(on bool) {
+	if on == (d.unsubscribe != nil) {
+		return
+	}
+	if !on {
+		d.unsubscribe()
+		d.unsubscribe = nil
+		return
+	}
+
+	events, unsubscribeEvents := rankEvents.subscribe()
+	refreshes, unsubscribeRefreshes := leaderboardRefreshes.subscribe()
+	stop := make(chan struct{})
+	d.unsubscribe = func() {
+		unsubscribeEvents()
+		unsubscribeRefreshes()
+		close(stop)
+	}
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				d.dispatch(WebhookEventRankChange, e, rankChangeFields(e))
+			case e := <-refreshes:
+				d.dispatch(WebhookEventRefresh, e, nil)
+			}
+		}
+	}()
+}
+
+// dispatch queues an event for every subscription that wants it. Filters
+// apply to rank_change events only. The payload is encoded once per pinned
+// schema version.
+func (d *WebhookDispatcher) dispatch(eventType string, payload any, fields map[string]string) {
+	id, now := newEventID(), time.Now().UTC()
+	deliveries := map[int]*WebhookDelivery{}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.workers {
+		if !slices.Contains(w.sub.Events, eventType) || (fields != nil && !w.filter.Match(fields)) {
+			continue
+		}
+		delivery, seen := deliveries[w.sub.SchemaVersion]
+		if !seen {
+			delivery = newWebhookDelivery(id, eventType, now, payload, w.sub.SchemaVersion)
+			deliveries[w.sub.SchemaVersion] = delivery
+		}
+		if delivery == nil {
+			continue
+		}
+		select {
+		case w.queue <- *delivery:
+			w.stats.queued.Add(1)
+		default:
+			w.stats.dropped.Add(1)
+		}
+	}
+}
+
+func (w *webhookWorker) run(client *http.Client) {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case delivery := <-w.queue:
+			w.stats.queued.Add(-1)
+			w.deliver(client, delivery)
+		}
+	}
+}
+
+// deliver retries with linear backoff until the receiver answers 2xx or the
+// attempts run out. A delivery abandoned during backoff, because the worker
+// is stopping, counts as failed.
+func (w *webhookWorker) deliver(client *http.Client, delivery WebhookDelivery) {
+	for attempt := 1; ; attempt++ {
+		sub := w.current.Load()
+		status, err := sendWebhook(client, sub, delivery, w.stats)
+		if err == nil {
+			w.stats.delivered.Add(1)
+			return
+		}
+		if attempt >= webhookMaxAttempts {
+			w.stats.failed.Add(1)
+			slog.Error("Webhook giving up on delivery", "webhook_id", sub.ID, "type", delivery.Type, "delivery_id", delivery.ID,
+				"attempts", attempt, "status", status, "error", err)
+			return
+		}
+		w.stats.retries.Add(1)
+		select {
+		case <-w.stop:
+			w.stats.failed.Add(1)
+			slog.Warn("Webhook delivery abandoned during retry backoff", "webhook_id", sub.ID, "type", delivery.Type, "delivery_id", delivery.ID,
+				"attempts", attempt, "error", err)
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+// newWebhookDelivery encodes an event for subscribers pinned to version. It
+// returns nil when they can't receive it: the event type is newer than their
+// pin, or it didn't encode.
+func newWebhookDelivery(id, eventType string, at time.Time, payload any, version int) *WebhookDelivery {
+	data, ok, err := encodeEvent(EventChannelStream, eventType, payload, version)
+	if err != nil {
+		slog.Error("Error encoding webhook event", "event_type", eventType, "error", err)
+	}
+	if !ok {
+		return nil
+	}
+	return &WebhookDelivery{
+		ID:            id,
+		Type:          eventType,
+		SchemaVersion: cmp.Or(version, eventSchemaVersion),
+		CreatedAt:     at,
+		Data:          data,
+		subject:       eventSubject(payload),
+	}
+}
+
+func (d WebhookDelivery) cloudEvent() CloudEvent {
+	return newCloudEvent(d.ID, d.Type, d.CreatedAt, d.SchemaVersion, d.subject, d.Data)
+}
+
+// sendWebhook makes one delivery attempt and records it in stats.
+func sendWebhook(client *http.Client, sub *WebhookSubscription, delivery WebhookDelivery, stats *webhookCounters) (int, error) {
+	contentType := "application/json"
+	var body []byte
+	var err error
+	if sub.Format == EventFormatCloudEvents {
+		contentType = cloudEventsContentType
+		body, err = json.Marshal(delivery.cloudEvent())
+	} else {
+		body, err = json.Marshal(delivery)
+	}
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Webhook-Id", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Type)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", webhookSignature(sub.secret, timestamp, body))
+	if sub.previousSecret != "" && sub.PreviousSecretExpiresAt != nil && time.Now().Before(*sub.PreviousSecretExpiresAt) {
+		req.Header.Set("X-Webhook-Signature-Previous", webhookSignature(sub.previousSecret, timestamp, body))
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+		resp.Body.Close()
+		if status < 200 || status > 299 {
+			err = fmt.Errorf("receiver answered %d", status)
+		}
+	}
+
+	stats.latencyTotal.Add(time.Since(start).Milliseconds())
+	stats.mu.Lock()
+	stats.lastStatus, stats.lastError, stats.lastAttemptAt = status, "", start.UTC()
+	if err != nil {
+		stats.lastError = err.Error()
+	}
+	stats.mu.Unlock()
+	return status, err
+}
+
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *webhookCounters) snapshot() *WebhookDeliveryStats {
+	s := &WebhookDeliveryStats{
+		Queued:    c.queued.Load(),
+		Delivered: c.delivered.Load(),
+		Failed:    c.failed.Load(),
+		Retries:   c.retries.Load(),
+		Dropped:   c.dropped.Load(),
+	}
+	if attempts := s.Delivered + s.Failed + s.Retries; attempts > 0 {
+		s.AvgLatencyMs = float64(c.latencyTotal.Load()) / float64(attempts)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.LastStatus, s.LastError = c.lastStatus, c.lastError
+	if !c.lastAttemptAt.IsZero() {
+		t := c.lastAttemptAt
+		s.LastAttemptAt = &t
+	}
+	return s
+}
+
+func (d *WebhookDispatcher) stats(id int64) *WebhookDeliveryStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c := d.counters[id]; c != nil {
+		return c.snapshot()
+	}
+	return &WebhookDeliveryStats{}
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func loadWebhookSubscriptions(where string, args ...any) ([]*WebhookSubscription, error) {
+	rows, err := db.Query(`
+		SELECT id, url, events, filter, schema_version, format, secret, COALESCE(previous_secret, ''), previous_secret_expires_at,
+			active, COALESCE(api_key, ''), created_at, updated_at
+		FROM webhook_subscriptions `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*WebhookSubscription
+	for rows.Next() {
+		var s WebhookSubscription
+		if err := rows.Scan(&s.ID, &s.URL, pq.Array(&s.Events), &s.Filter, &s.SchemaVersion, &s.Format, &s.secret, &s.previousSecret,
+			&s.PreviousSecretExpiresAt, &s.Active, &s.APIKey, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		subs = append(subs, &s)
+	}
+	return subs, rows.Err()
+}
+
+func getWebhookSubscription(c *gin.Context) (*WebhookSubscription, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	var subs []*WebhookSubscription
+	if err == nil {
+		subs, err = loadWebhookSubscriptions(`WHERE id = $1`, id)
+	}
+	if err != nil {
+		requestLog(c).Error("Error reading webhook subscription", "webhook_id", c.Param("id"), "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to read webhook subscription")
+		return nil, false
+	}
+	if len(subs) == 0 {
+		respondError(c, http.StatusNotFound, errWebhookNotFound.Error())
+		return nil, false
+	}
+	return subs[0], true
+}
+
+// webhooksAvailable answers 503 on replicas, which don't deliver.
+func webhooksAvailable(c *gin.Context) bool {
+	if webhooks == nil {
+		respondError(c, http.StatusServiceUnavailable, "Webhooks are managed on the primary")
+		return false
+	}
+	return true
+}
+
+func reloadWebhooks() {
+	if err := webhooks.reload(); err != nil {
+		slog.Warn("Failed to reload webhook subscriptions", "error", err)
+	}
+}
+
+type WebhookSubscriptionInput struct {
+	URL           string   `json:"url"`
+	Events        []string `json:"events"`
+	Filter        string   `json:"filter"`
+	SchemaVersion int      `json:"schema_version"`
+	Format        string   `json:"format"`
+	APIKey        string   `json:"api_key"`
+}
+
+func (in *WebhookSubscriptionInput) validate() error {
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	if len(in.Events) == 0 {
+		in.Events = []string{WebhookEventRankChange}
+	}
+	for _, e := range in.Events {
+		if !slices.Contains(webhookEventTypes, e) {
+			return fmt.Errorf("unknown event %q (expected one of %s)", e, strings.Join(webhookEventTypes, ", "))
+		}
+	}
+	if _, err := parseWebhookFilter(in.Filter); err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+	if in.SchemaVersion < 0 || in.SchemaVersion > eventSchemaVersion {
+		return fmt.Errorf("schema_version must be 0 (latest) to %d", eventSchemaVersion)
+	}
+	if in.Format == "" {
+		in.Format = EventFormatNative
+	}
+	if !slices.Contains(eventFormats, in.Format) {
+		return fmt.Errorf("format must be native or cloudevents")
+	}
+	return nil
+}
+
+type CreatedWebhook struct {
+	WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// HandleCreateWebhooks creates up to 100 subscriptions in one transaction:
+// {"subscriptions": [{"url", "events", "filter", "schema_version", "format", "api_key"}, ...]}. Each one's secret is
+// only returned here. A subscription made for an API key counts towards that
+// key's max_webhooks.
+func HandleCreateWebhooks(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	var req struct {
+		Subscriptions []WebhookSubscriptionInput `json:"subscriptions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Subscriptions) == 0 || len(req.Subscriptions) > maxWebhookBulk {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Body must list 1-%d subscriptions", maxWebhookBulk))
+		return
+	}
+	for i := range req.Subscriptions {
+		if err := req.Subscriptions[i].validate(); err != nil {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("subscriptions[%d]: %v", i, err))
+			return
+		}
+	}
+
+	created, err := createWebhooks(req.Subscriptions)
+	var quotaErr *quotaExceededError
+	if errors.As(err, &quotaErr) {
+		respondQuotaExceeded(c, quotaErr)
+		return
+	}
+	if errors.Is(err, errUnknownQuotaKey) {
+		respondError(c, http.StatusBadRequest, "api_key must name an active API key")
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error creating webhook subscriptions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to create webhook subscriptions")
+		return
+	}
+	reloadWebhooks()
+	requestLog(c).Info("✓ Webhook subscriptions created", "count", len(created))
+
+	respond(c, http.StatusCreated, gin.H{
+		"subscriptions": created,
+	})
+}
+
+func createWebhooks(inputs []WebhookSubscriptionInput) ([]CreatedWebhook, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	perKey := map[string]int{}
+	for _, in := range inputs {
+		if in.APIKey != "" {
+			perKey[in.APIKey]++
+		}
+	}
+	for key, n := range perKey {
+		quota, err := loadQuota(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := claimQuota(tx, key, "max_webhooks", quota.MaxWebhooks, n, `SELECT COUNT(*) FROM webhook_subscriptions WHERE api_key = $1`); err != nil {
+			return nil, err
+		}
+	}
+
+	created := make([]CreatedWebhook, 0, len(inputs))
+	for _, in := range inputs {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		w := CreatedWebhook{
+			WebhookSubscription: WebhookSubscription{URL: in.URL, Events: in.Events, Filter: in.Filter, SchemaVersion: in.SchemaVersion, Format: in.Format, Active: true, APIKey: in.APIKey},
+			Secret:              secret,
+		}
+		if err := tx.QueryRow(`
+			INSERT INTO webhook_subscriptions (url, events, filter, schema_version, format, secret, api_key)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+			RETURNING id, created_at, updated_at
+		`, in.URL, pq.Array(in.Events), in.Filter, in.SchemaVersion, in.Format, secret, in.APIKey).Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to insert webhook subscription: %w", err)
+		}
+		created = append(created, w)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook subscriptions: %w", err)
+	}
+	return created, nil
+}
+
+func HandleListWebhooks(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	subs, err := loadWebhookSubscriptions(``)
+	if err != nil {
+		requestLog(c).Error("Error listing webhook subscriptions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to list webhook subscriptions")
+		return
+	}
+	for _, s := range subs {
+		s.Stats = webhooks.stats(s.ID)
+	}
+	if subs == nil {
+		subs = []*WebhookSubscription{}
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"subscriptions": subs,
+	})
+}
+
+func HandleGetWebhook(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	sub, ok := getWebhookSubscription(c)
+	if !ok {
+		return
+	}
+	sub.Stats = webhooks.stats(sub.ID)
+
+	respond(c, http.StatusOK, gin.H{
+		"subscription": sub,
+	})
+}
+
+// HandleUpdateWebhooks applies the same change to many subscriptions:
+// {"ids": [1, 2], "active": false, "events": [...], "filter": "...",
+// "schema_version": 1, "format": "cloudevents"}, with
+// every field but ids optional.
+func HandleUpdateWebhooks(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	var req struct {
+		IDs           []int64  `json:"ids"`
+		Active        *bool    `json:"active"`
+		Events        []string `json:"events"`
+		Filter        *string  `json:"filter"`
+		SchemaVersion *int     `json:"schema_version"`
+		Format        *string  `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 || len(req.IDs) > maxWebhookBulk {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Body must list 1-%d ids", maxWebhookBulk))
+		return
+	}
+	check := WebhookSubscriptionInput{URL: "http://placeholder", Events: req.Events}
+	if req.Filter != nil {
+		check.Filter = *req.Filter
+	}
+	if req.SchemaVersion != nil {
+		check.SchemaVersion = *req.SchemaVersion
+	}
+	if req.Format != nil {
+		check.Format = *req.Format
+	}
+	if err := check.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Format != nil {
+		req.Format = &check.Format
+	}
+
+	var events any
+	if len(req.Events) > 0 {
+		events = pq.Array(req.Events)
+	}
+	res, err := db.Exec(`
+		UPDATE webhook_subscriptions SET
+			active = COALESCE($2, active),
+			events = COALESCE($3, events),
+			filter = COALESCE($4, filter),
+			schema_version = COALESCE($5, schema_version),
+			format = COALESCE($6, format),
+			updated_at = NOW()
+		WHERE id = ANY($1)
+	`, pq.Array(req.IDs), req.Active, events, req.Filter, req.SchemaVersion, req.Format)
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		requestLog(c).Error("Error updating webhook subscriptions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to update webhook subscriptions")
+		return
+	}
+	reloadWebhooks()
+
+	respond(c, http.StatusOK, gin.H{
+		"updated": n,
+	})
+}
+
+// HandleDeleteWebhooks deletes the subscriptions in ?ids=1,2,3.
+func HandleDeleteWebhooks(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	var ids []int64
+	for _, s := range strings.Split(c.Query("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			ids = nil
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > maxWebhookBulk {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("ids must list 1-%d subscription ids", maxWebhookBulk))
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM webhook_subscriptions WHERE id = ANY($1)`, pq.Array(ids))
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		requestLog(c).Error("Error deleting webhook subscriptions", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to delete webhook subscriptions")
+		return
+	}
+	reloadWebhooks()
+
+	respond(c, http.StatusOK, gin.H{
+		"deleted": n,
+	})
+}
+
+// HandleTestWebhook sends one "test" event right away, without retries, and
+// reports how the receiver answered. It counts towards the stats like any
+// other attempt.
+func HandleTestWebhook(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	sub, ok := getWebhookSubscription(c)
+	if !ok {
+		return
+	}
+
+	webhooks.mu.Lock()
+	if webhooks.counters[sub.ID] == nil {
+		webhooks.counters[sub.ID] = &webhookCounters{}
+	}
+	stats := webhooks.counters[sub.ID]
+	webhooks.mu.Unlock()
+
+	delivery := newWebhookDelivery(newEventID(), WebhookEventTest, time.Now().UTC(),
+		WebhookTestEvent{SubscriptionID: sub.ID, Message: "Test delivery"}, sub.SchemaVersion)
+	start := time.Now()
+	status, err := sendWebhook(webhooks.client, sub, *delivery, stats)
+	if err == nil {
+		stats.delivered.Add(1)
+	} else {
+		stats.failed.Add(1)
+	}
+	result := gin.H{
+		"delivered":  err == nil,
+		"status":     status,
+		"latency_ms": time.Since(start).Milliseconds(),
+		"id":         delivery.ID,
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"result": result,
+	})
+}
+
+// HandleRotateWebhookSecret issues a new secret. The old one keeps signing
+// alongside it for WEBHOOK_SECRET_GRACE_SEC so receivers can switch over.
+func HandleRotateWebhookSecret(c *gin.Context) {
+	if !webhooksAvailable(c) {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusNotFound, errWebhookNotFound.Error())
+		return
+	}
+	secret, err := newWebhookSecret()
+	var expires time.Time
+	if err == nil {
+		err = db.QueryRow(`
+			UPDATE webhook_subscriptions SET
+				previous_secret = secret,
+				previous_secret_expires_at = NOW() + $3 * INTERVAL '1 second',
+				secret = $2,
+				updated_at = NOW()
+			WHERE id = $1
+			RETURNING previous_secret_expires_at
+		`, id, secret, int64(webhookSecretGrace.Seconds())).Scan(&expires)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errWebhookNotFound.Error())
+		return
+	}
+	if err != nil {
+		requestLog(c).Error("Error rotating webhook secret", "webhook_id", id, "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to rotate secret")
+		return
+	}
+	reloadWebhooks()
+	requestLog(c).Info("✓ Webhook secret rotated", "webhook_id", id, "previous_valid_until", expires.UTC().Format(time.RFC3339))
+
+	respond(c, http.StatusOK, gin.H{
+		"secret":                     secret,
+		"previous_secret_expires_at": expires.UTC(),
+	})
+}
@@ -1,4 +1,8 @@
 const API_BASE_URL = process.env.EXPO_PUBLIC_API_URL || 'http://localhost:8080';
+const API_KEY = process.env.EXPO_PUBLIC_API_KEY;
+
+// Write endpoints require an X-API-Key when the backend sets API_BOOTSTRAP_KEY.
+const writeHeaders: Record<string, string> = API_KEY ? { 'X-API-Key': API_KEY } : {};
 
 export const DEFAULT_PAGE_SIZE = 100;
 
@@ -31,7 +35,7 @@ export async function fetchLeaderboard(
 ): Promise<{ users: User[]; hasMore: boolean }> {
   try {
     const response = await fetch(
-      `${API_BASE_URL}/leaderboard?page=${page}&limit=${limit}`
+      `${API_BASE_URL}/leaderboard?page=${page}&limit=${limit}&include_bots=true`
     );
     
     if (!response.ok) {
@@ -69,7 +73,7 @@ export async function searchUsers(
   try {
     const encodedUsername = encodeURIComponent(username.trim());
     const response = await fetch(
-      `${API_BASE_URL}/search?username=${encodedUsername}&page=${page}&limit=${limit}`
+      `${API_BASE_URL}/search?username=${encodedUsername}&page=${page}&limit=${limit}&include_bots=true`
     );
     
     if (!response.ok) {
@@ -96,6 +100,7 @@ export async function triggerSimulation(): Promise<{ message: string; updated: n
   try {
     const response = await fetch(`${API_BASE_URL}/simulate`, {
       method: 'POST',
+      headers: writeHeaders,
     });
     
     if (!response.ok) {
@@ -138,6 +143,7 @@ export async function simulateUserRating(
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
+        ...writeHeaders,
       },
       body: JSON.stringify({
         username: username.trim(),